@@ -0,0 +1,287 @@
+package addressing
+
+import (
+	"ecommerce/models"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+//every country we ship to has a schema in data/<country code>.json
+//required fields, the postal code formats and the regions (states, provinces) if the country uses them
+//postal formats use # for a digit and @ for a letter, anything else is a separator that gets inserted
+
+//go:embed data/*.json
+var data embed.FS
+
+type Region struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+}
+
+type Country struct {
+	Code           string   `json:"code"`
+	Name           string   `json:"name"`
+	Required       []string `json:"required"`
+	Postal_Label   string   `json:"postal_label"`
+	Postal_Formats []string `json:"postal_formats"`
+	Uppercase_City bool     `json:"uppercase_city"`
+	Regions        []Region `json:"regions"`
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every field that is wrong, not just the first one
+type ValidationError struct {
+	Fields []FieldError `json:"fields"`
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		messages[i] = field.Field + ": " + field.Message
+	}
+	return "invalid address: " + strings.Join(messages, ", ")
+}
+
+func (e *ValidationError) add(field string, format string, args ...interface{}) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+var countries = loadCountries()
+
+// DefaultCountry is assumed for addresses that do not say where they are
+var DefaultCountry = strings.ToUpper(envOr("DEFAULT_COUNTRY", "IN"))
+
+func envOr(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func loadCountries() map[string]Country {
+	loaded := make(map[string]Country)
+	files, err := data.ReadDir("data")
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, file := range files {
+		raw, err := data.ReadFile(path.Join("data", file.Name()))
+		if err != nil {
+			log.Fatal(err)
+		}
+		var country Country
+		if err := json.Unmarshal(raw, &country); err != nil {
+			log.Fatalf("addressing: %s: %v", file.Name(), err)
+		}
+		loaded[country.Code] = country
+	}
+	return loaded
+}
+
+func Lookup(code string) (Country, bool) {
+	country, ok := countries[strings.ToUpper(strings.TrimSpace(code))]
+	return country, ok
+}
+
+// Countries returns every supported country sorted by name
+func Countries() []Country {
+	list := make([]Country, 0, len(countries))
+	for _, country := range countries {
+		list = append(list, country)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Clean normalizes the address in place and validates it against the schema of its country
+func Clean(address *models.Address) error {
+	Normalize(address)
+	return Validate(*address)
+}
+
+// Normalize tidies whitespace and casing, resolves country and region names
+// to their codes and writes postal codes in the national format. Values it
+// cannot make sense of are left for Validate to report.
+func Normalize(address *models.Address) {
+	for _, field := range []**string{&address.Label, &address.Recipient_Name, &address.Phone, &address.House, &address.Street, &address.City, &address.Pincode, &address.Region, &address.Country} {
+		if *field == nil {
+			continue
+		}
+		value := strings.Join(strings.Fields(**field), " ")
+		if value == "" {
+			*field = nil
+			continue
+		}
+		*field = &value
+	}
+	if address.Country == nil {
+		country := DefaultCountry
+		address.Country = &country
+	}
+	code := resolveCountry(*address.Country)
+	address.Country = &code
+	country, ok := countries[code]
+	if !ok {
+		return
+	}
+	if address.City != nil {
+		city := smartTitle(*address.City)
+		if country.Uppercase_City {
+			city = strings.ToUpper(city)
+		}
+		address.City = &city
+	}
+	if address.Street != nil {
+		street := smartTitle(*address.Street)
+		address.Street = &street
+	}
+	if address.Recipient_Name != nil {
+		name := smartTitle(*address.Recipient_Name)
+		address.Recipient_Name = &name
+	}
+	if address.Region != nil {
+		if region, ok := findRegion(country, *address.Region); ok {
+			address.Region = &region.Code
+		}
+	}
+	if address.Pincode != nil {
+		if postal, ok := formatPostal(country, *address.Pincode); ok {
+			address.Pincode = &postal
+		}
+	}
+}
+
+var phonePattern = regexp.MustCompile(`^\+?[0-9][0-9 ()\-]{5,19}$`)
+
+func Validate(address models.Address) error {
+	problems := &ValidationError{}
+	code := DefaultCountry
+	if address.Country != nil {
+		code = strings.ToUpper(*address.Country)
+	}
+	country, ok := countries[code]
+	if !ok {
+		problems.add("country_code", "we do not ship to %q", code)
+		return problems
+	}
+	values := map[string]*string{
+		"recipient_name": address.Recipient_Name,
+		"phone":          address.Phone,
+		"house_name":     address.House,
+		"street_name":    address.Street,
+		"city_name":      address.City,
+		"pin_code":       address.Pincode,
+		"region":         address.Region,
+	}
+	for _, field := range country.Required {
+		if value := values[field]; value == nil || strings.TrimSpace(*value) == "" {
+			label := field
+			if field == "pin_code" {
+				label = strings.ToLower(country.Postal_Label)
+			}
+			problems.add(field, "%s is required in %s", label, country.Name)
+		}
+	}
+	if address.Pincode != nil {
+		if _, ok := formatPostal(country, *address.Pincode); !ok {
+			problems.add("pin_code", "%q is not a valid %s, expected %s", *address.Pincode, strings.ToLower(country.Postal_Label), strings.Join(country.Postal_Formats, " or "))
+		}
+	}
+	if address.Region != nil && len(country.Regions) > 0 {
+		if _, ok := findRegion(country, *address.Region); !ok {
+			problems.add("region", "%q is not a region of %s", *address.Region, country.Name)
+		}
+	}
+	if address.Phone != nil && !phonePattern.MatchString(*address.Phone) {
+		problems.add("phone", "%q is not a phone number", *address.Phone)
+	}
+	if len(problems.Fields) > 0 {
+		return problems
+	}
+	return nil
+}
+
+func resolveCountry(value string) string {
+	if _, ok := countries[strings.ToUpper(value)]; ok {
+		return strings.ToUpper(value)
+	}
+	for code, country := range countries {
+		if strings.EqualFold(country.Name, value) {
+			return code
+		}
+	}
+	return strings.ToUpper(value)
+}
+
+func findRegion(country Country, value string) (Region, bool) {
+	for _, region := range country.Regions {
+		if strings.EqualFold(region.Code, value) || strings.EqualFold(region.Name, value) {
+			return region, true
+		}
+	}
+	return Region{}, false
+}
+
+// formatPostal matches the postal code against the formats of the country
+// ignoring spaces and dashes, and returns it written like the matching format
+func formatPostal(country Country, value string) (string, bool) {
+	compact := []rune(strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' {
+			return -1
+		}
+		return unicode.ToUpper(r)
+	}, value))
+	for _, format := range country.Postal_Formats {
+		var out strings.Builder
+		i := 0
+		matched := true
+		for _, f := range format {
+			if f != '#' && f != '@' {
+				out.WriteRune(f)
+				continue
+			}
+			if i >= len(compact) {
+				matched = false
+				break
+			}
+			c := compact[i]
+			if (f == '#' && !unicode.IsDigit(c)) || (f == '@' && !unicode.IsLetter(c)) {
+				matched = false
+				break
+			}
+			out.WriteRune(c)
+			i++
+		}
+		if matched && i == len(compact) {
+			return out.String(), true
+		}
+	}
+	return value, false
+}
+
+// smartTitle capitalizes words only when the whole value was typed in one case,
+// so "new   york" and "NEW YORK" become "New York" but "McAllen" is left alone
+func smartTitle(value string) string {
+	if value != strings.ToLower(value) && value != strings.ToUpper(value) {
+		return value
+	}
+	words := strings.Fields(strings.ToLower(value))
+	for i, word := range words {
+		runes := []rune(word)
+		runes[0] = unicode.ToUpper(runes[0])
+		words[i] = string(runes)
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,195 @@
+package addressing
+
+import (
+	"ecommerce/models"
+	"errors"
+	"sort"
+	"testing"
+)
+
+func str(value string) *string {
+	return &value
+}
+
+func value(field *string) string {
+	if field == nil {
+		return "<nil>"
+	}
+	return *field
+}
+
+func TestFormatPostal(t *testing.T) {
+	cases := []struct {
+		country string
+		postal  string
+		want    string
+		ok      bool
+	}{
+		{"IN", "560001", "560001", true},
+		{"IN", "560 001", "560001", true},
+		{"IN", "56001", "56001", false},
+		{"IN", "56000A", "56000A", false},
+		{"US", "94105", "94105", true},
+		{"US", "941051234", "94105-1234", true},
+		{"US", "94105 - 1234", "94105-1234", true},
+		{"US", "9410512", "9410512", false},
+		{"CA", "k1a0b1", "K1A 0B1", true},
+		{"CA", "K1A-0B1", "K1A 0B1", true},
+		{"CA", "11A 0B1", "11A 0B1", false},
+		{"GB", "m11ae", "M1 1AE", true},
+		{"GB", "b338th", "B33 8TH", true},
+		{"GB", "cr26xh", "CR2 6XH", true},
+		{"GB", "dn551pt", "DN55 1PT", true},
+		{"GB", "w1a1hq", "W1A 1HQ", true},
+		{"GB", "sw1a1aa", "SW1A 1AA", true},
+		{"GB", "sw1a1a", "sw1a1a", false},
+		{"NL", "1234ab", "1234 AB", true},
+		{"NL", "12345", "12345", false},
+		{"DE", "10115", "10115", true},
+		{"DE", "1011", "1011", false},
+		{"FR", "75001", "75001", true},
+		{"AU", "2000", "2000", true},
+		{"AU", "20000", "20000", false},
+	}
+	for _, c := range cases {
+		got, ok := formatPostal(countries[c.country], c.postal)
+		if got != c.want || ok != c.ok {
+			t.Errorf("%s %q: got %q %v, want %q %v", c.country, c.postal, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	cases := []struct {
+		name    string
+		address models.Address
+		want    models.Address
+	}{
+		{
+			"country and region names become codes",
+			models.Address{Street: str("market  st"), City: str("SAN FRANCISCO"), Region: str("california"), Pincode: str("941051234"), Country: str("united states")},
+			models.Address{Street: str("Market St"), City: str("San Francisco"), Region: str("CA"), Pincode: str("94105-1234"), Country: str("US")},
+		},
+		{
+			"mixed case is left alone",
+			models.Address{Recipient_Name: str("Ronald McDonald"), City: str("McAllen"), Country: str("us")},
+			models.Address{Recipient_Name: str("Ronald McDonald"), City: str("McAllen"), Country: str("US")},
+		},
+		{
+			"cities in capitals where the country writes them so",
+			models.Address{City: str("saint  denis"), Pincode: str("93200"), Country: str("FR")},
+			models.Address{City: str("SAINT DENIS"), Pincode: str("93200"), Country: str("FR")},
+		},
+		{
+			"postal codes with the separators of the format",
+			models.Address{Pincode: str(" k1a 0b1 "), Region: str("ontario"), Country: str("CA")},
+			models.Address{Pincode: str("K1A 0B1"), Region: str("ON"), Country: str("CA")},
+		},
+		{
+			"blank values are dropped",
+			models.Address{Label: str("  "), House: str(" 12 "), Pincode: str("1234ab"), Country: str("netherlands")},
+			models.Address{House: str("12"), Pincode: str("1234 AB"), Country: str("NL")},
+		},
+		{
+			"no country is the default one",
+			models.Address{Pincode: str("560 001")},
+			models.Address{Pincode: str("560001"), Country: str(DefaultCountry)},
+		},
+		{
+			"what cannot be resolved is kept for validate",
+			models.Address{Region: str("atlantis"), Pincode: str("abc"), Country: str("US")},
+			models.Address{Region: str("atlantis"), Pincode: str("abc"), Country: str("US")},
+		},
+		{
+			"unknown countries are only upper cased",
+			models.Address{City: str("gotham"), Country: str("zz")},
+			models.Address{City: str("gotham"), Country: str("ZZ")},
+		},
+	}
+	for _, c := range cases {
+		got := c.address
+		Normalize(&got)
+		fields := []struct {
+			field     string
+			got, want *string
+		}{
+			{"label", got.Label, c.want.Label},
+			{"recipient_name", got.Recipient_Name, c.want.Recipient_Name},
+			{"house_name", got.House, c.want.House},
+			{"street_name", got.Street, c.want.Street},
+			{"city_name", got.City, c.want.City},
+			{"pin_code", got.Pincode, c.want.Pincode},
+			{"region", got.Region, c.want.Region},
+			{"country_code", got.Country, c.want.Country},
+		}
+		for _, f := range fields {
+			if value(f.got) != value(f.want) {
+				t.Errorf("%s: %s is %s, want %s", c.name, f.field, value(f.got), value(f.want))
+			}
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name    string
+		address models.Address
+		fields  []string
+	}{
+		{"india", models.Address{House: str("12"), Street: str("MG Road"), City: str("Bengaluru"), Pincode: str("560001"), Country: str("IN")}, nil},
+		{"united states", models.Address{Street: str("1 Market St"), City: str("San Francisco"), Region: str("CA"), Pincode: str("94105-1234"), Phone: str("+1 (415) 555-0100"), Country: str("US")}, nil},
+		{"canada", models.Address{Street: str("24 Sussex Dr"), City: str("Ottawa"), Region: str("ON"), Pincode: str("K1M 1M4"), Country: str("CA")}, nil},
+		{"united kingdom", models.Address{Street: str("Downing St"), City: str("London"), Pincode: str("SW1A 2AA"), Country: str("GB")}, nil},
+		{"netherlands", models.Address{House: str("1"), Street: str("Dam"), City: str("Amsterdam"), Pincode: str("1012 JS"), Country: str("NL")}, nil},
+		{"germany", models.Address{Street: str("Unter den Linden"), City: str("Berlin"), Pincode: str("10117"), Country: str("DE")}, nil},
+		{"france", models.Address{Street: str("Rue de Rivoli"), City: str("PARIS"), Pincode: str("75001"), Country: str("FR")}, nil},
+		{"australia", models.Address{Street: str("George St"), City: str("Sydney"), Region: str("NSW"), Pincode: str("2000"), Country: str("AU")}, nil},
+		{"missing required fields", models.Address{Street: str("MG Road"), Pincode: str("560001"), Country: str("IN")}, []string{"city_name", "house_name"}},
+		{"blank counts as missing", models.Address{Street: str(" "), City: str("Berlin"), Pincode: str("10117"), Country: str("DE")}, []string{"street_name"}},
+		{"region is required", models.Address{Street: str("George St"), City: str("Sydney"), Pincode: str("2000"), Country: str("AU")}, []string{"region"}},
+		{"bad postal code", models.Address{Street: str("Dam"), House: str("1"), City: str("Amsterdam"), Pincode: str("1012"), Country: str("NL")}, []string{"pin_code"}},
+		{"unknown region", models.Address{Street: str("1 Market St"), City: str("San Francisco"), Region: str("XX"), Pincode: str("94105"), Country: str("US")}, []string{"region"}},
+		{"bad phone", models.Address{Street: str("Downing St"), City: str("London"), Pincode: str("SW1A 2AA"), Phone: str("call me"), Country: str("GB")}, []string{"phone"}},
+		{"every problem at once", models.Address{Region: str("XX"), Pincode: str("abc"), Country: str("US")}, []string{"city_name", "pin_code", "region", "street_name"}},
+		{"a country we do not ship to", models.Address{Street: str("Main St"), Country: str("ZZ")}, []string{"country_code"}},
+	}
+	for _, c := range cases {
+		err := Validate(c.address)
+		if c.fields == nil {
+			if err != nil {
+				t.Errorf("%s: %v", c.name, err)
+			}
+			continue
+		}
+		var problems *ValidationError
+		if !errors.As(err, &problems) {
+			t.Errorf("%s: got %v, want a validation error", c.name, err)
+			continue
+		}
+		got := make([]string, len(problems.Fields))
+		for i, field := range problems.Fields {
+			got[i] = field.Field
+		}
+		sort.Strings(got)
+		if len(got) != len(c.fields) {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.fields)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.fields[i] {
+				t.Errorf("%s: got %v, want %v", c.name, got, c.fields)
+				break
+			}
+		}
+	}
+}
+
+func TestClean(t *testing.T) {
+	address := models.Address{Street: str("downing  st"), City: str("london"), Pincode: str("sw1a2aa"), Country: str("United Kingdom")}
+	if err := Clean(&address); err != nil {
+		t.Fatal(err)
+	}
+	if *address.Street != "Downing St" || *address.City != "London" || *address.Pincode != "SW1A 2AA" || *address.Country != "GB" {
+		t.Errorf("got %s, %s, %s, %s", *address.Street, *address.City, *address.Pincode, *address.Country)
+	}
+}
@@ -0,0 +1,607 @@
+package controllers
+
+import (
+	"context"
+	"ecommerce/models"
+	"ecommerce/pricing"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+//a cart holds one line per product, or per variant of a product, with the
+//quantity wanted. Carts from before quantities repeated the line once per
+//unit, they are merged into single lines the first time they are read.
+
+var ErrNotInCart = errors.New("the product is not in your cart")
+var ErrCartChanged = errors.New("the cart was changed at the same time, try again")
+var ErrQuantity = errors.New("quantity must be a whole number")
+var ErrCartOutdated = errors.New("the catalog changed since the products went into the cart, acknowledge the changes before checking out")
+var ErrChangesMoved = errors.New("the catalog changed again since the cart was shown, acknowledge the changes as they are now")
+
+// CartMaxQuantity is the most units of one product a cart line can hold, CART_MAX_QUANTITY sets it
+var CartMaxQuantity = cartMaxQuantity()
+
+func cartMaxQuantity() int {
+	if max, err := strconv.Atoi(os.Getenv("CART_MAX_QUANTITY")); err == nil && max > 0 {
+		return max
+	}
+	return 99
+}
+
+// QuantityError tells how many units of the product one order can have at most
+type QuantityError struct {
+	Max int
+}
+
+func (e *QuantityError) Error() string {
+	return fmt.Sprintf("at most %d of this product can be ordered at once", e.Max)
+}
+
+// maxQuantity is CartMaxQuantity or the lower max_quantity of the product
+func maxQuantity(product models.Product) int {
+	if product.Max_Quantity != nil && *product.Max_Quantity < CartMaxQuantity {
+		return *product.Max_Quantity
+	}
+	return CartMaxQuantity
+}
+
+// cartRef is the document a cart is kept in, the usercart of a user or a
+// guest cart, guest carts carry the token that names them
+type cartRef struct {
+	collection *mongo.Collection
+	id         primitive.ObjectID
+	token      string
+}
+
+func userCart(usert_id primitive.ObjectID) cartRef {
+	return cartRef{collection: UserCollection, id: usert_id}
+}
+
+// mergeLines turns repeated lines of one product into one line with their
+// quantities added up, it reports whether anything had to change
+func mergeLines(items []models.ProductUser) ([]models.ProductUser, bool) {
+	merged := make([]models.ProductUser, 0, len(items))
+	index := make(map[lineKey]int)
+	changed := false
+	for _, item := range items {
+		if item.Quantity < 1 {
+			changed = true
+		}
+		key := itemKey(item.Product_ID, item.Variant_ID)
+		if i, ok := index[key]; ok {
+			merged[i].Quantity += item.Units()
+			changed = true
+			continue
+		}
+		index[key] = len(merged)
+		item.Quantity = item.Units()
+		merged = append(merged, item)
+	}
+	return merged, changed
+}
+
+// lines reads the cart, merging the lines of an old cart on the way
+func (cart cartRef) lines(ctx context.Context) ([]models.ProductUser, error) {
+	var holder struct {
+		UserCart []models.ProductUser `bson:"usercart"`
+	}
+	projection := options.FindOne().SetProjection(bson.M{"usercart": 1})
+	err := cart.collection.FindOne(ctx, bson.M{"_id": cart.id}, projection).Decode(&holder)
+	if err != nil {
+		return nil, err
+	}
+	merged, changed := mergeLines(holder.UserCart)
+	if changed {
+		//only carts still holding lines without a quantity are rewritten
+		filter := bson.M{"_id": cart.id, "usercart": bson.M{"$elemMatch": bson.M{"quantity": bson.M{"$exists": false}}}}
+		if _, err := cart.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"usercart": merged}}); err != nil {
+			return nil, err
+		}
+	}
+	return merged, nil
+}
+
+// cartEntry finds the line of the product in the cart, the variant can be left
+// out when the cart holds only one variant of the product
+func cartEntry(lines []models.ProductUser, productt_id primitive.ObjectID, variant string) (models.ProductUser, error) {
+	found := make([]models.ProductUser, 0)
+	for _, item := range lines {
+		if item.Product_ID != productt_id {
+			continue
+		}
+		if variant == "" || (item.Variant_ID != nil && item.Variant_ID.Hex() == variant) || (item.SKU != nil && strings.EqualFold(*item.SKU, variant)) {
+			found = append(found, item)
+		}
+	}
+	if len(found) == 0 {
+		return models.ProductUser{}, ErrNotInCart
+	}
+	if len(found) > 1 {
+		return models.ProductUser{}, ErrVariantRequired
+	}
+	return found[0], nil
+}
+
+// cartFilter matches the line of the product in the cart
+func cartFilter(product_id primitive.ObjectID, variant_id *primitive.ObjectID) bson.M {
+	return bson.M{"_id": product_id, "variant_id": variant_id}
+}
+
+// change adds by units of the product to the cart, a negative by takes units
+// out and the line goes when none are left. With set by is the new quantity
+// instead. Raising the quantity checks the product can still be bought and
+// stays within its max quantity. Every write only goes through if the line
+// still has the quantity it was read with, otherwise it is tried again.
+func (cart cartRef) change(ctx context.Context, productt_id primitive.ObjectID, variant string, by int, set bool) error {
+	for attempt := 0; attempt < 3; attempt++ {
+		lines, err := cart.lines(ctx)
+		if err != nil {
+			return err
+		}
+		current := 0
+		entry, err := cartEntry(lines, productt_id, variant)
+		if err == nil {
+			current = entry.Units()
+			if entry.Variant_ID != nil {
+				variant = entry.Variant_ID.Hex()
+			}
+		} else if err != ErrNotInCart {
+			return err
+		}
+		quantity := current + by
+		if set {
+			quantity = by
+		}
+		if quantity < 0 {
+			quantity = 0
+		}
+		if current == 0 && quantity == 0 {
+			return ErrNotInCart
+		}
+		var line models.ProductUser
+		if quantity > current {
+			product, err := cartProduct(ctx, productt_id)
+			if err != nil {
+				return err
+			}
+			if line, err = productLine(product, variant); err != nil {
+				return err
+			}
+			if max := maxQuantity(product); quantity > max {
+				return &QuantityError{Max: max}
+			}
+		}
+		filter := bson.M{"_id": cart.id}
+		var update bson.M
+		switch {
+		case current == 0:
+			line.Quantity = quantity
+			filter["usercart"] = bson.M{"$not": bson.M{"$elemMatch": cartFilter(line.Product_ID, line.Variant_ID)}}
+			update = bson.M{"$push": bson.M{"usercart": line}}
+		case quantity == 0:
+			held := cartFilter(entry.Product_ID, entry.Variant_ID)
+			held["quantity"] = current
+			filter["usercart"] = bson.M{"$elemMatch": held}
+			update = bson.M{"$pull": bson.M{"usercart": cartFilter(entry.Product_ID, entry.Variant_ID)}}
+		default:
+			held := cartFilter(entry.Product_ID, entry.Variant_ID)
+			held["quantity"] = current
+			filter["usercart"] = bson.M{"$elemMatch": held}
+			update = bson.M{"$set": bson.M{"usercart.$.quantity": quantity}}
+		}
+		result, err := cart.collection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount > 0 {
+			return nil
+		}
+	}
+	return ErrCartChanged
+}
+
+/*****CATALOG CHECK*****/
+
+//the price of a cart line is the price the customer agreed to. The catalog
+//can change after that, the cart shows what changed and checkout refuses to
+//charge anything else until the customer acknowledged the changes
+
+const (
+	ChangePrice       = "price_changed"
+	ChangeUnavailable = "unavailable"
+	ChangeQuantity    = "quantity_limited"
+)
+
+// cartChange is a line of the cart the catalog no longer agrees with
+type cartChange struct {
+	Type         string              `json:"type"`
+	Product_ID   primitive.ObjectID  `json:"product_id"`
+	Variant_ID   *primitive.ObjectID `json:"variant_id,omitempty"`
+	Name         string              `json:"name"`
+	Old_Price    int                 `json:"old_price,omitempty"`
+	New_Price    int                 `json:"new_price,omitempty"`
+	Quantity     int                 `json:"quantity,omitempty"`
+	Max_Quantity int                 `json:"max_quantity,omitempty"`
+	Reason       string              `json:"reason,omitempty"`
+}
+
+// reconcile holds the cart against the catalog. It returns every line that
+// can still be bought as the catalog has it now, with its current price, and
+// what changed since the customer last agreed to the cart.
+func reconcile(ctx context.Context, lines []models.ProductUser) ([]models.ProductUser, []cartChange, error) {
+	fresh := make([]models.ProductUser, 0, len(lines))
+	changes := make([]cartChange, 0)
+	if len(lines) == 0 {
+		return fresh, changes, nil
+	}
+	ids := make([]primitive.ObjectID, 0, len(lines))
+	for _, line := range lines {
+		ids = append(ids, line.Product_ID)
+	}
+	cursor, err := ProductCollection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return fresh, changes, err
+	}
+	var products []models.Product
+	if err := cursor.All(ctx, &products); err != nil {
+		return fresh, changes, err
+	}
+	byID := make(map[primitive.ObjectID]models.Product, len(products))
+	for _, product := range products {
+		byID[product.Product_ID] = product
+	}
+	for _, line := range lines {
+		change := cartChange{Product_ID: line.Product_ID, Variant_ID: line.Variant_ID, Name: lineName(line)}
+		product, ok := byID[line.Product_ID]
+		if !ok || product.Archived {
+			change.Type = ChangeUnavailable
+			change.Reason = "the product is no longer sold"
+			changes = append(changes, change)
+			continue
+		}
+		variant := ""
+		if line.Variant_ID != nil {
+			variant = line.Variant_ID.Hex()
+		}
+		current, err := productLine(product, variant)
+		if err != nil {
+			change.Type = ChangeUnavailable
+			change.Reason = "the variant is no longer sold"
+			changes = append(changes, change)
+			continue
+		}
+		current.Quantity = line.Units()
+		if current.Price != line.Price {
+			price := change
+			price.Type = ChangePrice
+			price.Old_Price = line.Price
+			price.New_Price = current.Price
+			changes = append(changes, price)
+		}
+		if max := maxQuantity(product); current.Quantity > max {
+			limited := change
+			limited.Type = ChangeQuantity
+			limited.Quantity = current.Quantity
+			limited.Max_Quantity = max
+			changes = append(changes, limited)
+			current.Quantity = max
+		}
+		fresh = append(fresh, current)
+	}
+	return fresh, changes, nil
+}
+
+// checkCart is the cart as it gets ordered, it answers the request when the
+// customer still has to acknowledge changes
+func checkCart(c *gin.Context, ctx context.Context, lines []models.ProductUser) ([]models.ProductUser, bool) {
+	lines, _ = mergeLines(lines)
+	fresh, changes, err := reconcile(ctx, lines)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+		return nil, false
+	}
+	if len(changes) > 0 {
+		c.IndentedJSON(http.StatusConflict, gin.H{"error": ErrCartOutdated.Error(), "changes": changes})
+		return nil, false
+	}
+	return fresh, true
+}
+
+// sameChanges tells whether the changes a customer saw are still the changes
+// the catalog makes, same lines with the same new prices and limits
+func sameChanges(seen []cartChange, current []cartChange) bool {
+	if len(seen) != len(current) {
+		return false
+	}
+	type changeKey struct {
+		line         lineKey
+		kind         string
+		new_price    int
+		max_quantity int
+	}
+	count := make(map[changeKey]int, len(current))
+	for _, change := range current {
+		count[changeKey{itemKey(change.Product_ID, change.Variant_ID), change.Type, change.New_Price, change.Max_Quantity}]++
+	}
+	for _, change := range seen {
+		key := changeKey{itemKey(change.Product_ID, change.Variant_ID), change.Type, change.New_Price, change.Max_Quantity}
+		if count[key] == 0 {
+			return false
+		}
+		count[key]--
+	}
+	return true
+}
+
+// acknowledge makes the cart agree with the catalog again, lines get their
+// current price and max quantity and lines that can no longer be bought go.
+// Every line only changes if it still is what the customer acknowledged.
+func (cart cartRef) acknowledge(ctx context.Context, lines []models.ProductUser, changes []cartChange) error {
+	agreed := make(map[lineKey]models.ProductUser, len(lines))
+	for _, line := range lines {
+		agreed[itemKey(line.Product_ID, line.Variant_ID)] = line
+	}
+	for _, change := range changes {
+		line := agreed[itemKey(change.Product_ID, change.Variant_ID)]
+		held := cartFilter(change.Product_ID, change.Variant_ID)
+		var update bson.M
+		switch change.Type {
+		case ChangeUnavailable:
+			update = bson.M{"$pull": bson.M{"usercart": cartFilter(change.Product_ID, change.Variant_ID)}}
+		case ChangePrice:
+			held["price"] = change.Old_Price
+			update = bson.M{"$set": bson.M{"usercart.$.price": change.New_Price}}
+		case ChangeQuantity:
+			held["quantity"] = line.Units()
+			update = bson.M{"$set": bson.M{"usercart.$.quantity": change.Max_Quantity}}
+		default:
+			continue
+		}
+		filter := bson.M{"_id": cart.id, "usercart": bson.M{"$elemMatch": held}}
+		if _, err := cart.collection.UpdateOne(ctx, filter, update); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cartItem is a line of the cart with what it comes to, the total is after its share of the discounts
+type cartItem struct {
+	models.ProductUser
+	Subtotal  int  `json:"subtotal"`
+	Total     int  `json:"total"`
+	Available bool `json:"available"`
+}
+
+// cartView is the cart as the customer agreed to it, lines that can no longer
+// be bought do not count towards the totals. With changes the customer has to
+// acknowledge them before checking out.
+type cartView struct {
+	Items []cartItem `json:"items"`
+	Units int        `json:"units"`
+	models.Totals
+	Changes                  []cartChange `json:"changes"`
+	Requires_Acknowledgement bool         `json:"requires_acknowledgement"`
+	Cart_Token               string       `json:"cart_token,omitempty"`
+}
+
+func viewCart(lines []models.ProductUser, changes []cartChange) cartView {
+	view := cartView{Items: make([]cartItem, 0, len(lines)), Changes: changes, Requires_Acknowledgement: len(changes) > 0}
+	gone := make(map[lineKey]bool)
+	for _, change := range changes {
+		if change.Type == ChangeUnavailable {
+			gone[itemKey(change.Product_ID, change.Variant_ID)] = true
+		}
+	}
+	available := make([]models.ProductUser, 0, len(lines))
+	for _, line := range lines {
+		if !gone[itemKey(line.Product_ID, line.Variant_ID)] {
+			available = append(available, line)
+			view.Units += line.Units()
+		}
+	}
+	priced, totals := pricing.Quote(available)
+	view.Totals = totals
+	for _, line := range lines {
+		item := cartItem{ProductUser: line, Subtotal: line.Price * line.Units()}
+		if !gone[itemKey(line.Product_ID, line.Variant_ID)] {
+			item.ProductUser = priced[0]
+			item.Available = true
+			priced = priced[1:]
+		}
+		item.Total = item.Subtotal - item.Discount
+		view.Items = append(view.Items, item)
+	}
+	return view
+}
+
+// view reads the cart and checks it against the catalog
+func (cart cartRef) view(ctx context.Context) (cartView, error) {
+	lines, err := cart.lines(ctx)
+	if err != nil {
+		return cartView{}, err
+	}
+	_, changes, err := reconcile(ctx, lines)
+	if err != nil {
+		return cartView{}, err
+	}
+	return viewCart(lines, changes), nil
+}
+
+func cartError(c *gin.Context, err error) {
+	var limit *QuantityError
+	switch {
+	case errors.As(err, &limit):
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error(), "max_quantity": limit.Max})
+	case err == ErrNotInCart:
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case err == ErrCartChanged:
+		c.IndentedJSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err == ErrQuantity:
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		cartLineError(c, err)
+	}
+}
+
+// cartParams reads the product of a request on a cart line, ?id=xxxproduct_idxxx
+// with &variant= or &sku= for a variant
+func cartParams(c *gin.Context) (primitive.ObjectID, string, bool) {
+	productt_id, err := primitive.ObjectIDFromHex(c.Query("id"))
+	if err != nil {
+		c.Header("Content-Type", "application/json")
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invalid product id"})
+		c.Abort()
+		return productt_id, "", false
+	}
+	variant := c.Query("variant")
+	if variant == "" {
+		variant = c.Query("sku")
+	}
+	return productt_id, variant, true
+}
+
+// queryQuantity reads a quantity from the query string, def when it is left out
+func queryQuantity(c *gin.Context, key string, def int) (int, error) {
+	value, err := queryUint(c, key)
+	if err != nil || (value != nil && *value > uint64(CartMaxQuantity)*10) {
+		return 0, ErrQuantity
+	}
+	if value == nil {
+		return def, nil
+	}
+	return int(*value), nil
+}
+
+// changeCart is what the quantity handlers share, it changes the cart of the
+// logged in user or of the guest and answers with the cart. Guests adding
+// their first product get a new guest cart.
+func changeCart(c *gin.Context, by int, set bool) {
+	productt_id, variant, ok := cartParams(c)
+	if !ok {
+		return
+	}
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+	cart, ok := requestCart(c, ctx, by > 0)
+	if !ok {
+		return
+	}
+	if err := cart.change(ctx, productt_id, variant, by, set); err != nil {
+		cartError(c, err)
+		return
+	}
+	answerCart(c, ctx, cart)
+}
+
+//the quantity handlers work for guests too, under /guest/cart/... with the Cart-Token header
+
+//function setting how many of a product are in the cart, 0 takes it out
+//PUT request : http://localhost:8000/cart/quantity?id=xxxproduct_idxxx&variant=xxxvariant_idxxx&quantity=3
+
+func SetCartQuantity() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		quantity, err := queryQuantity(c, "quantity", -1)
+		if err == nil && quantity < 0 {
+			err = ErrQuantity
+		}
+		if err != nil {
+			cartError(c, err)
+			return
+		}
+		changeCart(c, quantity, true)
+	}
+}
+
+//function adding one more of a product to the cart, &by=3 adds three
+//POST request : http://localhost:8000/cart/increment?id=xxxproduct_idxxx&variant=xxxvariant_idxxx
+
+func IncrementCartQuantity() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		by, err := queryQuantity(c, "by", 1)
+		if err != nil {
+			cartError(c, err)
+			return
+		}
+		changeCart(c, by, false)
+	}
+}
+
+//function taking one of a product out of the cart, &by=3 takes three, the line goes once none are left
+//POST request : http://localhost:8000/cart/decrement?id=xxxproduct_idxxx&variant=xxxvariant_idxxx
+
+func DecrementCartQuantity() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		by, err := queryQuantity(c, "by", 1)
+		if err != nil {
+			cartError(c, err)
+			return
+		}
+		changeCart(c, -by, false)
+	}
+}
+
+//function accepting what changed in the catalog since the products went into the cart
+//lines get the current price and max quantity, products that are no longer sold leave the cart
+//the changes the customer was shown are sent back, when the catalog moved on since then
+//the answer is 409 with the changes as they are now and nothing is accepted
+//POST request : http://localhost:8000/cart/acknowledge
+/*
+{
+"changes" : [{"type":"price_changed","product_id":"xxxproduct_idxxx","new_price":6}]
+}
+*/
+
+func AcknowledgeCart() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request struct {
+			Changes []cartChange `json:"changes" binding:"required"`
+		}
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		cart, ok := requestCart(c, ctx, false)
+		if !ok {
+			return
+		}
+		lines, err := cart.lines(ctx)
+		if err != nil {
+			cartError(c, err)
+			return
+		}
+		_, changes, err := reconcile(ctx, lines)
+		if err != nil {
+			cartError(c, err)
+			return
+		}
+		if !sameChanges(request.Changes, changes) {
+			c.IndentedJSON(http.StatusConflict, gin.H{"error": ErrChangesMoved.Error(), "changes": changes})
+			return
+		}
+		if err := cart.acknowledge(ctx, lines, changes); err != nil {
+			cartError(c, err)
+			return
+		}
+		view, err := cart.view(ctx)
+		if err != nil {
+			cartError(c, err)
+			return
+		}
+		view.Cart_Token = cart.token
+		c.IndentedJSON(200, gin.H{"acknowledged": changes, "cart": view})
+	}
+}
@@ -0,0 +1,126 @@
+package controllers
+
+import (
+	"ecommerce/models"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMergeLines(t *testing.T) {
+	small, large := oid(2), oid(3)
+	cases := []struct {
+		name       string
+		items      []models.ProductUser
+		quantities []int
+		changed    bool
+	}{
+		{"already merged", []models.ProductUser{{Product_ID: oid(1), Quantity: 2}, {Product_ID: oid(1), Variant_ID: &small, Quantity: 1}}, []int{2, 1}, false},
+		//carts from before quantities held one line per unit
+		{"one line per unit", []models.ProductUser{{Product_ID: oid(1)}, {Product_ID: oid(4)}, {Product_ID: oid(1)}}, []int{2, 1}, true},
+		{"variants stay apart", []models.ProductUser{{Product_ID: oid(1), Variant_ID: &small}, {Product_ID: oid(1), Variant_ID: &large}, {Product_ID: oid(1), Variant_ID: &small, Quantity: 3}}, []int{4, 1}, true},
+		{"repeated lines with quantities", []models.ProductUser{{Product_ID: oid(1), Quantity: 2}, {Product_ID: oid(1), Quantity: 3}}, []int{5}, true},
+		{"empty", nil, []int{}, false},
+	}
+	for _, c := range cases {
+		merged, changed := mergeLines(c.items)
+		if changed != c.changed || len(merged) != len(c.quantities) {
+			t.Errorf("%s: got %+v changed %v", c.name, merged, changed)
+			continue
+		}
+		for i, line := range merged {
+			if line.Quantity != c.quantities[i] {
+				t.Errorf("%s: line %d holds %d, want %d", c.name, i, line.Quantity, c.quantities[i])
+			}
+		}
+	}
+}
+
+func TestMaxQuantity(t *testing.T) {
+	low, high := 5, CartMaxQuantity+1
+	if got := maxQuantity(models.Product{}); got != CartMaxQuantity {
+		t.Errorf("without a limit got %d", got)
+	}
+	if got := maxQuantity(models.Product{Max_Quantity: &low}); got != 5 {
+		t.Errorf("with a lower limit got %d", got)
+	}
+	if got := maxQuantity(models.Product{Max_Quantity: &high}); got != CartMaxQuantity {
+		t.Errorf("with a higher limit got %d", got)
+	}
+}
+
+func TestCartEntry(t *testing.T) {
+	small, medium := oid(2), oid(3)
+	sku := "TEE-M"
+	lines := []models.ProductUser{
+		{Product_ID: oid(1), Variant_ID: &small},
+		{Product_ID: oid(1), Variant_ID: &medium, SKU: &sku},
+		{Product_ID: oid(4)},
+	}
+	cases := []struct {
+		product primitive.ObjectID
+		variant string
+		want    *primitive.ObjectID
+		err     error
+	}{
+		{oid(1), small.Hex(), &small, nil},
+		{oid(1), "tee-m", &medium, nil},
+		{oid(4), "", nil, nil},
+		{oid(1), "", nil, ErrVariantRequired},
+		{oid(1), "TEE-L", nil, ErrNotInCart},
+		{oid(5), "", nil, ErrNotInCart},
+	}
+	for _, c := range cases {
+		line, err := cartEntry(lines, c.product, c.variant)
+		if err != c.err {
+			t.Errorf("%s %q: got %v, want %v", c.product.Hex(), c.variant, err, c.err)
+			continue
+		}
+		if err == nil && (line.Product_ID != c.product || !sameVariantID(line.Variant_ID, c.want)) {
+			t.Errorf("%s %q: found %+v", c.product.Hex(), c.variant, line)
+		}
+	}
+}
+
+func TestSameChanges(t *testing.T) {
+	pencil := primitive.NewObjectID()
+	pen := primitive.NewObjectID()
+	blue := primitive.NewObjectID()
+	current := []cartChange{
+		{Type: ChangePrice, Product_ID: pencil, Old_Price: 5, New_Price: 6},
+		{Type: ChangeQuantity, Product_ID: pen, Variant_ID: &blue, Quantity: 12, Max_Quantity: 10},
+	}
+	cases := []struct {
+		name string
+		seen []cartChange
+		same bool
+	}{
+		{"the changes as shown", []cartChange{
+			{Type: ChangeQuantity, Product_ID: pen, Variant_ID: &blue, Max_Quantity: 10},
+			{Type: ChangePrice, Product_ID: pencil, New_Price: 6},
+		}, true},
+		{"the price moved again", []cartChange{
+			{Type: ChangePrice, Product_ID: pencil, New_Price: 7},
+			{Type: ChangeQuantity, Product_ID: pen, Variant_ID: &blue, Max_Quantity: 10},
+		}, false},
+		{"another variant", []cartChange{
+			{Type: ChangePrice, Product_ID: pencil, New_Price: 6},
+			{Type: ChangeQuantity, Product_ID: pen, Max_Quantity: 10},
+		}, false},
+		{"a change was not shown", []cartChange{
+			{Type: ChangePrice, Product_ID: pencil, New_Price: 6},
+		}, false},
+		{"one change sent twice", []cartChange{
+			{Type: ChangePrice, Product_ID: pencil, New_Price: 6},
+			{Type: ChangePrice, Product_ID: pencil, New_Price: 6},
+		}, false},
+	}
+	for _, c := range cases {
+		if got := sameChanges(c.seen, current); got != c.same {
+			t.Errorf("%s: sameChanges = %v, want %v", c.name, got, c.same)
+		}
+	}
+	if !sameChanges(nil, []cartChange{}) {
+		t.Error("no changes shown and none made should agree")
+	}
+}
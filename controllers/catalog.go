@@ -0,0 +1,264 @@
+package controllers
+
+import (
+	"context"
+	"ecommerce/database"
+	"ecommerce/models"
+	"ecommerce/search"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ProductSearch answers /users/search, SEARCH_ENGINE picks the implementation
+var ProductSearch = search.New(ProductCollection)
+
+// ProductSuggest answers /users/search/suggest from memory, reloading every
+// SEARCH_REFRESH (10 minutes when not set) for what other servers changed
+var ProductSuggest = search.NewSuggester(ProductCollection, database.UserData(database.Client, "SearchQueries"), suggestRefresh())
+
+func suggestRefresh() time.Duration {
+	refresh, err := time.ParseDuration(os.Getenv("SEARCH_REFRESH"))
+	if err != nil || refresh <= 0 {
+		return 10 * time.Minute
+	}
+	return refresh
+}
+
+// indexProduct hands a new or changed product to the search and the
+// suggestions, a failure there must not fail the change itself
+func indexProduct(ctx context.Context, product models.Product) {
+	if err := ProductSearch.Index(ctx, product); err != nil {
+		log.Println("search index:", err)
+	}
+	if err := ProductSuggest.Index(ctx, product); err != nil {
+		log.Println("suggest index:", err)
+	}
+}
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// the sorts the product listing knows, a - in front sorts descending.
+// newest sorts on the id, object ids start with the time they were made
+// so products from before created_at existed sort right too
+var productSorts = map[string]struct {
+	field string
+	order int
+}{
+	"price":   {"price", 1},
+	"-price":  {"price", -1},
+	"rating":  {"rating", 1},
+	"-rating": {"rating", -1},
+	"name":    {"product_name", 1},
+	"-name":   {"product_name", -1},
+	"newest":  {"_id", -1},
+	"oldest":  {"_id", 1},
+}
+
+// pageCursor points just past the last product of a page, it is handed out
+// base64 encoded so clients treat it as an opaque string
+type pageCursor struct {
+	Sort  string             `json:"s"`
+	Value interface{}        `json:"v"`
+	ID    primitive.ObjectID `json:"id"`
+}
+
+type productListing struct {
+	Filter search.Filter
+	Sort   string
+	Limit  int64
+	Offset int64
+	Cursor *pageCursor
+}
+
+func queryUint(c *gin.Context, key string) (*uint64, error) {
+	value := c.Query(key)
+	if value == "" {
+		return nil, nil
+	}
+	number, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return nil, errors.New(key + " must be a whole positive number")
+	}
+	return &number, nil
+}
+
+// parseListing reads paging, sorting and filters from the query string
+// http://localhost:8000/users/productview?sort=-price&min_price=100&max_price=900&min_rating=4&category=laptops,phones&limit=20&offset=40
+func parseListing(c *gin.Context) (productListing, error) {
+	listing := productListing{Sort: c.DefaultQuery("sort", "newest")}
+	if _, ok := productSorts[listing.Sort]; !ok {
+		return listing, errors.New("sort must be one of price, -price, rating, -rating, name, -name, newest or oldest")
+	}
+	var err error
+	if listing.Limit, listing.Offset, err = parsePage(c); err != nil {
+		return listing, err
+	}
+	if cursor := c.Query("cursor"); cursor != "" {
+		if c.Query("offset") != "" {
+			return listing, errors.New("use either cursor or offset, not both")
+		}
+		if listing.Cursor, err = decodeCursor(cursor, listing.Sort); err != nil {
+			return listing, err
+		}
+	}
+	listing.Filter, err = parseFilter(c)
+	return listing, err
+}
+
+// parseFilter reads the filters shared by the product listing and the search,
+// categories still have to be widened to their subcategories with expandCategories
+func parseFilter(c *gin.Context) (search.Filter, error) {
+	var filter search.Filter
+	var err error
+	if filter.Min_Price, err = queryUint(c, "min_price"); err != nil {
+		return filter, err
+	}
+	if filter.Max_Price, err = queryUint(c, "max_price"); err != nil {
+		return filter, err
+	}
+	if filter.Min_Price != nil && filter.Max_Price != nil && *filter.Min_Price > *filter.Max_Price {
+		return filter, errors.New("min_price is higher than max_price")
+	}
+	if filter.Min_Rating, err = queryUint(c, "min_rating"); err != nil {
+		return filter, err
+	}
+	if category := c.Query("category"); category != "" {
+		for _, name := range strings.Split(category, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				filter.Categories = append(filter.Categories, name)
+			}
+		}
+	}
+	return filter, nil
+}
+
+// parsePage reads limit and offset
+func parsePage(c *gin.Context) (int64, int64, error) {
+	limit, err := queryUint(c, "limit")
+	if err != nil {
+		return 0, 0, err
+	}
+	if limit == nil {
+		size := uint64(defaultPageSize)
+		limit = &size
+	}
+	if *limit == 0 || *limit > maxPageSize {
+		return 0, 0, errors.New("limit must be between 1 and " + strconv.Itoa(maxPageSize))
+	}
+	offset, err := queryUint(c, "offset")
+	if err != nil {
+		return 0, 0, err
+	}
+	if offset == nil {
+		return int64(*limit), 0, nil
+	}
+	return int64(*limit), int64(*offset), nil
+}
+
+func encodeCursor(cursor pageCursor) string {
+	raw, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(value string, sort string) (*pageCursor, error) {
+	var cursor pageCursor
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err == nil {
+		err = json.Unmarshal(raw, &cursor)
+	}
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	switch cursor.Value.(type) {
+	case nil, float64, string:
+	default:
+		return nil, errors.New("invalid cursor")
+	}
+	if cursor.Sort != sort {
+		return nil, errors.New("the cursor belongs to a listing sorted by " + cursor.Sort)
+	}
+	return &cursor, nil
+}
+
+// after is the filter for everything behind the cursor. Products missing the
+// sort field (old products without a rating) come first ascending and last
+// descending, the id breaks ties so no product is skipped or shown twice.
+func (listing productListing) after() bson.M {
+	sort := productSorts[listing.Sort]
+	cursor := listing.Cursor
+	beyond := "$gt"
+	if sort.order < 0 {
+		beyond = "$lt"
+	}
+	if sort.field == "_id" {
+		return bson.M{"_id": bson.M{beyond: cursor.ID}}
+	}
+	if cursor.Value == nil {
+		next := bson.A{bson.M{sort.field: nil, "_id": bson.M{beyond: cursor.ID}}}
+		if sort.order > 0 {
+			next = append(next, bson.M{sort.field: bson.M{"$ne": nil}})
+		}
+		return bson.M{"$or": next}
+	}
+	next := bson.A{
+		bson.M{sort.field: bson.M{beyond: cursor.Value}},
+		bson.M{sort.field: cursor.Value, "_id": bson.M{beyond: cursor.ID}},
+	}
+	if sort.order < 0 {
+		next = append(next, bson.M{sort.field: nil})
+	}
+	return bson.M{"$or": next}
+}
+
+// query is the filter and options for one page, one product more than the page
+// is asked for to know whether there is a next page
+func (listing productListing) query() (bson.M, *options.FindOptions) {
+	sort := productSorts[listing.Sort]
+	filter := listing.Filter.BSON()
+	if listing.Cursor != nil {
+		filter = bson.M{"$and": bson.A{filter, listing.after()}}
+	}
+	order := bson.D{{Key: sort.field, Value: sort.order}}
+	if sort.field != "_id" {
+		order = append(order, bson.E{Key: "_id", Value: sort.order})
+	}
+	opts := options.Find().SetSort(order).SetLimit(listing.Limit + 1)
+	if listing.Offset > 0 {
+		opts.SetSkip(listing.Offset)
+	}
+	return filter, opts
+}
+
+// cursorAfter builds the cursor pointing past the given product
+func (listing productListing) cursorAfter(product models.Product) string {
+	cursor := pageCursor{Sort: listing.Sort, ID: product.Product_ID}
+	switch productSorts[listing.Sort].field {
+	case "price":
+		if product.Price != nil {
+			cursor.Value = *product.Price
+		}
+	case "rating":
+		if product.Rating != nil {
+			cursor.Value = *product.Rating
+		}
+	case "product_name":
+		if product.Product_Name != nil {
+			cursor.Value = *product.Product_Name
+		}
+	}
+	return encodeCursor(cursor)
+}
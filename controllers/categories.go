@@ -0,0 +1,726 @@
+package controllers
+
+import (
+	"context"
+	"ecommerce/database"
+	"ecommerce/models"
+	"ecommerce/search"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var CategoryCollection *mongo.Collection = database.ProductData(database.Client, "Categories")
+
+var ErrCategoryNotFound = errors.New("category not found")
+
+var ErrCategoryCycle = errors.New("a category can not be moved below itself")
+
+var categoryIndexOnce sync.Once
+
+// slugs have to be unique, the index is made the first time a category is saved
+func ensureCategoryIndex(ctx context.Context) {
+	categoryIndexOnce.Do(func() {
+		index := mongo.IndexModel{Keys: bson.M{"slug": 1}, Options: options.Index().SetUnique(true)}
+		if _, err := CategoryCollection.Indexes().CreateOne(ctx, index); err != nil {
+			log.Println("category slug index:", err)
+		}
+	})
+}
+
+// reindexProducts hands products changed behind the search's back to it again
+func reindexProducts(ctx context.Context, filter bson.M) error {
+	cursor, err := ProductCollection.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	var products []models.Product
+	if err := cursor.All(ctx, &products); err != nil {
+		return err
+	}
+	for _, product := range products {
+		indexProduct(ctx, product)
+	}
+	return nil
+}
+
+func sameParent(a *primitive.ObjectID, b *primitive.ObjectID) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// slugify turns "Crème & Cakes" into "creme-cakes"
+func slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range search.Fold(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+	return b.String()
+}
+
+// the whole tree is small enough to load for every request that needs it
+type categoryIndex struct {
+	byID   map[primitive.ObjectID]*models.Category
+	bySlug map[string]*models.Category
+	all    []*models.Category
+}
+
+func loadCategories(ctx context.Context) (categoryIndex, error) {
+	index := categoryIndex{byID: make(map[primitive.ObjectID]*models.Category), bySlug: make(map[string]*models.Category)}
+	cursor, err := CategoryCollection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "position", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return index, err
+	}
+	var categories []models.Category
+	if err := cursor.All(ctx, &categories); err != nil {
+		return index, err
+	}
+	for i := range categories {
+		category := &categories[i]
+		index.byID[category.Category_ID] = category
+		index.bySlug[category.Slug] = category
+		index.all = append(index.all, category)
+	}
+	return index, nil
+}
+
+// tree nests the categories under their parents, siblings in position order
+func (index categoryIndex) tree() []*models.Category {
+	roots := make([]*models.Category, 0)
+	for _, category := range index.all {
+		category.Children = nil
+	}
+	for _, category := range index.all {
+		if category.Parent == nil || index.byID[*category.Parent] == nil {
+			roots = append(roots, category)
+			continue
+		}
+		parent := index.byID[*category.Parent]
+		parent.Children = append(parent.Children, category)
+	}
+	return roots
+}
+
+// subtree is the slug with the slugs of every category below it
+func (index categoryIndex) subtree(slug string) ([]string, bool) {
+	top, ok := index.bySlug[slug]
+	if !ok {
+		return nil, false
+	}
+	slugs := []string{top.Slug}
+	for _, category := range index.all {
+		for _, ancestor := range category.Ancestors {
+			if ancestor == top.Category_ID {
+				slugs = append(slugs, category.Slug)
+				break
+			}
+		}
+	}
+	return slugs, true
+}
+
+// trail is the path from the root down to the category
+func (index categoryIndex) trail(category *models.Category) []models.Breadcrumb {
+	trail := make([]models.Breadcrumb, 0, len(category.Ancestors)+1)
+	for _, id := range category.Ancestors {
+		if ancestor, ok := index.byID[id]; ok {
+			trail = append(trail, models.Breadcrumb{Category_ID: ancestor.Category_ID, Name: *ancestor.Name, Slug: ancestor.Slug})
+		}
+	}
+	return append(trail, models.Breadcrumb{Category_ID: category.Category_ID, Name: *category.Name, Slug: category.Slug})
+}
+
+// addBreadcrumbs gives every product one trail per category it is in
+func addBreadcrumbs(ctx context.Context, products []models.Product) error {
+	index, err := loadCategories(ctx)
+	if err != nil {
+		return err
+	}
+	for i := range products {
+		products[i].Breadcrumbs = nil
+		for _, slug := range products[i].Categories {
+			if category, ok := index.bySlug[slug]; ok {
+				products[i].Breadcrumbs = append(products[i].Breadcrumbs, index.trail(category))
+			}
+		}
+	}
+	return nil
+}
+
+// expandCategories widens a category filter to the categories below it
+func expandCategories(ctx context.Context, filter *search.Filter) error {
+	if len(filter.Categories) == 0 {
+		return nil
+	}
+	index, err := loadCategories(ctx)
+	if err != nil {
+		return err
+	}
+	expanded := make([]string, 0)
+	for _, slug := range filter.Categories {
+		slugs, ok := index.subtree(slug)
+		if !ok {
+			return ErrCategoryNotFound
+		}
+		expanded = append(expanded, slugs...)
+	}
+	filter.Categories = expanded
+	return nil
+}
+
+// checkCategories makes sure a product is only put in categories that exist
+func checkCategories(ctx context.Context, slugs []string) error {
+	if len(slugs) == 0 {
+		return nil
+	}
+	count, err := CategoryCollection.CountDocuments(ctx, bson.M{"slug": bson.M{"$in": slugs}})
+	if err != nil {
+		return err
+	}
+	unique := make(map[string]bool)
+	for _, slug := range slugs {
+		unique[slug] = true
+	}
+	if int(count) != len(unique) {
+		return ErrCategoryNotFound
+	}
+	return nil
+}
+
+// siblings are the categories under parent in position order, leaving out skip
+func siblings(ctx context.Context, parent *primitive.ObjectID, skip primitive.ObjectID) ([]primitive.ObjectID, error) {
+	var children []models.Category
+	filter := bson.M{"parent_id": parent, "_id": bson.M{"$ne": skip}}
+	opts := options.Find().SetSort(bson.D{{Key: "position", Value: 1}, {Key: "_id", Value: 1}}).SetProjection(bson.M{"_id": 1})
+	cursor, err := CategoryCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &children); err != nil {
+		return nil, err
+	}
+	ids := make([]primitive.ObjectID, len(children))
+	for i, child := range children {
+		ids[i] = child.Category_ID
+	}
+	return ids, nil
+}
+
+// setPositions numbers the categories 0, 1, 2... in the order given
+func setPositions(ctx context.Context, ids []primitive.ObjectID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	updates := make([]mongo.WriteModel, len(ids))
+	for i, id := range ids {
+		updates[i] = mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": id}).SetUpdate(bson.M{"$set": bson.M{"position": i}})
+	}
+	_, err := CategoryCollection.BulkWrite(ctx, updates)
+	return err
+}
+
+// placeCategory puts the category at position among its new siblings, a
+// position past the end or below zero puts it last
+func placeCategory(ctx context.Context, parent *primitive.ObjectID, id primitive.ObjectID, position *int) error {
+	others, err := siblings(ctx, parent, id)
+	if err != nil {
+		return err
+	}
+	at := len(others)
+	if position != nil && *position >= 0 && *position < len(others) {
+		at = *position
+	}
+	ordered := append(append(append(make([]primitive.ObjectID, 0, len(others)+1), others[:at]...), id), others[at:]...)
+	return setPositions(ctx, ordered)
+}
+
+func categoryParam(c *gin.Context) (primitive.ObjectID, bool) {
+	categoryt_id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.Header("Content-Type", "application/json")
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invalid category id"})
+		c.Abort()
+		return categoryt_id, false
+	}
+	return categoryt_id, true
+}
+
+func findCategory(ctx context.Context, filter bson.M) (models.Category, error) {
+	var category models.Category
+	err := CategoryCollection.FindOne(ctx, filter).Decode(&category)
+	if err == mongo.ErrNoDocuments {
+		return category, ErrCategoryNotFound
+	}
+	return category, err
+}
+
+// parentCategory reads an optional parent id, an empty one means the root
+func parentCategory(ctx context.Context, parent_id *string) (*models.Category, error) {
+	if parent_id == nil || *parent_id == "" {
+		return nil, nil
+	}
+	parentt_id, err := primitive.ObjectIDFromHex(*parent_id)
+	if err != nil {
+		return nil, ErrCategoryNotFound
+	}
+	parent, err := findCategory(ctx, bson.M{"_id": parentt_id})
+	if err != nil {
+		return nil, err
+	}
+	return &parent, nil
+}
+
+// ancestorsUnder is the path of a category moved below parent, a nil parent makes it a root
+func ancestorsUnder(categoryt_id primitive.ObjectID, parent *models.Category) ([]primitive.ObjectID, error) {
+	ancestors := make([]primitive.ObjectID, 0)
+	if parent == nil {
+		return ancestors, nil
+	}
+	ancestors = append(append(ancestors, parent.Ancestors...), parent.Category_ID)
+	for _, id := range ancestors {
+		if id == categoryt_id {
+			return nil, ErrCategoryCycle
+		}
+	}
+	return ancestors, nil
+}
+
+// rebase swaps the part of a descendant's path above the moved category for its new ancestors
+func rebase(path []primitive.ObjectID, categoryt_id primitive.ObjectID, ancestors []primitive.ObjectID) []primitive.ObjectID {
+	rebased := append(append([]primitive.ObjectID{}, ancestors...), categoryt_id)
+	for i, id := range path {
+		if id == categoryt_id {
+			return append(rebased, path[i+1:]...)
+		}
+	}
+	return rebased
+}
+
+func categoryError(c *gin.Context, err error) {
+	switch {
+	case err == ErrCategoryNotFound:
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case err == ErrCategoryCycle:
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case mongo.IsDuplicateKeyError(err):
+		c.IndentedJSON(http.StatusConflict, gin.H{"error": "a category with this slug already exists"})
+	default:
+		c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+	}
+}
+
+//public function listing the whole category tree for navigation menus
+//GET request : http://localhost:8000/users/categories
+
+func ListCategories() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		index, err := loadCategories(ctx)
+		if err != nil {
+			categoryError(c, err)
+			return
+		}
+		c.IndentedJSON(200, index.tree())
+	}
+}
+
+//public function showing one category with its breadcrumbs and the categories right below it
+//its products are listed by http://localhost:8000/users/productview?category=laptops which includes the categories below
+//GET request : http://localhost:8000/users/categories/laptops
+
+func GetCategory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		index, err := loadCategories(ctx)
+		if err != nil {
+			categoryError(c, err)
+			return
+		}
+		index.tree()
+		category, ok := index.bySlug[c.Param("slug")]
+		if !ok {
+			categoryError(c, ErrCategoryNotFound)
+			return
+		}
+		c.IndentedJSON(200, gin.H{"category": category, "breadcrumbs": index.trail(category)})
+	}
+}
+
+//admin function adding a category, without a slug one is made from the name
+//without a parent_id it is a top level category, without a position it goes last
+//POST request : http://localhost:8000/admin/categories
+/*
+{
+"name"      : "Gaming Laptops",
+"slug"      : "gaming-laptops",
+"parent_id" : "xxxxxxcategory_idxxxxxx",
+"position"  : 0
+}
+*/
+
+type categoryRequest struct {
+	Name      *string `json:"name"`
+	Slug      *string `json:"slug"`
+	Parent_ID *string `json:"parent_id"`
+	Position  *int    `json:"position"`
+}
+
+func AddCategory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request categoryRequest
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		ensureCategoryIndex(ctx)
+		var category models.Category
+		category.Category_ID = primitive.NewObjectID()
+		if request.Name != nil {
+			name := strings.TrimSpace(*request.Name)
+			category.Name = &name
+		}
+		if err := Validate.Struct(category); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		category.Slug = slugify(*category.Name)
+		if request.Slug != nil {
+			category.Slug = slugify(*request.Slug)
+		}
+		if category.Slug == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the slug needs at least one letter or digit"})
+			return
+		}
+		parent, err := parentCategory(ctx, request.Parent_ID)
+		if err != nil {
+			categoryError(c, err)
+			return
+		}
+		category.Ancestors = make([]primitive.ObjectID, 0)
+		if parent != nil {
+			category.Parent = &parent.Category_ID
+			category.Ancestors = append(append(category.Ancestors, parent.Ancestors...), parent.Category_ID)
+		}
+		others, err := siblings(ctx, category.Parent, category.Category_ID)
+		if err != nil {
+			categoryError(c, err)
+			return
+		}
+		category.Position = len(others)
+		category.Created_At = time.Now()
+		category.Updated_At = category.Created_At
+		if _, err := CategoryCollection.InsertOne(ctx, category); err != nil {
+			categoryError(c, err)
+			return
+		}
+		if request.Position != nil {
+			if err := placeCategory(ctx, category.Parent, category.Category_ID, request.Position); err != nil {
+				categoryError(c, err)
+				return
+			}
+		}
+		c.IndentedJSON(http.StatusCreated, category)
+	}
+}
+
+//admin function renaming a category, a new slug is carried over to the products in it
+//PUT request : http://localhost:8000/admin/categories/xxxxxxcategory_idxxxxxx
+/*
+{
+"name" : "Gaming Notebooks",
+"slug" : "gaming-notebooks"
+}
+*/
+
+func UpdateCategory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		categoryt_id, ok := categoryParam(c)
+		if !ok {
+			return
+		}
+		var request categoryRequest
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		ensureCategoryIndex(ctx)
+		category, err := findCategory(ctx, bson.M{"_id": categoryt_id})
+		if err != nil {
+			categoryError(c, err)
+			return
+		}
+		oldslug := category.Slug
+		if request.Name != nil {
+			name := strings.TrimSpace(*request.Name)
+			category.Name = &name
+		}
+		if err := Validate.Struct(category); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if request.Slug != nil {
+			if category.Slug = slugify(*request.Slug); category.Slug == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "the slug needs at least one letter or digit"})
+				return
+			}
+		}
+		category.Updated_At = time.Now()
+		update := bson.M{"$set": bson.M{"name": category.Name, "slug": category.Slug, "updated_at": category.Updated_At}}
+		if _, err := CategoryCollection.UpdateOne(ctx, bson.M{"_id": categoryt_id}, update); err != nil {
+			categoryError(c, err)
+			return
+		}
+		if category.Slug != oldslug {
+			_, err := ProductCollection.UpdateMany(ctx, bson.M{"categories": oldslug}, bson.M{"$set": bson.M{"categories.$": category.Slug}})
+			if err == nil {
+				err = reindexProducts(ctx, bson.M{"categories": category.Slug})
+			}
+			if err != nil {
+				categoryError(c, err)
+				return
+			}
+		}
+		c.IndentedJSON(200, category)
+	}
+}
+
+//admin function moving a category with everything below it to another parent and/or position
+//a null or empty parent_id makes it a top level category, keeping the parent only reorders it
+//PUT request : http://localhost:8000/admin/categories/xxxxxxcategory_idxxxxxx/move
+/*
+{
+"parent_id" : "xxxxxxcategory_idxxxxxx",
+"position"  : 2
+}
+*/
+
+func MoveCategory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		categoryt_id, ok := categoryParam(c)
+		if !ok {
+			return
+		}
+		var request categoryRequest
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		category, err := findCategory(ctx, bson.M{"_id": categoryt_id})
+		if err != nil {
+			categoryError(c, err)
+			return
+		}
+		parent, err := parentCategory(ctx, request.Parent_ID)
+		if err != nil {
+			categoryError(c, err)
+			return
+		}
+		ancestors, err := ancestorsUnder(categoryt_id, parent)
+		if err != nil {
+			categoryError(c, err)
+			return
+		}
+		var parentt_id *primitive.ObjectID
+		if parent != nil {
+			parentt_id = &parent.Category_ID
+		}
+		update := bson.M{"$set": bson.M{"parent_id": parentt_id, "ancestors": ancestors, "updated_at": time.Now()}}
+		if _, err := CategoryCollection.UpdateOne(ctx, bson.M{"_id": categoryt_id}, update); err != nil {
+			categoryError(c, err)
+			return
+		}
+		//everything below keeps its path under the category, only the part above it changes
+		var descendants []models.Category
+		cursor, err := CategoryCollection.Find(ctx, bson.M{"ancestors": categoryt_id})
+		if err == nil {
+			err = cursor.All(ctx, &descendants)
+		}
+		if err != nil {
+			categoryError(c, err)
+			return
+		}
+		updates := make([]mongo.WriteModel, 0, len(descendants))
+		for _, descendant := range descendants {
+			path := rebase(descendant.Ancestors, categoryt_id, ancestors)
+			updates = append(updates, mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": descendant.Category_ID}).SetUpdate(bson.M{"$set": bson.M{"ancestors": path}}))
+		}
+		if len(updates) > 0 {
+			if _, err := CategoryCollection.BulkWrite(ctx, updates); err != nil {
+				categoryError(c, err)
+				return
+			}
+		}
+		if err := placeCategory(ctx, parentt_id, categoryt_id, request.Position); err != nil {
+			categoryError(c, err)
+			return
+		}
+		if !sameParent(category.Parent, parentt_id) {
+			//close the gap left under the old parent
+			left, err := siblings(ctx, category.Parent, categoryt_id)
+			if err == nil {
+				err = setPositions(ctx, left)
+			}
+			if err != nil {
+				categoryError(c, err)
+				return
+			}
+		}
+		category, err = findCategory(ctx, bson.M{"_id": categoryt_id})
+		if err != nil {
+			categoryError(c, err)
+			return
+		}
+		c.IndentedJSON(200, category)
+	}
+}
+
+//admin function putting all categories under one parent in a new order, every one of them has to be listed
+//PUT request : http://localhost:8000/admin/categories/reorder
+/*
+{
+"parent_id" : "xxxxxxcategory_idxxxxxx",
+"order"     : ["xxxcategory_idxxx", "xxxcategory_idxxx", "xxxcategory_idxxx"]
+}
+*/
+
+func ReorderCategories() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request struct {
+			Parent_ID *string  `json:"parent_id"`
+			Order     []string `json:"order"`
+		}
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		parent, err := parentCategory(ctx, request.Parent_ID)
+		if err != nil {
+			categoryError(c, err)
+			return
+		}
+		var parentt_id *primitive.ObjectID
+		if parent != nil {
+			parentt_id = &parent.Category_ID
+		}
+		children, err := siblings(ctx, parentt_id, primitive.NilObjectID)
+		if err != nil {
+			categoryError(c, err)
+			return
+		}
+		current := make(map[primitive.ObjectID]bool)
+		for _, id := range children {
+			current[id] = true
+		}
+		order := make([]primitive.ObjectID, 0, len(request.Order))
+		for _, hex := range request.Order {
+			id, err := primitive.ObjectIDFromHex(hex)
+			if err != nil || !current[id] {
+				c.JSON(http.StatusBadRequest, gin.H{"error": hex + " is not a category under this parent"})
+				return
+			}
+			delete(current, id)
+			order = append(order, id)
+		}
+		if len(current) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "every category under the parent has to be in the order"})
+			return
+		}
+		if err := setPositions(ctx, order); err != nil {
+			categoryError(c, err)
+			return
+		}
+		c.IndentedJSON(200, "Successfully reordered the categories")
+	}
+}
+
+//admin function deleting a category that has nothing below it, its products simply leave it
+//DELETE request : http://localhost:8000/admin/categories/xxxxxxcategory_idxxxxxx
+
+func DeleteCategory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		categoryt_id, ok := categoryParam(c)
+		if !ok {
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		category, err := findCategory(ctx, bson.M{"_id": categoryt_id})
+		if err != nil {
+			categoryError(c, err)
+			return
+		}
+		children, err := CategoryCollection.CountDocuments(ctx, bson.M{"parent_id": categoryt_id})
+		if err != nil {
+			categoryError(c, err)
+			return
+		}
+		if children > 0 {
+			c.IndentedJSON(http.StatusConflict, gin.H{"error": "move or delete the categories below it first"})
+			return
+		}
+		if _, err := CategoryCollection.DeleteOne(ctx, bson.M{"_id": categoryt_id}); err != nil {
+			categoryError(c, err)
+			return
+		}
+		var members []models.Product
+		cursor, err := ProductCollection.Find(ctx, bson.M{"categories": category.Slug}, options.Find().SetProjection(bson.M{"_id": 1}))
+		if err == nil {
+			err = cursor.All(ctx, &members)
+		}
+		if err != nil {
+			categoryError(c, err)
+			return
+		}
+		if _, err := ProductCollection.UpdateMany(ctx, bson.M{"categories": category.Slug}, bson.M{"$pull": bson.M{"categories": category.Slug}}); err != nil {
+			categoryError(c, err)
+			return
+		}
+		ids := make([]primitive.ObjectID, len(members))
+		for i, member := range members {
+			ids[i] = member.Product_ID
+		}
+		if err := reindexProducts(ctx, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+			categoryError(c, err)
+			return
+		}
+		left, err := siblings(ctx, category.Parent, categoryt_id)
+		if err == nil {
+			err = setPositions(ctx, left)
+		}
+		if err != nil {
+			categoryError(c, err)
+			return
+		}
+		c.IndentedJSON(200, "Successfully deleted the category")
+	}
+}
@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"ecommerce/models"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func oid(n byte) primitive.ObjectID {
+	var id primitive.ObjectID
+	id[11] = n
+	return id
+}
+
+// testCategory is category n below the given ancestors, the last of them is its parent
+func testCategory(n byte, name string, ancestors ...primitive.ObjectID) *models.Category {
+	category := &models.Category{Category_ID: oid(n), Name: &name, Slug: slugify(name), Ancestors: ancestors}
+	if len(ancestors) > 0 {
+		category.Parent = &ancestors[len(ancestors)-1]
+	}
+	return category
+}
+
+func testIndex(categories ...*models.Category) categoryIndex {
+	index := categoryIndex{byID: make(map[primitive.ObjectID]*models.Category), bySlug: make(map[string]*models.Category)}
+	for _, category := range categories {
+		index.byID[category.Category_ID] = category
+		index.bySlug[category.Slug] = category
+		index.all = append(index.all, category)
+	}
+	return index
+}
+
+// home > kitchen > cookware > pans, and garden on its own
+var (
+	home     = testCategory(1, "Home")
+	kitchen  = testCategory(2, "Kitchen", oid(1))
+	cookware = testCategory(3, "Cookware", oid(1), oid(2))
+	pans     = testCategory(4, "Pans", oid(1), oid(2), oid(3))
+	garden   = testCategory(5, "Garden")
+)
+
+func TestSlugify(t *testing.T) {
+	cases := map[string]string{
+		"Kitchen":         "kitchen",
+		"Crème & Cakes":   "creme-cakes",
+		"  Pots, Pans!  ": "pots-pans",
+		"USB-C Cables 2m": "usb-c-cables-2m",
+		"!!!":             "",
+	}
+	for name, want := range cases {
+		if got := slugify(name); got != want {
+			t.Errorf("slugify(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestAncestorsUnder(t *testing.T) {
+	cases := []struct {
+		name     string
+		category primitive.ObjectID
+		parent   *models.Category
+		want     []primitive.ObjectID
+		err      error
+	}{
+		{"to the top", kitchen.Category_ID, nil, []primitive.ObjectID{}, nil},
+		{"below another root", kitchen.Category_ID, garden, []primitive.ObjectID{oid(5)}, nil},
+		{"up a level", pans.Category_ID, kitchen, []primitive.ObjectID{oid(1), oid(2)}, nil},
+		{"below itself", kitchen.Category_ID, kitchen, nil, ErrCategoryCycle},
+		{"below its own child", kitchen.Category_ID, cookware, nil, ErrCategoryCycle},
+		{"below something further down", home.Category_ID, pans, nil, ErrCategoryCycle},
+	}
+	for _, c := range cases {
+		got, err := ancestorsUnder(c.category, c.parent)
+		if err != c.err || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %v %v, want %v %v", c.name, got, err, c.want, c.err)
+		}
+	}
+}
+
+func TestRebase(t *testing.T) {
+	cases := []struct {
+		name      string
+		path      []primitive.ObjectID
+		moved     primitive.ObjectID
+		ancestors []primitive.ObjectID
+		want      []primitive.ObjectID
+	}{
+		{"child of the moved category", cookware.Ancestors, kitchen.Category_ID, []primitive.ObjectID{oid(5)}, []primitive.ObjectID{oid(5), oid(2)}},
+		{"further down", pans.Ancestors, kitchen.Category_ID, []primitive.ObjectID{oid(5)}, []primitive.ObjectID{oid(5), oid(2), oid(3)}},
+		{"moved to the top", pans.Ancestors, kitchen.Category_ID, []primitive.ObjectID{}, []primitive.ObjectID{oid(2), oid(3)}},
+		{"moved down a branch", pans.Ancestors, cookware.Category_ID, []primitive.ObjectID{oid(5), oid(6)}, []primitive.ObjectID{oid(5), oid(6), oid(3)}},
+	}
+	for _, c := range cases {
+		path := append([]primitive.ObjectID{}, c.path...)
+		if got := rebase(path, c.moved, c.ancestors); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+		if !reflect.DeepEqual(path, c.path) {
+			t.Errorf("%s: the old path changed to %v", c.name, path)
+		}
+	}
+}
+
+func TestCategoryIndex(t *testing.T) {
+	index := testIndex(home, kitchen, cookware, pans, garden)
+	roots := index.tree()
+	if len(roots) != 2 || roots[0] != home || roots[1] != garden {
+		t.Fatalf("got roots %v", roots)
+	}
+	if len(home.Children) != 1 || home.Children[0] != kitchen || len(cookware.Children) != 1 || cookware.Children[0] != pans {
+		t.Errorf("the tree is not nested: %v %v", home.Children, cookware.Children)
+	}
+	slugs, ok := index.subtree("kitchen")
+	if !ok || !reflect.DeepEqual(slugs, []string{"kitchen", "cookware", "pans"}) {
+		t.Errorf("subtree of kitchen is %v", slugs)
+	}
+	if _, ok := index.subtree("toys"); ok {
+		t.Error("found a subtree for a category that does not exist")
+	}
+	trail := index.trail(pans)
+	names := make([]string, len(trail))
+	for i, crumb := range trail {
+		names[i] = crumb.Name
+	}
+	if !reflect.DeepEqual(names, []string{"Home", "Kitchen", "Cookware", "Pans"}) {
+		t.Errorf("trail of pans is %v", names)
+	}
+}
@@ -0,0 +1,156 @@
+package controllers
+
+import (
+	"ecommerce/addressing"
+	"ecommerce/models"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+//checkout takes the addresses either by id from the address book or inline
+//query parameters work for the plain GET checkout, a json body for the POST one
+//payment is cod (the default) or digital, digital orders hold their stock until they are paid
+//guests checking out also send their email, see guestcheckout.go
+/*
+{
+"shipping_address_id" : "xxxxxxaddress_idxxxxxx",
+"billing_address"     : {"house_name":"jupyterlab","street_name":"notebook","city_name":"mars","pin_code":"685607"},
+"payment"             : "digital"
+}
+*/
+
+type checkoutRequest struct {
+	Shipping_Address_ID string          `json:"shipping_address_id"`
+	Billing_Address_ID  string          `json:"billing_address_id"`
+	Shipping_Address    *models.Address `json:"shipping_address"`
+	Billing_Address     *models.Address `json:"billing_address"`
+	Payment             string          `json:"payment"`
+	Email               string          `json:"email"`
+}
+
+var ErrNoShippingAddress = errors.New("a shipping address is required, add one to the address book or send it with the checkout")
+
+func bindCheckout(c *gin.Context) (checkoutRequest, error) {
+	var request checkoutRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			return request, err
+		}
+	}
+	if request.Shipping_Address_ID == "" {
+		request.Shipping_Address_ID = c.Query("address_id")
+	}
+	if request.Billing_Address_ID == "" {
+		request.Billing_Address_ID = c.Query("billing_address_id")
+	}
+	if request.Payment == "" {
+		request.Payment = c.Query("payment")
+	}
+	if request.Email == "" {
+		request.Email = c.Query("email")
+	}
+	return request, nil
+}
+
+func addressFromBook(user models.User, address_id string) (*models.Address, error) {
+	addresst_id, err := primitive.ObjectIDFromHex(address_id)
+	if err != nil {
+		return nil, errors.New("invalid address id " + address_id)
+	}
+	for _, address := range user.Address_Details {
+		if address.Address_id == addresst_id {
+			snapshot := address
+			return &snapshot, nil
+		}
+	}
+	return nil, errors.New("address " + address_id + " is not in the address book")
+}
+
+// defaultAddress is the address flagged with the given default, or the first one in the book
+func defaultAddress(user models.User, billing bool) *models.Address {
+	for _, address := range user.Address_Details {
+		if (billing && address.Default_Billing) || (!billing && address.Default_Shipping) {
+			snapshot := address
+			return &snapshot
+		}
+	}
+	if billing || len(user.Address_Details) == 0 {
+		return nil
+	}
+	snapshot := user.Address_Details[0]
+	return &snapshot
+}
+
+// checkoutAddresses resolves the shipping and billing address of a new order.
+// The result is a copy, editing the address book later leaves placed orders alone.
+// Without a shipping address the default shipping address is used, without a
+// billing address the default billing address or else the shipping address.
+func checkoutAddresses(request checkoutRequest, user models.User) (*models.Address, *models.Address, error) {
+	var shipping, billing *models.Address
+	var err error
+	switch {
+	case request.Shipping_Address != nil:
+		snapshot := *request.Shipping_Address
+		snapshot.Address_id = primitive.NewObjectID()
+		if err := addressing.Clean(&snapshot); err != nil {
+			return nil, nil, err
+		}
+		shipping = &snapshot
+	case request.Shipping_Address_ID != "":
+		if shipping, err = addressFromBook(user, request.Shipping_Address_ID); err != nil {
+			return nil, nil, err
+		}
+	default:
+		if shipping = defaultAddress(user, false); shipping == nil {
+			return nil, nil, ErrNoShippingAddress
+		}
+	}
+	switch {
+	case request.Billing_Address != nil:
+		snapshot := *request.Billing_Address
+		snapshot.Address_id = primitive.NewObjectID()
+		if err := addressing.Clean(&snapshot); err != nil {
+			return nil, nil, err
+		}
+		billing = &snapshot
+	case request.Billing_Address_ID != "":
+		if billing, err = addressFromBook(user, request.Billing_Address_ID); err != nil {
+			return nil, nil, err
+		}
+	default:
+		if billing = defaultAddress(user, true); billing == nil {
+			snapshot := *shipping
+			billing = &snapshot
+		}
+	}
+	return shipping, billing, nil
+}
+
+// addressError answers with every invalid field so the client can mark them all at once
+/*
+{
+"error"  : "invalid address",
+"fields" : [{"field":"pin_code","message":"\"12\" is not a valid pin code, expected ######"}]
+}
+*/
+func addressError(c *gin.Context, err error) {
+	var invalid *addressing.ValidationError
+	if errors.As(err, &invalid) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "invalid address", "fields": invalid.Fields})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
+
+//function listing the countries we ship to with their address rules so forms can be built from it
+//GET request
+//http://localhost:8000/users/countries
+
+func ListCountries() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.IndentedJSON(200, addressing.Countries())
+	}
+}
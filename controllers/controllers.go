@@ -2,12 +2,18 @@ package controllers
 
 import (
 	"context"
+	"ecommerce/addressing"
 	"ecommerce/database"
+	"ecommerce/inventory"
 	"ecommerce/models"
+	"ecommerce/pricing"
+	"ecommerce/search"
 	generate "ecommerce/tokens"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -15,6 +21,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -47,6 +54,7 @@ func VerifyPassword(userpassword string, givenpassword string) (bool, string) {
 //accept a post request
 //POST Request
 //http://localhost:8000/users/signnup
+//a guest signing up or logging in with the Cart-Token header takes their guest cart along, see guestcart.go
 /*
    "fisrt_name":"joseph",
    "last_name":"hermis",
@@ -70,7 +78,7 @@ func SignUp() gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr})
 			return
 		}
-		count, err := UserCollection.CountDocuments(ctx, bson.M{"email": user.Email})
+		count, err := UserCollection.CountDocuments(ctx, bson.M{"email": user.Email, "guest": bson.M{"$ne": true}})
 		defer cancel()
 		if err != nil {
 			log.Panic(err)
@@ -103,12 +111,18 @@ func SignUp() gin.HandlerFunc {
 		user.UserCart = make([]models.ProductUser, 0)
 		user.Address_Details = make([]models.Address, 0)
 		user.Order_Status = make([]models.Order, 0)
+		//store credit only comes from returns and guests are only made at checkout
+		user.Store_Credit = 0
+		user.Guest = false
 		_, inserterr := UserCollection.InsertOne(ctx, user)
 		if inserterr != nil {
 			msg := fmt.Sprintf("not created")
 			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
 			return
 		}
+		if _, err := mergeGuestCart(c, ctx, user.ID); err != nil {
+			log.Println(err)
+		}
 		defer cancel()
 		c.JSON(http.StatusCreated, "Successfully Signed Up!!")
 	}
@@ -134,7 +148,7 @@ func Login() gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err})
 			return
 		}
-		err := UserCollection.FindOne(ctx, bson.M{"email": user.Email}).Decode(&founduser)
+		err := UserCollection.FindOne(ctx, bson.M{"email": user.Email, "guest": bson.M{"$ne": true}}).Decode(&founduser)
 		defer cancel()
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "login or password incorrect"})
@@ -150,6 +164,15 @@ func Login() gin.HandlerFunc {
 		token, refreshToken, _ := generate.TokenGenerator(*founduser.Email, *founduser.First_Name, *founduser.Last_Name, founduser.User_ID)
 		defer cancel()
 		generate.UpdateAllTokens(token, refreshToken, founduser.User_ID)
+		merged, err := mergeGuestCart(c, ctx, founduser.ID)
+		if err != nil {
+			log.Println(err)
+		}
+		if merged > 0 {
+			if lines, err := userCart(founduser.ID).lines(ctx); err == nil {
+				founduser.UserCart = lines
+			}
+		}
 		c.JSON(http.StatusFound, founduser)
 
 	}
@@ -167,10 +190,13 @@ json
 {
 "product_name" : "pencil"
 "price"        : 98
-"rating"       : 10
 "image"        : "image-url"
 }
 
+images can be uploaded along with the product as multipart form files named
+image, the product json then goes in a form field named product
+curl -F product='{"product_name":"pencil","price":98}' -F image=@pencil.jpg http://localhost:8000/admin/addproduct
+
 
 
 
@@ -180,87 +206,236 @@ func ProductViewerAdmin() gin.HandlerFunc {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		var products models.Product
 		defer cancel()
-		if err := c.BindJSON(&products); err != nil {
+		form, ok := bindNewProduct(c, &products)
+		if !ok {
+			return
+		}
+		if products.Product_Name != nil {
+			name := strings.TrimSpace(*products.Product_Name)
+			products.Product_Name = &name
+		}
+		if err := Validate.Struct(products); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		if err := checkCategories(ctx, products.Categories); err != nil {
+			categoryError(c, err)
+			return
+		}
 		products.Product_ID = primitive.NewObjectID()
+		products.Variants = nil
+		if len(products.Options) > 0 {
+			if err := cleanOptions(products.Options); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			products.Variants = generateVariants(products, products.Options)
+		}
+		if err := checkSKUs(ctx, products); err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if form != nil {
+			if products.Images, ok = uploadImages(c, ctx, products.Product_ID, form); !ok {
+				return
+			}
+			if cover := coverImage(products.Images); cover != nil {
+				products.Image = cover
+			}
+		}
+		//the rating comes from the reviews
+		products.Rating = nil
+		products.Reviews = nil
+		products.Archived = false
+		products.Archived_At = nil
+		products.Version = 1
+		products.Created_At = time.Now()
+		products.Updated_At = products.Created_At
 		_, anyerr := ProductCollection.InsertOne(ctx, products)
 		if anyerr != nil {
+			deleteImageFiles(ctx, products.Images)
 			msg := fmt.Sprintf("Not Created")
 			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
 			return
 		}
 		defer cancel()
+		inventory.RecordEdits(ctx, models.Product{}, products)
+		indexProduct(ctx, products)
 		c.JSON(http.StatusOK, "Successfully added our Product Admin!!")
 	}
 }
 
-// The Function to list all the productsin the database
-//paging will be added and fixed soon
+// The Function to list the products in the database a page at a time
+//pages go by offset (?offset=40) or by the next_cursor of the previous page (?cursor=xxx), cursors do not shift when products are added
+//sorting and filters are described at parseListing
+/*
+{
+"products"    : [...],
+"total"       : 134,
+"limit"       : 20,
+"offset"      : 0,
+"next_cursor" : "eyJzIjoibmV3ZXN0Ii..."
+}
+*/
 
 func SearchProduct() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		listing, err := parseListing(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		var productlist []models.Product
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
-		cursor, err := ProductCollection.Find(ctx, bson.D{{}})
+		if err := expandCategories(ctx, &listing.Filter); err != nil {
+			categoryError(c, err)
+			return
+		}
+		total, err := ProductCollection.CountDocuments(ctx, listing.Filter.BSON())
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, "Someting Went Wrong Please Try After Some Time")
+			return
+		}
+		filter, opts := listing.query()
+		cursor, err := ProductCollection.Find(ctx, filter, opts)
 		if err != nil {
 			c.IndentedJSON(http.StatusInternalServerError, "Someting Went Wrong Please Try After Some Time")
 			return
 		}
-		cursor.All(ctx, &productlist)
 		defer cursor.Close(ctx)
-		if err := cursor.Err(); err != nil {
+		if err := cursor.All(ctx, &productlist); err != nil {
 			c.IndentedJSON(400, "invalid")
 			return
 		}
-		defer cancel()
-		c.IndentedJSON(200, productlist)
-
+		productlist = append(make([]models.Product, 0, len(productlist)), productlist...)
+		response := gin.H{"total": total, "limit": listing.Limit, "next_cursor": nil}
+		if listing.Cursor == nil {
+			response["offset"] = listing.Offset
+		}
+		if int64(len(productlist)) > listing.Limit {
+			productlist = productlist[:listing.Limit]
+			response["next_cursor"] = listing.cursorAfter(productlist[len(productlist)-1])
+		}
+		if err := addBreadcrumbs(ctx, productlist); err != nil {
+			log.Println(err)
+		}
+		response["products"] = productlist
+		c.IndentedJSON(200, response)
 	}
 }
 
-// This is the function to search products based on alphabet name
+// This is the function to search products by words in their name or category
+//case and accents do not matter and the best matches come first, see the search package
+//GET request : http://localhost:8000/users/search?name=pencil&category=stationery&min_price=10&limit=20&offset=0
+/*
+{
+"hits"   : [{"product":{...},"score":2.197,"highlights":{"product_name":"Blue <em>Pencil</em>"}}],
+"total"  : 12,
+"facets" : {
+            "categories" : [{"value":"stationery","count":9}],
+            "price"      : [{"from":0,"to":100,"count":12}, ...],
+            "rating"     : [{"value":"4","count":7}]
+           }
+}
+*/
+
 func SearchProductByQuery() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var searchproducts []models.Product
 		queryParam := c.Query("name")
 		if queryParam == "" {
+			queryParam = c.Query("q")
+		}
+		if strings.TrimSpace(queryParam) == "" {
 			c.Header("Content-Type", "application/json")
 			c.JSON(http.StatusNotFound, gin.H{"Error": "Invalid Search Index"})
 			c.Abort()
 			return
 		}
+		filter, err := parseFilter(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		limit, offset, err := parsePage(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
-		searchquerydb, err := ProductCollection.Find(ctx, bson.M{"product_name": bson.M{"$regex": queryParam}})
-		if err != nil {
-			c.IndentedJSON(404, "something went wrong in fetching the dbquery")
+		if err := expandCategories(ctx, &filter); err != nil {
+			categoryError(c, err)
 			return
 		}
-		searchquerydb.All(ctx, &searchproducts)
-		defer searchquerydb.Close(ctx)
-		if err := searchquerydb.Err(); err != nil {
-			c.IndentedJSON(400, "invalid request")
+		result, err := ProductSearch.Search(ctx, search.Query{Text: queryParam, Filter: filter, Limit: int(limit), Offset: int(offset)})
+		if err != nil {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, "something went wrong in fetching the dbquery")
 			return
 		}
+		hits := make([]models.Product, len(result.Hits))
+		for i, hit := range result.Hits {
+			hits[i] = hit.Product
+		}
+		if err := addBreadcrumbs(ctx, hits); err == nil {
+			for i := range result.Hits {
+				result.Hits[i].Product.Breadcrumbs = hits[i].Breadcrumbs
+			}
+		}
+		if result.Total > 0 && offset == 0 {
+			if err := ProductSuggest.Record(ctx, queryParam); err != nil {
+				log.Println(err)
+			}
+		}
+		c.IndentedJSON(200, result)
+	}
+}
+
+//function suggesting what the customer may be typing, meant to be called on every key press
+//completions come from popular searches first and then product names, did_you_mean corrects typos
+//GET request : http://localhost:8000/users/search/suggest?q=blue penc&limit=8
+/*
+{
+"completions"  : [{"text":"blue pencil","kind":"query"},{"text":"Blue Pencil HB","kind":"product","product_id":"616152fa9f29be942bd9df91"}],
+"did_you_mean" : "pencil"
+}
+*/
+
+func SearchSuggest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := 8
+		if value := c.Query("limit"); value != "" {
+			number, err := strconv.Atoi(value)
+			if err != nil || number < 1 || number > 20 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 20"})
+				return
+			}
+			limit = number
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
-		c.IndentedJSON(200, searchproducts)
+		suggestions, err := ProductSuggest.Suggest(ctx, c.Query("q"), limit)
+		if err != nil {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+			return
+		}
+		c.IndentedJSON(200, suggestions)
 	}
 }
 
 /**************************************************CART********************************************************************************************************/
 
 //function to add products to cart
+//products that come in variants need the variant, by its id or its sku
+//adding a product that is already in the cart raises its quantity, &quantity=3 adds three
 // GET request
-//http://localhost:8000/addtocart?id=xxxproduct_id&normal=xxxxxxuser_idxxxxxx
+//http://localhost:8000/addtocart?id=xxxproduct_id&variant=xxxvariant_id_or_skuxxx&quantity=1
 
 func AddToCart() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var productcart []models.ProductUser
 		productqueryid := c.Query("id")
-		userid := c.Query("normal")
 		productid, _ := primitive.ObjectIDFromHex(productqueryid)
 		if productqueryid == "" {
 			c.Header("Content-Type", "application/json")
@@ -268,24 +443,29 @@ func AddToCart() gin.HandlerFunc {
 			c.Abort()
 			return
 		}
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-		defer cancel()
-		searchfromdb, err := ProductCollection.Find(ctx, bson.M{"_id": productid})
+		usert_id, err := primitive.ObjectIDFromHex(c.GetString("uid"))
 		if err != nil {
-			c.IndentedJSON(http.StatusNotFound, "Invalid ID refer")
+			c.IndentedJSON(http.StatusUnauthorized, "Invalid user")
 			return
 		}
-		searchfromdb.All(ctx, &productcart)
-		defer cancel()
-		id, err := primitive.ObjectIDFromHex(userid)
+		variant := c.Query("variant")
+		if variant == "" {
+			variant = c.Query("sku")
+		}
+		quantity, err := queryQuantity(c, "quantity", 1)
+		if err == nil && quantity == 0 {
+			err = ErrQuantity
+		}
 		if err != nil {
-			fmt.Println(err)
+			cartError(c, err)
+			return
 		}
-		filter := bson.D{primitive.E{Key: "_id", Value: id}}
-		update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "usercart", Value: bson.D{{Key: "$each", Value: productcart}}}}}}
-		_, err = UserCollection.UpdateOne(ctx, filter, update)
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		err = userCart(usert_id).change(ctx, productid, variant, quantity, false)
 		if err != nil {
-			c.IndentedJSON(http.StatusInternalServerError, err)
+			cartError(c, err)
+			return
 		}
 		c.IndentedJSON(200, "Successfully Added to the cart")
 	}
@@ -293,28 +473,38 @@ func AddToCart() gin.HandlerFunc {
 
 /************************************************************************************************************************************/
 
-//function to remove item from cart
+//function to remove item from cart, the whole line whatever its quantity
 //GET Request
-//http://localhost:8000/addtocart?id=xxxproduct_id&normal=xxxxxxuser_idxxxxxx
+//http://localhost:8000/removeitem?id=xxxproduct_id
+//with &variant=xxxvariant_idxxx only that variant of the product is removed
 func RemoveItem() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		remove_id := c.Query("id")
-		user_id := c.Query("normal")
-		if remove_id == "" || user_id == "" {
+		if remove_id == "" {
 			c.Header("Content-Type", "application/json")
 			c.JSON(http.StatusNotFound, gin.H{"Error": "Invalid Query"})
 			c.Abort()
 			return
 		}
 		removed_id, _ := primitive.ObjectIDFromHex(remove_id)
-		usert_id, err := primitive.ObjectIDFromHex(user_id)
+		usert_id, err := primitive.ObjectIDFromHex(c.GetString("uid"))
 		if err != nil {
-			fmt.Println(err)
+			c.IndentedJSON(http.StatusUnauthorized, "Invalid user")
+			return
 		}
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 		filter := bson.D{primitive.E{Key: "_id", Value: usert_id}}
-		update := bson.M{"$pull": bson.M{"usercart": bson.M{"_id": removed_id}}}
+		removed := bson.M{"_id": removed_id}
+		if variant := c.Query("variant"); variant != "" {
+			variantt_id, err := primitive.ObjectIDFromHex(variant)
+			if err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Invalid variant id"})
+				return
+			}
+			removed["variant_id"] = variantt_id
+		}
+		update := bson.M{"$pull": bson.M{"usercart": removed}}
 		_, err = UserCollection.UpdateMany(ctx, filter, update)
 		if err != nil {
 			c.IndentedJSON(500, "Server Error")
@@ -327,299 +517,387 @@ func RemoveItem() gin.HandlerFunc {
 
 /***************************************************************************************************************/
 
-//function to get all items in the cart and total price
+//function to get all items in the cart and what they come to
+//every line comes with its subtotal (the price times the quantity), its discount and its total
+//the totals are worked out by the pricing package, the same way checkout does
 //GET request
-//http://localhost:8000/listcart?id=xxxxxxuser_idxxxxxxxxxx
+//http://localhost:8000/listcart
+/*
+{
+"items"          : [{"Product_ID":"xxxproduct_idxxx","product_name":"Pencil","price":5,"quantity":3,"subtotal":15,"total":15}],
+"units"          : 3,
+"subtotal"       : 15,
+"discounts"      : [],
+"discount_total" : 0,
+"shipping"       : 40,
+"taxes"          : [{"name":"Tax","rate":18,"included":true,"taxable":55,"amount_cents":839}],
+"grand_total"    : 55
+}
+*/
 func GetItemFromCart() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var filledcart models.User
-		user_id := c.Query("id")
-		if user_id == "" {
-			c.Header("Content-Type", "application/json")
-			c.JSON(http.StatusNotFound, gin.H{"error": "invalid id"})
-			c.Abort()
+		usert_id, err := primitive.ObjectIDFromHex(c.GetString("uid"))
+		if err != nil {
+			c.IndentedJSON(http.StatusUnauthorized, "Invalid user")
 			return
 		}
-		usert_id, _ := primitive.ObjectIDFromHex(user_id)
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
-		err := UserCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: usert_id}}).Decode(&filledcart)
+		view, err := userCart(usert_id).view(ctx)
 		if err != nil {
 			c.IndentedJSON(500, "not id found")
 			return
 		}
-		filter_match := bson.D{{Key: "$match", Value: bson.D{primitive.E{Key: "_id", Value: usert_id}}}}
-		unwind := bson.D{{Key: "$unwind", Value: bson.D{primitive.E{Key: "path", Value: "$usercart"}}}}
-		grouping := bson.D{{Key: "$group", Value: bson.D{primitive.E{Key: "_id", Value: "$_id"}, {Key: "total", Value: bson.D{primitive.E{Key: "$sum", Value: "$usercart.price"}}}}}}
-		pointcursor, err := UserCollection.Aggregate(ctx, mongo.Pipeline{filter_match, unwind, grouping})
+		c.IndentedJSON(200, view)
+	}
+}
+
+/***********************************************************ADDRESS*************************************************************************/
+
+//the address book of the logged in user, as many addresses as they like
+//one of them is the default for shipping and one (maybe the same) for billing
+//the first address added becomes the default for both
+//addresses are normalized and checked against the rules of their country (see the addressing package) before they are saved
+
+// addressParam reads the logged in user and the :address_id path parameter
+func addressParam(c *gin.Context) (primitive.ObjectID, primitive.ObjectID, bool) {
+	usert_id, err := primitive.ObjectIDFromHex(c.GetString("uid"))
+	if err != nil {
+		c.IndentedJSON(http.StatusUnauthorized, "Invalid user")
+		return usert_id, primitive.NilObjectID, false
+	}
+	addresst_id, err := primitive.ObjectIDFromHex(c.Param("address_id"))
+	if err != nil {
+		c.Header("Content-Type", "application/json")
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invalid address id"})
+		c.Abort()
+		return usert_id, addresst_id, false
+	}
+	return usert_id, addresst_id, true
+}
+
+// setDefaultAddress flags one address as the default and clears the flag on all others in the same write
+func setDefaultAddress(ctx context.Context, usert_id primitive.ObjectID, addresst_id primitive.ObjectID, field string) error {
+	update := bson.M{"$set": bson.M{
+		"address.$[other]." + field: false,
+		"address.$[this]." + field:  true,
+	}}
+	filters := options.ArrayFilters{Filters: []interface{}{
+		bson.M{"other._id": bson.M{"$ne": addresst_id}},
+		bson.M{"this._id": addresst_id},
+	}}
+	_, err := UserCollection.UpdateOne(ctx, bson.M{"_id": usert_id}, update, options.Update().SetArrayFilters(filters))
+	return err
+}
+
+func applyAddressDefaults(ctx context.Context, usert_id primitive.ObjectID, address models.Address) error {
+	if address.Default_Shipping {
+		if err := setDefaultAddress(ctx, usert_id, address.Address_id, "default_shipping"); err != nil {
+			return err
+		}
+	}
+	if address.Default_Billing {
+		if err := setDefaultAddress(ctx, usert_id, address.Address_id, "default_billing"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//function to list the address book
+//GET request
+//http://localhost:8000/addresses
+
+func ListAddresses() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		usert_id, err := primitive.ObjectIDFromHex(c.GetString("uid"))
 		if err != nil {
-			fmt.Println(err)
+			c.IndentedJSON(http.StatusUnauthorized, "Invalid user")
+			return
 		}
-		var listing []bson.M
-		if err = pointcursor.All(ctx, &listing); err != nil {
-			panic(err)
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		var founduser models.User
+		projection := options.FindOne().SetProjection(bson.M{"address": 1})
+		if err := UserCollection.FindOne(ctx, bson.M{"_id": usert_id}, projection).Decode(&founduser); err != nil {
+			c.IndentedJSON(http.StatusNotFound, "User not found")
+			return
 		}
-		for _, json := range listing {
-			c.IndentedJSON(200, json["total"])
-			c.IndentedJSON(200, filledcart.UserCart)
+		if founduser.Address_Details == nil {
+			founduser.Address_Details = make([]models.Address, 0)
 		}
-		ctx.Done()
+		c.IndentedJSON(200, founduser.Address_Details)
 	}
 }
 
-/***********************************************************ADDRESS*************************************************************************/
+/**********************************************************************************************************/
 
-//function to add the address and limited to 2
-//home and work address
+//function to add an address to the address book
 /*
 {
+"label":"home",
+"recipient_name":"Joseph Hermis",
+"phone":"+1558426655",
 "house_name":"jupyterlab",
 "street_name":"notebook",
 "city_name":"josua",
-"pin_code":"685607"
+"pin_code":"685607",
+"region":"KL",
+"country_code":"IN",
+"default_shipping":true,
+"default_billing":false
 }
 The Post Request Url will look like this
 POST
-http://localhost:8000/addadress?id=user_id*************
+http://localhost:8000/addresses
 
 */
 
 func AddAddress() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		user_id := c.Query("id")
-		if user_id == "" {
-			c.Header("Content-Type", "application/json")
-			c.JSON(http.StatusNotFound, gin.H{"error": "Invalid code"})
-			c.Abort()
-			return
-		}
-		address, err := primitive.ObjectIDFromHex(user_id)
+		usert_id, err := primitive.ObjectIDFromHex(c.GetString("uid"))
 		if err != nil {
-			c.IndentedJSON(500, "Internal Server Error")
+			c.IndentedJSON(http.StatusUnauthorized, "Invalid user")
+			return
 		}
-		var addresses models.Address
-		addresses.Address_id = primitive.NewObjectID()
-		if err = c.BindJSON(&addresses); err != nil {
+		var address models.Address
+		if err = c.BindJSON(&address); err != nil {
 			c.IndentedJSON(http.StatusNotAcceptable, err.Error())
+			return
+		}
+		if err := addressing.Clean(&address); err != nil {
+			addressError(c, err)
+			return
 		}
+		address.Address_id = primitive.NewObjectID()
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-		match_filter := bson.D{{Key: "$match", Value: bson.D{primitive.E{Key: "_id", Value: address}}}}
-		unwind := bson.D{{Key: "$unwind", Value: bson.D{primitive.E{Key: "path", Value: "$address"}}}}
-		group := bson.D{{Key: "$group", Value: bson.D{primitive.E{Key: "_id", Value: "$address_id"}, {Key: "count", Value: bson.D{primitive.E{Key: "$sum", Value: 1}}}}}}
-		pointcursor, err := UserCollection.Aggregate(ctx, mongo.Pipeline{match_filter, unwind, group})
+		defer cancel()
+		//the very first address is the default for everything
+		count, err := UserCollection.CountDocuments(ctx, bson.M{"_id": usert_id, "address.0": bson.M{"$exists": true}})
 		if err != nil {
 			c.IndentedJSON(500, "Internal Server Error")
+			return
 		}
-		var addressinfo []bson.M
-		if err = pointcursor.All(ctx, &addressinfo); err != nil {
-			panic(err)
+		if count == 0 {
+			address.Default_Shipping = true
+			address.Default_Billing = true
 		}
-		var size int32
-		for _, address_no := range addressinfo {
-			count := address_no["count"]
-			size = count.(int32)
+		filter := bson.D{primitive.E{Key: "_id", Value: usert_id}}
+		update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "address", Value: address}}}}
+		result, err := UserCollection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			c.IndentedJSON(500, "Internal Server Error")
+			return
 		}
-		if size < 2 {
-			filter := bson.D{primitive.E{Key: "_id", Value: address}}
-			update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "address", Value: addresses}}}}
-			_, err := UserCollection.UpdateOne(ctx, filter, update)
-			if err != nil {
-				fmt.Println(err)
-			}
-		} else {
-			c.IndentedJSON(400, "Not Allowed ")
+		if result.MatchedCount == 0 {
+			c.IndentedJSON(http.StatusNotFound, "User not found")
+			return
 		}
-		defer cancel()
-		ctx.Done()
+		if err := applyAddressDefaults(ctx, usert_id, address); err != nil {
+			c.IndentedJSON(500, "Internal Server Error")
+			return
+		}
+		c.IndentedJSON(http.StatusCreated, address)
 	}
 }
 
 /**********************************************************************************************************/
 
-//function to edit the address put request
-/*
-
-{
-"house_name":"jupyterlab",
-"street_name":"notebook",
-"city_name":"mars",
-"pin_code":"12231997"
-}
-PUT
-http://localhost:8000/edithomeaddress?id=xxxxxxxxxxxxxxxxxxxxxxxxx
-
-*/
+//function to get a single address
+//GET request
+//http://localhost:8000/addresses/xxxxxxaddress_idxxxxxx
 
-func EditHomeAddress() gin.HandlerFunc {
+func GetAddress() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		user_id := c.Query("id")
-		if user_id == "" {
-			c.Header("Content-Type", "application/json")
-			c.JSON(http.StatusNotFound, gin.H{"Error": "Invalid"})
-			c.Abort()
+		usert_id, addresst_id, ok := addressParam(c)
+		if !ok {
 			return
 		}
-		usert_id, err := primitive.ObjectIDFromHex(user_id)
-		if err != nil {
-			c.IndentedJSON(500, err)
-		}
-		var editaddress models.Address
-		if err := c.BindJSON(&editaddress); err != nil {
-			c.IndentedJSON(http.StatusBadRequest, err.Error())
-		}
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
-		filter := bson.D{primitive.E{Key: "_id", Value: usert_id}}
-		update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "address.0.house_name", Value: editaddress.House}, {Key: "address.0.street_name", Value: editaddress.Street}, {Key: "address.0.city_name", Value: editaddress.City}, {Key: "address.0.pin_code", Value: editaddress.Pincode}}}}
-		_, err = UserCollection.UpdateOne(ctx, filter, update)
-		if err != nil {
-			c.IndentedJSON(500, "Something Went Wrong")
+		var founduser models.User
+		projection := options.FindOne().SetProjection(bson.M{"address.$": 1})
+		err := UserCollection.FindOne(ctx, bson.M{"_id": usert_id, "address._id": addresst_id}, projection).Decode(&founduser)
+		if err != nil || len(founduser.Address_Details) == 0 {
+			c.IndentedJSON(http.StatusNotFound, "Address not found")
 			return
 		}
-		defer cancel()
-		ctx.Done()
-		c.IndentedJSON(200, "Successfully Updated the Home address")
+		c.IndentedJSON(200, founduser.Address_Details[0])
 	}
 }
 
-/*****************************************************************************************************/
+/**********************************************************************************************************/
 
-//function to edit the work address put request
+//function to edit an address, the whole address is replaced
+//default_shipping or default_billing set to true moves that default here, false leaves the flag as it is
 /*
 
 {
+"label":"work",
 "house_name":"jupyterlab",
 "street_name":"notebook",
 "city_name":"mars",
 "pin_code":"12231997"
 }
 PUT
-http://localhost:8000/editworkaddress?id=xxxxxxxxxxxxxxxxxxxxxxxxx
+http://localhost:8000/addresses/xxxxxxaddress_idxxxxxx
 
 */
 
-func EditWorkAddress() gin.HandlerFunc {
+func UpdateAddress() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		user_id := c.Query("id")
-		if user_id == "" {
-			c.Header("Content-Type", "application/json")
-			c.JSON(http.StatusNotFound, gin.H{"Error": "Wrong id not provided"})
-			c.Abort()
+		usert_id, addresst_id, ok := addressParam(c)
+		if !ok {
 			return
 		}
-		usert_id, err := primitive.ObjectIDFromHex(user_id)
-		if err != nil {
-			c.IndentedJSON(500, err)
-		}
 		var editaddress models.Address
 		if err := c.BindJSON(&editaddress); err != nil {
 			c.IndentedJSON(http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := addressing.Clean(&editaddress); err != nil {
+			addressError(c, err)
+			return
 		}
+		editaddress.Address_id = addresst_id
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
-		filter := bson.D{primitive.E{Key: "_id", Value: usert_id}}
-		update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "address.1.house_name", Value: editaddress.House}, {Key: "address.1.street_name", Value: editaddress.Street}, {Key: "address.1.city_name", Value: editaddress.City}, {Key: "address.1.pin_code", Value: editaddress.Pincode}}}}
-		_, err = UserCollection.UpdateOne(ctx, filter, update)
+		filter := bson.M{"_id": usert_id, "address._id": addresst_id}
+		update := bson.M{"$set": bson.M{
+			"address.$.label":          editaddress.Label,
+			"address.$.recipient_name": editaddress.Recipient_Name,
+			"address.$.phone":          editaddress.Phone,
+			"address.$.house_name":     editaddress.House,
+			"address.$.street_name":    editaddress.Street,
+			"address.$.city_name":      editaddress.City,
+			"address.$.pin_code":       editaddress.Pincode,
+			"address.$.region":         editaddress.Region,
+			"address.$.country_code":   editaddress.Country,
+		}}
+		result, err := UserCollection.UpdateOne(ctx, filter, update)
 		if err != nil {
-			c.IndentedJSON(500, "something Went wrong")
+			c.IndentedJSON(500, "Something Went Wrong")
 			return
 		}
-		defer cancel()
-		ctx.Done()
-		c.IndentedJSON(200, "Successfully updated the Work Address")
+		if result.MatchedCount == 0 {
+			c.IndentedJSON(http.StatusNotFound, "Address not found")
+			return
+		}
+		if err := applyAddressDefaults(ctx, usert_id, editaddress); err != nil {
+			c.IndentedJSON(500, "Something Went Wrong")
+			return
+		}
+		c.IndentedJSON(200, "Successfully Updated the address")
 	}
 }
 
 /********************************************************************************************/
 
-//function to delete the address here both the address will be removed fix soon
-//GET request
-//http://localhost:8000/deleteaddresses?id=xxxxxxxxxxxxxxxxxxxxxxxx
+//function to delete one address, if it was a default the first remaining address takes over
+//DELETE request
+//http://localhost:8000/addresses/xxxxxxaddress_idxxxxxx
 
 func DeleteAddress() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		user_id := c.Query("id")
-		if user_id == "" {
-			c.Header("Content-Type", "application/json")
-			c.JSON(http.StatusNotFound, gin.H{"Error": "Invalid Search Index"})
-			c.Abort()
+		usert_id, addresst_id, ok := addressParam(c)
+		if !ok {
 			return
 		}
-		addresses := make([]models.Address, 0)
-		usert_id, err := primitive.ObjectIDFromHex(user_id)
-		if err != nil {
-			c.IndentedJSON(500, "Internal Server Error")
-		}
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
-		filter := bson.D{primitive.E{Key: "_id", Value: usert_id}}
-		update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "address", Value: addresses}}}}
-		_, err = UserCollection.UpdateOne(ctx, filter, update)
+		var before models.User
+		filter := bson.M{"_id": usert_id, "address._id": addresst_id}
+		update := bson.M{"$pull": bson.M{"address": bson.M{"_id": addresst_id}}}
+		err := UserCollection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetProjection(bson.M{"address": 1})).Decode(&before)
+		if err == mongo.ErrNoDocuments {
+			c.IndentedJSON(http.StatusNotFound, "Address not found")
+			return
+		}
 		if err != nil {
-			c.IndentedJSON(404, "Wromg")
+			c.IndentedJSON(500, "Something Went Wrong")
 			return
 		}
-		defer cancel()
-		ctx.Done()
+		var removed models.Address
+		var remaining []models.Address
+		for _, address := range before.Address_Details {
+			if address.Address_id == addresst_id {
+				removed = address
+			} else {
+				remaining = append(remaining, address)
+			}
+		}
+		if len(remaining) > 0 && (removed.Default_Shipping || removed.Default_Billing) {
+			heir := remaining[0]
+			heir.Default_Shipping = removed.Default_Shipping
+			heir.Default_Billing = removed.Default_Billing
+			if err := applyAddressDefaults(ctx, usert_id, heir); err != nil {
+				c.IndentedJSON(500, "Something Went Wrong")
+				return
+			}
+		}
 		c.IndentedJSON(200, "Successfully Deleted!")
 	}
 }
 
 /***********************************************************************************************************************************************************************/
 
+//function to place an order with everything in the cart
+//the cart is checked against the catalog first, when prices changed or products are no longer
+//sold the answer is 409 with the changes until they are accepted with POST /cart/acknowledge
+//GET or POST request, see checkout.go for picking the shipping and billing address
+//http://localhost:8000/cartcheckout?address_id=xxaddress_idxxx
+
 func BuyFromCart() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		user_id := c.Query("id")
-		if user_id == "" {
-			c.Header("Content-Type", "application/json")
-			c.JSON(http.StatusNotFound, gin.H{"error": "Invalid"})
-			c.Abort()
-			return
-		}
-		usert_id, err := primitive.ObjectIDFromHex(user_id)
+		usert_id, err := primitive.ObjectIDFromHex(c.GetString("uid"))
 		if err != nil {
-			c.IndentedJSON(500, "Internal Server Error")
+			c.IndentedJSON(http.StatusUnauthorized, "Invalid user")
+			return
 		}
 		var getcartitems models.User
-		var ordercart models.Order
-		ordercart.Order_ID = primitive.NewObjectID()
-		ordercart.Orderered_At = time.Now()
-		ordercart.Order_Cart = make([]models.ProductUser, 0)
-		ordercart.Payment_Method.COD = true
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
-		unwind := bson.D{{Key: "$unwind", Value: bson.D{primitive.E{Key: "path", Value: "$usercart"}}}}
-		grouping := bson.D{{Key: "$group", Value: bson.D{primitive.E{Key: "_id", Value: "$_id"}, {Key: "total", Value: bson.D{primitive.E{Key: "$sum", Value: "$usercart.price"}}}}}}
-		currentresults, err := UserCollection.Aggregate(ctx, mongo.Pipeline{unwind, grouping})
-
-		ctx.Done()
+		err = UserCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: usert_id}}).Decode(&getcartitems)
 		if err != nil {
-			panic(err)
+			c.IndentedJSON(500, "something went wrong")
+			return
 		}
-		var getusercart []bson.M
-		if err = currentresults.All(ctx, &getusercart); err != nil {
-			panic(err)
+		if len(getcartitems.UserCart) == 0 {
+			c.IndentedJSON(400, "Cart is empty")
+			return
 		}
-		var total_price int32
-		for _, user_item := range getusercart {
-			price := user_item["total"]
-			total_price = price.(int32)
+		cartitems, ok := checkCart(c, ctx, getcartitems.UserCart)
+		if !ok {
+			return
 		}
-		ordercart.Price = int(total_price)
-		filter := bson.D{primitive.E{Key: "_id", Value: usert_id}}
-		update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "orders", Value: ordercart}}}}
-		UserCollection.UpdateMany(ctx, filter, update)
-		err = UserCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: usert_id}}).Decode(&getcartitems)
+		request, err := bindCheckout(c)
 		if err != nil {
-			c.IndentedJSON(500, "something went wrong")
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		shipping, billing, err := checkoutAddresses(request, getcartitems)
+		if err != nil {
+			addressError(c, err)
+			return
+		}
+		ordercart, err := newOrder(ctx)
+		if err != nil {
+			c.IndentedJSON(500, "Internal Server Error")
+			return
+		}
+		ordercart.Shipping_Address = shipping
+		ordercart.Billing_Address = billing
+		pricing.PriceOrder(&ordercart, cartitems)
+		if !reserveStock(c, ctx, &ordercart, usert_id, request.Payment) {
+			return
 		}
-		var ktx, kancel = context.WithTimeout(context.Background(), 100*time.Second)
-		defer kancel()
-		filter2 := bson.D{primitive.E{Key: "_id", Value: usert_id}}
-		update2 := bson.M{"$push": bson.M{"orders.$[].order_list": bson.M{"$each": getcartitems.UserCart}}}
-		_, err = UserCollection.UpdateOne(ctx, filter2, update2)
+		filter := bson.D{primitive.E{Key: "_id", Value: usert_id}}
+		update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "orders", Value: ordercart}}}}
+		_, err = UserCollection.UpdateOne(ctx, filter, update)
 		if err != nil {
+			releaseStock(ctx, ordercart)
 			c.IndentedJSON(500, "something went wrong")
+			return
 		}
 		usercart_empty := make([]models.ProductUser, 0)
 		filtered := bson.D{primitive.E{Key: "_id", Value: usert_id}}
@@ -627,19 +905,22 @@ func BuyFromCart() gin.HandlerFunc {
 		_, err = UserCollection.UpdateOne(ctx, filtered, updated)
 		if err != nil {
 			c.IndentedJSON(500, "Internal Server Errror")
+			return
 		}
-
-		ktx.Done()
-		c.IndentedJSON(200, "Successfully Placed the order")
+		c.IndentedJSON(200, gin.H{"message": "Successfully Placed the order", "order_id": ordercart.Order_ID, "order_number": ordercart.Order_Number, "status": ordercart.Status, "reserved_until": ordercart.Reserved_Until, "totals": ordercart.Totals})
 
 	}
 }
 
+//function to order a single product right away, takes the same addresses as the cart checkout
+//&quantity=3 orders three of it, up to the max quantity of the product
+//GET or POST request
+//http://localhost:8000/instantbuy?pid=xxproduct_idxxx&address_id=xxaddress_idxxx&variant=xxvariant_id_or_skuxxx&quantity=1
+
 func InstantBuy() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		item_id := c.Query("pid")
-		user_id := c.Query("id")
-		if item_id == "" || user_id == "" {
+		if item_id == "" {
 			c.Header("Content-Type", "application/json")
 			c.JSON(http.StatusNotFound, gin.H{"Error": "Invalid Code"})
 			c.Abort()
@@ -649,34 +930,76 @@ func InstantBuy() gin.HandlerFunc {
 		if err != nil {
 			c.IndentedJSON(500, "Internal Server Erroe")
 		}
-		usert_id, err := primitive.ObjectIDFromHex(user_id)
+		usert_id, err := primitive.ObjectIDFromHex(c.GetString("uid"))
 		if err != nil {
-			c.IndentedJSON(500, "Internal Server Error")
+			c.IndentedJSON(http.StatusUnauthorized, "Invalid user")
+			return
+		}
+		variant := c.Query("variant")
+		if variant == "" {
+			variant = c.Query("sku")
+		}
+		quantity, err := queryQuantity(c, "quantity", 1)
+		if err == nil && quantity == 0 {
+			err = ErrQuantity
+		}
+		if err != nil {
+			cartError(c, err)
+			return
 		}
-		var product_details models.ProductUser
-		var orders_detail models.Order
-		orders_detail.Order_ID = primitive.NewObjectID()
-		orders_detail.Orderered_At = time.Now()
-		orders_detail.Order_Cart = make([]models.ProductUser, 0)
-		orders_detail.Payment_Method.COD = true
+		var buyer models.User
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-		err = ProductCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: itemt_id}}).Decode(&product_details)
+		defer cancel()
+		product, err := cartProduct(ctx, itemt_id)
 		if err != nil {
-			c.IndentedJSON(400, "Something Wrong happened")
+			cartLineError(c, err)
+			return
+		}
+		product_details, err := productLine(product, variant)
+		if err != nil {
+			cartLineError(c, err)
+			return
+		}
+		if max := maxQuantity(product); quantity > max {
+			cartError(c, &QuantityError{Max: max})
+			return
+		}
+		product_details.Quantity = quantity
+		err = UserCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: usert_id}}).Decode(&buyer)
+		if err != nil {
+			c.IndentedJSON(500, "Internal Server Error")
+			return
+		}
+		request, err := bindCheckout(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		shipping, billing, err := checkoutAddresses(request, buyer)
+		if err != nil {
+			addressError(c, err)
+			return
+		}
+		orders_detail, err := newOrder(ctx)
+		if err != nil {
+			c.IndentedJSON(500, "Internal Server Error")
+			return
+		}
+		orders_detail.Shipping_Address = shipping
+		orders_detail.Billing_Address = billing
+		pricing.PriceOrder(&orders_detail, []models.ProductUser{product_details})
+		if !reserveStock(c, ctx, &orders_detail, usert_id, request.Payment) {
+			return
 		}
-		orders_detail.Price = product_details.Price
 		filter := bson.D{primitive.E{Key: "_id", Value: usert_id}}
 		update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "orders", Value: orders_detail}}}}
-		UserCollection.UpdateOne(ctx, filter, update)
-		ctx.Done()
-		filter2 := bson.D{primitive.E{Key: "_id", Value: usert_id}}
-		update2 := bson.M{"$push": bson.M{"orders.$[].order_list": product_details}}
-		_, err = UserCollection.UpdateOne(ctx, filter2, update2)
+		_, err = UserCollection.UpdateOne(ctx, filter, update)
 		if err != nil {
+			releaseStock(ctx, orders_detail)
 			c.IndentedJSON(400, "something wrong happened")
+			return
 		}
-		c.IndentedJSON(200, "Successully placed the order ")
-		defer cancel()
+		c.IndentedJSON(200, gin.H{"message": "Successully placed the order ", "order_id": orders_detail.Order_ID, "order_number": orders_detail.Order_Number, "status": orders_detail.Status, "reserved_until": orders_detail.Reserved_Until, "totals": orders_detail.Totals})
 
 	}
 }
@@ -0,0 +1,274 @@
+package controllers
+
+import (
+	"context"
+	"ecommerce/database"
+	"ecommerce/models"
+	generate "ecommerce/tokens"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+//visitors who are not logged in get a guest cart the first time they add a
+//product. The signed cart token that names it comes back in the Cart-Token
+//header and has to be sent along with every later request, as the Cart-Token
+//header or ?cart_token=. Logging in or signing up with the token moves the
+//guest cart into the cart of the user.
+
+var GuestCartCollection *mongo.Collection = database.UserData(database.Client, "GuestCarts")
+
+const CartTokenHeader = "Cart-Token"
+
+// GuestCartTTL is how long a guest cart is kept after it was last used, GUEST_CART_TTL sets it
+var GuestCartTTL = guestCartTTL()
+
+func guestCartTTL() time.Duration {
+	if ttl, err := time.ParseDuration(os.Getenv("GUEST_CART_TTL")); err == nil && ttl > 0 {
+		return ttl
+	}
+	return 30 * 24 * time.Hour
+}
+
+// what happens when the guest cart and the cart of the user both have a product
+// sum     adds the quantities up (the default), never beyond the max quantity
+// max     keeps the larger quantity
+// user    keeps the line of the user
+// guest   the line of the guest cart replaces the one of the user
+const (
+	MergeSum   = "sum"
+	MergeMax   = "max"
+	MergeUser  = "user"
+	MergeGuest = "guest"
+)
+
+// CartMergeRule is the merge rule used when the login does not pick one, CART_MERGE_RULE sets it
+var CartMergeRule = cartMergeRule(os.Getenv("CART_MERGE_RULE"), MergeSum)
+
+func cartMergeRule(rule string, def string) string {
+	switch rule {
+	case MergeSum, MergeMax, MergeUser, MergeGuest:
+		return rule
+	}
+	return def
+}
+
+var guestCartIndexOnce sync.Once
+
+// guest carts expire through a ttl index, made once with the first guest cart
+func ensureGuestCartIndex(ctx context.Context) {
+	guestCartIndexOnce.Do(func() {
+		index := mongo.IndexModel{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)}
+		if _, err := GuestCartCollection.Indexes().CreateOne(ctx, index); err != nil {
+			log.Println(err)
+		}
+	})
+}
+
+func newGuestCart(ctx context.Context) (cartRef, error) {
+	ensureGuestCartIndex(ctx)
+	now := time.Now()
+	cart := cartRef{collection: GuestCartCollection, id: primitive.NewObjectID()}
+	cart.token = generate.CartToken(cart.id)
+	document := bson.M{"_id": cart.id, "usercart": bson.A{}, "created_at": now, "updated_at": now, "expires_at": now.Add(GuestCartTTL)}
+	_, err := GuestCartCollection.InsertOne(ctx, document)
+	return cart, err
+}
+
+// touchGuestCart keeps a guest cart that is still in use, it reports whether the cart is still there
+func touchGuestCart(ctx context.Context, cart_id primitive.ObjectID) (bool, error) {
+	now := time.Now()
+	update := bson.M{"$set": bson.M{"updated_at": now, "expires_at": now.Add(GuestCartTTL)}}
+	result, err := GuestCartCollection.UpdateOne(ctx, bson.M{"_id": cart_id}, update)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+func cartToken(c *gin.Context) string {
+	if token := c.GetHeader(CartTokenHeader); token != "" {
+		return token
+	}
+	return c.Query("cart_token")
+}
+
+// requestCart finds the cart a request works on, the cart of the logged in user
+// or else the guest cart of the cart token. With create a visitor without a
+// cart, or whose cart expired, gets a new one. It answers the request itself
+// when there is no cart to work on.
+func requestCart(c *gin.Context, ctx context.Context, create bool) (cartRef, bool) {
+	if uid := c.GetString("uid"); uid != "" {
+		usert_id, err := primitive.ObjectIDFromHex(uid)
+		if err != nil {
+			c.IndentedJSON(http.StatusUnauthorized, "Invalid user")
+			return cartRef{}, false
+		}
+		return userCart(usert_id), true
+	}
+	if token := cartToken(c); token != "" {
+		cart_id, err := generate.ValidateCartToken(token)
+		if err != nil {
+			c.IndentedJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return cartRef{}, false
+		}
+		found, err := touchGuestCart(ctx, cart_id)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+			return cartRef{}, false
+		}
+		if found {
+			return cartRef{collection: GuestCartCollection, id: cart_id, token: token}, true
+		}
+	}
+	if !create {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "there is no cart yet, add a product first"})
+		return cartRef{}, false
+	}
+	cart, err := newGuestCart(ctx)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+		return cartRef{}, false
+	}
+	return cart, true
+}
+
+// answerCart answers with the cart, guests get their cart token with it
+func answerCart(c *gin.Context, ctx context.Context, cart cartRef) {
+	view, err := cart.view(ctx)
+	if err != nil {
+		cartError(c, err)
+		return
+	}
+	if cart.token != "" {
+		c.Header(CartTokenHeader, cart.token)
+		view.Cart_Token = cart.token
+	}
+	c.IndentedJSON(200, view)
+}
+
+//function showing the cart, of the logged in user or of the guest with the cart token
+//GET request : http://localhost:8000/cart
+//GET request : http://localhost:8000/guest/cart with the Cart-Token header
+
+func ViewCart() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		cart, ok := requestCart(c, ctx, false)
+		if !ok {
+			return
+		}
+		answerCart(c, ctx, cart)
+	}
+}
+
+//function taking a product out of the cart, the whole line whatever its quantity
+//DELETE request : http://localhost:8000/cart/items?id=xxxproduct_idxxx&variant=xxxvariant_idxxx
+
+func RemoveFromCart() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productt_id, variant, ok := cartParams(c)
+		if !ok {
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		cart, ok := requestCart(c, ctx, false)
+		if !ok {
+			return
+		}
+		if err := cart.change(ctx, productt_id, variant, 0, true); err != nil {
+			cartError(c, err)
+			return
+		}
+		answerCart(c, ctx, cart)
+	}
+}
+
+// mergeLine puts one line of a guest cart into the cart of the user following the rule
+func mergeLine(ctx context.Context, cart cartRef, mine []models.ProductUser, line models.ProductUser, rule string) error {
+	variant := ""
+	if line.Variant_ID != nil {
+		variant = line.Variant_ID.Hex()
+	}
+	held, err := cartEntry(mine, line.Product_ID, variant)
+	if err == ErrNotInCart {
+		filter := bson.M{"_id": cart.id, "usercart": bson.M{"$not": bson.M{"$elemMatch": cartFilter(line.Product_ID, line.Variant_ID)}}}
+		_, err := cart.collection.UpdateOne(ctx, filter, bson.M{"$push": bson.M{"usercart": line}})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	quantity := held.Units()
+	switch rule {
+	case MergeSum:
+		quantity += line.Units()
+	case MergeMax:
+		if line.Units() > quantity {
+			quantity = line.Units()
+		}
+	case MergeGuest:
+		filter := bson.M{"_id": cart.id, "usercart": bson.M{"$elemMatch": cartFilter(held.Product_ID, held.Variant_ID)}}
+		_, err := cart.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"usercart.$": line}})
+		return err
+	}
+	if quantity == held.Units() {
+		return nil
+	}
+	err = cart.change(ctx, line.Product_ID, variant, quantity, true)
+	if limit, ok := err.(*QuantityError); ok {
+		err = cart.change(ctx, line.Product_ID, variant, limit.Max, true)
+	}
+	return err
+}
+
+// mergeGuestCart moves the guest cart of the request into the cart of the user
+// and deletes it, the rule comes from ?merge= or CartMergeRule. A request
+// without a usable cart token has nothing to merge.
+func mergeGuestCart(c *gin.Context, ctx context.Context, usert_id primitive.ObjectID) (int, error) {
+	token := cartToken(c)
+	if token == "" {
+		return 0, nil
+	}
+	guest_id, err := generate.ValidateCartToken(token)
+	if err != nil {
+		return 0, nil
+	}
+	guest := cartRef{collection: GuestCartCollection, id: guest_id}
+	lines, err := guest.lines(ctx)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	rule := cartMergeRule(c.Query("merge"), CartMergeRule)
+	cart := userCart(usert_id)
+	mine, err := cart.lines(ctx)
+	if err != nil {
+		return 0, err
+	}
+	merged := 0
+	for _, line := range lines {
+		if err := mergeLine(ctx, cart, mine, line, rule); err != nil {
+			//a product that can no longer be bought stays behind, the rest still moves
+			log.Println(err)
+			continue
+		}
+		merged++
+	}
+	if _, err := GuestCartCollection.DeleteOne(ctx, bson.M{"_id": guest_id}); err != nil {
+		return merged, err
+	}
+	return merged, nil
+}
@@ -0,0 +1,439 @@
+package controllers
+
+import (
+	"context"
+	"ecommerce/events"
+	"ecommerce/models"
+	"ecommerce/notify"
+	"ecommerce/ordernumber"
+	"ecommerce/pricing"
+	generate "ecommerce/tokens"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+//guests check out their guest cart with an email, a shipping address and a
+//payment. Their orders are kept in a guest user, one per email, flagged with
+//"guest" so it can not log in. That way refunds, returns, shipping and the
+//stock hold treat guest orders like any other. The guest finds an order again
+//with its order number and the email, and can turn the guest user into an
+//account later with a link sent to that email, the orders stay with it.
+//Paying is confirmed by admins (POST /admin/orders/pay) like any other order.
+
+var ErrGuestEmail = errors.New("a valid email is required to check out as a guest")
+var ErrAccountExists = errors.New("there already is an account with this email, log in instead")
+
+// GuestLookupLimit is how many guest order lookups one ip, or one email, gets in
+// GuestLookupWindow. GUEST_LOOKUP_LIMIT and GUEST_LOOKUP_WINDOW set them
+var GuestLookupLimit = guestLookupLimit()
+var GuestLookupWindow = guestDuration("GUEST_LOOKUP_WINDOW", 15*time.Minute)
+
+// AccountLinkTTL is how long the link turning a guest into an account works, GUEST_ACCOUNT_LINK_TTL sets it
+var AccountLinkTTL = guestDuration("GUEST_ACCOUNT_LINK_TTL", 24*time.Hour)
+
+// AccountLinkURL is the page the account link opens, it gets ?token= and posts
+// the details of the account to POST /guest/account. GUEST_ACCOUNT_URL sets it
+var AccountLinkURL = guestAccountURL()
+
+func guestLookupLimit() int {
+	if limit, err := strconv.Atoi(os.Getenv("GUEST_LOOKUP_LIMIT")); err == nil && limit > 0 {
+		return limit
+	}
+	return 10
+}
+
+func guestDuration(key string, fallback time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(key)); err == nil && value > 0 {
+		return value
+	}
+	return fallback
+}
+
+func guestAccountURL() string {
+	if link := os.Getenv("GUEST_ACCOUNT_URL"); link != "" {
+		return link
+	}
+	return "http://localhost:8000/guest/account"
+}
+
+// lookupLimiter counts requests per key in a sliding window, it lives in
+// memory so every instance of the server counts on its own
+type lookupLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	seen   map[string][]time.Time
+}
+
+var guestLookups = &lookupLimiter{limit: GuestLookupLimit, window: GuestLookupWindow, seen: make(map[string][]time.Time)}
+
+// allow records a request at now against every key, false without recording
+// anything once one of the keys used up the window
+func (l *lookupLimiter) allow(now time.Time, keys ...string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.seen) > 10000 {
+		for seen, times := range l.seen {
+			if len(times) == 0 || now.Sub(times[len(times)-1]) >= l.window {
+				delete(l.seen, seen)
+			}
+		}
+	}
+	allowed := true
+	for _, key := range keys {
+		recent := make([]time.Time, 0, l.limit)
+		for _, at := range l.seen[key] {
+			if now.Sub(at) < l.window {
+				recent = append(recent, at)
+			}
+		}
+		l.seen[key] = recent
+		if len(recent) >= l.limit {
+			allowed = false
+		}
+	}
+	if !allowed {
+		return false
+	}
+	for _, key := range keys {
+		l.seen[key] = append(l.seen[key], now)
+	}
+	return true
+}
+
+var guestIndexOnce sync.Once
+
+// one guest user per email, the index is made with the first guest checkout
+func ensureGuestIndex(ctx context.Context) {
+	guestIndexOnce.Do(func() {
+		index := mongo.IndexModel{
+			Keys:    bson.D{{Key: "email", Value: 1}},
+			Options: options.Index().SetName("one_guest_per_email").SetUnique(true).SetPartialFilterExpression(bson.M{"guest": true}),
+		}
+		if _, err := UserCollection.Indexes().CreateOne(ctx, index); err != nil {
+			log.Println(err)
+		}
+	})
+}
+
+func guestEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if err := Validate.Var(email, "required,email"); err != nil {
+		return "", ErrGuestEmail
+	}
+	return email, nil
+}
+
+// guestUser finds the guest user of the email, making it the first time
+func guestUser(ctx context.Context, email string) (models.User, error) {
+	ensureGuestIndex(ctx)
+	var guest models.User
+	now := time.Now()
+	id := primitive.NewObjectID()
+	insert := bson.M{
+		"_id":        id,
+		"user_id":    id.Hex(),
+		"usercart":   bson.A{},
+		"address":    bson.A{},
+		"orders":     bson.A{},
+		"created_at": now,
+		"updated_at": now,
+	}
+	filter := bson.M{"email": email, "guest": true}
+	upsert := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After).SetProjection(bson.M{"orders": 0})
+	err := UserCollection.FindOneAndUpdate(ctx, filter, bson.M{"$setOnInsert": insert}, upsert).Decode(&guest)
+	if mongo.IsDuplicateKeyError(err) {
+		//made by a checkout running at the same time
+		err = UserCollection.FindOne(ctx, filter, options.FindOne().SetProjection(bson.M{"orders": 0})).Decode(&guest)
+	}
+	return guest, err
+}
+
+// guestOrder finds the order of a guest by ?number= and ?email=, answering the request when there is none.
+// Every ip and every email only gets GuestLookupLimit tries in GuestLookupWindow.
+func guestOrder(c *gin.Context, ctx context.Context) (models.User, models.Order, bool) {
+	var guest models.User
+	number, err := ordernumber.Normalize(c.Query("number"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return guest, models.Order{}, false
+	}
+	email, err := guestEmail(c.Query("email"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return guest, models.Order{}, false
+	}
+	//the ip is the one of the connection unless it comes through one of TRUSTED_PROXIES
+	if !guestLookups.allow(time.Now(), "ip:"+c.ClientIP(), "email:"+email) {
+		c.Header("Retry-After", strconv.Itoa(int(GuestLookupWindow.Seconds())))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many order lookups, try again later"})
+		return guest, models.Order{}, false
+	}
+	projection := bson.M{"orders.$": 1, "email": 1, "guest": 1}
+	filter := bson.M{"email": email, "guest": true, "orders.order_number": number}
+	err = UserCollection.FindOne(ctx, filter, options.FindOne().SetProjection(projection)).Decode(&guest)
+	if err == mongo.ErrNoDocuments || (err == nil && len(guest.Order_Status) == 0) {
+		//the same answer for a wrong number and a wrong email
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "no order with this number and email"})
+		return guest, models.Order{}, false
+	}
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+		return guest, models.Order{}, false
+	}
+	return guest, guest.Order_Status[0], true
+}
+
+//function placing an order with everything in the guest cart, no account needed
+//the cart is checked against the catalog like the cart checkout, the shipping address goes inline
+//POST request : http://localhost:8000/guest/checkout with the Cart-Token header
+/*
+{
+"email"            : "someone@example.com",
+"shipping_address" : {"recipient_name":"Joseph Hermis","house_name":"jupyterlab","street_name":"notebook","city_name":"mars","pin_code":"685607"},
+"payment"          : "digital"
+}
+*/
+
+func GuestCheckout() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		request, err := bindCheckout(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		email, err := guestEmail(request.Email)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if request.Shipping_Address_ID != "" || request.Billing_Address_ID != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "guests have no address book, send the addresses inline"})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		if c.GetString("uid") != "" || cartToken(c) == "" {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "guest checkout needs the Cart-Token of a guest cart"})
+			return
+		}
+		cart, ok := requestCart(c, ctx, false)
+		if !ok {
+			return
+		}
+		lines, err := cart.lines(ctx)
+		if err != nil {
+			cartError(c, err)
+			return
+		}
+		if len(lines) == 0 {
+			c.IndentedJSON(400, "Cart is empty")
+			return
+		}
+		shipping, billing, err := checkoutAddresses(request, models.User{})
+		if err != nil {
+			addressError(c, err)
+			return
+		}
+		cartitems, ok := checkCart(c, ctx, lines)
+		if !ok {
+			return
+		}
+		guest, err := guestUser(ctx, email)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+			return
+		}
+		order, err := newOrder(ctx)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+			return
+		}
+		order.Shipping_Address = shipping
+		order.Billing_Address = billing
+		pricing.PriceOrder(&order, cartitems)
+		if !reserveStock(c, ctx, &order, guest.ID, request.Payment) {
+			return
+		}
+		update := bson.M{"$push": bson.M{"orders": order}, "$set": bson.M{"updated_at": time.Now()}}
+		if _, err := UserCollection.UpdateOne(ctx, bson.M{"_id": guest.ID}, update); err != nil {
+			releaseStock(ctx, order)
+			c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+			return
+		}
+		if _, err := GuestCartCollection.DeleteOne(ctx, bson.M{"_id": cart.id}); err != nil {
+			log.Println(err)
+		}
+		c.IndentedJSON(200, gin.H{"message": "Successfully Placed the order", "order_id": order.Order_ID, "order_number": order.Order_Number, "email": email, "status": order.Status, "reserved_until": order.Reserved_Until, "totals": order.Totals})
+	}
+}
+
+//function showing a guest order, only with the right order number and email
+//GET request : http://localhost:8000/guest/orders?number=ORD-2026-000042-5&email=someone@example.com
+
+func GuestOrderLookup() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		guest, order, ok := guestOrder(c, ctx)
+		if !ok {
+			return
+		}
+		c.IndentedJSON(200, gin.H{"email": guest.Email, "order": order})
+	}
+}
+
+//function asking for an account, a link to finish it is sent to the email of the order
+//whoever asks, only the owner of the email can use the link
+//POST request : http://localhost:8000/guest/orders/account?number=ORD-2026-000042-5&email=someone@example.com
+
+func GuestAccount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		guest, _, ok := guestOrder(c, ctx)
+		if !ok {
+			return
+		}
+		count, err := UserCollection.CountDocuments(ctx, bson.M{"email": guest.Email, "guest": bson.M{"$ne": true}})
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": ErrAccountExists.Error()})
+			return
+		}
+		expires := time.Now().Add(AccountLinkTTL)
+		link := AccountLinkURL + "?token=" + url.QueryEscape(generate.AccountToken(guest.ID, deref(guest.Email), expires))
+		message := notify.Message{
+			Kind:    events.AccountLink,
+			To:      deref(guest.Email),
+			Subject: "Finish your account",
+			Body:    fmt.Sprintf("Open %s to choose a password, your orders come along. The link works until %s", link, expires.Format(time.RFC1123)),
+			Data:    gin.H{"user_id": guest.ID, "expires_at": expires},
+		}
+		if err := notify.Default.Notify(ctx, message); err != nil {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+			return
+		}
+		c.IndentedJSON(http.StatusAccepted, gin.H{"message": "we sent a link to finish the account to the email of the order"})
+	}
+}
+
+//function turning a guest into an account with the link from the email, every guest order placed with the email comes along
+//POST request : http://localhost:8000/guest/account?token=xxxtoken_from_the_linkxxx
+/*
+{
+"first_name" : "joseph",
+"last_name"  : "hermis",
+"phone"      : "1156422222",
+"password"   : "coollcollcoll"
+}
+*/
+
+func ConfirmGuestAccount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		guestt_id, email, err := generate.ValidateAccountToken(c.Query("token"), time.Now())
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		var request struct {
+			First_Name *string `json:"first_name"`
+			Last_Name  *string `json:"last_name"`
+			Phone      *string `json:"phone"`
+			Password   *string `json:"password"`
+		}
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		var guest models.User
+		err = UserCollection.FindOne(ctx, bson.M{"_id": guestt_id, "email": email, "guest": true}, options.FindOne().SetProjection(bson.M{"email": 1})).Decode(&guest)
+		if err == mongo.ErrNoDocuments {
+			//used already, the account exists
+			c.JSON(http.StatusConflict, gin.H{"error": ErrAccountExists.Error()})
+			return
+		}
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+			return
+		}
+		user := models.User{
+			ID:         guest.ID,
+			First_Name: request.First_Name,
+			Last_Name:  request.Last_Name,
+			Phone:      request.Phone,
+			Password:   request.Password,
+			Email:      guest.Email,
+		}
+		if err := Validate.Struct(user); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		count, err := UserCollection.CountDocuments(ctx, bson.M{"email": user.Email, "guest": bson.M{"$ne": true}})
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": ErrAccountExists.Error()})
+			return
+		}
+		count, err = UserCollection.CountDocuments(ctx, bson.M{"phone": user.Phone})
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Phone is already in use"})
+			return
+		}
+		password := HashPassword(*user.Password)
+		token, refreshtoken, err := generate.TokenGenerator(*user.Email, *user.First_Name, *user.Last_Name, user.ID.Hex())
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+			return
+		}
+		update := bson.M{
+			"$set": bson.M{
+				"first_name":    user.First_Name,
+				"last_name":     user.Last_Name,
+				"phone":         user.Phone,
+				"password":      password,
+				"token":         token,
+				"refresh_token": refreshtoken,
+				"updated_at":    time.Now(),
+			},
+			"$unset": bson.M{"guest": ""},
+		}
+		result, err := UserCollection.UpdateOne(ctx, bson.M{"_id": guest.ID, "guest": true}, update)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": ErrAccountExists.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"message": "Successfully Signed Up!!", "user_id": guest.ID.Hex(), "token": token, "refresh_token": refreshtoken})
+	}
+}
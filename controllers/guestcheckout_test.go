@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLookupLimiter(t *testing.T) {
+	limiter := &lookupLimiter{limit: 3, window: time.Minute, seen: make(map[string][]time.Time)}
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if !limiter.allow(start.Add(time.Duration(i)*time.Second), "ip:10.0.0.1") {
+			t.Fatalf("lookup %d was refused", i+1)
+		}
+	}
+	if limiter.allow(start.Add(5*time.Second), "ip:10.0.0.1") {
+		t.Error("a fourth lookup in the window was allowed")
+	}
+	if !limiter.allow(start.Add(5*time.Second), "ip:10.0.0.2") {
+		t.Error("another ip was refused")
+	}
+	//the first lookup left the window, one more fits
+	if !limiter.allow(start.Add(time.Minute+500*time.Millisecond), "ip:10.0.0.1") {
+		t.Error("the window did not move on")
+	}
+	if limiter.allow(start.Add(time.Minute+600*time.Millisecond), "ip:10.0.0.1") {
+		t.Error("refused lookups should not free up room")
+	}
+}
+
+func TestLookupLimiterKeys(t *testing.T) {
+	limiter := &lookupLimiter{limit: 2, window: time.Minute, seen: make(map[string][]time.Time)}
+	now := time.Now()
+	limiter.allow(now, "ip:10.0.0.1", "email:someone@example.com")
+	limiter.allow(now, "ip:10.0.0.2", "email:someone@example.com")
+	//the email is used up, the new ip must not be charged for the refused try
+	if limiter.allow(now, "ip:10.0.0.3", "email:someone@example.com") {
+		t.Fatal("a used up email was allowed from another ip")
+	}
+	if got := len(limiter.seen["ip:10.0.0.3"]); got != 0 {
+		t.Errorf("the refused try was recorded against the ip %d times", got)
+	}
+	for i := 0; i < 2; i++ {
+		if !limiter.allow(now, "ip:10.0.0.3", "email:other@example.com") {
+			t.Fatalf("try %d of a fresh ip and email was refused", i+1)
+		}
+	}
+	//the ip is used up, the fresh email must not be charged either
+	if limiter.allow(now, "ip:10.0.0.3", "email:third@example.com") {
+		t.Fatal("a used up ip was allowed with another email")
+	}
+	if got := len(limiter.seen["email:third@example.com"]); got != 0 {
+		t.Errorf("the refused try was recorded against the email %d times", got)
+	}
+}
@@ -0,0 +1,436 @@
+package controllers
+
+import (
+	"context"
+	"ecommerce/imaging"
+	"ecommerce/models"
+	"ecommerce/storage"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	maxProductImages = 20
+	maxAltLength     = 300
+)
+
+var ErrTooManyImages = fmt.Errorf("a product can have at most %d images", maxProductImages)
+var ErrImageNotFound = errors.New("image not found")
+var ErrImageOrder = errors.New("images has to list every image of the product once")
+var ErrNoImages = errors.New("send the images as multipart form files named image")
+
+// the main image of a product is the medium size of its first uploaded image
+const coverSize = "medium"
+
+// readUpload reads one uploaded file, refusing it as soon as it is larger than imaging.MaxBytes
+func readUpload(header *multipart.FileHeader) ([]byte, error) {
+	if header.Size > imaging.MaxBytes {
+		return nil, imaging.ErrTooLarge
+	}
+	file, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(file, imaging.MaxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > imaging.MaxBytes {
+		return nil, imaging.ErrTooLarge
+	}
+	return data, nil
+}
+
+// storeImage checks an upload, scales it and puts every size in the blob store
+func storeImage(ctx context.Context, product_id primitive.ObjectID, header *multipart.FileHeader, alt string) (models.ProductImage, error) {
+	image := models.ProductImage{
+		Image_ID:    primitive.NewObjectID(),
+		Alt:         alt,
+		Sizes:       make(map[string]models.ImageFile),
+		Uploaded_At: time.Now(),
+	}
+	data, err := readUpload(header)
+	if err != nil {
+		return image, err
+	}
+	renditions, err := imaging.Process(data)
+	if err != nil {
+		return image, err
+	}
+	for _, rendition := range renditions {
+		key := fmt.Sprintf("products/%s/%s/%s.%s", product_id.Hex(), image.Image_ID.Hex(), rendition.Name, rendition.Extension)
+		if err := storage.Default.Put(ctx, key, rendition.Content_Type, rendition.Data); err != nil {
+			deleteImageFiles(ctx, []models.ProductImage{image})
+			return image, err
+		}
+		image.Sizes[rendition.Name] = models.ImageFile{
+			Key:          key,
+			URL:          storage.Default.URL(key),
+			Content_Type: rendition.Content_Type,
+			Width:        rendition.Width,
+			Height:       rendition.Height,
+			Bytes:        len(rendition.Data),
+		}
+	}
+	return image, nil
+}
+
+// deleteImageFiles removes every size of the images from the blob store, a
+// file that stays behind only costs space so failures are just logged
+func deleteImageFiles(ctx context.Context, images []models.ProductImage) {
+	for _, image := range images {
+		for _, file := range image.Sizes {
+			if err := storage.Default.Delete(ctx, file.Key); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
+
+// uploadImages stores the files sent in the image fields of a multipart form,
+// an alt field per file describes it. Answers the request and returns false when it fails.
+func uploadImages(c *gin.Context, ctx context.Context, product_id primitive.ObjectID, form *multipart.Form) ([]models.ProductImage, bool) {
+	files := form.File["image"]
+	if len(files) > maxProductImages {
+		c.JSON(http.StatusBadRequest, gin.H{"error": ErrTooManyImages.Error()})
+		return nil, false
+	}
+	alts := form.Value["alt"]
+	images := make([]models.ProductImage, 0, len(files))
+	for i, header := range files {
+		alt := ""
+		if i < len(alts) {
+			alt = strings.TrimSpace(alts[i])
+		}
+		if len(alt) > maxAltLength {
+			deleteImageFiles(ctx, images)
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("alt can be at most %d characters", maxAltLength)})
+			return nil, false
+		}
+		image, err := storeImage(ctx, product_id, header, alt)
+		if err != nil {
+			deleteImageFiles(ctx, images)
+			imageError(c, header.Filename, err)
+			return nil, false
+		}
+		images = append(images, image)
+	}
+	return images, true
+}
+
+func imageError(c *gin.Context, filename string, err error) {
+	switch err {
+	case imaging.ErrTooLarge:
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("%s: %s, at most %d bytes", filename, err.Error(), imaging.MaxBytes)})
+	case imaging.ErrUnsupported:
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": filename + ": " + err.Error()})
+	case imaging.ErrBroken, imaging.ErrTooManyPixels:
+		c.JSON(http.StatusBadRequest, gin.H{"error": filename + ": " + err.Error()})
+	default:
+		log.Println(err)
+		c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+	}
+}
+
+// limitUpload caps the size of a multipart request before it is read
+func limitUpload(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, imaging.MaxBytes*maxProductImages+1<<20)
+}
+
+// bindNewProduct reads a product being added, from the json body or from the
+// product field of a multipart form that carries its images along. The
+// form is returned so the images can be stored once the product has an id.
+func bindNewProduct(c *gin.Context, product *models.Product) (*multipart.Form, bool) {
+	var form *multipart.Form
+	if c.ContentType() == "multipart/form-data" {
+		limitUpload(c)
+		var err error
+		if form, err = c.MultipartForm(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return nil, false
+		}
+		values := form.Value["product"]
+		if len(values) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "send the product as json in a form field named product"})
+			return nil, false
+		}
+		if err := json.Unmarshal([]byte(values[0]), product); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return nil, false
+		}
+	} else if err := c.BindJSON(product); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	//images only come in as uploads
+	product.Images = nil
+	return form, true
+}
+
+// coverImage is the main image url for the images, nil when there are none
+func coverImage(images []models.ProductImage) *string {
+	if len(images) == 0 {
+		return nil
+	}
+	file, ok := images[0].Sizes[coverSize]
+	if !ok {
+		file = images[0].Sizes[imaging.Original]
+	}
+	url := file.URL
+	return &url
+}
+
+// uploaded reports whether the url is one of the images
+func uploaded(images []models.ProductImage, url *string) bool {
+	if url == nil {
+		return false
+	}
+	for _, image := range images {
+		for _, file := range image.Sizes {
+			if file.URL == *url {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// changeImages saves the images change makes of the product, trying again
+// when somebody else saved the product in between since change looks at the
+// current images every time. The main image follows the first image, once the
+// last image is gone a main image that was uploaded goes with it.
+func changeImages(ctx context.Context, productt_id primitive.ObjectID, change func(product models.Product) ([]models.ProductImage, error)) (models.Product, models.Product, error) {
+	for attempt := 0; attempt < 3; attempt++ {
+		product, err := findProduct(ctx, productt_id)
+		if err != nil {
+			return product, product, err
+		}
+		images, err := change(product)
+		if err != nil {
+			return product, product, err
+		}
+		filter := bson.M{"_id": product.Product_ID, "version": product.Version}
+		if product.Version == 0 {
+			filter["version"] = bson.M{"$in": bson.A{0, nil}}
+		}
+		set := bson.M{"images": images, "updated_at": time.Now()}
+		unset := bson.M{}
+		if cover := coverImage(images); cover != nil {
+			set["image"] = cover
+		} else if uploaded(product.Images, product.Image) {
+			unset["image"] = ""
+		}
+		update := bson.M{"$set": set, "$inc": bson.M{"version": 1}}
+		if len(unset) > 0 {
+			update["$unset"] = unset
+		}
+		result, err := ProductCollection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			return product, product, err
+		}
+		if result.MatchedCount == 0 {
+			continue
+		}
+		saved, err := findProduct(ctx, productt_id)
+		return product, saved, err
+	}
+	return models.Product{}, models.Product{}, ErrProductChanged
+}
+
+func imagesError(c *gin.Context, ctx context.Context, productt_id primitive.ObjectID, err error) {
+	switch err {
+	case ErrTooManyImages, ErrImageOrder:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case ErrImageNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		productError(c, ctx, productt_id, err)
+	}
+}
+
+//admin function uploading images of a product as multipart form files named image, several at once
+//jpeg, png and gif up to MAX_IMAGE_BYTES, each is kept as uploaded and scaled down to large, medium and thumbnail
+//an alt field per image describes it, position puts them at that place among the images (0 is first), they go last without it
+//POST request : http://localhost:8000/admin/products/xxxproduct_idxxx/images
+//curl -F image=@front.jpg -F alt="from the front" -F image=@back.jpg -F alt="from the back" http://localhost:8000/admin/products/xxxproduct_idxxx/images
+
+func UploadProductImages() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productt_id, ok := productParam(c)
+		if !ok {
+			return
+		}
+		limitUpload(c)
+		form, err := c.MultipartForm()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if len(form.File["image"]) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": ErrNoImages.Error()})
+			return
+		}
+		position := -1
+		if values := form.Value["position"]; len(values) > 0 && values[0] != "" {
+			position, err = strconv.Atoi(values[0])
+			if err != nil || position < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "position must be a whole positive number"})
+				return
+			}
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		product, err := findProduct(ctx, productt_id)
+		if err != nil {
+			productError(c, ctx, productt_id, err)
+			return
+		}
+		if len(product.Images)+len(form.File["image"]) > maxProductImages {
+			c.JSON(http.StatusBadRequest, gin.H{"error": ErrTooManyImages.Error()})
+			return
+		}
+		added, ok := uploadImages(c, ctx, productt_id, form)
+		if !ok {
+			return
+		}
+		_, saved, err := changeImages(ctx, productt_id, func(product models.Product) ([]models.ProductImage, error) {
+			if len(product.Images)+len(added) > maxProductImages {
+				return nil, ErrTooManyImages
+			}
+			at := position
+			if at < 0 || at > len(product.Images) {
+				at = len(product.Images)
+			}
+			images := make([]models.ProductImage, 0, len(product.Images)+len(added))
+			images = append(images, product.Images[:at]...)
+			images = append(images, added...)
+			return append(images, product.Images[at:]...), nil
+		})
+		if err != nil {
+			deleteImageFiles(ctx, added)
+			imagesError(c, ctx, productt_id, err)
+			return
+		}
+		c.IndentedJSON(200, saved)
+	}
+}
+
+//admin function putting the images of a product in order and changing their alt text
+//every image of the product has to be listed, the first one is the main image
+//PUT request : http://localhost:8000/admin/products/xxxproduct_idxxx/images
+/*
+{
+"images" : [{"_id":"xxximage_idxxx","alt":"from the back"},{"_id":"xxximage_idxxx"}]
+}
+*/
+
+func ArrangeProductImages() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productt_id, ok := productParam(c)
+		if !ok {
+			return
+		}
+		var request struct {
+			Images []struct {
+				Image_ID primitive.ObjectID `json:"_id"`
+				Alt      *string            `json:"alt"`
+			} `json:"images"`
+		}
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		for _, listed := range request.Images {
+			if listed.Alt != nil && len(strings.TrimSpace(*listed.Alt)) > maxAltLength {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("alt can be at most %d characters", maxAltLength)})
+				return
+			}
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		_, saved, err := changeImages(ctx, productt_id, func(product models.Product) ([]models.ProductImage, error) {
+			if len(request.Images) != len(product.Images) {
+				return nil, ErrImageOrder
+			}
+			byID := make(map[primitive.ObjectID]models.ProductImage, len(product.Images))
+			for _, image := range product.Images {
+				byID[image.Image_ID] = image
+			}
+			images := make([]models.ProductImage, 0, len(product.Images))
+			for _, listed := range request.Images {
+				image, ok := byID[listed.Image_ID]
+				if !ok {
+					return nil, ErrImageOrder
+				}
+				delete(byID, listed.Image_ID)
+				if listed.Alt != nil {
+					image.Alt = strings.TrimSpace(*listed.Alt)
+				}
+				images = append(images, image)
+			}
+			return images, nil
+		})
+		if err != nil {
+			imagesError(c, ctx, productt_id, err)
+			return
+		}
+		c.IndentedJSON(200, saved)
+	}
+}
+
+//admin function removing an image of a product, every size of it is deleted from the storage
+//DELETE request : http://localhost:8000/admin/products/xxxproduct_idxxx/images/xxximage_idxxx
+
+func DeleteProductImage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productt_id, ok := productParam(c)
+		if !ok {
+			return
+		}
+		imaget_id, err := primitive.ObjectIDFromHex(c.Param("image_id"))
+		if err != nil {
+			c.Header("Content-Type", "application/json")
+			c.JSON(http.StatusNotFound, gin.H{"error": "Invalid image id"})
+			c.Abort()
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		var removed []models.ProductImage
+		_, saved, err := changeImages(ctx, productt_id, func(product models.Product) ([]models.ProductImage, error) {
+			removed = nil
+			images := make([]models.ProductImage, 0, len(product.Images))
+			for _, image := range product.Images {
+				if image.Image_ID == imaget_id {
+					removed = append(removed, image)
+					continue
+				}
+				images = append(images, image)
+			}
+			if len(removed) == 0 {
+				return nil, ErrImageNotFound
+			}
+			return images, nil
+		})
+		if err != nil {
+			imagesError(c, ctx, productt_id, err)
+			return
+		}
+		deleteImageFiles(ctx, removed)
+		c.IndentedJSON(200, saved)
+	}
+}
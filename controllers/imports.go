@@ -0,0 +1,781 @@
+package controllers
+
+import (
+	"context"
+	"ecommerce/database"
+	"ecommerce/inventory"
+	"ecommerce/models"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+//the catalog goes in and out as csv or json with one row per product and one
+//per variant. Rows are matched to products by sku, rows with a parent_sku
+//change a variant of that product. Export and import use the same columns so
+//an export can be edited and imported again, only the rating is left alone
+//since it comes from the reviews.
+/*
+sku,parent_sku,product_id,product_name,price,rating,image,stock,reorder_point,categories,archived,options
+AW-X15,,xxxproduct_idxxx,Alienware x15,2500,5,alienware.jpg,12,3,gaming-laptops|laptops,false,
+TSHIRT-M-RED,TSHIRT,,,25,,,40,5,,,color=Red|size=M
+*/
+
+const (
+	ImportQueued   = "queued"
+	ImportRunning  = "running"
+	ImportFinished = "finished"
+	ImportFailed   = "failed"
+)
+
+const (
+	maxImportErrors = 1000
+	importProgress  = 100
+)
+
+var ImportCollection *mongo.Collection = database.UserData(database.Client, "ImportJobs")
+
+// MaxImportBytes is the largest file an import takes, IMPORT_MAX_BYTES sets it (default 50MB)
+var MaxImportBytes = maxImportBytes()
+
+func maxImportBytes() int64 {
+	if size, err := strconv.ParseInt(os.Getenv("IMPORT_MAX_BYTES"), 10, 64); err == nil && size > 0 {
+		return size
+	}
+	return 50 << 20
+}
+
+var ErrImportFormat = errors.New("format has to be csv or json")
+
+var catalogColumns = []string{"sku", "parent_sku", "product_id", "product_name", "price", "rating", "image", "stock", "reorder_point", "categories", "archived", "options"}
+
+// catalogRow is one product or variant, fields left empty are not changed by an import
+type catalogRow struct {
+	SKU           string              `json:"sku"`
+	Parent_SKU    string              `json:"parent_sku,omitempty"`
+	Product_ID    *primitive.ObjectID `json:"product_id,omitempty"`
+	Product_Name  *string             `json:"product_name,omitempty"`
+	Price         *uint64             `json:"price,omitempty"`
+	Rating        *uint8              `json:"rating,omitempty"`
+	Image         *string             `json:"image,omitempty"`
+	Stock         *int                `json:"stock,omitempty"`
+	Reorder_Point *int                `json:"reorder_point,omitempty"`
+	Categories    []string            `json:"categories,omitempty"`
+	Archived      *bool               `json:"archived,omitempty"`
+	Options       map[string]string   `json:"options,omitempty"`
+}
+
+// importRow is a row of the file with its number, rows that could not be
+// read carry the reason instead
+type importRow struct {
+	Number int
+	Row    catalogRow
+	Err    error
+}
+
+type ImportError struct {
+	Row   int    `json:"row" bson:"row"`
+	SKU   string `json:"sku,omitempty" bson:"sku,omitempty"`
+	Error string `json:"error" bson:"error"`
+}
+
+type ImportJob struct {
+	Job_ID      primitive.ObjectID `json:"_id" bson:"_id"`
+	Filename    string             `json:"filename" bson:"filename"`
+	Format      string             `json:"format" bson:"format"`
+	Dry_Run     bool               `json:"dry_run" bson:"dry_run"`
+	Status      string             `json:"status" bson:"status"`
+	Total       int                `json:"total" bson:"total"`
+	Processed   int                `json:"processed" bson:"processed"`
+	Created     int                `json:"created" bson:"created"`
+	Updated     int                `json:"updated" bson:"updated"`
+	Unchanged   int                `json:"unchanged" bson:"unchanged"`
+	Failed      int                `json:"failed" bson:"failed"`
+	Errors      []ImportError      `json:"errors" bson:"errors"`
+	Error       string             `json:"error,omitempty" bson:"error,omitempty"`
+	Created_At  time.Time          `json:"created_at" bson:"created_at"`
+	Started_At  *time.Time         `json:"started_at,omitempty" bson:"started_at,omitempty"`
+	Finished_At *time.Time         `json:"finished_at,omitempty" bson:"finished_at,omitempty"`
+}
+
+// fail counts a row that could not be imported, only the first errors are kept
+func (job *ImportJob) fail(number int, sku string, err error) {
+	job.Failed++
+	if len(job.Errors) < maxImportErrors {
+		job.Errors = append(job.Errors, ImportError{Row: number, SKU: sku, Error: err.Error()})
+	}
+}
+
+func (job *ImportJob) save(ctx context.Context) {
+	if _, err := ImportCollection.ReplaceOne(ctx, bson.M{"_id": job.Job_ID}, job); err != nil {
+		log.Println(err)
+	}
+}
+
+/**************************************************READING********************************************************************************************************/
+
+// importFormat is the format asked for with ?format=, or the one of the file name
+func importFormat(format string, filename string) (string, error) {
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(filename)), ".")
+	}
+	switch format {
+	case "csv", "json":
+		return format, nil
+	}
+	return "", ErrImportFormat
+}
+
+// readCSV reads the rows of a csv file, the header names the columns and may
+// leave out any but sku. Cells that are empty change nothing.
+func readCSV(data []byte) ([]importRow, error) {
+	reader := csv.NewReader(strings.NewReader(string(data)))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("the file is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int, len(header))
+	known := make(map[string]bool, len(catalogColumns))
+	for _, column := range catalogColumns {
+		known[column] = true
+	}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if !known[name] {
+			return nil, fmt.Errorf("unknown column %q, the columns are %s", name, strings.Join(catalogColumns, ", "))
+		}
+		if _, twice := columns[name]; twice {
+			return nil, fmt.Errorf("column %q is there twice", name)
+		}
+		columns[name] = i
+	}
+	if _, ok := columns["sku"]; !ok {
+		return nil, errors.New("the sku column is required")
+	}
+	rows := make([]importRow, 0)
+	//rows are counted from the header, which is row 1
+	for number := 2; ; number++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var parse *csv.ParseError
+		if errors.As(err, &parse) {
+			rows = append(rows, importRow{Number: number, Err: err})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		cell := func(name string) string {
+			i, ok := columns[name]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+		row, err := csvRow(cell)
+		rows = append(rows, importRow{Number: number, Row: row, Err: err})
+	}
+	return rows, nil
+}
+
+func csvRow(cell func(string) string) (catalogRow, error) {
+	row := catalogRow{SKU: cell("sku"), Parent_SKU: cell("parent_sku")}
+	if value := cell("product_id"); value != "" {
+		id, err := primitive.ObjectIDFromHex(value)
+		if err != nil {
+			return row, errors.New("product_id is not a valid id")
+		}
+		row.Product_ID = &id
+	}
+	if value := cell("product_name"); value != "" {
+		row.Product_Name = &value
+	}
+	if value := cell("image"); value != "" {
+		row.Image = &value
+	}
+	if value := cell("price"); value != "" {
+		price, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return row, errors.New("price must be a whole positive number")
+		}
+		row.Price = &price
+	}
+	if value := cell("rating"); value != "" {
+		rating, err := strconv.ParseUint(value, 10, 8)
+		if err != nil {
+			return row, errors.New("rating must be a whole number from 0 to 10")
+		}
+		small := uint8(rating)
+		row.Rating = &small
+	}
+	for _, field := range []struct {
+		name   string
+		target **int
+	}{{"stock", &row.Stock}, {"reorder_point", &row.Reorder_Point}} {
+		value := cell(field.name)
+		if value == "" {
+			continue
+		}
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return row, errors.New(field.name + " must be a whole number")
+		}
+		*field.target = &number
+	}
+	if value := cell("categories"); value != "" {
+		row.Categories = strings.Split(value, "|")
+	}
+	if value := cell("archived"); value != "" {
+		archived, err := strconv.ParseBool(value)
+		if err != nil {
+			return row, errors.New("archived must be true or false")
+		}
+		row.Archived = &archived
+	}
+	return row, nil
+}
+
+// readJSON reads a json array of rows, a row that does not fit does not stop the others
+func readJSON(data []byte) ([]importRow, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, errors.New("the file has to be a json array of products: " + err.Error())
+	}
+	rows := make([]importRow, 0, len(raw))
+	for i, message := range raw {
+		var row catalogRow
+		err := json.Unmarshal(message, &row)
+		row.SKU = strings.TrimSpace(row.SKU)
+		row.Parent_SKU = strings.TrimSpace(row.Parent_SKU)
+		rows = append(rows, importRow{Number: i + 1, Row: row, Err: err})
+	}
+	return rows, nil
+}
+
+/**************************************************IMPORTING********************************************************************************************************/
+
+// findBySKU finds the product that has the sku itself or as one of its variants
+func findBySKU(ctx context.Context, sku string) (models.Product, bool, error) {
+	var product models.Product
+	err := ProductCollection.FindOne(ctx, bson.M{"$or": bson.A{bson.M{"sku": sku}, bson.M{"variants.sku": sku}}}).Decode(&product)
+	if err == mongo.ErrNoDocuments {
+		return product, false, nil
+	}
+	return product, err == nil, err
+}
+
+// importRowInto applies the row to the product it matches, or to a new one.
+// It returns what the product looked like before and after, before has a
+// zero id when the product is new.
+func importRowInto(ctx context.Context, row catalogRow) (models.Product, models.Product, error) {
+	if row.SKU == "" {
+		return models.Product{}, models.Product{}, errors.New("sku is required")
+	}
+	if row.Parent_SKU != "" {
+		return importVariant(ctx, row)
+	}
+	if len(row.Options) > 0 {
+		return models.Product{}, models.Product{}, errors.New("options only go on variant rows, variants are made with /admin/products/:id/options")
+	}
+	product, found, err := findBySKU(ctx, row.SKU)
+	if err != nil {
+		return models.Product{}, models.Product{}, err
+	}
+	if found && (product.SKU == nil || !strings.EqualFold(*product.SKU, row.SKU)) {
+		return models.Product{}, models.Product{}, fmt.Errorf("sku %s belongs to a variant, give its product in parent_sku", row.SKU)
+	}
+	if found && row.Product_ID != nil && *row.Product_ID != product.Product_ID {
+		return models.Product{}, models.Product{}, errors.New("sku and product_id are of different products")
+	}
+	if !found && row.Product_ID != nil {
+		//a product without a sku yet, or one whose sku is being changed
+		if product, err = findProduct(ctx, *row.Product_ID); err != nil {
+			return models.Product{}, models.Product{}, err
+		}
+		found = true
+	}
+	before := product
+	if !found {
+		if row.Product_Name == nil || row.Price == nil {
+			return models.Product{}, models.Product{}, errors.New("product_name and price are required to add a product")
+		}
+		product = models.Product{Product_ID: primitive.NewObjectID()}
+		before = models.Product{}
+	}
+	before.Variants = append([]models.Variant(nil), product.Variants...)
+	sku := row.SKU
+	request := productRequest{
+		Product_Name:  row.Product_Name,
+		Price:         row.Price,
+		Image:         row.Image,
+		Stock:         row.Stock,
+		Categories:    row.Categories,
+		SKU:           &sku,
+		Reorder_Point: row.Reorder_Point,
+		Archived:      row.Archived,
+	}
+	request.apply(&product, false)
+	if len(before.Stock_Levels) > 0 && row.Stock != nil && (before.Stock == nil || *row.Stock != *before.Stock) {
+		return before, product, inventory.ErrLocationRequired
+	}
+	return before, product, nil
+}
+
+// importVariant applies a row to an existing variant of the parent product
+func importVariant(ctx context.Context, row catalogRow) (models.Product, models.Product, error) {
+	if row.Product_Name != nil || row.Rating != nil || row.Categories != nil || row.Archived != nil {
+		return models.Product{}, models.Product{}, errors.New("a variant row can only change price, image, stock and reorder_point")
+	}
+	product, found, err := findBySKU(ctx, row.Parent_SKU)
+	if err != nil {
+		return models.Product{}, models.Product{}, err
+	}
+	if !found || product.SKU == nil || !strings.EqualFold(*product.SKU, row.Parent_SKU) {
+		return models.Product{}, models.Product{}, fmt.Errorf("there is no product with the sku %s", row.Parent_SKU)
+	}
+	before := product
+	before.Variants = append([]models.Variant(nil), product.Variants...)
+	product.Variants = append([]models.Variant(nil), product.Variants...)
+	variant, err := findVariant(product, row.SKU)
+	if err != nil {
+		return models.Product{}, models.Product{}, fmt.Errorf("%s has no variant %s, variants are made with /admin/products/:id/options", row.Parent_SKU, row.SKU)
+	}
+	if row.Price != nil {
+		variant.Price = row.Price
+	}
+	if row.Image != nil {
+		variant.Images = []string{*row.Image}
+	}
+	if row.Stock != nil {
+		if len(variant.Stock_Levels) > 0 && (variant.Stock == nil || *row.Stock != *variant.Stock) {
+			return before, product, inventory.ErrLocationRequired
+		}
+		variant.Stock = row.Stock
+	}
+	if row.Reorder_Point != nil {
+		variant.Reorder_Point = row.Reorder_Point
+	}
+	return before, product, nil
+}
+
+// importOne checks a row and, unless it is a dry run, saves it. It returns
+// whether the row created, updated or left the product as it was.
+func importOne(ctx context.Context, row catalogRow, dry_run bool) (string, error) {
+	for attempt := 0; ; attempt++ {
+		before, product, err := importRowInto(ctx, row)
+		if err != nil {
+			return "", err
+		}
+		if err := Validate.Struct(product); err != nil {
+			return "", err
+		}
+		if err := checkCategories(ctx, product.Categories); err != nil {
+			return "", err
+		}
+		if err := checkSKUs(ctx, product); err != nil {
+			return "", err
+		}
+		if before.Product_ID.IsZero() {
+			if dry_run {
+				return "created", nil
+			}
+			product.Version = 1
+			product.Created_At = time.Now()
+			product.Updated_At = product.Created_At
+			if _, err := ProductCollection.InsertOne(ctx, product); err != nil {
+				return "", err
+			}
+			inventory.RecordEdits(ctx, models.Product{}, product)
+			indexProduct(ctx, product)
+			return "created", nil
+		}
+		if reflect.DeepEqual(before, product) {
+			return "unchanged", nil
+		}
+		if dry_run {
+			return "updated", nil
+		}
+		saved, err := saveProduct(ctx, product, before.Version)
+		if err == ErrProductChanged && attempt < 2 {
+			//sold or edited while importing, the row is applied to the new version
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		inventory.RecordEdits(ctx, before, saved)
+		return "updated", nil
+	}
+}
+
+// runImport works through the rows in the background, the job shows how far it got
+func runImport(job ImportJob, rows []importRow) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Hour)
+	defer cancel()
+	defer func() {
+		if failure := recover(); failure != nil {
+			log.Println("import", job.Job_ID.Hex(), failure)
+			finished := time.Now()
+			job.Status = ImportFailed
+			job.Error = fmt.Sprint(failure)
+			job.Finished_At = &finished
+			job.save(ctx)
+		}
+	}()
+	started := time.Now()
+	job.Status = ImportRunning
+	job.Started_At = &started
+	job.save(ctx)
+	seen := make(map[string]int)
+	for i, read := range rows {
+		sku := strings.ToUpper(read.Row.SKU)
+		switch first, twice := seen[sku]; {
+		case read.Err != nil:
+			job.fail(read.Number, read.Row.SKU, read.Err)
+		case sku != "" && twice:
+			job.fail(read.Number, read.Row.SKU, fmt.Errorf("sku %s is already in row %d", read.Row.SKU, first))
+		default:
+			if sku != "" {
+				seen[sku] = read.Number
+			}
+			outcome, err := importOne(ctx, read.Row, job.Dry_Run)
+			switch {
+			case err != nil:
+				job.fail(read.Number, read.Row.SKU, err)
+			case outcome == "created":
+				job.Created++
+			case outcome == "updated":
+				job.Updated++
+			default:
+				job.Unchanged++
+			}
+		}
+		job.Processed++
+		if (i+1)%importProgress == 0 {
+			job.save(ctx)
+		}
+	}
+	finished := time.Now()
+	job.Status = ImportFinished
+	job.Finished_At = &finished
+	job.save(ctx)
+}
+
+//admin function importing products from a csv or json file sent as a multipart form file named file
+//rows are matched to products by sku and update them, rows with a new sku add a product (product_name and price are required then)
+//rows with a parent_sku change that variant of the product. dry_run=true checks every row without saving anything
+//the import runs in the background, the answer is the job to follow it with
+//POST request : http://localhost:8000/admin/catalog/import?dry_run=true
+//curl -F file=@catalog.csv "http://localhost:8000/admin/catalog/import?dry_run=true"
+
+func ImportCatalog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, MaxImportBytes+1<<20)
+		header, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "send the catalog as a multipart form file named file"})
+			return
+		}
+		format, err := importFormat(c.Query("format"), header.Filename)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if header.Size > MaxImportBytes {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("the file can be at most %d bytes", MaxImportBytes)})
+			return
+		}
+		file, err := header.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		defer file.Close()
+		data, err := ioutil.ReadAll(file)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var rows []importRow
+		if format == "csv" {
+			rows, err = readCSV(data)
+		} else {
+			rows, err = readJSON(data)
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		job := ImportJob{
+			Job_ID:     primitive.NewObjectID(),
+			Filename:   header.Filename,
+			Format:     format,
+			Dry_Run:    c.Query("dry_run") == "true",
+			Status:     ImportQueued,
+			Total:      len(rows),
+			Errors:     make([]ImportError, 0),
+			Created_At: time.Now(),
+		}
+		if _, err := ImportCollection.InsertOne(ctx, job); err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+			return
+		}
+		go runImport(job, rows)
+		c.IndentedJSON(http.StatusAccepted, job)
+	}
+}
+
+//admin function following an import, processed goes up to total while it runs
+//GET request : http://localhost:8000/admin/catalog/imports/xxxjob_idxxx
+/*
+{
+"status"    : "finished",
+"dry_run"   : false,
+"total"     : 20000,
+"processed" : 20000,
+"created"   : 120,
+"updated"   : 19850,
+"unchanged" : 28,
+"failed"    : 2,
+"errors"    : [{"row":118,"sku":"AW-X15","error":"price must be a whole positive number"}]
+}
+*/
+
+func GetImport() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		jobt_id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.Header("Content-Type", "application/json")
+			c.JSON(http.StatusNotFound, gin.H{"error": "Invalid import id"})
+			c.Abort()
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		var job ImportJob
+		err = ImportCollection.FindOne(ctx, bson.M{"_id": jobt_id}).Decode(&job)
+		if err == mongo.ErrNoDocuments {
+			c.IndentedJSON(http.StatusNotFound, gin.H{"error": "import not found"})
+			return
+		}
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+			return
+		}
+		c.IndentedJSON(200, job)
+	}
+}
+
+//admin function listing imports newest first, without their errors
+//GET request : http://localhost:8000/admin/catalog/imports?limit=20&offset=0
+
+func ListImports() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, offset, err := parsePage(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		total, err := ImportCollection.CountDocuments(ctx, bson.M{})
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+			return
+		}
+		find := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).SetLimit(limit).SetSkip(offset).SetProjection(bson.M{"errors": 0})
+		cursor, err := ImportCollection.Find(ctx, bson.M{}, find)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+			return
+		}
+		jobs := make([]ImportJob, 0)
+		if err := cursor.All(ctx, &jobs); err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+			return
+		}
+		c.IndentedJSON(200, gin.H{"imports": jobs, "total": total, "limit": limit, "offset": offset})
+	}
+}
+
+/**************************************************EXPORTING********************************************************************************************************/
+
+// exportRows turns a product into its row followed by a row per variant
+func exportRows(product models.Product) []catalogRow {
+	row := catalogRow{
+		Product_ID:    &product.Product_ID,
+		Product_Name:  product.Product_Name,
+		Price:         product.Price,
+		Rating:        product.Rating,
+		Image:         product.Image,
+		Stock:         product.Stock,
+		Reorder_Point: product.Reorder_Point,
+		Categories:    product.Categories,
+		Archived:      &product.Archived,
+	}
+	if product.SKU != nil {
+		row.SKU = *product.SKU
+	}
+	rows := []catalogRow{row}
+	for _, variant := range product.Variants {
+		line := catalogRow{
+			SKU:           variant.SKU,
+			Parent_SKU:    row.SKU,
+			Price:         variant.Price,
+			Stock:         variant.Stock,
+			Reorder_Point: variant.Reorder_Point,
+			Options:       variant.Options,
+		}
+		if len(variant.Images) > 0 {
+			line.Image = &variant.Images[0]
+		}
+		rows = append(rows, line)
+	}
+	return rows
+}
+
+// record is the row as csv in the order of catalogColumns
+func (row catalogRow) record() []string {
+	text := func(value *string) string {
+		if value == nil {
+			return ""
+		}
+		return *value
+	}
+	number := func(value *int) string {
+		if value == nil {
+			return ""
+		}
+		return strconv.Itoa(*value)
+	}
+	record := make([]string, 0, len(catalogColumns))
+	record = append(record, row.SKU, row.Parent_SKU)
+	if row.Product_ID != nil {
+		record = append(record, row.Product_ID.Hex())
+	} else {
+		record = append(record, "")
+	}
+	record = append(record, text(row.Product_Name))
+	if row.Price != nil {
+		record = append(record, strconv.FormatUint(*row.Price, 10))
+	} else {
+		record = append(record, "")
+	}
+	if row.Rating != nil {
+		record = append(record, strconv.Itoa(int(*row.Rating)))
+	} else {
+		record = append(record, "")
+	}
+	record = append(record, text(row.Image), number(row.Stock), number(row.Reorder_Point), strings.Join(row.Categories, "|"))
+	if row.Archived != nil {
+		record = append(record, strconv.FormatBool(*row.Archived))
+	} else {
+		record = append(record, "")
+	}
+	axes := make([]string, 0, len(row.Options))
+	for axis, value := range row.Options {
+		axes = append(axes, axis+"="+value)
+	}
+	sort.Strings(axes)
+	return append(record, strings.Join(axes, "|"))
+}
+
+//admin function downloading the whole catalog, archived products included, as csv (the default) or json
+//the rows are written as they are read so even a large catalog starts downloading right away
+//GET request : http://localhost:8000/admin/catalog/export?format=json
+
+func ExportCatalog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		format := c.DefaultQuery("format", "csv")
+		if format != "csv" && format != "json" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": ErrImportFormat.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		cursor, err := ProductCollection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+			return
+		}
+		defer cursor.Close(ctx)
+		filename := "catalog-" + time.Now().Format("2006-01-02") + "." + format
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		if format == "csv" {
+			c.Header("Content-Type", "text/csv; charset=utf-8")
+		} else {
+			c.Header("Content-Type", "application/json; charset=utf-8")
+		}
+		c.Status(http.StatusOK)
+		writer := csv.NewWriter(c.Writer)
+		if format == "csv" {
+			writer.Write(catalogColumns)
+		} else {
+			io.WriteString(c.Writer, "[")
+		}
+		first := true
+		for count := 1; cursor.Next(ctx); count++ {
+			var product models.Product
+			if err := cursor.Decode(&product); err != nil {
+				log.Println(err)
+				continue
+			}
+			for _, row := range exportRows(product) {
+				if format == "csv" {
+					writer.Write(row.record())
+					continue
+				}
+				line, err := json.Marshal(row)
+				if err != nil {
+					log.Println(err)
+					continue
+				}
+				if !first {
+					io.WriteString(c.Writer, ",")
+				}
+				first = false
+				io.WriteString(c.Writer, "\n")
+				c.Writer.Write(line)
+			}
+			if count%importProgress == 0 {
+				writer.Flush()
+				c.Writer.Flush()
+			}
+		}
+		if err := cursor.Err(); err != nil {
+			log.Println(err)
+		}
+		if format == "csv" {
+			writer.Flush()
+		} else {
+			io.WriteString(c.Writer, "\n]\n")
+		}
+	}
+}
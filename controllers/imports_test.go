@@ -0,0 +1,181 @@
+package controllers
+
+import (
+	"context"
+	"ecommerce/models"
+	"encoding/csv"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestImportFormat(t *testing.T) {
+	cases := []struct {
+		format, filename string
+		want             string
+		ok               bool
+	}{
+		{"", "catalog.csv", "csv", true},
+		{"", "Catalog.JSON", "json", true},
+		{"json", "catalog.csv", "json", true},
+		{"", "catalog.xlsx", "", false},
+		{"xml", "catalog.csv", "", false},
+		{"", "", "", false},
+	}
+	for _, c := range cases {
+		got, err := importFormat(c.format, c.filename)
+		if got != c.want || (err == nil) != c.ok {
+			t.Errorf("importFormat(%q, %q) = %q %v", c.format, c.filename, got, err)
+		}
+	}
+}
+
+func TestReadCSV(t *testing.T) {
+	data := "\ufeffSKU, Price ,stock,product_id,categories,archived\n" +
+		"AW-X15,2500,12,,gaming-laptops|laptops,false\n" +
+		"AW-X17,,,,,\n" +
+		"AW-X19,cheap,,,,\n" +
+		"AW-X21,100,,nope,,\n" +
+		"AW-X23,100,-1,,,yes\n" +
+		"AW-\"X25,100\n" +
+		"AW-X27\n"
+	rows, err := readCSV([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 7 {
+		t.Fatalf("got %d rows", len(rows))
+	}
+	first := rows[0]
+	if first.Number != 2 || first.Err != nil || first.Row.SKU != "AW-X15" || *first.Row.Price != 2500 || *first.Row.Stock != 12 || *first.Row.Archived {
+		t.Errorf("first row is %+v", first)
+	}
+	if !reflect.DeepEqual(first.Row.Categories, []string{"gaming-laptops", "laptops"}) {
+		t.Errorf("categories are %v", first.Row.Categories)
+	}
+	//empty cells change nothing
+	if second := rows[1].Row; rows[1].Err != nil || second.Price != nil || second.Stock != nil || second.Categories != nil || second.Archived != nil {
+		t.Errorf("second row is %+v", rows[1])
+	}
+	errs := map[int]string{
+		4: "price must be a whole positive number",
+		5: "product_id is not a valid id",
+		6: "archived must be true or false",
+	}
+	for _, row := range rows[2:5] {
+		if row.Err == nil || row.Err.Error() != errs[row.Number] {
+			t.Errorf("row %d got %v, want %s", row.Number, row.Err, errs[row.Number])
+		}
+	}
+	//a broken line does not stop the rows after it
+	var parse *csv.ParseError
+	if rows[5].Number != 7 || !errors.As(rows[5].Err, &parse) {
+		t.Errorf("row 7 got %+v", rows[5])
+	}
+	if last := rows[6]; last.Number != 8 || last.Err != nil || last.Row.SKU != "AW-X27" || last.Row.Price != nil {
+		t.Errorf("a short row got %+v", last)
+	}
+}
+
+func TestReadCSVHeader(t *testing.T) {
+	cases := map[string]string{
+		"":                       "the file is empty",
+		"sku,colour\nAW-X15,red": `unknown column "colour"`,
+		"sku,price,Price\n":      `column "price" is there twice`,
+		"product_name,price\n":   "the sku column is required",
+	}
+	for data, want := range cases {
+		if _, err := readCSV([]byte(data)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q got %v, want %s", data, err, want)
+		}
+	}
+}
+
+func TestReadJSON(t *testing.T) {
+	rows, err := readJSON([]byte(`[
+		{"sku": " AW-X15 ", "price": 2500, "categories": ["laptops"]},
+		{"sku": "AW-X17", "price": "cheap"},
+		{"sku": "AW-X15-BLK", "parent_sku": "AW-X15 ", "options": {"color": "black"}}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("got %d rows", len(rows))
+	}
+	if rows[0].Number != 1 || rows[0].Err != nil || rows[0].Row.SKU != "AW-X15" || *rows[0].Row.Price != 2500 {
+		t.Errorf("first row is %+v", rows[0])
+	}
+	if rows[1].Number != 2 || rows[1].Err == nil {
+		t.Errorf("a price that is not a number got %+v", rows[1])
+	}
+	if rows[2].Err != nil || rows[2].Row.Parent_SKU != "AW-X15" || rows[2].Row.Options["color"] != "black" {
+		t.Errorf("the variant row is %+v", rows[2])
+	}
+	for _, data := range []string{`{"sku": "AW-X15"}`, `[{"sku": "AW-X15"}`, ``} {
+		if _, err := readJSON([]byte(data)); err == nil {
+			t.Errorf("%q was read", data)
+		}
+	}
+}
+
+// rows that are wrong on their own are refused before the catalog is looked at
+func TestImportRowIntoChecks(t *testing.T) {
+	name := "Alienware x15"
+	cases := []struct {
+		row  catalogRow
+		want string
+	}{
+		{catalogRow{Product_Name: &name}, "sku is required"},
+		{catalogRow{SKU: "AW-X15", Options: map[string]string{"color": "black"}}, "options only go on variant rows"},
+		{catalogRow{SKU: "AW-X15-BLK", Parent_SKU: "AW-X15", Product_Name: &name}, "a variant row can only change"},
+		{catalogRow{SKU: "AW-X15-BLK", Parent_SKU: "AW-X15", Categories: []string{"laptops"}}, "a variant row can only change"},
+	}
+	for _, c := range cases {
+		if _, _, err := importRowInto(context.Background(), c.row); err == nil || !strings.HasPrefix(err.Error(), c.want) {
+			t.Errorf("%+v got %v, want %s", c.row, err, c.want)
+		}
+	}
+}
+
+func TestExportReadsBack(t *testing.T) {
+	name, sku, image := "Basic Tee", "TEE", "tee.jpg"
+	price, variantprice, stock := uint64(20), uint64(25), 7
+	product := models.Product{
+		Product_ID:   oid(1),
+		Product_Name: &name,
+		SKU:          &sku,
+		Price:        &price,
+		Image:        &image,
+		Categories:   []string{"shirts", "summer"},
+		Variants: []models.Variant{
+			{Variant_ID: oid(2), SKU: "TEE-M", Options: map[string]string{"size": "M", "color": "Red"}, Price: &variantprice, Stock: &stock, Images: []string{"tee-m.jpg"}},
+		},
+	}
+	exported := exportRows(product)
+	if len(exported) != 2 || exported[1].Parent_SKU != "TEE" {
+		t.Fatalf("got %+v", exported)
+	}
+	var out strings.Builder
+	writer := csv.NewWriter(&out)
+	writer.Write(catalogColumns)
+	for _, row := range exported {
+		writer.Write(row.record())
+	}
+	writer.Flush()
+	if !strings.Contains(out.String(), "color=Red|size=M") {
+		t.Errorf("the options are not written in order:\n%s", out.String())
+	}
+	rows, err := readCSV([]byte(out.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, row := range rows {
+		//options are only made through /admin/products/:id/options, the csv does not read them
+		exported[i].Options = nil
+		if row.Err != nil || !reflect.DeepEqual(row.Row, exported[i]) {
+			t.Errorf("row %d read back as %+v %v, want %+v", row.Number, row.Row, row.Err, exported[i])
+		}
+	}
+}
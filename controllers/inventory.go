@@ -0,0 +1,395 @@
+package controllers
+
+import (
+	"context"
+	"ecommerce/events"
+	"ecommerce/inventory"
+	"ecommerce/models"
+	"ecommerce/notify"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	PaymentCOD     = "cod"
+	PaymentDigital = "digital"
+)
+
+var ErrUnknownPayment = errors.New("payment has to be cod or digital")
+
+// reserveStock takes the stock for a new order before it is saved, split over
+// the locations into shipments when it is kept per location. Digital
+// payments only hold the stock until inventory.HoldFor has passed, the order
+// waits in pending_payment until PayOrder. Answers the request and returns false when it fails.
+func reserveStock(c *gin.Context, ctx context.Context, order *models.Order, usert_id primitive.ObjectID, payment string) bool {
+	hold := false
+	switch payment {
+	case "", PaymentCOD:
+	case PaymentDigital:
+		hold = true
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": ErrUnknownPayment.Error()})
+		return false
+	}
+	reservation, err := inventory.Reserve(ctx, order.Order_ID, usert_id, inventory.Lines(order.Order_Cart), order.Shipping_Address, hold)
+	var short *inventory.ShortageError
+	if errors.As(err, &short) {
+		c.JSON(http.StatusConflict, gin.H{"error": "not enough stock", "shortages": short.Shortages})
+		return false
+	}
+	if err == inventory.ErrProductNotFound {
+		c.JSON(http.StatusConflict, gin.H{"error": "a product in the order is no longer available"})
+		return false
+	}
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+		return false
+	}
+	order.Reservation_ID = &reservation.Reservation_ID
+	order.Shipments = inventory.Shipments(ctx, reservation.Items, order.Order_Cart)
+	if hold {
+		order.Status = models.OrderPendingPayment
+		order.Reserved_Until = reservation.Expires_At
+	}
+	return true
+}
+
+// releaseStock puts the stock of an order back, orders from before reservations never took any
+func releaseStock(ctx context.Context, order models.Order) {
+	if order.Reservation_ID == nil {
+		return
+	}
+	if err := inventory.Release(ctx, *order.Reservation_ID); err != nil {
+		log.Println(err)
+	}
+}
+
+// expireOrders cancels the orders whose stock hold ran out before they were paid
+func expireOrders(ctx context.Context) {
+	released, err := inventory.ReleaseExpired(ctx)
+	if err != nil {
+		log.Println(err)
+	}
+	for _, reservation := range released {
+		filter := bson.M{"_id": reservation.User_ID, "orders": bson.M{"$elemMatch": bson.M{
+			"_id":    reservation.Order_ID,
+			"status": models.OrderPendingPayment,
+		}}}
+		update := bson.M{"$set": bson.M{"orders.$.status": models.OrderCancelled, "orders.$.cancelled_on": time.Now()}}
+		result, err := UserCollection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if result.MatchedCount == 0 {
+			continue
+		}
+		payload := bson.M{"order_id": reservation.Order_ID, "user_id": reservation.User_ID, "reason": "payment_expired"}
+		if err := events.Emit(ctx, events.OrderCancelled, payload); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// ExpireReservations runs in the background and gives back the stock of unpaid orders every interval
+func ExpireReservations(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		expireOrders(ctx)
+		cancel()
+	}
+}
+
+//admin function to confirm the payment of an order placed with "payment":"digital"
+//placeholder until we integrate with a payment provider, whose webhook takes its place
+//customers never call it, paying is between them and the provider
+//it has to happen before the stock hold runs out
+//POST request
+//http://localhost:8000/admin/orders/pay?order_id=xxxxxxorder_idxxxxxx
+
+func PayOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ordert_id, err := primitive.ObjectIDFromHex(c.Query("order_id"))
+		if err != nil {
+			c.Header("Content-Type", "application/json")
+			c.JSON(http.StatusNotFound, gin.H{"error": "Invalid order id"})
+			c.Abort()
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		usert_id, order, err := findOrder(ctx, ordert_id)
+		if err != nil {
+			c.IndentedJSON(http.StatusNotFound, "Order not found")
+			return
+		}
+		payOrder(c, ctx, usert_id, order)
+	}
+}
+
+// payOrder takes the payment of an order waiting for it and answers the request
+func payOrder(c *gin.Context, ctx context.Context, usert_id primitive.ObjectID, order models.Order) {
+	if orderStatus(order) != models.OrderPendingPayment || order.Reservation_ID == nil {
+		c.IndentedJSON(http.StatusConflict, "Order is not waiting for payment")
+		return
+	}
+	if err := inventory.Commit(ctx, *order.Reservation_ID); err != nil {
+		if err == inventory.ErrReservationNotHeld {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+		return
+	}
+	filter := bson.M{"_id": usert_id, "orders": bson.M{"$elemMatch": bson.M{"_id": order.Order_ID, "status": models.OrderPendingPayment}}}
+	update := bson.M{
+		"$set":   bson.M{"orders.$.status": models.OrderPlaced, "orders.$.payment_method.digital": true, "orders.$.payment_method.cod": false},
+		"$unset": bson.M{"orders.$.reserved_until": ""},
+	}
+	result, err := UserCollection.UpdateOne(ctx, filter, update)
+	if err == nil && result.MatchedCount == 0 {
+		//cancelled while paying, the stock was committed after the cancel gave up on it
+		releaseStock(ctx, order)
+		c.IndentedJSON(http.StatusConflict, "Order is not waiting for payment")
+		return
+	}
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+		return
+	}
+	c.IndentedJSON(200, gin.H{"message": "Payment received", "order_id": order.Order_ID, "order_number": order.Order_Number})
+}
+
+// inventoryError answers a failed stock change
+func inventoryError(c *gin.Context, err error) {
+	switch err {
+	case ErrProductNotFound, inventory.ErrProductNotFound, inventory.ErrLocationNotFound, inventory.ErrTransferNotFound, ErrVariantNotFound:
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case inventory.ErrLocationRequired, inventory.ErrSameLocation:
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case inventory.ErrNegativeStock, inventory.ErrNotStockedHere, inventory.ErrBusy, inventory.ErrTransferDone:
+		c.IndentedJSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		var short *inventory.ShortageError
+		if errors.As(err, &short) {
+			c.JSON(http.StatusConflict, gin.H{"error": "not enough stock", "shortages": short.Shortages})
+			return
+		}
+		c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+	}
+}
+
+// stockLine finds the product and variant an admin is moving stock of
+func stockLine(ctx context.Context, product_id primitive.ObjectID, variant_id *primitive.ObjectID) (inventory.Line, error) {
+	product, err := findProduct(ctx, product_id)
+	if err != nil {
+		return inventory.Line{}, err
+	}
+	line := inventory.Line{Product_ID: product.Product_ID, SKU: product.SKU}
+	if variant_id != nil {
+		variant, err := findVariant(product, variant_id.Hex())
+		if err != nil {
+			return line, err
+		}
+		line.Variant_ID = &variant.Variant_ID
+		line.SKU = &variant.SKU
+	}
+	return line, nil
+}
+
+//admin function correcting the stock of a product or a variant, after a stock count or when goods arrive
+//change is added to the stock, negative numbers take stock away but never below zero
+//products kept per location need the location, adjusting at a new location starts keeping the stock per location
+//POST request : http://localhost:8000/admin/inventory/adjust
+/*
+{
+"product_id"  : "xxxproduct_idxxx",
+"variant_id"  : "xxxvariant_idxxx",
+"location_id" : "xxxlocation_idxxx",
+"change"      : 25,
+"note"        : "delivery from supplier"
+}
+*/
+
+func AdjustStock() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request struct {
+			Product_ID  primitive.ObjectID  `json:"product_id"`
+			Variant_ID  *primitive.ObjectID `json:"variant_id"`
+			Location_ID *primitive.ObjectID `json:"location_id"`
+			Change      int                 `json:"change"`
+			Note        *string             `json:"note"`
+		}
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if request.Product_ID.IsZero() || request.Change == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "product_id and a change other than 0 are required"})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		line, err := stockLine(ctx, request.Product_ID, request.Variant_ID)
+		if err != nil {
+			inventoryError(c, err)
+			return
+		}
+		if request.Location_ID != nil {
+			if _, err := inventory.FindLocation(ctx, *request.Location_ID); err != nil {
+				inventoryError(c, err)
+				return
+			}
+			line.Location_ID = request.Location_ID
+		}
+		movement, err := inventory.Adjust(ctx, line, request.Change, request.Note)
+		if err != nil {
+			inventoryError(c, err)
+			return
+		}
+		c.IndentedJSON(200, movement)
+	}
+}
+
+//admin function listing the inventory ledger newest first
+//GET request : http://localhost:8000/admin/inventory/movements?product_id=xxxproduct_idxxx&variant_id=xxxvariant_idxxx&reason=adjustment&limit=20&offset=0
+//order_id shows what one order did to the stock, location_id and transfer_id work the same way
+
+func ListMovements() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filter := bson.M{}
+		for _, field := range []string{"product_id", "variant_id", "order_id", "location_id", "transfer_id"} {
+			value := c.Query(field)
+			if value == "" {
+				continue
+			}
+			id, err := primitive.ObjectIDFromHex(value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + field})
+				return
+			}
+			filter[field] = id
+		}
+		if reason := c.Query("reason"); reason != "" {
+			filter["reason"] = reason
+		}
+		limit, offset, err := parsePage(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		movements, total, err := inventory.Movements(ctx, filter, limit, offset)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+			return
+		}
+		c.IndentedJSON(200, gin.H{"movements": movements, "total": total, "limit": limit, "offset": offset})
+	}
+}
+
+/**************************************************LOW STOCK********************************************************************************************************/
+
+//admin function listing low stock alerts newest first, status is open or closed
+//GET request : http://localhost:8000/admin/inventory/alerts?status=open&limit=20&offset=0
+
+func ListStockAlerts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filter := bson.M{}
+		if status := c.Query("status"); status != "" {
+			filter["status"] = status
+		}
+		if value := c.Query("product_id"); value != "" {
+			id, err := primitive.ObjectIDFromHex(value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid product_id"})
+				return
+			}
+			filter["product_id"] = id
+		}
+		limit, offset, err := parsePage(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		alerts, total, err := inventory.Alerts(ctx, filter, limit, offset)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+			return
+		}
+		c.IndentedJSON(200, gin.H{"alerts": alerts, "total": total, "limit": limit, "offset": offset})
+	}
+}
+
+//admin function checking the stock right away instead of waiting for the background job, returns the alerts it opened
+//POST request : http://localhost:8000/admin/inventory/alerts/check
+
+func CheckStock() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		opened, err := inventory.CheckStock(ctx, notify.Default)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+			return
+		}
+		c.IndentedJSON(200, gin.H{"opened": opened})
+	}
+}
+
+//admin function estimating when every product runs out at the pace it sold over the last days and how much to reorder
+//lead_days is how long the supplier takes to deliver, cover_days how long the reorder should last once it arrives
+//reorder=true only lists what needs reordering now
+//GET request : http://localhost:8000/admin/inventory/reorder?days=30&lead_days=7&cover_days=30&reorder=true
+
+func ReorderReport() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		opts := inventory.ReorderOptions{Days: 30, Lead_Days: inventory.LeadDays, Cover_Days: inventory.CoverDays}
+		for key, value := range map[string]*int{"days": &opts.Days, "lead_days": &opts.Lead_Days, "cover_days": &opts.Cover_Days} {
+			number, err := queryUint(c, key)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if number != nil {
+				if *number > 3650 {
+					c.JSON(http.StatusBadRequest, gin.H{"error": key + " can be at most 3650"})
+					return
+				}
+				*value = int(*number)
+			}
+		}
+		if opts.Days == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "days must be at least 1"})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		report, err := inventory.ReorderReport(ctx, opts)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+			return
+		}
+		if c.Query("reorder") == "true" {
+			due := make([]inventory.ReorderLine, 0, len(report))
+			for _, line := range report {
+				if line.Reorder_Now {
+					due = append(due, line)
+				}
+			}
+			report = due
+		}
+		c.IndentedJSON(200, gin.H{"days": opts.Days, "lead_days": opts.Lead_Days, "cover_days": opts.Cover_Days, "products": report})
+	}
+}
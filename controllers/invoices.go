@@ -0,0 +1,368 @@
+package controllers
+
+import (
+	"archive/zip"
+	"context"
+	"ecommerce/database"
+	"ecommerce/invoice"
+	"ecommerce/models"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func deref(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+func addressLines(address models.Address) []string {
+	lines := make([]string, 0)
+	for _, line := range []string{deref(address.House), deref(address.Street), strings.TrimSpace(deref(address.Pincode) + " " + deref(address.City))} {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// the billing address snapshot of the order, orders from before checkout took
+// addresses fall back to the first address in the book
+func buyerLines(user models.User, order models.Order) []string {
+	lines := []string{strings.TrimSpace(deref(user.First_Name) + " " + deref(user.Last_Name))}
+	if order.Billing_Address != nil {
+		lines = append(lines, addressLines(*order.Billing_Address)...)
+	} else if len(user.Address_Details) > 0 {
+		lines = append(lines, addressLines(user.Address_Details[0])...)
+	}
+	if user.Email != nil {
+		lines = append(lines, *user.Email)
+	}
+	return lines
+}
+
+// identical products at the same price are printed as one line with a quantity,
+// variants of a product get a line each
+func invoiceLines(order models.Order) []invoice.Line {
+	type key struct {
+		line  lineKey
+		price int
+	}
+	index := make(map[key]int)
+	lines := make([]invoice.Line, 0)
+	for _, item := range order.Order_Cart {
+		k := key{itemKey(item.Product_ID, item.Variant_ID), item.Price}
+		if i, ok := index[k]; ok {
+			lines[i].Quantity += item.Units()
+			lines[i].Total += item.Price * item.Units()
+			continue
+		}
+		index[k] = len(lines)
+		lines = append(lines, invoice.Line{Description: lineName(item), Quantity: item.Units(), Unit_Price: item.Price, Total: item.Price * item.Units()})
+	}
+	return lines
+}
+
+// orderTaxRate is the rate the order was charged, orders from before the totals
+// were kept take today's TAX_RATE
+func orderTaxRate(order models.Order) float64 {
+	if order.Totals == nil {
+		return invoice.TaxRate
+	}
+	if len(order.Totals.Taxes) == 0 {
+		return 0
+	}
+	return order.Totals.Taxes[0].Rate
+}
+
+func invoiceDocument(user models.User, order models.Order) invoice.Document {
+	doc := invoice.Document{
+		Kind:         invoice.KindInvoice,
+		Number:       order.Invoice_Number,
+		Issued:       order.Orderered_At,
+		Order_Number: order.Order_Number,
+		Seller:       invoice.DefaultSeller,
+		Buyer:        buyerLines(user, order),
+		Lines:        invoiceLines(order),
+		Total:        order.Price,
+		Tax_Rate:     orderTaxRate(order),
+	}
+	if order.Discount != nil {
+		doc.Discount = *order.Discount
+	}
+	if order.Totals != nil {
+		doc.Shipping = order.Totals.Shipping
+	}
+	if order.Payment_Method.COD {
+		doc.Note = "To be paid cash on delivery."
+	}
+	return doc
+}
+
+func creditNoteDocument(user models.User, order models.Order, refund models.Refund) invoice.Document {
+	names := make(map[lineKey]string)
+	for _, item := range order.Order_Cart {
+		names[itemKey(item.Product_ID, item.Variant_ID)] = lineName(item)
+	}
+	names[shippingLine] = "Shipping"
+	lines := make([]invoice.Line, 0)
+	for _, item := range refund.Items {
+		lines = append(lines, invoice.Line{Description: names[refundLine(item)], Quantity: 1, Unit_Price: item.Amount, Total: item.Amount})
+	}
+	doc := invoice.Document{
+		Kind:         invoice.KindCreditNote,
+		Number:       refund.Credit_Note_Number,
+		Reference:    order.Invoice_Number,
+		Issued:       refund.Created_At,
+		Order_Number: order.Order_Number,
+		Seller:       invoice.DefaultSeller,
+		Buyer:        buyerLines(user, order),
+		Lines:        lines,
+		Total:        refund.Amount,
+		Tax_Rate:     orderTaxRate(order),
+	}
+	if refund.Reason != nil {
+		doc.Note = "Reason: " + *refund.Reason
+	}
+	return doc
+}
+
+// orders placed before invoices existed get their number the first time one is asked for
+func ensureInvoiceNumber(ctx context.Context, usert_id primitive.ObjectID, order *models.Order) error {
+	if order.Invoice_Number != "" {
+		return nil
+	}
+	//numbered in the year of the order, a 2025 order does not get a 2026 invoice
+	issued := order.Orderered_At
+	if issued.IsZero() {
+		issued = time.Now()
+	}
+	number, err := invoice.NextNumber(ctx, database.Client, invoice.KindInvoice, issued)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"_id": usert_id, "orders": bson.M{"$elemMatch": bson.M{"_id": order.Order_ID, "invoice_number": bson.M{"$exists": false}}}}
+	result, err := UserCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"orders.$.invoice_number": number}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		//someone else numbered it first, use theirs
+		_, fresh, err := findOrder(ctx, order.Order_ID)
+		if err != nil {
+			return err
+		}
+		order.Invoice_Number = fresh.Invoice_Number
+		return nil
+	}
+	order.Invoice_Number = number
+	return nil
+}
+
+func ensureCreditNoteNumber(ctx context.Context, usert_id primitive.ObjectID, order models.Order, index int) (models.Refund, error) {
+	refund := order.Refunds[index]
+	if refund.Credit_Note_Number != "" {
+		return refund, nil
+	}
+	if err := numberRefund(ctx, &refund); err != nil {
+		return refund, err
+	}
+	field := fmt.Sprintf("refunds.%d.credit_note_number", index)
+	filter := bson.M{"_id": usert_id, "orders": bson.M{"$elemMatch": bson.M{"_id": order.Order_ID, field: bson.M{"$exists": false}}}}
+	result, err := UserCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"orders.$." + field: refund.Credit_Note_Number}})
+	if err != nil {
+		return refund, err
+	}
+	if result.MatchedCount == 0 {
+		_, fresh, err := findOrder(ctx, order.Order_ID)
+		if err != nil {
+			return refund, err
+		}
+		return fresh.Refunds[index], nil
+	}
+	return refund, nil
+}
+
+func sendPDF(c *gin.Context, filename string, body []byte) {
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Data(http.StatusOK, "application/pdf", body)
+}
+
+/**************************************************INVOICES********************************************************************************************************/
+
+//function to download the invoice of an order as pdf
+//GET request
+//http://localhost:8000/orders/xxxxxxorder_idxxxxxx/invoice
+
+func OrderInvoice() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		usert_id, err := primitive.ObjectIDFromHex(c.GetString("uid"))
+		if err != nil {
+			c.IndentedJSON(http.StatusUnauthorized, "Invalid user")
+			return
+		}
+		ordert_id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.IndentedJSON(http.StatusNotFound, "Invalid order id")
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		owner, order, err := findOrderOwner(ctx, ordert_id)
+		if err != nil || owner.ID != usert_id {
+			c.IndentedJSON(http.StatusNotFound, "Order not found")
+			return
+		}
+		if err := ensureInvoiceNumber(ctx, usert_id, &order); err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+			return
+		}
+		sendPDF(c, order.Invoice_Number+".pdf", invoice.Render(invoiceDocument(owner, order)))
+	}
+}
+
+/*******************************************************************************************************/
+
+//function to download the credit note of a refund as pdf
+//GET request
+//http://localhost:8000/orders/xxxxxxorder_idxxxxxx/creditnote/xxxxxxrefund_idxxxxxx
+
+func OrderCreditNote() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		usert_id, err := primitive.ObjectIDFromHex(c.GetString("uid"))
+		if err != nil {
+			c.IndentedJSON(http.StatusUnauthorized, "Invalid user")
+			return
+		}
+		ordert_id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.IndentedJSON(http.StatusNotFound, "Invalid order id")
+			return
+		}
+		refundt_id, err := primitive.ObjectIDFromHex(c.Param("refund_id"))
+		if err != nil {
+			c.IndentedJSON(http.StatusNotFound, "Invalid refund id")
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		owner, order, err := findOrderOwner(ctx, ordert_id)
+		if err != nil || owner.ID != usert_id {
+			c.IndentedJSON(http.StatusNotFound, "Order not found")
+			return
+		}
+		index := -1
+		for i, refund := range order.Refunds {
+			if refund.Refund_ID == refundt_id {
+				index = i
+			}
+		}
+		if index < 0 {
+			c.IndentedJSON(http.StatusNotFound, "Refund not found")
+			return
+		}
+		if err := ensureInvoiceNumber(ctx, usert_id, &order); err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+			return
+		}
+		refund, err := ensureCreditNoteNumber(ctx, usert_id, order, index)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+			return
+		}
+		sendPDF(c, refund.Credit_Note_Number+".pdf", invoice.Render(creditNoteDocument(owner, order, refund)))
+	}
+}
+
+/*******************************************************************************************************/
+
+//admin function to download every invoice and credit note of a month as one zip
+//GET request : http://localhost:8000/admin/invoices/export?month=2026-10
+
+type invoiceRow struct {
+	ID              primitive.ObjectID `bson:"_id"`
+	First_Name      *string            `bson:"first_name"`
+	Last_Name       *string            `bson:"last_name"`
+	Email           *string            `bson:"email"`
+	Address_Details []models.Address   `bson:"address"`
+	Order           models.Order       `bson:"orders"`
+}
+
+func ExportInvoices() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start, err := time.ParseInLocation("2006-01", c.Query("month"), time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "month should look like 2026-10"})
+			return
+		}
+		end := start.AddDate(0, 1, 0)
+		inmonth := bson.M{"$gte": start, "$lt": end}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		unwind := bson.D{{Key: "$unwind", Value: "$orders"}}
+		match := bson.D{{Key: "$match", Value: bson.M{"$or": bson.A{
+			bson.M{"orders.ordered_on": inmonth},
+			bson.M{"orders.refunds.created_at": inmonth},
+		}}}}
+		sort := bson.D{{Key: "$sort", Value: bson.M{"orders.ordered_on": 1}}}
+		cursor, err := UserCollection.Aggregate(ctx, mongo.Pipeline{unwind, match, sort})
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+			return
+		}
+		defer cursor.Close(ctx)
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "invoices-"+start.Format("2006-01")+".zip"))
+		c.Header("Content-Type", "application/zip")
+		c.Status(http.StatusOK)
+		archive := zip.NewWriter(c.Writer)
+		defer archive.Close()
+		add := func(name string, body []byte) {
+			w, err := archive.Create(name)
+			if err == nil {
+				_, err = w.Write(body)
+			}
+			if err != nil {
+				log.Println(err)
+			}
+		}
+		for cursor.Next(ctx) {
+			var row invoiceRow
+			if err := cursor.Decode(&row); err != nil {
+				log.Println(err)
+				continue
+			}
+			buyer := models.User{ID: row.ID, First_Name: row.First_Name, Last_Name: row.Last_Name, Email: row.Email, Address_Details: row.Address_Details}
+			order := row.Order
+			if err := ensureInvoiceNumber(ctx, row.ID, &order); err != nil {
+				log.Println(err)
+				continue
+			}
+			if !order.Orderered_At.Before(start) && order.Orderered_At.Before(end) {
+				add("invoices/"+order.Invoice_Number+".pdf", invoice.Render(invoiceDocument(buyer, order)))
+			}
+			for i, refund := range order.Refunds {
+				if refund.Created_At.Before(start) || !refund.Created_At.Before(end) {
+					continue
+				}
+				refund, err := ensureCreditNoteNumber(ctx, row.ID, order, i)
+				if err != nil {
+					log.Println(err)
+					continue
+				}
+				add("credit-notes/"+refund.Credit_Note_Number+".pdf", invoice.Render(creditNoteDocument(buyer, order, refund)))
+			}
+		}
+		if err := cursor.Err(); err != nil {
+			log.Println(err)
+		}
+	}
+}
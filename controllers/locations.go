@@ -0,0 +1,295 @@
+package controllers
+
+import (
+	"context"
+	"ecommerce/inventory"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+//warehouses and stores we keep stock at
+/*
+{
+"code"            : "WH-NORTH",
+"name"            : "Warehouse North",
+"kind"            : "warehouse",
+"country_code"    : "IN",
+"postal_prefixes" : ["68","69"],
+"priority"        : 1,
+"active"          : true
+}
+*/
+
+type locationRequest struct {
+	Code            *string  `json:"code"`
+	Name            *string  `json:"name"`
+	Kind            *string  `json:"kind"`
+	Country         *string  `json:"country_code"`
+	Postal_Prefixes []string `json:"postal_prefixes"`
+	Priority        *int     `json:"priority"`
+	Active          *bool    `json:"active"`
+}
+
+// apply copies the request onto the location, fields left out stay as they are
+func (request locationRequest) apply(location *inventory.Location) {
+	if request.Code != nil {
+		location.Code = strings.ToUpper(strings.TrimSpace(*request.Code))
+	}
+	if request.Name != nil {
+		location.Name = strings.TrimSpace(*request.Name)
+	}
+	if request.Kind != nil {
+		location.Kind = strings.ToLower(strings.TrimSpace(*request.Kind))
+	}
+	if request.Country != nil {
+		location.Country = strings.ToUpper(strings.TrimSpace(*request.Country))
+	}
+	if request.Postal_Prefixes != nil {
+		location.Postal_Prefixes = make([]string, 0, len(request.Postal_Prefixes))
+		for _, prefix := range request.Postal_Prefixes {
+			location.Postal_Prefixes = append(location.Postal_Prefixes, strings.TrimSpace(prefix))
+		}
+	}
+	if request.Priority != nil {
+		location.Priority = *request.Priority
+	}
+	if request.Active != nil {
+		location.Active = *request.Active
+	}
+}
+
+func locationError(c *gin.Context, err error) {
+	if mongo.IsDuplicateKeyError(err) {
+		c.IndentedJSON(http.StatusConflict, gin.H{"error": "a location with this code already exists"})
+		return
+	}
+	inventoryError(c, err)
+}
+
+//admin function adding a location, new locations ship right away unless "active" is false
+//POST request : http://localhost:8000/admin/locations
+
+func AddLocation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request locationRequest
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		inventory.EnsureLocationIndex(ctx)
+		location := inventory.Location{Location_ID: primitive.NewObjectID(), Kind: inventory.KindWarehouse, Postal_Prefixes: make([]string, 0), Active: true}
+		request.apply(&location)
+		if err := Validate.Struct(location); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		location.Created_At = time.Now()
+		location.Updated_At = location.Created_At
+		if _, err := inventory.LocationCollection.InsertOne(ctx, location); err != nil {
+			locationError(c, err)
+			return
+		}
+		c.IndentedJSON(200, location)
+	}
+}
+
+//admin function listing every location by priority
+//GET request : http://localhost:8000/admin/locations
+
+func ListLocations() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		locations, err := inventory.Locations(ctx, false)
+		if err != nil {
+			locationError(c, err)
+			return
+		}
+		c.IndentedJSON(200, locations)
+	}
+}
+
+//admin function changing a location, {"active":false} stops orders going out from it
+//PUT request : http://localhost:8000/admin/locations/xxxlocation_idxxx
+
+func UpdateLocation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		locationt_id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.Header("Content-Type", "application/json")
+			c.JSON(http.StatusNotFound, gin.H{"error": "Invalid location id"})
+			c.Abort()
+			return
+		}
+		var request locationRequest
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		location, err := inventory.FindLocation(ctx, locationt_id)
+		if err != nil {
+			locationError(c, err)
+			return
+		}
+		request.apply(&location)
+		if err := Validate.Struct(location); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		location.Updated_At = time.Now()
+		if _, err := inventory.LocationCollection.ReplaceOne(ctx, bson.M{"_id": locationt_id}, location); err != nil {
+			locationError(c, err)
+			return
+		}
+		c.IndentedJSON(200, location)
+	}
+}
+
+/**************************************************TRANSFERS********************************************************************************************************/
+
+//admin function sending stock from one location to another, it can not be sold until it is received
+//POST request : http://localhost:8000/admin/inventory/transfers
+/*
+{
+"product_id"       : "xxxproduct_idxxx",
+"variant_id"       : "xxxvariant_idxxx",
+"from_location_id" : "xxxlocation_idxxx",
+"to_location_id"   : "xxxlocation_idxxx",
+"quantity"         : 10,
+"note"             : "restocking the store"
+}
+*/
+
+func SendTransfer() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request struct {
+			Product_ID primitive.ObjectID  `json:"product_id"`
+			Variant_ID *primitive.ObjectID `json:"variant_id"`
+			From       primitive.ObjectID  `json:"from_location_id"`
+			To         primitive.ObjectID  `json:"to_location_id"`
+			Quantity   int                 `json:"quantity"`
+			Note       *string             `json:"note"`
+		}
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if request.Product_ID.IsZero() || request.From.IsZero() || request.To.IsZero() || request.Quantity <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "product_id, from_location_id, to_location_id and a positive quantity are required"})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		line, err := stockLine(ctx, request.Product_ID, request.Variant_ID)
+		if err != nil {
+			inventoryError(c, err)
+			return
+		}
+		for _, location_id := range []primitive.ObjectID{request.From, request.To} {
+			if _, err := inventory.FindLocation(ctx, location_id); err != nil {
+				inventoryError(c, err)
+				return
+			}
+		}
+		transfer, err := inventory.SendTransfer(ctx, inventory.Transfer{
+			Product_ID: line.Product_ID,
+			Variant_ID: line.Variant_ID,
+			SKU:        line.SKU,
+			From:       request.From,
+			To:         request.To,
+			Quantity:   request.Quantity,
+			Note:       request.Note,
+		})
+		if err != nil {
+			inventoryError(c, err)
+			return
+		}
+		c.IndentedJSON(200, transfer)
+	}
+}
+
+//admin function listing transfers newest first
+//GET request : http://localhost:8000/admin/inventory/transfers?status=in_transit&product_id=xxxproduct_idxxx&location_id=xxxlocation_idxxx
+
+func ListTransfers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filter := bson.M{}
+		if status := c.Query("status"); status != "" {
+			filter["status"] = status
+		}
+		if value := c.Query("product_id"); value != "" {
+			id, err := primitive.ObjectIDFromHex(value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid product_id"})
+				return
+			}
+			filter["product_id"] = id
+		}
+		if value := c.Query("location_id"); value != "" {
+			id, err := primitive.ObjectIDFromHex(value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid location_id"})
+				return
+			}
+			filter["$or"] = bson.A{bson.M{"from_location_id": id}, bson.M{"to_location_id": id}}
+		}
+		limit, offset, err := parsePage(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		transfers, total, err := inventory.Transfers(ctx, filter, limit, offset)
+		if err != nil {
+			inventoryError(c, err)
+			return
+		}
+		c.IndentedJSON(200, gin.H{"transfers": transfers, "total": total, "limit": limit, "offset": offset})
+	}
+}
+
+// finishTransfer is shared by receiving and cancelling, both only work while the transfer is in transit
+func finishTransfer(finish func(context.Context, primitive.ObjectID) (inventory.Transfer, error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		transfert_id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.Header("Content-Type", "application/json")
+			c.JSON(http.StatusNotFound, gin.H{"error": "Invalid transfer id"})
+			c.Abort()
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		transfer, err := finish(ctx, transfert_id)
+		if err != nil {
+			inventoryError(c, err)
+			return
+		}
+		c.IndentedJSON(200, transfer)
+	}
+}
+
+//admin function booking the stock of a transfer in at the location it was sent to
+//POST request : http://localhost:8000/admin/inventory/transfers/xxxtransfer_idxxx/receive
+
+func ReceiveTransfer() gin.HandlerFunc {
+	return finishTransfer(inventory.ReceiveTransfer)
+}
+
+//admin function calling a transfer off, the stock goes back to the location it came from
+//POST request : http://localhost:8000/admin/inventory/transfers/xxxtransfer_idxxx/cancel
+
+func CancelTransfer() gin.HandlerFunc {
+	return finishTransfer(inventory.CancelTransfer)
+}
@@ -0,0 +1,475 @@
+package controllers
+
+import (
+	"context"
+	"ecommerce/database"
+	"ecommerce/events"
+	"ecommerce/invoice"
+	"ecommerce/models"
+	"ecommerce/ordernumber"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrOrderNotFound = errors.New("order not found")
+var ErrOrderChanged = errors.New("order was changed")
+
+// every order placed goes through here so new fields get their defaults in one place
+func newOrder(ctx context.Context) (models.Order, error) {
+	var order models.Order
+	order.Order_ID = primitive.NewObjectID()
+	order.Orderered_At = time.Now()
+	number, err := ordernumber.Next(ctx, database.Client, order.Orderered_At)
+	if err != nil {
+		return order, err
+	}
+	order.Order_Number = number
+	order.Invoice_Number, err = invoice.NextNumber(ctx, database.Client, invoice.KindInvoice, order.Orderered_At)
+	if err != nil {
+		return order, err
+	}
+	order.Order_Cart = make([]models.ProductUser, 0)
+	order.Payment_Method.Payment_ID = primitive.NewObjectID()
+	order.Payment_Method.COD = true
+	order.Status = models.OrderPlaced
+	order.Refunds = make([]models.Refund, 0)
+	order.Returns = make([]models.Return, 0)
+	return order, nil
+}
+
+// orders placed before statuses existed have an empty status and count as placed
+func orderStatus(order models.Order) string {
+	if order.Status == "" {
+		return models.OrderPlaced
+	}
+	return order.Status
+}
+
+// orders live inside the user document so we look them up by the embedded id
+// and only project the matching order back
+func findOrder(ctx context.Context, orderid primitive.ObjectID) (primitive.ObjectID, models.Order, error) {
+	owner, order, err := findOrderOwner(ctx, orderid)
+	return owner.ID, order, err
+}
+
+// findOrderOwner also returns the contact details and address book of the customer
+func findOrderOwner(ctx context.Context, orderid primitive.ObjectID) (models.User, models.Order, error) {
+	var founduser models.User
+	projection := bson.M{"orders.$": 1, "email": 1, "first_name": 1, "last_name": 1, "phone": 1, "address": 1}
+	err := UserCollection.FindOne(ctx, bson.M{"orders._id": orderid}, options.FindOne().SetProjection(projection)).Decode(&founduser)
+	if err == mongo.ErrNoDocuments {
+		return founduser, models.Order{}, ErrOrderNotFound
+	}
+	if err != nil {
+		return founduser, models.Order{}, err
+	}
+	if len(founduser.Order_Status) == 0 {
+		return founduser, models.Order{}, ErrOrderNotFound
+	}
+	return founduser, founduser.Order_Status[0], nil
+}
+
+// shippingLine is the key of the shipping of an order among its refundable lines
+var shippingLine = lineKey{}
+
+// refundLine is the line of the order a refund item is for
+func refundLine(item models.RefundItem) lineKey {
+	if item.Shipping {
+		return shippingLine
+	}
+	return itemKey(item.Product_ID, item.Variant_ID)
+}
+
+// refundItem is the refund of amount on a line of the order
+func refundItem(line lineKey, amount int) models.RefundItem {
+	if line == shippingLine {
+		return models.RefundItem{Shipping: true, Amount: amount}
+	}
+	return models.RefundItem{Product_ID: line.Product, Variant_ID: line.variantID(), Amount: amount}
+}
+
+// how much can still be refunded for every product, or variant of a product, in
+// the order and for its shipping. Together they come to the price of the order.
+// Lines paid back as store credit by a return are no longer refundable.
+func refundableByLine(order models.Order) map[lineKey]int {
+	refundable := make(map[lineKey]int)
+	for _, item := range order.Order_Cart {
+		refundable[itemKey(item.Product_ID, item.Variant_ID)] += item.Price*item.Units() - item.Discount
+	}
+	if order.Totals != nil && order.Totals.Shipping > 0 {
+		refundable[shippingLine] += order.Totals.Shipping
+	}
+	for _, refund := range order.Refunds {
+		for _, item := range refund.Items {
+			refundable[refundLine(item)] -= item.Amount
+		}
+	}
+	for _, rma := range order.Returns {
+		if rma.Status != models.ReturnCompleted {
+			continue
+		}
+		for _, item := range rma.Credit_Items {
+			refundable[refundLine(item)] -= item.Amount
+		}
+	}
+	return refundable
+}
+
+// refundStatusAfter is full once the refund leaves nothing of the lines and the shipping to refund
+func refundStatusAfter(order models.Order, amount int) string {
+	left := 0
+	for _, remaining := range refundableByLine(order) {
+		if remaining > 0 {
+			left += remaining
+		}
+	}
+	if amount >= left {
+		return models.RefundFull
+	}
+	return models.RefundPartial
+}
+
+// saveRefund pushes the refund onto the order and emits it. It refuses the
+// update if another refund was added since the order was read, match holds
+// extra conditions on the order and extra holds additional $set fields that
+// have to change in the same write.
+func saveRefund(ctx context.Context, usert_id primitive.ObjectID, order models.Order, refund *models.Refund, match bson.M, extra bson.M) error {
+	if err := numberRefund(ctx, refund); err != nil {
+		return err
+	}
+	elem := bson.M{
+		"_id": order.Order_ID,
+		fmt.Sprintf("refunds.%d", len(order.Refunds)): bson.M{"$exists": false},
+	}
+	for key, value := range match {
+		elem[key] = value
+	}
+	filter := bson.M{"_id": usert_id, "orders": bson.M{"$elemMatch": elem}}
+	set := bson.M{"orders.$.refund_status": refundStatusAfter(order, refund.Amount)}
+	for key, value := range extra {
+		set[key] = value
+	}
+	update := bson.M{
+		"$set":  set,
+		"$push": bson.M{"orders.$.refunds": refund},
+	}
+	result, err := UserCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrOrderChanged
+	}
+	emitRefund(ctx, order.Order_ID, *refund)
+	return nil
+}
+
+// every refund gets its own credit note number so accounting can book it against the invoice
+func numberRefund(ctx context.Context, refund *models.Refund) error {
+	if refund.Credit_Note_Number != "" {
+		return nil
+	}
+	number, err := invoice.NextNumber(ctx, database.Client, invoice.KindCreditNote, refund.Created_At)
+	if err != nil {
+		return err
+	}
+	refund.Credit_Note_Number = number
+	return nil
+}
+
+func emitRefund(ctx context.Context, order_id primitive.ObjectID, refund models.Refund) {
+	payload := bson.M{
+		"refund_id":  refund.Refund_ID,
+		"order_id":   order_id,
+		"payment_id": refund.Payment_ID,
+		"amount":     refund.Amount,
+		"items":      refund.Items,
+	}
+	if err := events.Emit(ctx, events.RefundIssued, payload); err != nil {
+		log.Println(err)
+	}
+}
+
+/**************************************************ORDERS********************************************************************************************************/
+
+//function for the customer to cancel an order which has not been shipped yet
+//digitally paid orders are refunded in full, cod and unpaid orders have nothing to refund
+//the stock of the order goes back on the shelf
+//POST request
+//http://localhost:8000/cancelorder?order_id=xxxxxxorder_idxxxxxx
+
+func CancelOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user_id := c.GetString("uid")
+		order_id := c.Query("order_id")
+		if order_id == "" {
+			c.Header("Content-Type", "application/json")
+			c.JSON(http.StatusNotFound, gin.H{"error": "Invalid order id"})
+			c.Abort()
+			return
+		}
+		usert_id, err := primitive.ObjectIDFromHex(user_id)
+		if err != nil {
+			c.IndentedJSON(http.StatusUnauthorized, "Invalid user")
+			return
+		}
+		ordert_id, err := primitive.ObjectIDFromHex(order_id)
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, "Invalid order id")
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		owner_id, order, err := findOrder(ctx, ordert_id)
+		if err != nil || owner_id != usert_id {
+			c.IndentedJSON(http.StatusNotFound, "Order not found")
+			return
+		}
+		if status := orderStatus(order); status != models.OrderPlaced && status != models.OrderPendingPayment {
+			c.IndentedJSON(http.StatusConflict, "Order can no longer be cancelled")
+			return
+		}
+		now := time.Now()
+		set := bson.M{"orders.$.status": models.OrderCancelled, "orders.$.cancelled_on": now}
+		//the status is part of the filter so a concurrent shipment or cancel wins cleanly
+		cancellable := bson.M{"status": bson.M{"$in": bson.A{nil, "", models.OrderPlaced, models.OrderPendingPayment}}}
+		refund := models.Refund{
+			Refund_ID:  primitive.NewObjectID(),
+			Payment_ID: order.Payment_Method.Payment_ID,
+			Items:      make([]models.RefundItem, 0),
+			Created_At: now,
+		}
+		if order.Payment_Method.Digital {
+			//everything left on the lines and the shipping, the amount is what the items come to
+			for line, amount := range refundableByLine(order) {
+				if amount > 0 {
+					refund.Items = append(refund.Items, refundItem(line, amount))
+					refund.Amount += amount
+				}
+			}
+		}
+		if refund.Amount > 0 {
+			//the same guard as admin refunds, a refund added since the order was read fails the cancel
+			err = saveRefund(ctx, usert_id, order, &refund, cancellable, set)
+		} else {
+			cancellable["_id"] = ordert_id
+			var result *mongo.UpdateResult
+			result, err = UserCollection.UpdateOne(ctx, bson.M{"_id": usert_id, "orders": bson.M{"$elemMatch": cancellable}}, bson.M{"$set": set})
+			if err == nil && result.MatchedCount == 0 {
+				err = ErrOrderChanged
+			}
+		}
+		if err == ErrOrderChanged {
+			c.IndentedJSON(http.StatusConflict, "Order was changed, please retry")
+			return
+		}
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+			return
+		}
+		releaseStock(ctx, order)
+		if err := events.Emit(ctx, events.OrderCancelled, bson.M{"order_id": ordert_id, "user_id": usert_id}); err != nil {
+			log.Println(err)
+		}
+		c.IndentedJSON(200, "Successfully cancelled the order")
+	}
+}
+
+/*******************************************************************************************************/
+
+//admin function to refund an order, either fully or per line item
+//leaving out items refunds everything that has not been refunded yet, shipping included
+//leaving out the amount of an item refunds the rest of that item, {"shipping":true} refunds the shipping
+//POST request : http://localhost:8000/admin/refund?order_id=xxxxxxorder_idxxxxxx
+/*
+{
+"items"  : [{"product_id":"xxxxxxproduct_idxxxxxx","amount":50},{"shipping":true}],
+"reason" : "damaged in transit"
+}
+*/
+
+type refundRequest struct {
+	Items  []models.RefundItem `json:"items"`
+	Reason *string             `json:"reason"`
+}
+
+func RefundOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		order_id := c.Query("order_id")
+		ordert_id, err := primitive.ObjectIDFromHex(order_id)
+		if err != nil {
+			c.Header("Content-Type", "application/json")
+			c.JSON(http.StatusNotFound, gin.H{"error": "Invalid order id"})
+			c.Abort()
+			return
+		}
+		var request refundRequest
+		if c.Request.ContentLength != 0 {
+			if err := c.BindJSON(&request); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		usert_id, order, err := findOrder(ctx, ordert_id)
+		if err == ErrOrderNotFound {
+			c.IndentedJSON(http.StatusNotFound, "Order not found")
+			return
+		}
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+			return
+		}
+		refundable := refundableByLine(order)
+		refund := models.Refund{
+			Refund_ID:  primitive.NewObjectID(),
+			Payment_ID: order.Payment_Method.Payment_ID,
+			Items:      make([]models.RefundItem, 0),
+			Reason:     request.Reason,
+			Created_At: time.Now(),
+		}
+		if len(request.Items) == 0 {
+			for line, amount := range refundable {
+				if amount > 0 {
+					refund.Items = append(refund.Items, refundItem(line, amount))
+				}
+			}
+		}
+		for _, item := range request.Items {
+			if !item.Shipping && item.Product_ID.IsZero() {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "every item needs a product_id, or shipping"})
+				return
+			}
+			line := refundLine(item)
+			remaining, ok := refundable[line]
+			if !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s is not part of the order", line)})
+				return
+			}
+			if item.Amount == 0 {
+				item.Amount = remaining
+			}
+			if item.Amount < 0 || item.Amount > remaining {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("at most %d can be refunded for %s", remaining, line)})
+				return
+			}
+			refundable[line] -= item.Amount
+			refund.Items = append(refund.Items, refundItem(line, item.Amount))
+		}
+		for _, item := range refund.Items {
+			refund.Amount += item.Amount
+		}
+		if refund.Amount == 0 {
+			c.IndentedJSON(http.StatusConflict, "Nothing left to refund")
+			return
+		}
+		err = saveRefund(ctx, usert_id, order, &refund, nil, nil)
+		if err == ErrOrderChanged {
+			c.IndentedJSON(http.StatusConflict, "Order was changed, please retry")
+			return
+		}
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+			return
+		}
+		c.IndentedJSON(200, refund)
+	}
+}
+
+/*******************************************************************************************************/
+
+//admin function to move an order forward once it leaves the warehouse
+//PUT request : http://localhost:8000/admin/orderstatus?order_id=xxxxxxorder_idxxxxxx&status=shipped
+
+func UpdateOrderStatus() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		order_id := c.Query("order_id")
+		status := c.Query("status")
+		ordert_id, err := primitive.ObjectIDFromHex(order_id)
+		if err != nil {
+			c.Header("Content-Type", "application/json")
+			c.JSON(http.StatusNotFound, gin.H{"error": "Invalid order id"})
+			c.Abort()
+			return
+		}
+		//the statuses an order has to be in before it can move to the requested one
+		allowed := map[string]bson.A{
+			models.OrderShipped:   {nil, "", models.OrderPlaced},
+			models.OrderDelivered: {models.OrderShipped},
+		}
+		from, ok := allowed[status]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "status must be shipped or delivered"})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		filter := bson.M{"orders": bson.M{"$elemMatch": bson.M{"_id": ordert_id, "status": bson.M{"$in": from}}}}
+		update := bson.M{"$set": bson.M{"orders.$.status": status}}
+		result, err := UserCollection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.IndentedJSON(http.StatusConflict, "Order cannot be moved to "+status)
+			return
+		}
+		//reviews written before the order arrived become verified now
+		if status == models.OrderDelivered {
+			if owner, order, err := findOrder(ctx, ordert_id); err == nil {
+				verifyPurchases(ctx, owner, order)
+			} else {
+				log.Println(err)
+			}
+		}
+		c.IndentedJSON(200, "Successfully updated the order status")
+	}
+}
+
+/*******************************************************************************************************/
+
+//admin function for support staff to find an order by the number the customer reads out
+//spaces and missing dashes are fine, the check digit catches typos
+//GET request : http://localhost:8000/admin/orders/search?number=ORD-2026-000042-5
+
+func SearchOrderByNumber() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		number, err := ordernumber.Normalize(c.Query("number"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		var founduser models.User
+		projection := bson.M{"orders.$": 1, "email": 1, "first_name": 1, "last_name": 1, "phone": 1}
+		err = UserCollection.FindOne(ctx, bson.M{"orders.order_number": number}, options.FindOne().SetProjection(projection)).Decode(&founduser)
+		if err == mongo.ErrNoDocuments || (err == nil && len(founduser.Order_Status) == 0) {
+			c.IndentedJSON(http.StatusNotFound, "No order with number "+number)
+			return
+		}
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+			return
+		}
+		c.IndentedJSON(200, gin.H{
+			"user_id":    founduser.ID,
+			"email":      founduser.Email,
+			"first_name": founduser.First_Name,
+			"last_name":  founduser.Last_Name,
+			"phone":      founduser.Phone,
+			"order":      founduser.Order_Status[0],
+		})
+	}
+}
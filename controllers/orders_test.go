@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"ecommerce/invoice"
+	"ecommerce/models"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func refundedOrder() models.Order {
+	return models.Order{
+		Order_ID: primitive.NewObjectID(),
+		Order_Cart: []models.ProductUser{
+			{Product_ID: primitive.NewObjectID(), Price: 60, Quantity: 1, Discount: 10},
+			{Product_ID: primitive.NewObjectID(), Price: 20, Quantity: 2},
+		},
+		Price:  130,
+		Totals: &models.Totals{Subtotal: 100, Discount_Total: 10, Shipping: 40, Grand_Total: 130, Taxes: []models.TaxLine{{Name: "Tax", Rate: 12, Included: true}}},
+	}
+}
+
+func TestRefundableByLine(t *testing.T) {
+	order := refundedOrder()
+	first := itemKey(order.Order_Cart[0].Product_ID, nil)
+	second := itemKey(order.Order_Cart[1].Product_ID, nil)
+	refundable := refundableByLine(order)
+	if refundable[first] != 50 || refundable[second] != 40 || refundable[shippingLine] != 40 {
+		t.Fatalf("got %v", refundable)
+	}
+	if refundStatusAfter(order, 90) != models.RefundPartial {
+		t.Error("refunding the lines without the shipping is not a full refund")
+	}
+	order.Refunds = append(order.Refunds, models.Refund{Amount: 90, Items: []models.RefundItem{refundItem(first, 50), refundItem(second, 40)}})
+	refundable = refundableByLine(order)
+	if refundable[first] != 0 || refundable[second] != 0 || refundable[shippingLine] != 40 {
+		t.Fatalf("after the lines got %v", refundable)
+	}
+	shipping := refundItem(shippingLine, 40)
+	if !shipping.Shipping || !shipping.Product_ID.IsZero() || refundLine(shipping) != shippingLine {
+		t.Errorf("shipping item %+v", shipping)
+	}
+	if refundStatusAfter(order, 40) != models.RefundFull {
+		t.Error("refunding the shipping too should be a full refund")
+	}
+}
+
+func TestRefundableWithoutShipping(t *testing.T) {
+	order := refundedOrder()
+	order.Totals = nil
+	order.Price = 90
+	refundable := refundableByLine(order)
+	if _, ok := refundable[shippingLine]; ok {
+		t.Errorf("an order without totals got shipping to refund: %v", refundable)
+	}
+	if refundStatusAfter(order, 90) != models.RefundFull {
+		t.Error("refunding every line of an order without shipping should be full")
+	}
+}
+
+func TestOrderTaxRate(t *testing.T) {
+	order := refundedOrder()
+	if rate := orderTaxRate(order); rate != 12 {
+		t.Errorf("got %v, want the rate the order was charged", rate)
+	}
+	order.Totals.Taxes = nil
+	if rate := orderTaxRate(order); rate != 0 {
+		t.Errorf("got %v for an order charged without tax", rate)
+	}
+	order.Totals = nil
+	if rate := orderTaxRate(order); rate != invoice.TaxRate {
+		t.Errorf("got %v for an order from before totals, want TAX_RATE", rate)
+	}
+}
+
+func TestCreditNoteShipping(t *testing.T) {
+	order := refundedOrder()
+	refund := models.Refund{Amount: 90, Items: []models.RefundItem{refundItem(itemKey(order.Order_Cart[0].Product_ID, nil), 50), refundItem(shippingLine, 40)}}
+	doc := creditNoteDocument(models.User{}, order, refund)
+	if len(doc.Lines) != 2 || doc.Lines[1].Description != "Shipping" || doc.Tax_Rate != 12 {
+		t.Errorf("got %+v", doc)
+	}
+}
+
+func TestStoreCreditIsNotRefundable(t *testing.T) {
+	order := refundedOrder()
+	first := itemKey(order.Order_Cart[0].Product_ID, nil)
+	credited := []models.RefundItem{refundItem(first, 30)}
+	order.Returns = []models.Return{
+		{Status: models.ReturnReceived, Credit_Items: credited},
+		{Status: models.ReturnCompleted, Credit_Amount: 30, Credit_Items: credited},
+	}
+	if refundable := refundableByLine(order); refundable[first] != 20 {
+		t.Errorf("got %d refundable on a line credited 30 of 50, want 20", refundable[first])
+	}
+}
@@ -0,0 +1,385 @@
+package controllers
+
+import (
+	"context"
+	"ecommerce/inventory"
+	"ecommerce/models"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrProductNotFound = errors.New("product not found")
+var ErrProductChanged = errors.New("product was changed by someone else, reload it and try again")
+
+// the shop only ever shows products that are not archived, products added
+// before archiving existed have no archived field at all
+var activeProduct = bson.M{"archived": bson.M{"$ne": true}}
+
+//every change to a product has to say which version it was made on
+//the version comes from the json body or the If-Match header, for deletes also from ?version=
+/*
+{
+"product_name"  : "Alienware x15",
+"price"         : 2500,
+"image"         : "alienware.jpg",
+"stock"         : 12,
+"reorder_point" : 3,
+"max_quantity"  : 2,
+"categories"    : ["gaming-laptops"],
+"sku"           : "AW-X15",
+"version"       : 3
+}
+*/
+
+type productRequest struct {
+	Product_Name  *string  `json:"product_name"`
+	Price         *uint64  `json:"price"`
+	Image         *string  `json:"image"`
+	Stock         *int     `json:"stock"`
+	Categories    []string `json:"categories"`
+	SKU           *string  `json:"sku"`
+	Reorder_Point *int     `json:"reorder_point"`
+	Max_Quantity  *int     `json:"max_quantity"`
+	Archived      *bool    `json:"archived"`
+	Version       *int     `json:"version"`
+}
+
+// apply copies the request onto the product, a full update also clears what was left out
+func (request productRequest) apply(product *models.Product, full bool) {
+	if request.Product_Name != nil {
+		name := strings.TrimSpace(*request.Product_Name)
+		request.Product_Name = &name
+	}
+	if full || request.Product_Name != nil {
+		product.Product_Name = request.Product_Name
+	}
+	if full || request.Price != nil {
+		product.Price = request.Price
+	}
+	if full || request.Image != nil {
+		product.Image = request.Image
+	}
+	if full || request.Stock != nil {
+		product.Stock = request.Stock
+	}
+	if full || request.Categories != nil {
+		product.Categories = make([]string, 0, len(request.Categories))
+		for _, category := range request.Categories {
+			product.Categories = append(product.Categories, strings.TrimSpace(category))
+		}
+	}
+	if full || request.Reorder_Point != nil {
+		product.Reorder_Point = request.Reorder_Point
+	}
+	if full || request.Max_Quantity != nil {
+		product.Max_Quantity = request.Max_Quantity
+	}
+	if request.SKU != nil {
+		sku := strings.TrimSpace(*request.SKU)
+		request.SKU = &sku
+	}
+	if full || request.SKU != nil {
+		product.SKU = request.SKU
+	}
+	if request.Archived != nil && *request.Archived != product.Archived {
+		product.Archived = *request.Archived
+		product.Archived_At = nil
+		if product.Archived {
+			now := time.Now()
+			product.Archived_At = &now
+		}
+	}
+}
+
+func productParam(c *gin.Context) (primitive.ObjectID, bool) {
+	productt_id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.Header("Content-Type", "application/json")
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invalid product id"})
+		c.Abort()
+		return productt_id, false
+	}
+	return productt_id, true
+}
+
+// productVersion finds the version the admin was looking at, answering 428 when there is none
+func productVersion(c *gin.Context, body *int) (int, bool) {
+	if body != nil {
+		return *body, true
+	}
+	value := strings.Trim(c.GetHeader("If-Match"), `W/"`)
+	if value == "" {
+		value = c.Query("version")
+	}
+	version, err := strconv.Atoi(value)
+	if err != nil {
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": "the version of the product being changed is required"})
+		return 0, false
+	}
+	return version, true
+}
+
+func findProduct(ctx context.Context, productt_id primitive.ObjectID) (models.Product, error) {
+	var product models.Product
+	err := ProductCollection.FindOne(ctx, bson.M{"_id": productt_id}).Decode(&product)
+	if err == mongo.ErrNoDocuments {
+		return product, ErrProductNotFound
+	}
+	return product, err
+}
+
+// saveProduct writes the product only if nobody saved another version in the
+// meantime and bumps the version, products from before versioning count as version 0
+func saveProduct(ctx context.Context, product models.Product, version int) (models.Product, error) {
+	filter := bson.M{"_id": product.Product_ID, "version": version}
+	if version == 0 {
+		filter["version"] = bson.M{"$in": bson.A{0, nil}}
+	}
+	set := bson.M{
+		"product_name": product.Product_Name,
+		"price":        product.Price,
+		"image":        product.Image,
+		"archived":     product.Archived,
+		"updated_at":   time.Now(),
+	}
+	unset := bson.M{}
+	if product.Stock != nil {
+		set["stock"] = *product.Stock
+	} else {
+		unset["stock"] = ""
+	}
+	if len(product.Categories) > 0 {
+		set["categories"] = product.Categories
+	} else {
+		unset["categories"] = ""
+	}
+	if product.Reorder_Point != nil {
+		set["reorder_point"] = *product.Reorder_Point
+	} else {
+		unset["reorder_point"] = ""
+	}
+	if product.Max_Quantity != nil {
+		set["max_quantity"] = *product.Max_Quantity
+	} else {
+		unset["max_quantity"] = ""
+	}
+	if product.SKU != nil {
+		set["sku"] = *product.SKU
+	} else {
+		unset["sku"] = ""
+	}
+	if len(product.Variants) > 0 {
+		set["options"] = product.Options
+		set["variants"] = product.Variants
+	} else {
+		unset["options"] = ""
+		unset["variants"] = ""
+	}
+	if product.Archived_At != nil {
+		set["archived_at"] = product.Archived_At
+	} else {
+		unset["archived_at"] = ""
+	}
+	update := bson.M{"$set": set, "$inc": bson.M{"version": 1}}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	var saved models.Product
+	after := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := ProductCollection.FindOneAndUpdate(ctx, filter, update, after).Decode(&saved)
+	if err == mongo.ErrNoDocuments {
+		if _, err := findProduct(ctx, product.Product_ID); err != nil {
+			return saved, err
+		}
+		return saved, ErrProductChanged
+	}
+	if err != nil {
+		return saved, err
+	}
+	indexProduct(ctx, saved)
+	return saved, nil
+}
+
+// productError answers a failed save, on a conflict the current product is sent
+// along so the admin can see what changed
+func productError(c *gin.Context, ctx context.Context, productt_id primitive.ObjectID, err error) {
+	switch err {
+	case ErrProductNotFound:
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case ErrProductChanged:
+		current, _ := findProduct(ctx, productt_id)
+		c.IndentedJSON(http.StatusConflict, gin.H{"error": err.Error(), "product": current})
+	default:
+		c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+	}
+}
+
+// updateProduct is shared by PUT and PATCH, they only differ in what happens to fields left out
+func updateProduct(full bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productt_id, ok := productParam(c)
+		if !ok {
+			return
+		}
+		var request productRequest
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		version, ok := productVersion(c, request.Version)
+		if !ok {
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		product, err := findProduct(ctx, productt_id)
+		if err != nil {
+			productError(c, ctx, productt_id, err)
+			return
+		}
+		if product.Version != version {
+			productError(c, ctx, productt_id, ErrProductChanged)
+			return
+		}
+		before := product
+		request.apply(&product, full)
+		if len(before.Stock_Levels) > 0 {
+			//stock kept per location only changes through adjustments and transfers
+			if request.Stock != nil && (before.Stock == nil || *request.Stock != *before.Stock) {
+				inventoryError(c, inventory.ErrLocationRequired)
+				return
+			}
+			product.Stock = before.Stock
+		}
+		if err := Validate.Struct(product); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := checkCategories(ctx, product.Categories); err != nil {
+			categoryError(c, err)
+			return
+		}
+		if err := checkSKUs(ctx, product); err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		saved, err := saveProduct(ctx, product, version)
+		if err != nil {
+			productError(c, ctx, productt_id, err)
+			return
+		}
+		inventory.RecordEdits(ctx, before, saved)
+		c.IndentedJSON(200, saved)
+	}
+}
+
+//admin function to look at one product, archived ones included
+//GET request : http://localhost:8000/admin/products/xxxproduct_idxxx
+
+func GetProductAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productt_id, ok := productParam(c)
+		if !ok {
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		product, err := findProduct(ctx, productt_id)
+		if err != nil {
+			productError(c, ctx, productt_id, err)
+			return
+		}
+		products := []models.Product{product}
+		if err := addBreadcrumbs(ctx, products); err != nil {
+			log.Println(err)
+		}
+		c.Header("ETag", strconv.Quote(strconv.Itoa(product.Version)))
+		c.IndentedJSON(200, products[0])
+	}
+}
+
+//public function showing one product with the breadcrumbs of every category it is in
+//GET request : http://localhost:8000/users/products/xxxproduct_idxxx
+
+func GetProduct() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productt_id, ok := productParam(c)
+		if !ok {
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		product, err := findProduct(ctx, productt_id)
+		if err == nil && product.Archived {
+			err = ErrProductNotFound
+		}
+		if err != nil {
+			productError(c, ctx, productt_id, err)
+			return
+		}
+		products := []models.Product{product}
+		if err := addBreadcrumbs(ctx, products); err != nil {
+			log.Println(err)
+		}
+		c.IndentedJSON(200, products[0])
+	}
+}
+
+//admin function replacing a product, fields left out are cleared
+//PUT request : http://localhost:8000/admin/products/xxxproduct_idxxx
+
+func UpdateProduct() gin.HandlerFunc {
+	return updateProduct(true)
+}
+
+//admin function changing only the fields sent, {"archived":false} brings an archived product back
+//PATCH request : http://localhost:8000/admin/products/xxxproduct_idxxx
+
+func PatchProduct() gin.HandlerFunc {
+	return updateProduct(false)
+}
+
+//admin function archiving a product, it disappears from the shop but stays on placed orders
+//DELETE request : http://localhost:8000/admin/products/xxxproduct_idxxx?version=3
+
+func DeleteProduct() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productt_id, ok := productParam(c)
+		if !ok {
+			return
+		}
+		version, ok := productVersion(c, nil)
+		if !ok {
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		product, err := findProduct(ctx, productt_id)
+		if err != nil {
+			productError(c, ctx, productt_id, err)
+			return
+		}
+		if product.Version != version {
+			productError(c, ctx, productt_id, ErrProductChanged)
+			return
+		}
+		archived := true
+		productRequest{Archived: &archived}.apply(&product, false)
+		saved, err := saveProduct(ctx, product, version)
+		if err != nil {
+			productError(c, ctx, productt_id, err)
+			return
+		}
+		c.IndentedJSON(200, saved)
+	}
+}
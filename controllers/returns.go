@@ -0,0 +1,446 @@
+package controllers
+
+import (
+	"context"
+	"ecommerce/events"
+	"ecommerce/models"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func findReturn(order models.Order, return_id primitive.ObjectID) (int, models.Return, bool) {
+	for i, item := range order.Returns {
+		if item.Return_ID == return_id {
+			return i, item, true
+		}
+	}
+	return -1, models.Return{}, false
+}
+
+func validReason(code string) bool {
+	for _, reason := range models.ReturnReasons {
+		if reason == code {
+			return true
+		}
+	}
+	return false
+}
+
+// how many units of every product, or variant of a product, can still be put on a new return
+func returnableByLine(order models.Order) map[lineKey]int {
+	returnable := make(map[lineKey]int)
+	for _, item := range order.Order_Cart {
+		returnable[itemKey(item.Product_ID, item.Variant_ID)] += item.Units()
+	}
+	for _, rma := range order.Returns {
+		if rma.Status == models.ReturnRejected {
+			continue
+		}
+		for _, item := range rma.Items {
+			returnable[itemKey(item.Product_ID, item.Variant_ID)] -= item.Quantity
+		}
+	}
+	return returnable
+}
+
+// the money owed for a return, never more than what is still refundable on the order
+func returnRefundItems(order models.Order, rma models.Return) []models.RefundItem {
+	unitprice := make(map[lineKey]int)
+	for _, item := range order.Order_Cart {
+		line := itemKey(item.Product_ID, item.Variant_ID)
+		if _, ok := unitprice[line]; !ok {
+			//what a unit was paid, after its share of the discounts
+			unitprice[line] = (item.Price*item.Units() - item.Discount) / item.Units()
+		}
+	}
+	refundable := refundableByLine(order)
+	items := make([]models.RefundItem, 0)
+	for _, item := range rma.Items {
+		line := itemKey(item.Product_ID, item.Variant_ID)
+		amount := unitprice[line] * item.Quantity
+		if amount > refundable[line] {
+			amount = refundable[line]
+		}
+		if amount <= 0 {
+			continue
+		}
+		refundable[line] -= amount
+		items = append(items, models.RefundItem{Product_ID: item.Product_ID, Variant_ID: item.Variant_ID, Amount: amount})
+	}
+	return items
+}
+
+// returnParams reads the order_id and return_id query parameters every admin return call takes
+func returnParams(c *gin.Context) (primitive.ObjectID, primitive.ObjectID, bool) {
+	ordert_id, err := primitive.ObjectIDFromHex(c.Query("order_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invalid order id"})
+		c.Abort()
+		return ordert_id, primitive.NilObjectID, false
+	}
+	returnt_id, err := primitive.ObjectIDFromHex(c.Query("return_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invalid return id"})
+		c.Abort()
+		return ordert_id, returnt_id, false
+	}
+	return ordert_id, returnt_id, true
+}
+
+// moveReturn changes the status of a return if it is still in the expected one
+func moveReturn(ctx context.Context, usert_id primitive.ObjectID, order models.Order, index int, from string, set bson.M) (bool, error) {
+	prefix := fmt.Sprintf("orders.$.returns.%d.", index)
+	fields := bson.M{prefix + "updated_at": time.Now()}
+	for key, value := range set {
+		fields[prefix+key] = value
+	}
+	filter := bson.M{"_id": usert_id, "orders": bson.M{"$elemMatch": bson.M{
+		"_id":                                   order.Order_ID,
+		fmt.Sprintf("returns.%d.status", index): from,
+	}}}
+	result, err := UserCollection.UpdateOne(ctx, filter, bson.M{"$set": fields})
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+/**************************************************RETURNS********************************************************************************************************/
+
+//function for the customer to open a return for delivered items
+//resolution is refund (default) or store_credit
+//POST request
+//http://localhost:8000/returns?order_id=xxxxxxorder_idxxxxxx
+/*
+{
+"items"      : [{"product_id":"xxxxxxproduct_idxxxxxx","quantity":1,"reason_code":"damaged","comment":"box was crushed"}],
+"resolution" : "refund"
+}
+*/
+
+type returnRequest struct {
+	Items      []models.ReturnItem `json:"items"`
+	Resolution string              `json:"resolution"`
+}
+
+func RequestReturn() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		usert_id, err := primitive.ObjectIDFromHex(c.GetString("uid"))
+		if err != nil {
+			c.IndentedJSON(http.StatusUnauthorized, "Invalid user")
+			return
+		}
+		ordert_id, err := primitive.ObjectIDFromHex(c.Query("order_id"))
+		if err != nil {
+			c.Header("Content-Type", "application/json")
+			c.JSON(http.StatusNotFound, gin.H{"error": "Invalid order id"})
+			c.Abort()
+			return
+		}
+		var request returnRequest
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if request.Resolution == "" {
+			request.Resolution = models.ResolutionRefund
+		}
+		if request.Resolution != models.ResolutionRefund && request.Resolution != models.ResolutionStoreCredit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "resolution must be refund or store_credit"})
+			return
+		}
+		if len(request.Items) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "at least one item has to be returned"})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		owner_id, order, err := findOrder(ctx, ordert_id)
+		if err != nil || owner_id != usert_id {
+			c.IndentedJSON(http.StatusNotFound, "Order not found")
+			return
+		}
+		if orderStatus(order) != models.OrderDelivered {
+			c.IndentedJSON(http.StatusConflict, "Only delivered orders can be returned")
+			return
+		}
+		returnable := returnableByLine(order)
+		for i, item := range request.Items {
+			if item.Quantity == 0 {
+				item.Quantity = 1
+				request.Items[i].Quantity = 1
+			}
+			if !validReason(item.Reason_Code) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown reason code %q", item.Reason_Code), "reason_codes": models.ReturnReasons})
+				return
+			}
+			line := itemKey(item.Product_ID, item.Variant_ID)
+			if item.Quantity < 0 || item.Quantity > returnable[line] {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("at most %d of %s can be returned", returnable[line], line)})
+				return
+			}
+			returnable[line] -= item.Quantity
+		}
+		now := time.Now()
+		rma := models.Return{
+			Return_ID:  primitive.NewObjectID(),
+			Items:      request.Items,
+			Status:     models.ReturnRequested,
+			Resolution: request.Resolution,
+			Inspection: models.InspectionPending,
+			Created_At: now,
+			Updated_At: now,
+		}
+		//refuse the write if another return was opened meanwhile, it could claim the same items
+		filter := bson.M{"_id": usert_id, "orders": bson.M{"$elemMatch": bson.M{
+			"_id": ordert_id,
+			fmt.Sprintf("returns.%d", len(order.Returns)): bson.M{"$exists": false},
+		}}}
+		update := bson.M{"$push": bson.M{"orders.$.returns": rma}}
+		result, err := UserCollection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.IndentedJSON(http.StatusConflict, "Order was changed, please retry")
+			return
+		}
+		c.IndentedJSON(http.StatusCreated, rma)
+	}
+}
+
+/*******************************************************************************************************/
+
+//admin function to approve a return, the customer gets a return label to send the items back
+//PUT request : http://localhost:8000/admin/returns/approve?order_id=xxxxxxorder_idxxxxxx&return_id=xxxxxxreturn_idxxxxxx
+
+func ApproveReturn() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ordert_id, returnt_id, ok := returnParams(c)
+		if !ok {
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		usert_id, order, err := findOrder(ctx, ordert_id)
+		if err != nil {
+			c.IndentedJSON(http.StatusNotFound, "Order not found")
+			return
+		}
+		index, _, found := findReturn(order, returnt_id)
+		if !found {
+			c.IndentedJSON(http.StatusNotFound, "Return not found")
+			return
+		}
+		label := models.ReturnLabel{
+			Carrier:         "pending",
+			Tracking_Number: "RMA-" + returnt_id.Hex(),
+			Label_URL:       "/returns/" + returnt_id.Hex() + "/label",
+			Created_At:      time.Now(),
+		}
+		moved, err := moveReturn(ctx, usert_id, order, index, models.ReturnRequested, bson.M{"status": models.ReturnApproved, "label": label})
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+			return
+		}
+		if !moved {
+			c.IndentedJSON(http.StatusConflict, "Only requested returns can be approved")
+			return
+		}
+		c.IndentedJSON(200, label)
+	}
+}
+
+/*******************************************************************************************************/
+
+//admin function to reject a return
+//PUT request : http://localhost:8000/admin/returns/reject?order_id=xxxxxxorder_idxxxxxx&return_id=xxxxxxreturn_idxxxxxx
+/*
+{
+"note" : "outside of the return window"
+}
+*/
+
+func RejectReturn() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ordert_id, returnt_id, ok := returnParams(c)
+		if !ok {
+			return
+		}
+		var request struct {
+			Note *string `json:"note"`
+		}
+		if c.Request.ContentLength != 0 {
+			if err := c.BindJSON(&request); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		usert_id, order, err := findOrder(ctx, ordert_id)
+		if err != nil {
+			c.IndentedJSON(http.StatusNotFound, "Order not found")
+			return
+		}
+		index, _, found := findReturn(order, returnt_id)
+		if !found {
+			c.IndentedJSON(http.StatusNotFound, "Return not found")
+			return
+		}
+		moved, err := moveReturn(ctx, usert_id, order, index, models.ReturnRequested, bson.M{"status": models.ReturnRejected, "admin_note": request.Note})
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+			return
+		}
+		if !moved {
+			c.IndentedJSON(http.StatusConflict, "Only requested returns can be rejected")
+			return
+		}
+		c.IndentedJSON(200, "Successfully rejected the return")
+	}
+}
+
+/*******************************************************************************************************/
+
+//admin function to record the inspection of the parcel when it arrives
+//a passed inspection completes the return and issues the refund or store credit right away
+//a failed inspection rejects the return
+//PUT request : http://localhost:8000/admin/returns/receive?order_id=xxxxxxorder_idxxxxxx&return_id=xxxxxxreturn_idxxxxxx&inspection=passed
+
+func ReceiveReturn() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ordert_id, returnt_id, ok := returnParams(c)
+		if !ok {
+			return
+		}
+		inspection := c.Query("inspection")
+		if inspection != models.InspectionPassed && inspection != models.InspectionFailed {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "inspection must be passed or failed"})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		usert_id, order, err := findOrder(ctx, ordert_id)
+		if err != nil {
+			c.IndentedJSON(http.StatusNotFound, "Order not found")
+			return
+		}
+		index, rma, found := findReturn(order, returnt_id)
+		if !found {
+			c.IndentedJSON(http.StatusNotFound, "Return not found")
+			return
+		}
+		if inspection == models.InspectionFailed {
+			moved, err := moveReturn(ctx, usert_id, order, index, models.ReturnApproved, bson.M{"status": models.ReturnRejected, "inspection": inspection})
+			if err != nil {
+				c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+				return
+			}
+			if !moved {
+				c.IndentedJSON(http.StatusConflict, "Only approved returns can be received")
+				return
+			}
+			c.IndentedJSON(200, "Inspection failed, the return was rejected")
+			return
+		}
+		//mark it received first so a second call cannot pay out twice
+		//a return left received by an earlier failed call is simply completed again
+		if rma.Status != models.ReturnReceived {
+			moved, err := moveReturn(ctx, usert_id, order, index, models.ReturnApproved, bson.M{"status": models.ReturnReceived, "inspection": inspection})
+			if err != nil {
+				c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+				return
+			}
+			if !moved {
+				c.IndentedJSON(http.StatusConflict, "Only approved returns can be received")
+				return
+			}
+		}
+		rma, err = completeReturn(ctx, usert_id, order, index, rma)
+		if err != nil {
+			log.Println(err)
+			c.IndentedJSON(http.StatusInternalServerError, "Return received but could not be completed, please retry")
+			return
+		}
+		c.IndentedJSON(200, rma)
+	}
+}
+
+// completeReturn pays out a received return as a refund against the original payment or as store credit
+func completeReturn(ctx context.Context, usert_id primitive.ObjectID, order models.Order, index int, rma models.Return) (models.Return, error) {
+	items := returnRefundItems(order, rma)
+	amount := 0
+	for _, item := range items {
+		amount += item.Amount
+	}
+	prefix := fmt.Sprintf("returns.%d.", index)
+	now := time.Now()
+	rma.Status = models.ReturnCompleted
+	rma.Inspection = models.InspectionPassed
+	rma.Updated_At = now
+	if rma.Resolution == models.ResolutionStoreCredit || amount == 0 {
+		rma.Credit_Amount = amount
+		rma.Credit_Items = items
+		//the credit and the completion are one write on the user, a failed call leaves
+		//the return received without credit and it can simply be received again.
+		//The credited lines are no longer refundable, a refund added since the order
+		//was read could pay the same lines out so it fails the write
+		filter := bson.M{"_id": usert_id, "orders": bson.M{"$elemMatch": bson.M{
+			"_id":             order.Order_ID,
+			prefix + "status": models.ReturnReceived,
+			fmt.Sprintf("refunds.%d", len(order.Refunds)): bson.M{"$exists": false},
+		}}}
+		update := bson.M{
+			"$set": bson.M{
+				"orders.$." + prefix + "status":        models.ReturnCompleted,
+				"orders.$." + prefix + "credit_amount": amount,
+				"orders.$." + prefix + "credit_items":  items,
+				"orders.$." + prefix + "updated_at":    now,
+			},
+			"$inc": bson.M{"store_credit": amount},
+		}
+		result, err := UserCollection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			return rma, err
+		}
+		if result.MatchedCount == 0 {
+			return rma, ErrOrderChanged
+		}
+		if amount == 0 {
+			return rma, nil
+		}
+		payload := bson.M{"user_id": usert_id, "order_id": order.Order_ID, "return_id": rma.Return_ID, "amount": amount}
+		if err := events.Emit(ctx, events.CreditIssued, payload); err != nil {
+			log.Println(err)
+		}
+		return rma, nil
+	}
+	reason := "return " + rma.Return_ID.Hex()
+	refund := models.Refund{
+		Refund_ID:  primitive.NewObjectID(),
+		Payment_ID: order.Payment_Method.Payment_ID,
+		Items:      items,
+		Amount:     amount,
+		Reason:     &reason,
+		Created_At: now,
+	}
+	rma.Refund_ID = &refund.Refund_ID
+	match := bson.M{prefix + "status": models.ReturnReceived}
+	extra := bson.M{
+		"orders.$." + prefix + "status":     models.ReturnCompleted,
+		"orders.$." + prefix + "refund_id":  refund.Refund_ID,
+		"orders.$." + prefix + "updated_at": now,
+	}
+	if err := saveRefund(ctx, usert_id, order, &refund, match, extra); err != nil {
+		return rma, err
+	}
+	return rma, nil
+}
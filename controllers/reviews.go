@@ -0,0 +1,621 @@
+package controllers
+
+import (
+	"context"
+	"ecommerce/database"
+	"ecommerce/models"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ReviewCollection *mongo.Collection = database.UserData(database.Client, "Reviews")
+
+var ErrReviewNotFound = errors.New("review not found")
+var ErrNotOrdered = errors.New("only products you ordered can be reviewed")
+var ErrAlreadyReviewed = errors.New("you already reviewed this product, change your review instead")
+
+// the sorts of the public review list, helpful first by default
+var reviewSorts = map[string]bson.D{
+	"helpful": {{Key: "helpful", Value: -1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}},
+	"newest":  {{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}},
+	"oldest":  {{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}},
+	"rating":  {{Key: "rating", Value: 1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}},
+	"-rating": {{Key: "rating", Value: -1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}},
+}
+
+// the voters are only needed to stop double votes
+var hideVoters = bson.M{"voters": 0}
+
+var reviewIndexOnce sync.Once
+
+// the indexes are made the first time a review is posted
+func ensureReviewIndex(ctx context.Context) {
+	reviewIndexOnce.Do(func() {
+		indexes := []mongo.IndexModel{
+			{Keys: bson.D{{Key: "product_id", Value: 1}, {Key: "user_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "product_id", Value: 1}, {Key: "status", Value: 1}}},
+		}
+		if _, err := ReviewCollection.Indexes().CreateMany(ctx, indexes); err != nil {
+			log.Println(err)
+		}
+	})
+}
+
+// purchase looks through the orders of the user for the product. ordered is
+// true once an order with it went through, delivered once one of them arrived.
+// author is the name shown on the review, "Akhil S."
+func purchase(ctx context.Context, usert_id primitive.ObjectID, productt_id primitive.ObjectID) (ordered bool, delivered bool, author string, err error) {
+	var user models.User
+	projection := options.FindOne().SetProjection(bson.M{"first_name": 1, "last_name": 1, "orders.order_list._id": 1, "orders.status": 1})
+	if err := UserCollection.FindOne(ctx, bson.M{"_id": usert_id}, projection).Decode(&user); err != nil {
+		return false, false, "", err
+	}
+	author = strings.TrimSpace(deref(user.First_Name))
+	if last := strings.TrimSpace(deref(user.Last_Name)); last != "" {
+		author += " " + string([]rune(last)[:1]) + "."
+	}
+	for _, order := range user.Order_Status {
+		status := orderStatus(order)
+		if status == models.OrderCancelled || status == models.OrderPendingPayment {
+			continue
+		}
+		for _, item := range order.Order_Cart {
+			if item.Product_ID == productt_id {
+				ordered = true
+				delivered = delivered || status == models.OrderDelivered
+			}
+		}
+	}
+	return ordered, delivered, author, nil
+}
+
+// verifyPurchases marks the reviews a customer already wrote about the products
+// of an order as verified once that order is delivered
+func verifyPurchases(ctx context.Context, usert_id primitive.ObjectID, order models.Order) {
+	products := make([]primitive.ObjectID, 0, len(order.Order_Cart))
+	for _, item := range order.Order_Cart {
+		products = append(products, item.Product_ID)
+	}
+	if len(products) == 0 {
+		return
+	}
+	filter := bson.M{"user_id": usert_id, "product_id": bson.M{"$in": products}, "verified": false}
+	if _, err := ReviewCollection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"verified": true}}); err != nil {
+		log.Println(err)
+	}
+}
+
+// starCount is how many approved reviews gave the number of stars
+type starCount struct {
+	Rating int `bson:"_id"`
+	Count  int `bson:"count"`
+}
+
+// ratingSummary adds the star counts up into the summary and the whole star
+// rating of the product, the average rounded down
+func ratingSummary(stars []starCount) (models.RatingSummary, int) {
+	summary := models.RatingSummary{Histogram: map[string]int{"1": 0, "2": 0, "3": 0, "4": 0, "5": 0}}
+	total := 0
+	for _, star := range stars {
+		summary.Histogram[strconv.Itoa(star.Rating)] += star.Count
+		summary.Count += star.Count
+		total += star.Rating * star.Count
+	}
+	if summary.Count == 0 {
+		return summary, 0
+	}
+	average := float64(total) / float64(summary.Count)
+	summary.Average = math.Round(average*10) / 10
+	return summary, int(math.Floor(average))
+}
+
+// refreshRating adds up the approved reviews of the product into its rating
+// summary. It is worked out again from all reviews every time so it can not drift.
+func refreshRating(ctx context.Context, productt_id primitive.ObjectID) {
+	pipeline := bson.A{
+		bson.M{"$match": bson.M{"product_id": productt_id, "status": models.ReviewApproved}},
+		bson.M{"$group": bson.M{"_id": "$rating", "count": bson.M{"$sum": 1}}},
+	}
+	cursor, err := ReviewCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	var stars []starCount
+	if err := cursor.All(ctx, &stars); err != nil {
+		log.Println(err)
+		return
+	}
+	//the rating and the summary are kept by the reviews alone, the version is
+	//left alone so admins editing the product are not interrupted
+	update := bson.M{"$unset": bson.M{"rating": "", "reviews": ""}}
+	if summary, rating := ratingSummary(stars); summary.Count > 0 {
+		update = bson.M{"$set": bson.M{"rating": rating, "reviews": summary}}
+	}
+	var saved models.Product
+	after := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if err := ProductCollection.FindOneAndUpdate(ctx, bson.M{"_id": productt_id}, update, after).Decode(&saved); err != nil {
+		log.Println(err)
+		return
+	}
+	indexProduct(ctx, saved)
+}
+
+func reviewParam(c *gin.Context) (primitive.ObjectID, bool) {
+	reviewt_id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.Header("Content-Type", "application/json")
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invalid review id"})
+		c.Abort()
+		return reviewt_id, false
+	}
+	return reviewt_id, true
+}
+
+func findReview(ctx context.Context, filter bson.M) (models.Review, error) {
+	var review models.Review
+	err := ReviewCollection.FindOne(ctx, filter).Decode(&review)
+	if err == mongo.ErrNoDocuments {
+		return review, ErrReviewNotFound
+	}
+	return review, err
+}
+
+func reviewError(c *gin.Context, err error) {
+	switch err {
+	case ErrReviewNotFound, ErrProductNotFound:
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case ErrNotOrdered:
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case ErrAlreadyReviewed:
+		c.IndentedJSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+	}
+}
+
+//what a customer writes about a product, stars go from 1 to 5
+/*
+{
+"product_id" : "xxxproduct_idxxx",
+"rating"     : 4,
+"title"      : "Fast and quiet",
+"text"       : "Runs every game I have without the fans getting loud."
+}
+*/
+
+type reviewRequest struct {
+	Product_ID primitive.ObjectID `json:"product_id"`
+	Rating     int                `json:"rating"`
+	Title      string             `json:"title"`
+	Text       string             `json:"text"`
+}
+
+func (request reviewRequest) apply(review *models.Review) {
+	review.Rating = request.Rating
+	review.Title = strings.TrimSpace(request.Title)
+	review.Text = strings.TrimSpace(request.Text)
+}
+
+//function for customers to review a product they ordered, the review shows up once an admin approved it
+//POST request : http://localhost:8000/reviews
+
+func AddReview() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		usert_id, err := primitive.ObjectIDFromHex(c.GetString("uid"))
+		if err != nil {
+			c.IndentedJSON(http.StatusUnauthorized, "Invalid user")
+			return
+		}
+		var request reviewRequest
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		review := models.Review{Review_ID: primitive.NewObjectID(), Product_ID: request.Product_ID, User_ID: usert_id, Status: models.ReviewPending, Voters: make([]primitive.ObjectID, 0)}
+		request.apply(&review)
+		if err := Validate.Struct(review); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		if _, err := findProduct(ctx, request.Product_ID); err != nil {
+			reviewError(c, err)
+			return
+		}
+		ordered, delivered, author, err := purchase(ctx, usert_id, request.Product_ID)
+		if err != nil {
+			reviewError(c, err)
+			return
+		}
+		if !ordered {
+			reviewError(c, ErrNotOrdered)
+			return
+		}
+		ensureReviewIndex(ctx)
+		review.Author = author
+		review.Verified = delivered
+		review.Created_At = time.Now()
+		review.Updated_At = review.Created_At
+		if _, err := ReviewCollection.InsertOne(ctx, review); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				reviewError(c, ErrAlreadyReviewed)
+				return
+			}
+			reviewError(c, err)
+			return
+		}
+		c.IndentedJSON(http.StatusCreated, review)
+	}
+}
+
+//function for customers to list their own reviews with the state of their moderation
+//GET request : http://localhost:8000/reviews
+
+func MyReviews() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		usert_id, err := primitive.ObjectIDFromHex(c.GetString("uid"))
+		if err != nil {
+			c.IndentedJSON(http.StatusUnauthorized, "Invalid user")
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		find := options.Find().SetSort(reviewSorts["newest"]).SetProjection(hideVoters)
+		cursor, err := ReviewCollection.Find(ctx, bson.M{"user_id": usert_id}, find)
+		if err != nil {
+			reviewError(c, err)
+			return
+		}
+		reviews := make([]models.Review, 0)
+		if err := cursor.All(ctx, &reviews); err != nil {
+			reviewError(c, err)
+			return
+		}
+		c.IndentedJSON(200, reviews)
+	}
+}
+
+//function for customers to change their review, it goes back to the admins to be approved again
+//PUT request : http://localhost:8000/reviews/xxxreview_idxxx with the rating, title and text
+
+func UpdateReview() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		usert_id, err := primitive.ObjectIDFromHex(c.GetString("uid"))
+		if err != nil {
+			c.IndentedJSON(http.StatusUnauthorized, "Invalid user")
+			return
+		}
+		reviewt_id, ok := reviewParam(c)
+		if !ok {
+			return
+		}
+		var request reviewRequest
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		review, err := findReview(ctx, bson.M{"_id": reviewt_id, "user_id": usert_id})
+		if err != nil {
+			reviewError(c, err)
+			return
+		}
+		was := review.Status
+		request.apply(&review)
+		if err := Validate.Struct(review); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		_, delivered, _, err := purchase(ctx, usert_id, review.Product_ID)
+		if err != nil {
+			reviewError(c, err)
+			return
+		}
+		review.Verified = delivered
+		review.Status = models.ReviewPending
+		review.Moderation_Note = nil
+		review.Moderated_At = nil
+		review.Updated_At = time.Now()
+		update := bson.M{
+			"$set": bson.M{
+				"rating":     review.Rating,
+				"title":      review.Title,
+				"text":       review.Text,
+				"verified":   review.Verified,
+				"status":     review.Status,
+				"updated_at": review.Updated_At,
+			},
+			"$unset": bson.M{"moderation_note": "", "moderated_at": ""},
+		}
+		if _, err := ReviewCollection.UpdateOne(ctx, bson.M{"_id": reviewt_id, "user_id": usert_id}, update); err != nil {
+			reviewError(c, err)
+			return
+		}
+		if was == models.ReviewApproved {
+			refreshRating(ctx, review.Product_ID)
+		}
+		c.IndentedJSON(200, review)
+	}
+}
+
+//function for customers to take their review down
+//DELETE request : http://localhost:8000/reviews/xxxreview_idxxx
+
+func DeleteReview() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		usert_id, err := primitive.ObjectIDFromHex(c.GetString("uid"))
+		if err != nil {
+			c.IndentedJSON(http.StatusUnauthorized, "Invalid user")
+			return
+		}
+		reviewt_id, ok := reviewParam(c)
+		if !ok {
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		var review models.Review
+		err = ReviewCollection.FindOneAndDelete(ctx, bson.M{"_id": reviewt_id, "user_id": usert_id}).Decode(&review)
+		if err == mongo.ErrNoDocuments {
+			err = ErrReviewNotFound
+		}
+		if err != nil {
+			reviewError(c, err)
+			return
+		}
+		if review.Status == models.ReviewApproved {
+			refreshRating(ctx, review.Product_ID)
+		}
+		c.IndentedJSON(200, "Successfully removed the review")
+	}
+}
+
+// voteHelpful is shared by voting and taking the vote back, a customer has one
+// vote per review and can not vote on their own
+func voteHelpful(helpful bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		usert_id, err := primitive.ObjectIDFromHex(c.GetString("uid"))
+		if err != nil {
+			c.IndentedJSON(http.StatusUnauthorized, "Invalid user")
+			return
+		}
+		reviewt_id, ok := reviewParam(c)
+		if !ok {
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		filter := bson.M{"_id": reviewt_id, "status": models.ReviewApproved, "user_id": bson.M{"$ne": usert_id}}
+		var update bson.M
+		if helpful {
+			filter["voters"] = bson.M{"$ne": usert_id}
+			update = bson.M{"$addToSet": bson.M{"voters": usert_id}, "$inc": bson.M{"helpful": 1}}
+		} else {
+			filter["voters"] = usert_id
+			update = bson.M{"$pull": bson.M{"voters": usert_id}, "$inc": bson.M{"helpful": -1}}
+		}
+		if _, err := ReviewCollection.UpdateOne(ctx, filter, update); err != nil {
+			reviewError(c, err)
+			return
+		}
+		//voting twice or taking back a vote that was never given changes nothing
+		review, err := findReview(ctx, bson.M{"_id": reviewt_id, "status": models.ReviewApproved})
+		if err != nil {
+			reviewError(c, err)
+			return
+		}
+		if review.User_ID == usert_id {
+			c.IndentedJSON(http.StatusForbidden, gin.H{"error": "you can not vote on your own review"})
+			return
+		}
+		voted := false
+		for _, voter := range review.Voters {
+			voted = voted || voter == usert_id
+		}
+		c.IndentedJSON(200, gin.H{"review_id": review.Review_ID, "helpful": review.Helpful, "voted": voted})
+	}
+}
+
+//function for customers to mark a review of somebody else as helpful
+//POST request : http://localhost:8000/reviews/xxxreview_idxxx/helpful
+
+func VoteReviewHelpful() gin.HandlerFunc {
+	return voteHelpful(true)
+}
+
+//function for customers to take their helpful vote back
+//DELETE request : http://localhost:8000/reviews/xxxreview_idxxx/helpful
+
+func UnvoteReviewHelpful() gin.HandlerFunc {
+	return voteHelpful(false)
+}
+
+//public function listing the approved reviews of a product with its rating summary
+//sort is helpful (the default), newest, oldest, rating or -rating, rating=5 and verified=true narrow them down
+//GET request : http://localhost:8000/users/products/xxxproduct_idxxx/reviews?sort=newest&rating=5&verified=true&limit=20&offset=0
+/*
+{
+"summary" : {"average":4.3,"count":12,"histogram":{"1":0,"2":1,"3":1,"4":3,"5":7}},
+"reviews" : [...],
+"total"   : 7,
+"limit"   : 20,
+"offset"  : 0
+}
+*/
+
+func ListProductReviews() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productt_id, ok := productParam(c)
+		if !ok {
+			return
+		}
+		sort, ok := reviewSorts[c.DefaultQuery("sort", "helpful")]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "sort must be one of helpful, newest, oldest, rating or -rating"})
+			return
+		}
+		filter := bson.M{"product_id": productt_id, "status": models.ReviewApproved}
+		if value := c.Query("rating"); value != "" {
+			stars, err := strconv.Atoi(value)
+			if err != nil || stars < 1 || stars > 5 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "rating must be a number from 1 to 5"})
+				return
+			}
+			filter["rating"] = stars
+		}
+		if c.Query("verified") == "true" {
+			filter["verified"] = true
+		}
+		limit, offset, err := parsePage(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		product, err := findProduct(ctx, productt_id)
+		if err == nil && product.Archived {
+			err = ErrProductNotFound
+		}
+		if err != nil {
+			reviewError(c, err)
+			return
+		}
+		summary := product.Reviews
+		if summary == nil {
+			summary = &models.RatingSummary{Histogram: map[string]int{"1": 0, "2": 0, "3": 0, "4": 0, "5": 0}}
+		}
+		total, err := ReviewCollection.CountDocuments(ctx, filter)
+		if err != nil {
+			reviewError(c, err)
+			return
+		}
+		find := options.Find().SetSort(sort).SetLimit(limit).SetSkip(offset).SetProjection(hideVoters)
+		cursor, err := ReviewCollection.Find(ctx, filter, find)
+		if err != nil {
+			reviewError(c, err)
+			return
+		}
+		reviews := make([]models.Review, 0)
+		if err := cursor.All(ctx, &reviews); err != nil {
+			reviewError(c, err)
+			return
+		}
+		c.IndentedJSON(200, gin.H{"summary": summary, "reviews": reviews, "total": total, "limit": limit, "offset": offset})
+	}
+}
+
+//admin function listing reviews to moderate, oldest first so the queue is worked off in order
+//GET request : http://localhost:8000/admin/reviews?status=pending&product_id=xxxproduct_idxxx&limit=20&offset=0
+
+func ListReviewsAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filter := bson.M{"status": c.DefaultQuery("status", models.ReviewPending)}
+		if value := c.Query("product_id"); value != "" {
+			id, err := primitive.ObjectIDFromHex(value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid product_id"})
+				return
+			}
+			filter["product_id"] = id
+		}
+		limit, offset, err := parsePage(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		total, err := ReviewCollection.CountDocuments(ctx, filter)
+		if err != nil {
+			reviewError(c, err)
+			return
+		}
+		find := options.Find().SetSort(reviewSorts["oldest"]).SetLimit(limit).SetSkip(offset).SetProjection(hideVoters)
+		cursor, err := ReviewCollection.Find(ctx, filter, find)
+		if err != nil {
+			reviewError(c, err)
+			return
+		}
+		reviews := make([]models.Review, 0)
+		if err := cursor.All(ctx, &reviews); err != nil {
+			reviewError(c, err)
+			return
+		}
+		c.IndentedJSON(200, gin.H{"reviews": reviews, "total": total, "limit": limit, "offset": offset})
+	}
+}
+
+//admin function approving or rejecting a review, the note tells the customer why
+//approving and taking an approval back both update the rating of the product
+//PUT request : http://localhost:8000/admin/reviews/xxxreview_idxxx/moderate
+/*
+{
+"status" : "rejected",
+"note"   : "reviews can not contain links"
+}
+*/
+
+func ModerateReview() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reviewt_id, ok := reviewParam(c)
+		if !ok {
+			return
+		}
+		var request struct {
+			Status string  `json:"status"`
+			Note   *string `json:"note"`
+		}
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if request.Status != models.ReviewApproved && request.Status != models.ReviewRejected && request.Status != models.ReviewPending {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "status must be approved, rejected or pending"})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		now := time.Now()
+		set := bson.M{"status": request.Status, "moderated_at": now}
+		update := bson.M{"$set": set}
+		if request.Note != nil && strings.TrimSpace(*request.Note) != "" {
+			set["moderation_note"] = strings.TrimSpace(*request.Note)
+		} else {
+			update["$unset"] = bson.M{"moderation_note": ""}
+		}
+		var before models.Review
+		err := ReviewCollection.FindOneAndUpdate(ctx, bson.M{"_id": reviewt_id}, update, options.FindOneAndUpdate().SetProjection(hideVoters)).Decode(&before)
+		if err == mongo.ErrNoDocuments {
+			err = ErrReviewNotFound
+		}
+		if err != nil {
+			reviewError(c, err)
+			return
+		}
+		if (before.Status == models.ReviewApproved) != (request.Status == models.ReviewApproved) {
+			refreshRating(ctx, before.Product_ID)
+		}
+		review, err := findReview(ctx, bson.M{"_id": reviewt_id})
+		if err != nil {
+			reviewError(c, err)
+			return
+		}
+		c.IndentedJSON(200, review)
+	}
+}
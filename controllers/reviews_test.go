@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"ecommerce/models"
+	"reflect"
+	"testing"
+)
+
+func TestRatingSummary(t *testing.T) {
+	cases := []struct {
+		name      string
+		stars     []starCount
+		histogram map[string]int
+		count     int
+		average   float64
+		rating    int
+	}{
+		{"no reviews", nil, map[string]int{"1": 0, "2": 0, "3": 0, "4": 0, "5": 0}, 0, 0, 0},
+		{"one review", []starCount{{4, 1}}, map[string]int{"1": 0, "2": 0, "3": 0, "4": 1, "5": 0}, 1, 4, 4},
+		//(5*3 + 4*1 + 1*1) / 5 = 4
+		{"whole average", []starCount{{5, 3}, {4, 1}, {1, 1}}, map[string]int{"1": 1, "2": 0, "3": 0, "4": 1, "5": 3}, 5, 4, 4},
+		//(5*2 + 4*1) / 3 = 4.67, shown as 4.7 but rated 4 stars
+		{"rounded to a tenth, rated down", []starCount{{5, 2}, {4, 1}}, map[string]int{"1": 0, "2": 0, "3": 0, "4": 1, "5": 2}, 3, 4.7, 4},
+		//(2*1 + 3*2) / 3 = 2.67
+		{"order of the groups does not matter", []starCount{{3, 2}, {2, 1}}, map[string]int{"1": 0, "2": 1, "3": 2, "4": 0, "5": 0}, 3, 2.7, 2},
+		//(1*1 + 2*1) / 2 = 1.5
+		{"half way", []starCount{{1, 1}, {2, 1}}, map[string]int{"1": 1, "2": 1, "3": 0, "4": 0, "5": 0}, 2, 1.5, 1},
+	}
+	for _, c := range cases {
+		summary, rating := ratingSummary(c.stars)
+		if !reflect.DeepEqual(summary.Histogram, c.histogram) {
+			t.Errorf("%s: histogram %v, want %v", c.name, summary.Histogram, c.histogram)
+		}
+		if summary.Count != c.count || summary.Average != c.average || rating != c.rating {
+			t.Errorf("%s: got %d reviews averaging %v rated %d, want %d averaging %v rated %d", c.name, summary.Count, summary.Average, rating, c.count, c.average, c.rating)
+		}
+	}
+}
+
+func TestReviewRequestApply(t *testing.T) {
+	request := reviewRequest{Rating: 4, Title: "  Fast and quiet ", Text: "\nRuns every game.  "}
+	var review models.Review
+	request.apply(&review)
+	if review.Rating != 4 || review.Title != "Fast and quiet" || review.Text != "Runs every game." {
+		t.Errorf("got %+v", review)
+	}
+}
@@ -0,0 +1,429 @@
+package controllers
+
+import (
+	"context"
+	"ecommerce/inventory"
+	"ecommerce/models"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ErrVariantRequired = errors.New("this product comes in variants, pick one with variant or sku")
+var ErrVariantNotFound = errors.New("variant not found")
+var ErrSKUTaken = errors.New("sku is already used by another product")
+
+// lineKey tells order lines apart, two variants of one product are different lines
+type lineKey struct {
+	Product primitive.ObjectID
+	Variant primitive.ObjectID
+}
+
+func itemKey(product_id primitive.ObjectID, variant_id *primitive.ObjectID) lineKey {
+	key := lineKey{Product: product_id}
+	if variant_id != nil {
+		key.Variant = *variant_id
+	}
+	return key
+}
+
+func (key lineKey) variantID() *primitive.ObjectID {
+	if key.Variant.IsZero() {
+		return nil
+	}
+	id := key.Variant
+	return &id
+}
+
+func (key lineKey) String() string {
+	if key == shippingLine {
+		return "shipping"
+	}
+	if key.Variant.IsZero() {
+		return "product " + key.Product.Hex()
+	}
+	return "product " + key.Product.Hex() + " variant " + key.Variant.Hex()
+}
+
+// lineName is the product name with the options of the variant, "T-Shirt (M, Red)"
+func lineName(item models.ProductUser) string {
+	name := deref(item.Product_Name)
+	if len(item.Options) == 0 {
+		return name
+	}
+	axes := make([]string, 0, len(item.Options))
+	for axis := range item.Options {
+		axes = append(axes, axis)
+	}
+	sort.Strings(axes)
+	values := make([]string, 0, len(axes))
+	for _, axis := range axes {
+		values = append(values, item.Options[axis])
+	}
+	return name + " (" + strings.Join(values, ", ") + ")"
+}
+
+func findVariant(product models.Product, variant string) (*models.Variant, error) {
+	for i := range product.Variants {
+		if product.Variants[i].Variant_ID.Hex() == variant || strings.EqualFold(product.Variants[i].SKU, variant) {
+			return &product.Variants[i], nil
+		}
+	}
+	return nil, ErrVariantNotFound
+}
+
+// cartLine is what goes into the cart and later the order for the product, or
+// for one of its variants when it has them
+func cartLine(ctx context.Context, product_id primitive.ObjectID, variant string) (models.ProductUser, error) {
+	product, err := cartProduct(ctx, product_id)
+	if err != nil {
+		return models.ProductUser{}, err
+	}
+	return productLine(product, variant)
+}
+
+// cartProduct finds a product that can still be bought
+func cartProduct(ctx context.Context, product_id primitive.ObjectID) (models.Product, error) {
+	var product models.Product
+	err := ProductCollection.FindOne(ctx, bson.M{"_id": product_id, "archived": bson.M{"$ne": true}}).Decode(&product)
+	if err == mongo.ErrNoDocuments {
+		return product, ErrProductNotFound
+	}
+	return product, err
+}
+
+// productLine is cartLine for a product that was already loaded
+func productLine(product models.Product, variant string) (models.ProductUser, error) {
+	var line models.ProductUser
+	line.Product_ID = product.Product_ID
+	line.Product_Name = product.Product_Name
+	line.Image = product.Image
+	line.SKU = product.SKU
+	if product.Price != nil {
+		line.Price = int(*product.Price)
+	}
+	if product.Rating != nil {
+		rating := uint(*product.Rating)
+		line.Rating = &rating
+	}
+	if len(product.Variants) == 0 {
+		return line, nil
+	}
+	if variant == "" {
+		return line, ErrVariantRequired
+	}
+	chosen, err := findVariant(product, variant)
+	if err != nil {
+		return line, err
+	}
+	line.Variant_ID = &chosen.Variant_ID
+	line.SKU = &chosen.SKU
+	line.Options = chosen.Options
+	if chosen.Price != nil {
+		line.Price = int(*chosen.Price)
+	}
+	if len(chosen.Images) > 0 {
+		line.Image = &chosen.Images[0]
+	}
+	return line, nil
+}
+
+func cartLineError(c *gin.Context, err error) {
+	switch err {
+	case ErrProductNotFound, ErrVariantNotFound:
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case ErrVariantRequired:
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.IndentedJSON(http.StatusInternalServerError, "Something Went Wrong")
+	}
+}
+
+// skuTaken tells whether another product or one of its variants has the sku
+func skuTaken(ctx context.Context, product_id primitive.ObjectID, sku string) (bool, error) {
+	filter := bson.M{"_id": bson.M{"$ne": product_id}, "$or": bson.A{bson.M{"sku": sku}, bson.M{"variants.sku": sku}}}
+	count, err := ProductCollection.CountDocuments(ctx, filter)
+	return count > 0, err
+}
+
+// checkSKUs makes sure every sku of the product is unique, within the product and across products
+func checkSKUs(ctx context.Context, product models.Product) error {
+	skus := make([]string, 0, len(product.Variants)+1)
+	if product.SKU != nil {
+		skus = append(skus, *product.SKU)
+	}
+	for _, variant := range product.Variants {
+		skus = append(skus, variant.SKU)
+	}
+	seen := make(map[string]bool)
+	for _, sku := range skus {
+		key := strings.ToUpper(sku)
+		if seen[key] {
+			return fmt.Errorf("sku %s is used twice", sku)
+		}
+		seen[key] = true
+		taken, err := skuTaken(ctx, product.Product_ID, sku)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return ErrSKUTaken
+		}
+	}
+	return nil
+}
+
+func skuPart(value string) string {
+	return strings.ToUpper(slugify(value))
+}
+
+// generateVariants makes one variant per combination of option values. Variants
+// of combinations that already existed are kept with their sku, price, stock
+// and images, variants of combinations that are gone are dropped.
+func generateVariants(product models.Product, options []models.ProductOption) []models.Variant {
+	existing := make(map[string]models.Variant)
+	combination := func(values map[string]string) string {
+		parts := make([]string, 0, len(options))
+		for _, option := range options {
+			parts = append(parts, option.Name+"="+values[option.Name])
+		}
+		return strings.Join(parts, "|")
+	}
+	for _, variant := range product.Variants {
+		if len(variant.Options) == len(options) {
+			existing[combination(variant.Options)] = variant
+		}
+	}
+	base := ""
+	if product.SKU != nil {
+		base = skuPart(*product.SKU)
+	} else if product.Product_Name != nil {
+		base = skuPart(*product.Product_Name)
+	}
+	combinations := []map[string]string{{}}
+	for _, option := range options {
+		next := make([]map[string]string, 0, len(combinations)*len(option.Values))
+		for _, partial := range combinations {
+			for _, value := range option.Values {
+				values := make(map[string]string, len(partial)+1)
+				for axis, chosen := range partial {
+					values[axis] = chosen
+				}
+				values[option.Name] = value
+				next = append(next, values)
+			}
+		}
+		combinations = next
+	}
+	used := make(map[string]bool)
+	for _, variant := range existing {
+		used[strings.ToUpper(variant.SKU)] = true
+	}
+	variants := make([]models.Variant, 0, len(combinations))
+	for _, values := range combinations {
+		if variant, ok := existing[combination(values)]; ok {
+			variants = append(variants, variant)
+			continue
+		}
+		parts := []string{base}
+		for _, option := range options {
+			parts = append(parts, skuPart(values[option.Name]))
+		}
+		sku := strings.Trim(strings.Join(parts, "-"), "-")
+		for n := 2; used[sku]; n++ {
+			sku = fmt.Sprintf("%s-%d", strings.Trim(strings.Join(parts, "-"), "-"), n)
+		}
+		used[sku] = true
+		variants = append(variants, models.Variant{Variant_ID: primitive.NewObjectID(), SKU: sku, Options: values})
+	}
+	return variants
+}
+
+// cleanOptions trims names and values and refuses repeated ones
+func cleanOptions(options []models.ProductOption) error {
+	names := make(map[string]bool)
+	for i := range options {
+		options[i].Name = strings.TrimSpace(options[i].Name)
+		if names[strings.ToLower(options[i].Name)] {
+			return fmt.Errorf("option %s is listed twice", options[i].Name)
+		}
+		names[strings.ToLower(options[i].Name)] = true
+		values := make(map[string]bool)
+		for j := range options[i].Values {
+			options[i].Values[j] = strings.TrimSpace(options[i].Values[j])
+			if values[strings.ToLower(options[i].Values[j])] {
+				return fmt.Errorf("%s %s is listed twice", options[i].Name, options[i].Values[j])
+			}
+			values[strings.ToLower(options[i].Values[j])] = true
+		}
+	}
+	return nil
+}
+
+//admin function setting the option axes of a product, the variants are generated from them
+//sending no options turns the product back into a single item
+//PUT request : http://localhost:8000/admin/products/xxxproduct_idxxx/options
+/*
+{
+"options" : [{"name":"size","values":["S","M","L"]},{"name":"color","values":["Red","Black"]}],
+"version" : 3
+}
+*/
+
+func SetProductOptions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productt_id, ok := productParam(c)
+		if !ok {
+			return
+		}
+		var request struct {
+			Options []models.ProductOption `json:"options"`
+			Version *int                   `json:"version"`
+		}
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		version, ok := productVersion(c, request.Version)
+		if !ok {
+			return
+		}
+		if err := cleanOptions(request.Options); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		product, err := findProduct(ctx, productt_id)
+		if err != nil {
+			productError(c, ctx, productt_id, err)
+			return
+		}
+		if product.Version != version {
+			productError(c, ctx, productt_id, ErrProductChanged)
+			return
+		}
+		before := product
+		//generated from the variants the product has now, so the ones still wanted keep their sku, price and stock
+		var variants []models.Variant
+		if len(request.Options) > 0 {
+			variants = generateVariants(product, request.Options)
+		}
+		product.Options = request.Options
+		product.Variants = variants
+		if err := Validate.Struct(product); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := checkSKUs(ctx, product); err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		saved, err := saveProduct(ctx, product, version)
+		if err != nil {
+			productError(c, ctx, productt_id, err)
+			return
+		}
+		inventory.RecordEdits(ctx, before, saved)
+		c.IndentedJSON(200, saved)
+	}
+}
+
+//admin function changing the sku, price, stock, images or reorder point of one variant, fields left out stay as they are
+//a variant without a reorder point uses the one of the product
+//PATCH request : http://localhost:8000/admin/products/xxxproduct_idxxx/variants/xxxvariant_idxxx
+/*
+{
+"sku"           : "TSHIRT-M-RED",
+"price"         : 25,
+"stock"         : 40,
+"images"        : ["tshirt-red-front.jpg","tshirt-red-back.jpg"],
+"reorder_point" : 5,
+"version"       : 4
+}
+*/
+
+func UpdateVariant() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productt_id, ok := productParam(c)
+		if !ok {
+			return
+		}
+		var request struct {
+			SKU           *string  `json:"sku"`
+			Price         *uint64  `json:"price"`
+			Stock         *int     `json:"stock"`
+			Reorder_Point *int     `json:"reorder_point"`
+			Images        []string `json:"images"`
+			Version       *int     `json:"version"`
+		}
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		version, ok := productVersion(c, request.Version)
+		if !ok {
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		product, err := findProduct(ctx, productt_id)
+		if err != nil {
+			productError(c, ctx, productt_id, err)
+			return
+		}
+		if product.Version != version {
+			productError(c, ctx, productt_id, ErrProductChanged)
+			return
+		}
+		before := product
+		before.Variants = append([]models.Variant(nil), product.Variants...)
+		variant, err := findVariant(product, c.Param("variant_id"))
+		if err != nil {
+			c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if request.SKU != nil {
+			variant.SKU = strings.TrimSpace(*request.SKU)
+		}
+		if request.Price != nil {
+			variant.Price = request.Price
+		}
+		if request.Stock != nil {
+			if len(variant.Stock_Levels) > 0 && (variant.Stock == nil || *request.Stock != *variant.Stock) {
+				inventoryError(c, inventory.ErrLocationRequired)
+				return
+			}
+			variant.Stock = request.Stock
+		}
+		if request.Images != nil {
+			variant.Images = request.Images
+		}
+		if request.Reorder_Point != nil {
+			variant.Reorder_Point = request.Reorder_Point
+		}
+		if err := Validate.Struct(product); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := checkSKUs(ctx, product); err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		saved, err := saveProduct(ctx, product, version)
+		if err != nil {
+			productError(c, ctx, productt_id, err)
+			return
+		}
+		inventory.RecordEdits(ctx, before, saved)
+		c.IndentedJSON(200, saved)
+	}
+}
@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"ecommerce/models"
+	"reflect"
+	"testing"
+)
+
+func skus(variants []models.Variant) []string {
+	out := make([]string, len(variants))
+	for i, variant := range variants {
+		out[i] = variant.SKU
+	}
+	return out
+}
+
+func TestGenerateVariants(t *testing.T) {
+	name := "Basic Tee"
+	product := models.Product{Product_Name: &name}
+	options := []models.ProductOption{{Name: "size", Values: []string{"S", "M"}}, {Name: "color", Values: []string{"Red", "Light Blue"}}}
+	variants := generateVariants(product, options)
+	want := []string{"BASIC-TEE-S-RED", "BASIC-TEE-S-LIGHT-BLUE", "BASIC-TEE-M-RED", "BASIC-TEE-M-LIGHT-BLUE"}
+	if got := skus(variants); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if !reflect.DeepEqual(variants[1].Options, map[string]string{"size": "S", "color": "Light Blue"}) {
+		t.Errorf("options of the second variant are %v", variants[1].Options)
+	}
+	seen := make(map[string]bool)
+	for _, variant := range variants {
+		if variant.Variant_ID.IsZero() || seen[variant.Variant_ID.Hex()] {
+			t.Errorf("variant %s has id %s", variant.SKU, variant.Variant_ID.Hex())
+		}
+		seen[variant.Variant_ID.Hex()] = true
+	}
+
+	sku := "tee 01"
+	product.SKU = &sku
+	if got := skus(generateVariants(product, options[:1])); !reflect.DeepEqual(got, []string{"TEE-01-S", "TEE-01-M"}) {
+		t.Errorf("with a product sku got %v", got)
+	}
+}
+
+func TestGenerateVariantsKeepsExisting(t *testing.T) {
+	name := "Basic Tee"
+	price, stock := uint64(25), 4
+	kept := models.Variant{Variant_ID: oid(1), SKU: "TEE-SMALL-RED", Options: map[string]string{"size": "S", "color": "Red"}, Price: &price, Stock: &stock}
+	gone := models.Variant{Variant_ID: oid(2), SKU: "TEE-SMALL-BLACK", Options: map[string]string{"size": "S", "color": "Black"}}
+	product := models.Product{Product_Name: &name, Variants: []models.Variant{kept, gone}}
+
+	variants := generateVariants(product, []models.ProductOption{{Name: "size", Values: []string{"S", "M"}}, {Name: "color", Values: []string{"Red"}}})
+	if len(variants) != 2 {
+		t.Fatalf("got %v", skus(variants))
+	}
+	if !reflect.DeepEqual(variants[0], kept) {
+		t.Errorf("the kept variant changed to %+v", variants[0])
+	}
+	if variants[1].SKU != "BASIC-TEE-M-RED" || variants[1].Variant_ID == gone.Variant_ID {
+		t.Errorf("the new variant is %+v", variants[1])
+	}
+
+	//another axis makes every combination new
+	variants = generateVariants(product, []models.ProductOption{{Name: "size", Values: []string{"S"}}, {Name: "color", Values: []string{"Red"}}, {Name: "fit", Values: []string{"Slim"}}})
+	if len(variants) != 1 || variants[0].Variant_ID == kept.Variant_ID || variants[0].SKU != "BASIC-TEE-S-RED-SLIM" {
+		t.Errorf("with a new axis got %+v", variants)
+	}
+}
+
+func TestGenerateVariantsUniqueSKUs(t *testing.T) {
+	name := "Mug"
+	taken := models.Variant{Variant_ID: oid(1), SKU: "MUG-BIG", Options: map[string]string{"size": "Small"}}
+	product := models.Product{Product_Name: &name, Variants: []models.Variant{taken}}
+	variants := generateVariants(product, []models.ProductOption{{Name: "size", Values: []string{"Small", "big", "Big!", "BIG?"}}})
+	want := []string{"MUG-BIG", "MUG-BIG-2", "MUG-BIG-3", "MUG-BIG-4"}
+	if got := skus(variants); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestCleanOptions(t *testing.T) {
+	cases := []struct {
+		options []models.ProductOption
+		ok      bool
+	}{
+		{[]models.ProductOption{{Name: " size ", Values: []string{" S", "M "}}}, true},
+		{[]models.ProductOption{{Name: "size", Values: []string{"S"}}, {Name: "Size", Values: []string{"M"}}}, false},
+		{[]models.ProductOption{{Name: "size", Values: []string{"S", " s"}}}, false},
+		{nil, true},
+	}
+	for _, c := range cases {
+		if err := cleanOptions(c.options); (err == nil) != c.ok {
+			t.Errorf("%+v: got %v", c.options, err)
+		}
+	}
+	options := []models.ProductOption{{Name: " size ", Values: []string{" S", "M "}}}
+	cleanOptions(options)
+	if options[0].Name != "size" || !reflect.DeepEqual(options[0].Values, []string{"S", "M"}) {
+		t.Errorf("got %+v", options)
+	}
+}
+
+func TestFindVariant(t *testing.T) {
+	product := models.Product{Variants: []models.Variant{{Variant_ID: oid(1), SKU: "TEE-S"}, {Variant_ID: oid(2), SKU: "TEE-M"}}}
+	for _, variant := range []string{oid(2).Hex(), "TEE-M", "tee-m"} {
+		if found, err := findVariant(product, variant); err != nil || found.Variant_ID != oid(2) {
+			t.Errorf("%s found %v %v", variant, found, err)
+		}
+	}
+	if _, err := findVariant(product, "TEE-L"); err != ErrVariantNotFound {
+		t.Errorf("an unknown sku got %v", err)
+	}
+}
+
+func TestLineName(t *testing.T) {
+	name := "T-Shirt"
+	if got := lineName(models.ProductUser{Product_Name: &name}); got != "T-Shirt" {
+		t.Errorf("got %q", got)
+	}
+	if got := lineName(models.ProductUser{Product_Name: &name, Options: map[string]string{"size": "M", "color": "Red"}}); got != "T-Shirt (Red, M)" {
+		t.Errorf("got %q", got)
+	}
+}
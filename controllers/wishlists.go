@@ -0,0 +1,856 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"ecommerce/database"
+	"ecommerce/events"
+	"ecommerce/models"
+	"ecommerce/notify"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var WishlistCollection *mongo.Collection = database.UserData(database.Client, "Wishlists")
+
+const (
+	defaultWishlistName = "Wishlist"
+	maxWishlists        = 20
+	maxWishlistItems    = 100
+)
+
+var ErrWishlistNotFound = errors.New("wishlist not found")
+var ErrWishlistName = errors.New("you already have a wishlist with this name")
+var ErrWishlistDefault = errors.New("the default wishlist can not be deleted, make another one the default first")
+var ErrTooManyWishlists = fmt.Errorf("you can have at most %d wishlists", maxWishlists)
+var ErrWishlistFull = fmt.Errorf("a wishlist can hold at most %d products", maxWishlistItems)
+var ErrNotInWishlist = errors.New("the product is not in this wishlist")
+
+// WishlistPricesEvery is how often wishlists are checked for lower prices, WISHLIST_PRICE_INTERVAL sets it
+var WishlistPricesEvery = wishlistPricesEvery()
+
+func wishlistPricesEvery() time.Duration {
+	if every, err := time.ParseDuration(os.Getenv("WISHLIST_PRICE_INTERVAL")); err == nil && every > 0 {
+		return every
+	}
+	return time.Hour
+}
+
+var wishlistIndexOnce sync.Once
+
+// the indexes are made the first time a wishlist is saved
+func ensureWishlistIndex(ctx context.Context) {
+	wishlistIndexOnce.Do(func() {
+		indexes := []mongo.IndexModel{
+			{Keys: bson.D{{Key: "user_id", Value: 1}}},
+			//one default per customer even when two requests create it at once
+			{Keys: bson.D{{Key: "user_id", Value: 1}}, Options: options.Index().SetName("one_default").SetUnique(true).SetPartialFilterExpression(bson.M{"default": true})},
+			{Keys: bson.D{{Key: "share_token", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+		}
+		if _, err := WishlistCollection.Indexes().CreateMany(ctx, indexes); err != nil {
+			log.Println(err)
+		}
+	})
+}
+
+func shareToken() (string, error) {
+	raw := make([]byte, 18)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// defaultWishlist finds the default wishlist of the user, making it the first time
+func defaultWishlist(ctx context.Context, usert_id primitive.ObjectID) (models.Wishlist, error) {
+	var wishlist models.Wishlist
+	now := time.Now()
+	insert := bson.M{"_id": primitive.NewObjectID(), "name": defaultWishlistName, "items": bson.A{}, "created_at": now, "updated_at": now}
+	upsert := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err := WishlistCollection.FindOneAndUpdate(ctx, bson.M{"user_id": usert_id, "default": true}, bson.M{"$setOnInsert": insert}, upsert).Decode(&wishlist)
+	if mongo.IsDuplicateKeyError(err) {
+		//made by a request running at the same time
+		err = WishlistCollection.FindOne(ctx, bson.M{"user_id": usert_id, "default": true}).Decode(&wishlist)
+	}
+	return wishlist, err
+}
+
+// findWishlist finds a wishlist of the user by id, "default" is their default wishlist
+func findWishlist(ctx context.Context, usert_id primitive.ObjectID, id string) (models.Wishlist, error) {
+	if id == "default" {
+		return defaultWishlist(ctx, usert_id)
+	}
+	var wishlist models.Wishlist
+	wishlistt_id, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return wishlist, ErrWishlistNotFound
+	}
+	err = WishlistCollection.FindOne(ctx, bson.M{"_id": wishlistt_id, "user_id": usert_id}).Decode(&wishlist)
+	if err == mongo.ErrNoDocuments {
+		return wishlist, ErrWishlistNotFound
+	}
+	return wishlist, err
+}
+
+// nameTaken reports whether the user has another wishlist with the name, names are compared without case
+func nameTaken(ctx context.Context, usert_id primitive.ObjectID, name string, skip primitive.ObjectID) (bool, error) {
+	filter := bson.M{"user_id": usert_id, "_id": bson.M{"$ne": skip}}
+	cursor, err := WishlistCollection.Find(ctx, filter, options.Find().SetProjection(bson.M{"name": 1}))
+	if err != nil {
+		return false, err
+	}
+	var wishlists []models.Wishlist
+	if err := cursor.All(ctx, &wishlists); err != nil {
+		return false, err
+	}
+	for _, wishlist := range wishlists {
+		if strings.EqualFold(wishlist.Name, name) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// freshen fills in what the products of the wishlists look like now, products
+// that were archived or lost their variant are no longer available
+func freshen(ctx context.Context, wishlists []models.Wishlist) error {
+	ids := make([]primitive.ObjectID, 0)
+	for _, wishlist := range wishlists {
+		for _, item := range wishlist.Items {
+			ids = append(ids, item.Product_ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	cursor, err := ProductCollection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}, "archived": bson.M{"$ne": true}})
+	if err != nil {
+		return err
+	}
+	var products []models.Product
+	if err := cursor.All(ctx, &products); err != nil {
+		return err
+	}
+	byID := make(map[primitive.ObjectID]models.Product, len(products))
+	for _, product := range products {
+		byID[product.Product_ID] = product
+	}
+	for w := range wishlists {
+		for i := range wishlists[w].Items {
+			item := &wishlists[w].Items[i]
+			product, ok := byID[item.Product_ID]
+			if !ok {
+				continue
+			}
+			variant := ""
+			if item.Variant_ID != nil {
+				variant = item.Variant_ID.Hex()
+			}
+			line, err := productLine(product, variant)
+			if err != nil {
+				continue
+			}
+			item.Product_Name = line.Product_Name
+			item.Image = line.Image
+			item.Price = line.Price
+			item.Available = true
+		}
+	}
+	return nil
+}
+
+func wishlistItem(line models.ProductUser) models.WishlistItem {
+	return models.WishlistItem{
+		Product_ID:    line.Product_ID,
+		Variant_ID:    line.Variant_ID,
+		SKU:           line.SKU,
+		Options:       line.Options,
+		Product_Name:  line.Product_Name,
+		Image:         line.Image,
+		Price:         line.Price,
+		Added_Price:   line.Price,
+		Watched_Price: line.Price,
+		Available:     true,
+		Added_At:      time.Now(),
+	}
+}
+
+// itemFilter matches the product in a wishlist, the variant too when the item has one
+func itemFilter(product_id primitive.ObjectID, variant_id *primitive.ObjectID) bson.M {
+	return bson.M{"product_id": product_id, "variant_id": variant_id}
+}
+
+// addToWishlist puts the line in the wishlist unless it is already there
+func addToWishlist(ctx context.Context, wishlist models.Wishlist, line models.ProductUser) error {
+	item := wishlistItem(line)
+	filter := bson.M{
+		"_id":   wishlist.Wishlist_ID,
+		"items": bson.M{"$not": bson.M{"$elemMatch": itemFilter(item.Product_ID, item.Variant_ID)}},
+		fmt.Sprintf("items.%d", maxWishlistItems-1): bson.M{"$exists": false},
+	}
+	update := bson.M{"$push": bson.M{"items": item}, "$set": bson.M{"updated_at": time.Now()}}
+	result, err := WishlistCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		for _, had := range wishlist.Items {
+			if had.Product_ID == item.Product_ID && sameVariantID(had.Variant_ID, item.Variant_ID) {
+				return nil
+			}
+		}
+		return ErrWishlistFull
+	}
+	return nil
+}
+
+func sameVariantID(a *primitive.ObjectID, b *primitive.ObjectID) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+// removeFromWishlist takes the product out of the wishlist, reporting whether it was there
+func removeFromWishlist(ctx context.Context, wishlist_id primitive.ObjectID, product_id primitive.ObjectID, variant_id *primitive.ObjectID) (bool, error) {
+	filter := bson.M{"_id": wishlist_id, "items": bson.M{"$elemMatch": itemFilter(product_id, variant_id)}}
+	update := bson.M{"$pull": bson.M{"items": itemFilter(product_id, variant_id)}, "$set": bson.M{"updated_at": time.Now()}}
+	result, err := WishlistCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+func wishlistError(c *gin.Context, err error) {
+	switch err {
+	case ErrWishlistNotFound, ErrNotInCart, ErrNotInWishlist:
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case ErrWishlistName:
+		c.IndentedJSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case ErrWishlistDefault, ErrTooManyWishlists, ErrWishlistFull:
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		cartError(c, err)
+	}
+}
+
+// wishlistParams reads the user, the wishlist and the product of a request on a
+// wishlist item, ?id=xxxproduct_idxxx with &variant= or &sku= for a variant
+func wishlistParams(c *gin.Context) (primitive.ObjectID, primitive.ObjectID, string, bool) {
+	usert_id, err := primitive.ObjectIDFromHex(c.GetString("uid"))
+	if err != nil {
+		c.IndentedJSON(http.StatusUnauthorized, "Invalid user")
+		return usert_id, usert_id, "", false
+	}
+	productt_id, err := primitive.ObjectIDFromHex(c.Query("id"))
+	if err != nil {
+		c.Header("Content-Type", "application/json")
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invalid product id"})
+		c.Abort()
+		return usert_id, productt_id, "", false
+	}
+	variant := c.Query("variant")
+	if variant == "" {
+		variant = c.Query("sku")
+	}
+	return usert_id, productt_id, variant, true
+}
+
+// showWishlist answers with the wishlist as it looks now
+func showWishlist(c *gin.Context, ctx context.Context, usert_id primitive.ObjectID, id string) {
+	wishlist, err := findWishlist(ctx, usert_id, id)
+	if err != nil {
+		wishlistError(c, err)
+		return
+	}
+	wishlists := []models.Wishlist{wishlist}
+	if err := freshen(ctx, wishlists); err != nil {
+		wishlistError(c, err)
+		return
+	}
+	c.IndentedJSON(200, wishlists[0])
+}
+
+//function listing the wishlists of the user, the default one first
+//GET request : http://localhost:8000/wishlists
+
+func ListWishlists() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		usert_id, err := primitive.ObjectIDFromHex(c.GetString("uid"))
+		if err != nil {
+			c.IndentedJSON(http.StatusUnauthorized, "Invalid user")
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		ensureWishlistIndex(ctx)
+		if _, err := defaultWishlist(ctx, usert_id); err != nil {
+			wishlistError(c, err)
+			return
+		}
+		find := options.Find().SetSort(bson.D{{Key: "default", Value: -1}, {Key: "created_at", Value: 1}})
+		cursor, err := WishlistCollection.Find(ctx, bson.M{"user_id": usert_id}, find)
+		if err != nil {
+			wishlistError(c, err)
+			return
+		}
+		wishlists := make([]models.Wishlist, 0)
+		if err := cursor.All(ctx, &wishlists); err != nil {
+			wishlistError(c, err)
+			return
+		}
+		if err := freshen(ctx, wishlists); err != nil {
+			wishlistError(c, err)
+			return
+		}
+		c.IndentedJSON(200, wishlists)
+	}
+}
+
+//function showing one wishlist, "default" works in place of the id
+//GET request : http://localhost:8000/wishlists/xxxwishlist_idxxx
+
+func GetWishlist() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		usert_id, err := primitive.ObjectIDFromHex(c.GetString("uid"))
+		if err != nil {
+			c.IndentedJSON(http.StatusUnauthorized, "Invalid user")
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		showWishlist(c, ctx, usert_id, c.Param("id"))
+	}
+}
+
+//function adding a wishlist, {"default":true} makes it the default one
+//POST request : http://localhost:8000/wishlists
+/*
+{
+"name"    : "Birthday",
+"default" : false
+}
+*/
+
+type wishlistRequest struct {
+	Name    *string `json:"name"`
+	Default *bool   `json:"default"`
+}
+
+// makeDefault moves the default flag to the wishlist
+func makeDefault(ctx context.Context, usert_id primitive.ObjectID, wishlist_id primitive.ObjectID) error {
+	if _, err := WishlistCollection.UpdateMany(ctx, bson.M{"user_id": usert_id, "_id": bson.M{"$ne": wishlist_id}}, bson.M{"$set": bson.M{"default": false}}); err != nil {
+		return err
+	}
+	_, err := WishlistCollection.UpdateOne(ctx, bson.M{"_id": wishlist_id, "user_id": usert_id}, bson.M{"$set": bson.M{"default": true}})
+	return err
+}
+
+func AddWishlist() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		usert_id, err := primitive.ObjectIDFromHex(c.GetString("uid"))
+		if err != nil {
+			c.IndentedJSON(http.StatusUnauthorized, "Invalid user")
+			return
+		}
+		var request wishlistRequest
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		now := time.Now()
+		wishlist := models.Wishlist{Wishlist_ID: primitive.NewObjectID(), User_ID: usert_id, Items: make([]models.WishlistItem, 0), Created_At: now, Updated_At: now}
+		if request.Name != nil {
+			wishlist.Name = strings.TrimSpace(*request.Name)
+		}
+		if err := Validate.Struct(wishlist); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		ensureWishlistIndex(ctx)
+		if _, err := defaultWishlist(ctx, usert_id); err != nil {
+			wishlistError(c, err)
+			return
+		}
+		count, err := WishlistCollection.CountDocuments(ctx, bson.M{"user_id": usert_id})
+		if err != nil {
+			wishlistError(c, err)
+			return
+		}
+		if count >= maxWishlists {
+			wishlistError(c, ErrTooManyWishlists)
+			return
+		}
+		if taken, err := nameTaken(ctx, usert_id, wishlist.Name, wishlist.Wishlist_ID); err != nil || taken {
+			if err == nil {
+				err = ErrWishlistName
+			}
+			wishlistError(c, err)
+			return
+		}
+		if _, err := WishlistCollection.InsertOne(ctx, wishlist); err != nil {
+			wishlistError(c, err)
+			return
+		}
+		if request.Default != nil && *request.Default {
+			if err := makeDefault(ctx, usert_id, wishlist.Wishlist_ID); err != nil {
+				wishlistError(c, err)
+				return
+			}
+		}
+		showWishlist(c, ctx, usert_id, wishlist.Wishlist_ID.Hex())
+	}
+}
+
+//function renaming a wishlist or making it the default one
+//PUT request : http://localhost:8000/wishlists/xxxwishlist_idxxx with {"name":"Later","default":true}
+
+func UpdateWishlist() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		usert_id, err := primitive.ObjectIDFromHex(c.GetString("uid"))
+		if err != nil {
+			c.IndentedJSON(http.StatusUnauthorized, "Invalid user")
+			return
+		}
+		var request wishlistRequest
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		wishlist, err := findWishlist(ctx, usert_id, c.Param("id"))
+		if err != nil {
+			wishlistError(c, err)
+			return
+		}
+		if request.Name != nil {
+			wishlist.Name = strings.TrimSpace(*request.Name)
+			if err := Validate.Struct(wishlist); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if taken, err := nameTaken(ctx, usert_id, wishlist.Name, wishlist.Wishlist_ID); err != nil || taken {
+				if err == nil {
+					err = ErrWishlistName
+				}
+				wishlistError(c, err)
+				return
+			}
+			update := bson.M{"$set": bson.M{"name": wishlist.Name, "updated_at": time.Now()}}
+			if _, err := WishlistCollection.UpdateOne(ctx, bson.M{"_id": wishlist.Wishlist_ID}, update); err != nil {
+				wishlistError(c, err)
+				return
+			}
+		}
+		if request.Default != nil && *request.Default && !wishlist.Default {
+			if err := makeDefault(ctx, usert_id, wishlist.Wishlist_ID); err != nil {
+				wishlistError(c, err)
+				return
+			}
+		}
+		showWishlist(c, ctx, usert_id, wishlist.Wishlist_ID.Hex())
+	}
+}
+
+//function deleting a wishlist with everything in it, the default one stays
+//DELETE request : http://localhost:8000/wishlists/xxxwishlist_idxxx
+
+func DeleteWishlist() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		usert_id, err := primitive.ObjectIDFromHex(c.GetString("uid"))
+		if err != nil {
+			c.IndentedJSON(http.StatusUnauthorized, "Invalid user")
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		wishlist, err := findWishlist(ctx, usert_id, c.Param("id"))
+		if err != nil {
+			wishlistError(c, err)
+			return
+		}
+		if wishlist.Default {
+			wishlistError(c, ErrWishlistDefault)
+			return
+		}
+		result, err := WishlistCollection.DeleteOne(ctx, bson.M{"_id": wishlist.Wishlist_ID, "user_id": usert_id, "default": false})
+		if err == nil && result.DeletedCount == 0 {
+			err = ErrWishlistDefault
+		}
+		if err != nil {
+			wishlistError(c, err)
+			return
+		}
+		c.IndentedJSON(200, "Successfully deleted the wishlist")
+	}
+}
+
+//function adding a product to a wishlist, adding it twice changes nothing
+//POST request : http://localhost:8000/wishlists/xxxwishlist_idxxx/items?id=xxxproduct_idxxx&variant=xxxvariant_idxxx
+//"default" works in place of the wishlist id
+
+func AddToWishlist() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		usert_id, productt_id, variant, ok := wishlistParams(c)
+		if !ok {
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		wishlist, err := findWishlist(ctx, usert_id, c.Param("id"))
+		if err != nil {
+			wishlistError(c, err)
+			return
+		}
+		line, err := cartLine(ctx, productt_id, variant)
+		if err != nil {
+			wishlistError(c, err)
+			return
+		}
+		if err := addToWishlist(ctx, wishlist, line); err != nil {
+			wishlistError(c, err)
+			return
+		}
+		showWishlist(c, ctx, usert_id, wishlist.Wishlist_ID.Hex())
+	}
+}
+
+//function taking a product out of a wishlist, &variant= for one variant of it
+//DELETE request : http://localhost:8000/wishlists/xxxwishlist_idxxx/items?id=xxxproduct_idxxx&variant=xxxvariant_idxxx
+
+func RemoveFromWishlist() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		usert_id, productt_id, variant, ok := wishlistParams(c)
+		if !ok {
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		wishlist, err := findWishlist(ctx, usert_id, c.Param("id"))
+		if err != nil {
+			wishlistError(c, err)
+			return
+		}
+		item, err := wishlistEntry(wishlist, productt_id, variant)
+		if err != nil {
+			wishlistError(c, err)
+			return
+		}
+		if _, err := removeFromWishlist(ctx, wishlist.Wishlist_ID, item.Product_ID, item.Variant_ID); err != nil {
+			wishlistError(c, err)
+			return
+		}
+		showWishlist(c, ctx, usert_id, wishlist.Wishlist_ID.Hex())
+	}
+}
+
+// wishlistEntry finds the product in the wishlist, the variant can be left
+// out when the wishlist holds only one variant of the product
+func wishlistEntry(wishlist models.Wishlist, productt_id primitive.ObjectID, variant string) (models.WishlistItem, error) {
+	found := make([]models.WishlistItem, 0)
+	for _, item := range wishlist.Items {
+		if item.Product_ID != productt_id {
+			continue
+		}
+		if variant == "" || (item.Variant_ID != nil && item.Variant_ID.Hex() == variant) || (item.SKU != nil && strings.EqualFold(*item.SKU, variant)) {
+			found = append(found, item)
+		}
+	}
+	if len(found) == 0 {
+		return models.WishlistItem{}, ErrNotInWishlist
+	}
+	if len(found) > 1 {
+		return models.WishlistItem{}, ErrVariantRequired
+	}
+	return found[0], nil
+}
+
+//function moving a product from a wishlist into the cart
+//POST request : http://localhost:8000/wishlists/xxxwishlist_idxxx/tocart?id=xxxproduct_idxxx&variant=xxxvariant_idxxx
+
+func MoveWishlistToCart() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		usert_id, productt_id, variant, ok := wishlistParams(c)
+		if !ok {
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		wishlist, err := findWishlist(ctx, usert_id, c.Param("id"))
+		if err != nil {
+			wishlistError(c, err)
+			return
+		}
+		item, err := wishlistEntry(wishlist, productt_id, variant)
+		if err != nil {
+			wishlistError(c, err)
+			return
+		}
+		if item.Variant_ID != nil {
+			variant = item.Variant_ID.Hex()
+		}
+		cart := userCart(usert_id)
+		if err := cart.change(ctx, item.Product_ID, variant, 1, false); err != nil {
+			wishlistError(c, err)
+			return
+		}
+		if _, err := removeFromWishlist(ctx, wishlist.Wishlist_ID, item.Product_ID, item.Variant_ID); err != nil {
+			wishlistError(c, err)
+			return
+		}
+		view, err := cart.view(ctx)
+		if err != nil {
+			wishlistError(c, err)
+			return
+		}
+		c.IndentedJSON(200, gin.H{"message": "Successfully moved to the cart", "cart": view})
+	}
+}
+
+//function saving a product in the cart for later, it leaves the cart and goes into the wishlist
+//POST request : http://localhost:8000/wishlists/xxxwishlist_idxxx/fromcart?id=xxxproduct_idxxx&variant=xxxvariant_idxxx
+
+func MoveCartToWishlist() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		usert_id, productt_id, variant, ok := wishlistParams(c)
+		if !ok {
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		wishlist, err := findWishlist(ctx, usert_id, c.Param("id"))
+		if err != nil {
+			wishlistError(c, err)
+			return
+		}
+		lines, err := userCart(usert_id).lines(ctx)
+		if err != nil {
+			wishlistError(c, err)
+			return
+		}
+		saved, err := cartEntry(lines, productt_id, variant)
+		if err != nil {
+			wishlistError(c, err)
+			return
+		}
+		if err := addToWishlist(ctx, wishlist, saved); err != nil {
+			wishlistError(c, err)
+			return
+		}
+		removed := cartFilter(saved.Product_ID, saved.Variant_ID)
+		if _, err := UserCollection.UpdateOne(ctx, bson.M{"_id": usert_id}, bson.M{"$pull": bson.M{"usercart": removed}}); err != nil {
+			wishlistError(c, err)
+			return
+		}
+		showWishlist(c, ctx, usert_id, wishlist.Wishlist_ID.Hex())
+	}
+}
+
+//function sharing a wishlist, anyone with the link can look at it until it is unshared
+//POST request : http://localhost:8000/wishlists/xxxwishlist_idxxx/share
+//DELETE on the same url stops sharing, sharing again makes a new link
+/*
+{
+"share_token" : "pQ3n0r...",
+"url"         : "/users/wishlists/pQ3n0r..."
+}
+*/
+
+func ShareWishlist() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		usert_id, err := primitive.ObjectIDFromHex(c.GetString("uid"))
+		if err != nil {
+			c.IndentedJSON(http.StatusUnauthorized, "Invalid user")
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		wishlist, err := findWishlist(ctx, usert_id, c.Param("id"))
+		if err != nil {
+			wishlistError(c, err)
+			return
+		}
+		if wishlist.Share_Token == nil {
+			token, err := shareToken()
+			if err != nil {
+				wishlistError(c, err)
+				return
+			}
+			ensureWishlistIndex(ctx)
+			update := bson.M{"$set": bson.M{"share_token": token, "updated_at": time.Now()}}
+			if _, err := WishlistCollection.UpdateOne(ctx, bson.M{"_id": wishlist.Wishlist_ID, "share_token": bson.M{"$exists": false}}, update); err != nil {
+				wishlistError(c, err)
+				return
+			}
+			if wishlist, err = findWishlist(ctx, usert_id, wishlist.Wishlist_ID.Hex()); err != nil {
+				wishlistError(c, err)
+				return
+			}
+		}
+		c.IndentedJSON(200, gin.H{"share_token": *wishlist.Share_Token, "url": "/users/wishlists/" + *wishlist.Share_Token})
+	}
+}
+
+func UnshareWishlist() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		usert_id, err := primitive.ObjectIDFromHex(c.GetString("uid"))
+		if err != nil {
+			c.IndentedJSON(http.StatusUnauthorized, "Invalid user")
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		wishlist, err := findWishlist(ctx, usert_id, c.Param("id"))
+		if err != nil {
+			wishlistError(c, err)
+			return
+		}
+		update := bson.M{"$unset": bson.M{"share_token": ""}, "$set": bson.M{"updated_at": time.Now()}}
+		if _, err := WishlistCollection.UpdateOne(ctx, bson.M{"_id": wishlist.Wishlist_ID}, update); err != nil {
+			wishlistError(c, err)
+			return
+		}
+		c.IndentedJSON(200, "The wishlist is no longer shared")
+	}
+}
+
+//public function showing a shared wishlist, only its name, the products and the first name of its owner
+//GET request : http://localhost:8000/users/wishlists/xxxshare_tokenxxx
+
+func SharedWishlist() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.Param("token")
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		var wishlist models.Wishlist
+		err := WishlistCollection.FindOne(ctx, bson.M{"share_token": token}).Decode(&wishlist)
+		if err == mongo.ErrNoDocuments || token == "" {
+			wishlistError(c, ErrWishlistNotFound)
+			return
+		}
+		if err != nil {
+			wishlistError(c, err)
+			return
+		}
+		wishlists := []models.Wishlist{wishlist}
+		if err := freshen(ctx, wishlists); err != nil {
+			wishlistError(c, err)
+			return
+		}
+		var owner models.User
+		if err := UserCollection.FindOne(ctx, bson.M{"_id": wishlist.User_ID}, options.FindOne().SetProjection(bson.M{"first_name": 1})).Decode(&owner); err != nil {
+			log.Println(err)
+		}
+		c.IndentedJSON(200, gin.H{"name": wishlist.Name, "owner": deref(owner.First_Name), "items": wishlists[0].Items})
+	}
+}
+
+/**************************************************PRICE DROPS********************************************************************************************************/
+
+// checkWishlistPrices tells customers about every product in their wishlists
+// that got cheaper than the price they last heard of. Price rises only move the
+// watched price up so the next drop below it is reported again.
+func checkWishlistPrices(ctx context.Context, notifier notify.Notifier) error {
+	cursor, err := WishlistCollection.Find(ctx, bson.M{"items.0": bson.M{"$exists": true}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var wishlist models.Wishlist
+		if err := cursor.Decode(&wishlist); err != nil {
+			log.Println(err)
+			continue
+		}
+		watched := make([]int, len(wishlist.Items))
+		for i, item := range wishlist.Items {
+			watched[i] = item.Watched_Price
+		}
+		wishlists := []models.Wishlist{wishlist}
+		if err := freshen(ctx, wishlists); err != nil {
+			return err
+		}
+		var owner *models.User
+		for i, item := range wishlists[0].Items {
+			if !item.Available || item.Price == watched[i] {
+				continue
+			}
+			//every instance runs the check, only the one that moves the watched price on tells the customer
+			held := itemFilter(item.Product_ID, item.Variant_ID)
+			held["watched_price"] = watched[i]
+			filter := bson.M{"_id": wishlist.Wishlist_ID, "items": bson.M{"$elemMatch": held}}
+			result, err := WishlistCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"items.$.watched_price": item.Price}})
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			if result.ModifiedCount != 1 || item.Price > watched[i] {
+				continue
+			}
+			if owner == nil {
+				projection := options.FindOne().SetProjection(bson.M{"email": 1, "first_name": 1})
+				var found models.User
+				if err := UserCollection.FindOne(ctx, bson.M{"_id": wishlist.User_ID}, projection).Decode(&found); err != nil {
+					log.Println(err)
+					continue
+				}
+				owner = &found
+			}
+			if deref(owner.Email) == "" {
+				continue
+			}
+			if err := notifier.Notify(ctx, priceDropMessage(wishlist, item, watched[i], deref(owner.Email))); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+	return cursor.Err()
+}
+
+func priceDropMessage(wishlist models.Wishlist, item models.WishlistItem, was int, email string) notify.Message {
+	name := lineName(models.ProductUser{Product_Name: item.Product_Name, Options: item.Options})
+	return notify.Message{
+		Kind:    events.PriceDropped,
+		To:      email,
+		Subject: "Price drop: " + name,
+		Body:    fmt.Sprintf("%s from your wishlist %s is now %d, it was %d", name, wishlist.Name, item.Price, was),
+		Data: gin.H{
+			"user_id":     wishlist.User_ID,
+			"wishlist_id": wishlist.Wishlist_ID,
+			"product_id":  item.Product_ID,
+			"variant_id":  item.Variant_ID,
+			"price":       item.Price,
+			"was":         was,
+		},
+	}
+}
+
+// WatchWishlistPrices runs in the background and looks for price drops every interval
+func WatchWishlistPrices(interval time.Duration, notifier notify.Notifier) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		if err := checkWishlistPrices(ctx, notifier); err != nil {
+			log.Println(err)
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"ecommerce/events"
+	"ecommerce/models"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestProductLine(t *testing.T) {
+	name, sku, image, variantimage := "Basic Tee", "TEE", "tee.jpg", "tee-m.jpg"
+	price, variantprice := uint64(20), uint64(25)
+	single := models.Product{Product_ID: oid(1), Product_Name: &name, SKU: &sku, Price: &price, Image: &image}
+	line, err := productLine(single, "")
+	if err != nil || line.Product_ID != oid(1) || line.Price != 20 || *line.SKU != "TEE" || *line.Image != "tee.jpg" || line.Variant_ID != nil {
+		t.Errorf("a product without variants got %+v %v", line, err)
+	}
+
+	variants := single
+	variants.Variants = []models.Variant{
+		{Variant_ID: oid(2), SKU: "TEE-S", Options: map[string]string{"size": "S"}},
+		{Variant_ID: oid(3), SKU: "TEE-M", Options: map[string]string{"size": "M"}, Price: &variantprice, Images: []string{variantimage}},
+	}
+	if _, err := productLine(variants, ""); err != ErrVariantRequired {
+		t.Errorf("no variant got %v", err)
+	}
+	if _, err := productLine(variants, "TEE-L"); err != ErrVariantNotFound {
+		t.Errorf("an unknown variant got %v", err)
+	}
+	//a variant without its own price and images has the ones of the product
+	line, err = productLine(variants, "tee-s")
+	if err != nil || *line.Variant_ID != oid(2) || *line.SKU != "TEE-S" || line.Price != 20 || *line.Image != "tee.jpg" || line.Options["size"] != "S" {
+		t.Errorf("the small variant got %+v %v", line, err)
+	}
+	line, err = productLine(variants, oid(3).Hex())
+	if err != nil || *line.Variant_ID != oid(3) || line.Price != 25 || *line.Image != "tee-m.jpg" {
+		t.Errorf("the medium variant got %+v %v", line, err)
+	}
+}
+
+func TestWishlistEntry(t *testing.T) {
+	small, medium := oid(2), oid(3)
+	sku := "TEE-M"
+	wishlist := models.Wishlist{Items: []models.WishlistItem{
+		{Product_ID: oid(1), Variant_ID: &small},
+		{Product_ID: oid(1), Variant_ID: &medium, SKU: &sku},
+		{Product_ID: oid(4)},
+	}}
+	cases := []struct {
+		product primitive.ObjectID
+		variant string
+		want    *primitive.ObjectID
+		err     error
+	}{
+		{oid(1), small.Hex(), &small, nil},
+		{oid(1), "tee-m", &medium, nil},
+		{oid(4), "", nil, nil},
+		//with two variants in the list one has to be picked
+		{oid(1), "", nil, ErrVariantRequired},
+		{oid(1), "TEE-L", nil, ErrNotInWishlist},
+		{oid(5), "", nil, ErrNotInWishlist},
+	}
+	for _, c := range cases {
+		item, err := wishlistEntry(wishlist, c.product, c.variant)
+		if err != c.err {
+			t.Errorf("%s %q: got %v, want %v", c.product.Hex(), c.variant, err, c.err)
+			continue
+		}
+		if err == nil && (item.Product_ID != c.product || !sameVariantID(item.Variant_ID, c.want)) {
+			t.Errorf("%s %q: found %+v", c.product.Hex(), c.variant, item)
+		}
+	}
+}
+
+func TestWishlistItem(t *testing.T) {
+	name := "Basic Tee"
+	item := wishlistItem(models.ProductUser{Product_ID: oid(1), Product_Name: &name, Price: 20})
+	//the customer hears about drops below what the product cost when it was added
+	if item.Price != 20 || item.Added_Price != 20 || item.Watched_Price != 20 || !item.Available || item.Added_At.IsZero() {
+		t.Errorf("got %+v", item)
+	}
+}
+
+func TestPriceDropMessage(t *testing.T) {
+	name := "T-Shirt"
+	wishlist := models.Wishlist{Wishlist_ID: oid(7), User_ID: oid(8), Name: "Summer"}
+	item := models.WishlistItem{Product_ID: oid(1), Product_Name: &name, Options: map[string]string{"size": "M"}, Price: 18}
+	message := priceDropMessage(wishlist, item, 25, "someone@example.com")
+	if message.Kind != events.PriceDropped || message.To != "someone@example.com" || message.Subject != "Price drop: T-Shirt (M)" {
+		t.Errorf("got %+v", message)
+	}
+	if message.Body != "T-Shirt (M) from your wishlist Summer is now 18, it was 25" {
+		t.Errorf("got %q", message.Body)
+	}
+}
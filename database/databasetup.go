@@ -7,6 +7,7 @@ import (
 	"log"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -38,3 +39,21 @@ func ProductData(client *mongo.Client, CollectionName string) *mongo.Collection
 	var productcollection *mongo.Collection = client.Database("Ecommerce").Collection(CollectionName)
 	return productcollection
 }
+
+// NextSequence atomically increments the named counter in the Counters
+// collection and returns the new value, the first call for a name returns 1.
+func NextSequence(ctx context.Context, client *mongo.Client, name string) (int64, error) {
+	counters := client.Database("Ecommerce").Collection("Counters")
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+	filter := bson.M{"_id": name}
+	update := bson.M{"$inc": bson.M{"seq": 1}}
+	err := counters.FindOneAndUpdate(ctx, filter, update, opts).Decode(&counter)
+	//two upserts racing on a brand new counter, the loser just retries against the existing document
+	if mongo.IsDuplicateKeyError(err) {
+		err = counters.FindOneAndUpdate(ctx, filter, update, opts).Decode(&counter)
+	}
+	return counter.Seq, err
+}
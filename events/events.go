@@ -0,0 +1,42 @@
+package events
+
+import (
+	"context"
+	"ecommerce/database"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+//events are appended to the Events collection and never updated
+//downstream systems (accounting etc) read them in insertion order
+
+const (
+	OrderCancelled = "order.cancelled"
+	RefundIssued   = "refund.issued"
+	CreditIssued   = "store_credit.issued"
+	StockLow       = "stock.low"
+	PriceDropped   = "wishlist.price_dropped"
+	AccountLink    = "guest.account_link"
+)
+
+type Event struct {
+	Event_ID   primitive.ObjectID `json:"event_id"   bson:"_id"`
+	Type       string             `json:"type"       bson:"type"`
+	Payload    interface{}        `json:"payload"    bson:"payload"`
+	Created_At time.Time          `json:"created_at" bson:"created_at"`
+}
+
+var EventCollection *mongo.Collection = database.UserData(database.Client, "Events")
+
+func Emit(ctx context.Context, eventtype string, payload interface{}) error {
+	event := Event{
+		Event_ID:   primitive.NewObjectID(),
+		Type:       eventtype,
+		Payload:    payload,
+		Created_At: time.Now(),
+	}
+	_, err := EventCollection.InsertOne(ctx, event)
+	return err
+}
@@ -0,0 +1,217 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+//uploaded images are checked and scaled down into the sizes the shop shows.
+//jpeg, png and gif are accepted, what the file really is counts and not what
+//the upload claims. png stays png so transparency survives, the rest becomes jpeg.
+
+var ErrTooLarge = errors.New("the image is too large")
+var ErrUnsupported = errors.New("only jpeg, png and gif images are accepted")
+var ErrTooManyPixels = errors.New("the image has too many pixels")
+var ErrBroken = errors.New("the image could not be read")
+
+// MaxBytes is the largest upload accepted, MAX_IMAGE_BYTES sets it (default 10MB)
+var MaxBytes = maxBytes()
+
+// MaxPixels keeps a small file that unpacks into a huge image from eating the memory
+const MaxPixels = 50000000
+
+func maxBytes() int64 {
+	if size, err := strconv.ParseInt(os.Getenv("MAX_IMAGE_BYTES"), 10, 64); err == nil && size > 0 {
+		return size
+	}
+	return 10 << 20
+}
+
+// a size fits the image into a square of Box pixels, images are never scaled up
+type Size struct {
+	Name string
+	Box  int
+}
+
+// Sizes are made from largest to smallest, every one is scaled from the one before
+var Sizes = []Size{
+	{Name: "large", Box: 1200},
+	{Name: "medium", Box: 600},
+	{Name: "thumbnail", Box: 150},
+}
+
+const Original = "original"
+
+type Rendition struct {
+	Name         string
+	Content_Type string
+	Extension    string
+	Width        int
+	Height       int
+	Data         []byte
+}
+
+// ContentType is what the data really is, ErrUnsupported when it is no image we take
+func ContentType(data []byte) (string, error) {
+	switch kind := http.DetectContentType(data); kind {
+	case "image/jpeg", "image/png", "image/gif":
+		return kind, nil
+	}
+	return "", ErrUnsupported
+}
+
+func extension(content_type string) string {
+	switch content_type {
+	case "image/png":
+		return "png"
+	case "image/gif":
+		return "gif"
+	}
+	return "jpg"
+}
+
+// Process checks an upload and returns the original followed by one rendition per size
+func Process(data []byte) ([]Rendition, error) {
+	if int64(len(data)) > MaxBytes {
+		return nil, ErrTooLarge
+	}
+	content_type, err := ContentType(data)
+	if err != nil {
+		return nil, err
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrBroken
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, ErrBroken
+	}
+	if config.Width*config.Height > MaxPixels {
+		return nil, ErrTooManyPixels
+	}
+	var decoded image.Image
+	switch content_type {
+	case "image/jpeg":
+		decoded, err = jpeg.Decode(bytes.NewReader(data))
+	case "image/png":
+		decoded, err = png.Decode(bytes.NewReader(data))
+	case "image/gif":
+		decoded, err = gif.Decode(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, ErrBroken
+	}
+	bounds := decoded.Bounds()
+	renditions := []Rendition{{
+		Name:         Original,
+		Content_Type: content_type,
+		Extension:    extension(content_type),
+		Width:        bounds.Dx(),
+		Height:       bounds.Dy(),
+		Data:         data,
+	}}
+	//scaling is done on premultiplied pixels so transparent edges do not turn dark
+	current := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(current, current.Bounds(), decoded, bounds.Min, draw.Src)
+	output := "image/jpeg"
+	if content_type == "image/png" {
+		output = "image/png"
+	}
+	for _, size := range Sizes {
+		width, height := fit(current.Bounds().Dx(), current.Bounds().Dy(), size.Box)
+		if width != current.Bounds().Dx() || height != current.Bounds().Dy() {
+			current = scale(current, width, height)
+		}
+		encoded, err := encode(current, output)
+		if err != nil {
+			return nil, fmt.Errorf("imaging: encoding %s: %w", size.Name, err)
+		}
+		renditions = append(renditions, Rendition{
+			Name:         size.Name,
+			Content_Type: output,
+			Extension:    extension(output),
+			Width:        width,
+			Height:       height,
+			Data:         encoded,
+		})
+	}
+	return renditions, nil
+}
+
+// fit scales width and height down to fit the box keeping the aspect ratio
+func fit(width int, height int, box int) (int, int) {
+	if width <= box && height <= box {
+		return width, height
+	}
+	if width >= height {
+		scaled := (height*box + width/2) / width
+		if scaled < 1 {
+			scaled = 1
+		}
+		return box, scaled
+	}
+	scaled := (width*box + height/2) / height
+	if scaled < 1 {
+		scaled = 1
+	}
+	return scaled, box
+}
+
+// scale shrinks the image by averaging every source pixel a target pixel covers
+func scale(src *image.RGBA, width int, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	for y := 0; y < height; y++ {
+		y0, y1 := y*sh/height, (y+1)*sh/height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0, x1 := x*sw/width, (x+1)*sw/width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var r, g, b, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride+x0*4 : sy*src.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					r += uint32(row[i])
+					g += uint32(row[i+1])
+					b += uint32(row[i+2])
+					a += uint32(row[i+3])
+					n++
+				}
+			}
+			i := y*dst.Stride + x*4
+			dst.Pix[i] = uint8((r + n/2) / n)
+			dst.Pix[i+1] = uint8((g + n/2) / n)
+			dst.Pix[i+2] = uint8((b + n/2) / n)
+			dst.Pix[i+3] = uint8((a + n/2) / n)
+		}
+	}
+	return dst
+}
+
+func encode(img *image.RGBA, content_type string) ([]byte, error) {
+	var out bytes.Buffer
+	if content_type == "image/png" {
+		err := png.Encode(&out, img)
+		return out.Bytes(), err
+	}
+	//jpeg has no transparency, it goes onto white
+	flat := image.NewRGBA(img.Bounds())
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+	err := jpeg.Encode(&out, flat, &jpeg.Options{Quality: 85})
+	return out.Bytes(), err
+}
@@ -0,0 +1,46 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+)
+
+func TestFit(t *testing.T) {
+	cases := []struct {
+		width, height, box int
+		want_w, want_h     int
+	}{
+		{100, 50, 1200, 100, 50},
+		{1200, 1200, 1200, 1200, 1200},
+		{2400, 1200, 1200, 1200, 600},
+		{1200, 2400, 600, 300, 600},
+		{1000, 333, 300, 300, 100},
+		{5000, 1, 300, 300, 1},
+		{1, 5000, 300, 1, 300},
+	}
+	for _, c := range cases {
+		w, h := fit(c.width, c.height, c.box)
+		if w != c.want_w || h != c.want_h {
+			t.Errorf("fit(%d, %d, %d) = %d, %d, want %d, %d", c.width, c.height, c.box, w, h, c.want_w, c.want_h)
+		}
+	}
+}
+
+func TestContentType(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	kind, err := ContentType(buf.Bytes())
+	if err != nil || kind != "image/png" || extension(kind) != "png" {
+		t.Errorf("png upload came out as %q %v", kind, err)
+	}
+	if _, err := ContentType([]byte("<svg xmlns=\"http://www.w3.org/2000/svg\"></svg>")); err != ErrUnsupported {
+		t.Errorf("svg upload = %v, want ErrUnsupported", err)
+	}
+	if extension("image/jpeg") != "jpg" || extension("image/gif") != "gif" {
+		t.Error("wrong extension")
+	}
+}
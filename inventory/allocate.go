@@ -0,0 +1,215 @@
+package inventory
+
+import (
+	"context"
+	"ecommerce/models"
+	"errors"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Allocate decides which location every line is taken from. Lines of products
+// that are not kept per location are left as they are.
+//
+// nearest takes every line from the locations nearest to the address first and
+// moves on to the next location for what is left, so an order can be split.
+// single first looks for the nearest location that has everything and only
+// splits the order when there is none.
+func Allocate(ctx context.Context, lines []Line, to *models.Address, strategy string) ([]Line, error) {
+	ids := make([]primitive.ObjectID, 0, len(lines))
+	for _, line := range lines {
+		ids = append(ids, line.Product_ID)
+	}
+	var products []models.Product
+	cursor, err := ProductCollection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &products); err != nil {
+		return nil, err
+	}
+	byID := make(map[primitive.ObjectID]models.Product, len(products))
+	for _, product := range products {
+		byID[product.Product_ID] = product
+	}
+	locations, err := Locations(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	ranked := rank(locations, to)
+
+	holdings := make([]holding, len(lines))
+	for i, line := range lines {
+		product, ok := byID[line.Product_ID]
+		if !ok {
+			return nil, ErrProductNotFound
+		}
+		held, ok := holdingOf(product, line.Variant_ID)
+		if !ok {
+			return nil, ErrProductNotFound
+		}
+		holdings[i] = held
+	}
+	return plan(lines, holdings, ranked, strategy)
+}
+
+// plan spreads the lines over the ranked locations, holdings[i] is what the
+// product of lines[i] has in stock
+func plan(lines []Line, holdings []holding, ranked []Location, strategy string) ([]Line, error) {
+	if strategy == StrategySingle {
+		for _, location := range ranked {
+			fits := true
+			for i, line := range lines {
+				if len(holdings[i].levels) == 0 {
+					continue
+				}
+				if stock, _ := holdings[i].at(location.Location_ID); stock < line.Quantity {
+					fits = false
+					break
+				}
+			}
+			if fits {
+				planned := make([]Line, 0, len(lines))
+				for i, line := range lines {
+					if len(holdings[i].levels) > 0 {
+						location_id := location.Location_ID
+						line.Location_ID = &location_id
+					}
+					planned = append(planned, line)
+				}
+				return planned, nil
+			}
+		}
+	}
+
+	planned := make([]Line, 0, len(lines))
+	shortages := make([]Shortage, 0)
+	for i, line := range lines {
+		if len(holdings[i].levels) == 0 {
+			planned = append(planned, line)
+			continue
+		}
+		remaining, available := line.Quantity, 0
+		for _, location := range ranked {
+			stock, _ := holdings[i].at(location.Location_ID)
+			if stock <= 0 {
+				continue
+			}
+			available += stock
+			if remaining == 0 {
+				continue
+			}
+			take := stock
+			if take > remaining {
+				take = remaining
+			}
+			location_id := location.Location_ID
+			part := line
+			part.Location_ID = &location_id
+			part.Quantity = take
+			planned = append(planned, part)
+			remaining -= take
+		}
+		if remaining > 0 {
+			shortages = append(shortages, Shortage{Product_ID: line.Product_ID, Variant_ID: line.Variant_ID, SKU: line.SKU, Requested: line.Quantity, Available: available})
+		}
+	}
+	if len(shortages) > 0 {
+		return nil, &ShortageError{Shortages: shortages}
+	}
+	return planned, nil
+}
+
+// take allocates the lines and takes their stock, all of it or nothing. retry
+// is true when a location sold out between planning and taking.
+func take(ctx context.Context, lines []Line, to *models.Address) (taken []Line, stocks []int, retry bool, err error) {
+	planned, err := Allocate(ctx, lines, to, Strategy)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	taken = make([]Line, 0, len(planned))
+	stocks = make([]int, 0, len(planned))
+	shortages := make([]Shortage, 0)
+	for _, line := range planned {
+		stock, tracked, err := move(ctx, line, -line.Quantity)
+		var short *ShortageError
+		if errors.As(err, &short) {
+			shortages = append(shortages, short.Shortages...)
+			retry = retry || line.Location_ID != nil
+			continue
+		}
+		if err != nil {
+			putBack(ctx, taken)
+			return nil, nil, false, err
+		}
+		if tracked {
+			taken = append(taken, line)
+			stocks = append(stocks, stock)
+		}
+	}
+	if len(shortages) > 0 {
+		putBack(ctx, taken)
+		return nil, nil, retry, &ShortageError{Shortages: shortages}
+	}
+	return taken, stocks, false, nil
+}
+
+// putBack returns stock that was taken for an order that is not going ahead, nothing was sold so the ledger is left alone
+func putBack(ctx context.Context, lines []Line) {
+	for _, line := range lines {
+		if _, _, err := move(ctx, line, line.Quantity); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// Shipments groups the reserved lines of an order by the location they go out from
+func Shipments(ctx context.Context, lines []Line, items []models.ProductUser) []models.Shipment {
+	shipments := make([]models.Shipment, 0)
+	index := make(map[primitive.ObjectID]int)
+	unlocated := -1
+	add := func(i int, line Line) {
+		shipments[i].Items = append(shipments[i].Items, models.ShipmentItem{Product_ID: line.Product_ID, Variant_ID: line.Variant_ID, SKU: line.SKU, Quantity: line.Quantity})
+	}
+	for _, line := range lines {
+		if line.Location_ID == nil {
+			if unlocated < 0 {
+				unlocated = len(shipments)
+				shipments = append(shipments, models.Shipment{Items: make([]models.ShipmentItem, 0)})
+			}
+			add(unlocated, line)
+			continue
+		}
+		i, ok := index[*line.Location_ID]
+		if !ok {
+			i = len(shipments)
+			index[*line.Location_ID] = i
+			shipment := models.Shipment{Location_ID: line.Location_ID, Items: make([]models.ShipmentItem, 0)}
+			if location, err := FindLocation(ctx, *line.Location_ID); err == nil {
+				shipment.Location_Name = location.Name
+			}
+			shipments = append(shipments, shipment)
+		}
+		add(i, line)
+	}
+	//whatever does not track stock ships along with the rest
+	for _, line := range Lines(items) {
+		tracked := false
+		for _, reserved := range lines {
+			if reserved.Product_ID == line.Product_ID && sameVariant(reserved.Variant_ID, line.Variant_ID) {
+				tracked = true
+				break
+			}
+		}
+		if tracked {
+			continue
+		}
+		if len(shipments) == 0 {
+			shipments = append(shipments, models.Shipment{Items: make([]models.ShipmentItem, 0)})
+		}
+		add(0, line)
+	}
+	return shipments
+}
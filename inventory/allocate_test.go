@@ -0,0 +1,101 @@
+package inventory
+
+import (
+	"ecommerce/models"
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func text(value string) *string {
+	return &value
+}
+
+func TestRank(t *testing.T) {
+	north := Location{Location_ID: primitive.NewObjectID(), Code: "NORTH", Country: "IN", Postal_Prefixes: []string{"11"}, Priority: 2}
+	south := Location{Location_ID: primitive.NewObjectID(), Code: "SOUTH", Country: "IN", Postal_Prefixes: []string{"68", "6856"}, Priority: 3}
+	abroad := Location{Location_ID: primitive.NewObjectID(), Code: "ABROAD", Country: "US", Postal_Prefixes: []string{"685"}, Priority: 1}
+	locations := []Location{north, south, abroad}
+	cases := []struct {
+		name    string
+		address *models.Address
+		want    []string
+	}{
+		{"no address goes by priority", nil, []string{"ABROAD", "NORTH", "SOUTH"}},
+		{"longest prefix wins", &models.Address{Pincode: text("685607"), Country: text("IN")}, []string{"SOUTH", "ABROAD", "NORTH"}},
+		{"spaces and case are ignored", &models.Address{Pincode: text("11 00 01"), Country: text("in")}, []string{"NORTH", "SOUTH", "ABROAD"}},
+		{"country alone breaks ties with priority", &models.Address{Pincode: text("999999"), Country: text("IN")}, []string{"NORTH", "SOUTH", "ABROAD"}},
+	}
+	for _, c := range cases {
+		ranked := rank(locations, c.address)
+		for i, location := range ranked {
+			if location.Code != c.want[i] {
+				t.Errorf("%s: got %s at %d, want %v", c.name, location.Code, i, c.want)
+				break
+			}
+		}
+	}
+	if locations[0].Code != "NORTH" {
+		t.Error("rank reordered the locations it was given")
+	}
+}
+
+func TestPlan(t *testing.T) {
+	near := Location{Location_ID: primitive.NewObjectID(), Code: "NEAR"}
+	far := Location{Location_ID: primitive.NewObjectID(), Code: "FAR"}
+	ranked := []Location{near, far}
+	levels := func(atnear int, atfar int) holding {
+		return holding{levels: []models.StockLevel{{Location_ID: near.Location_ID, Stock: atnear}, {Location_ID: far.Location_ID, Stock: atfar}}}
+	}
+	pen := Line{Product_ID: primitive.NewObjectID(), Quantity: 5}
+	ink := Line{Product_ID: primitive.NewObjectID(), Quantity: 2}
+	loose := Line{Product_ID: primitive.NewObjectID(), Quantity: 3}
+
+	type part struct {
+		code     string
+		quantity int
+	}
+	cases := []struct {
+		name     string
+		lines    []Line
+		holdings []holding
+		strategy string
+		want     []part
+		short    bool
+	}{
+		{"nearest first", []Line{pen}, []holding{levels(9, 9)}, StrategyNearest, []part{{"NEAR", 5}}, false},
+		{"nearest splits what does not fit", []Line{pen}, []holding{levels(3, 9)}, StrategyNearest, []part{{"NEAR", 3}, {"FAR", 2}}, false},
+		{"single keeps the order together", []Line{pen, ink}, []holding{levels(9, 9), levels(1, 9)}, StrategySingle, []part{{"FAR", 5}, {"FAR", 2}}, false},
+		{"single splits when no location has everything", []Line{pen, ink}, []holding{levels(9, 0), levels(0, 9)}, StrategySingle, []part{{"NEAR", 5}, {"FAR", 2}}, false},
+		{"stock not kept per location is left alone", []Line{loose}, []holding{{}}, StrategyNearest, []part{{"", 3}}, false},
+		{"not enough anywhere", []Line{pen}, []holding{levels(2, 2)}, StrategyNearest, nil, true},
+	}
+	for _, c := range cases {
+		planned, err := plan(c.lines, c.holdings, ranked, c.strategy)
+		var short *ShortageError
+		if c.short {
+			if !errors.As(err, &short) || short.Shortages[0].Available != 4 {
+				t.Errorf("%s: got %v, want a shortage with 4 available", c.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if len(planned) != len(c.want) {
+			t.Errorf("%s: got %d lines, want %d", c.name, len(planned), len(c.want))
+			continue
+		}
+		for i, line := range planned {
+			code := ""
+			if line.Location_ID != nil {
+				code = map[primitive.ObjectID]string{near.Location_ID: "NEAR", far.Location_ID: "FAR"}[*line.Location_ID]
+			}
+			if code != c.want[i].code || line.Quantity != c.want[i].quantity {
+				t.Errorf("%s: line %d is %d at %q, want %d at %q", c.name, i, line.Quantity, code, c.want[i].quantity, c.want[i].code)
+			}
+		}
+	}
+}
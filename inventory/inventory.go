@@ -0,0 +1,397 @@
+package inventory
+
+import (
+	"context"
+	"ecommerce/database"
+	"ecommerce/models"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+//stock is the number of units that can still be sold, units held for an order
+//waiting on payment are already taken off. Products and variants without a
+//stock field are not tracked and can always be sold.
+//every change to the stock is written to the InventoryMovements ledger
+
+// why the stock moved
+const (
+	ReasonSale         = "sale"
+	ReasonReservation  = "reservation"
+	ReasonCancellation = "cancellation"
+	ReasonRelease      = "release"
+	ReasonExpiry       = "expiry"
+	ReasonAdjustment   = "adjustment"
+	ReasonProductEdit  = "product_edit"
+)
+
+var ErrProductNotFound = errors.New("product not found")
+var ErrNegativeStock = errors.New("stock can not go below zero")
+var ErrLocationRequired = errors.New("the stock is kept per location, pick a location")
+var ErrNotStockedHere = errors.New("not stocked at this location")
+var ErrBusy = errors.New("the product kept changing, try again")
+
+var ProductCollection *mongo.Collection = database.ProductData(database.Client, "Products")
+var MovementCollection *mongo.Collection = database.UserData(database.Client, "InventoryMovements")
+
+// Line is a quantity of one product, or of one variant of it, taken from one
+// location when the stock is kept per location
+type Line struct {
+	Product_ID  primitive.ObjectID  `json:"product_id" bson:"product_id"`
+	Variant_ID  *primitive.ObjectID `json:"variant_id,omitempty" bson:"variant_id,omitempty"`
+	SKU         *string             `json:"sku,omitempty" bson:"sku,omitempty"`
+	Location_ID *primitive.ObjectID `json:"location_id,omitempty" bson:"location_id,omitempty"`
+	Quantity    int                 `json:"quantity" bson:"quantity"`
+}
+
+type Movement struct {
+	Movement_ID    primitive.ObjectID  `json:"_id" bson:"_id"`
+	Product_ID     primitive.ObjectID  `json:"product_id" bson:"product_id"`
+	Variant_ID     *primitive.ObjectID `json:"variant_id,omitempty" bson:"variant_id,omitempty"`
+	SKU            *string             `json:"sku,omitempty" bson:"sku,omitempty"`
+	Location_ID    *primitive.ObjectID `json:"location_id,omitempty" bson:"location_id,omitempty"`
+	Change         int                 `json:"change" bson:"change"`
+	Stock          int                 `json:"stock" bson:"stock"`
+	Reason         string              `json:"reason" bson:"reason"`
+	Order_ID       *primitive.ObjectID `json:"order_id,omitempty" bson:"order_id,omitempty"`
+	Reservation_ID *primitive.ObjectID `json:"reservation_id,omitempty" bson:"reservation_id,omitempty"`
+	Transfer_ID    *primitive.ObjectID `json:"transfer_id,omitempty" bson:"transfer_id,omitempty"`
+	Note           *string             `json:"note,omitempty" bson:"note,omitempty"`
+	Created_At     time.Time           `json:"created_at" bson:"created_at"`
+}
+
+type Shortage struct {
+	Product_ID  primitive.ObjectID  `json:"product_id"`
+	Variant_ID  *primitive.ObjectID `json:"variant_id,omitempty"`
+	SKU         *string             `json:"sku,omitempty"`
+	Location_ID *primitive.ObjectID `json:"location_id,omitempty"`
+	Requested   int                 `json:"requested"`
+	Available   int                 `json:"available"`
+}
+
+// ShortageError lists every line there is not enough stock for
+type ShortageError struct {
+	Shortages []Shortage
+}
+
+func (e *ShortageError) Error() string {
+	parts := make([]string, 0, len(e.Shortages))
+	for _, shortage := range e.Shortages {
+		parts = append(parts, fmt.Sprintf("%s: %d requested, %d available", shortage.Product_ID.Hex(), shortage.Requested, shortage.Available))
+	}
+	return "not enough stock for " + strings.Join(parts, ", ")
+}
+
+func sameVariant(a *primitive.ObjectID, b *primitive.ObjectID) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+// Lines counts the units of every product and variant in a cart or order, every cart entry is one unit
+func Lines(items []models.ProductUser) []Line {
+	lines := make([]Line, 0)
+	for _, item := range items {
+		found := false
+		for i := range lines {
+			if lines[i].Product_ID == item.Product_ID && sameVariant(lines[i].Variant_ID, item.Variant_ID) {
+				lines[i].Quantity += item.Units()
+				found = true
+				break
+			}
+		}
+		if !found {
+			lines = append(lines, Line{Product_ID: item.Product_ID, Variant_ID: item.Variant_ID, SKU: item.SKU, Quantity: item.Units()})
+		}
+	}
+	return lines
+}
+
+// holding is what a line takes its stock from, the product or one of its variants
+type holding struct {
+	stock  *int
+	levels []models.StockLevel
+}
+
+func holdingOf(product models.Product, variant_id *primitive.ObjectID) (holding, bool) {
+	if variant_id == nil {
+		return holding{product.Stock, product.Stock_Levels}, true
+	}
+	for _, variant := range product.Variants {
+		if variant.Variant_ID == *variant_id {
+			return holding{variant.Stock, variant.Stock_Levels}, true
+		}
+	}
+	return holding{}, false
+}
+
+// at is the stock kept at the location
+func (h holding) at(location_id primitive.ObjectID) (int, bool) {
+	for _, level := range h.levels {
+		if level.Location_ID == location_id {
+			return level.Stock, true
+		}
+	}
+	return 0, false
+}
+
+// current is the stock the line can take from, the level at its location or the total
+func (h holding) current(line Line) *int {
+	if line.Location_ID == nil {
+		return h.stock
+	}
+	stock, ok := h.at(*line.Location_ID)
+	if !ok {
+		return nil
+	}
+	return &stock
+}
+
+// move changes the stock of the line by change in one update. Taking stock
+// only matches while enough is left so two checkouts can never sell the same
+// unit. Every move bumps the product version, an admin editing the product at
+// the same time gets a conflict instead of writing back an old stock.
+// A line with a location changes the level at that location and the total
+// together, stock kept per location can only be moved with a location.
+// tracked is false when the product or variant has no stock to take from.
+func move(ctx context.Context, line Line, change int) (stock int, tracked bool, err error) {
+	filter := bson.M{"_id": line.Product_ID}
+	inc := bson.M{"version": 1}
+	after := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	match := bson.M{}
+	if line.Location_ID != nil {
+		level := bson.M{"location_id": *line.Location_ID}
+		if change < 0 {
+			level["stock"] = bson.M{"$gte": -change}
+		}
+		match["stock_levels"] = bson.M{"$elemMatch": level}
+		filters := []interface{}{bson.M{"level.location_id": *line.Location_ID}}
+		if line.Variant_ID != nil {
+			filters = append(filters, bson.M{"variant._id": *line.Variant_ID})
+			inc["variants.$[variant].stock"] = change
+			inc["variants.$[variant].stock_levels.$[level].stock"] = change
+		} else {
+			inc["stock"] = change
+			inc["stock_levels.$[level].stock"] = change
+		}
+		after.SetArrayFilters(options.ArrayFilters{Filters: filters})
+	} else {
+		match["stock_levels.0"] = bson.M{"$exists": false}
+		if change < 0 {
+			match["stock"] = bson.M{"$gte": -change}
+		}
+		if line.Variant_ID != nil {
+			inc["variants.$.stock"] = change
+		} else {
+			inc["stock"] = change
+		}
+	}
+	if line.Variant_ID != nil {
+		match["_id"] = *line.Variant_ID
+		filter["variants"] = bson.M{"$elemMatch": match}
+	} else {
+		for key, value := range match {
+			filter[key] = value
+		}
+	}
+	var product models.Product
+	err = ProductCollection.FindOneAndUpdate(ctx, filter, bson.M{"$inc": inc}, after).Decode(&product)
+	if err == mongo.ErrNoDocuments {
+		//find out whether there is not enough, nothing to track or no such location
+		if err := ProductCollection.FindOne(ctx, bson.M{"_id": line.Product_ID}).Decode(&product); err != nil {
+			if err == mongo.ErrNoDocuments {
+				return 0, false, ErrProductNotFound
+			}
+			return 0, false, err
+		}
+		held, ok := holdingOf(product, line.Variant_ID)
+		if !ok {
+			return 0, false, ErrProductNotFound
+		}
+		if line.Location_ID == nil && len(held.levels) > 0 {
+			return 0, true, ErrLocationRequired
+		}
+		current := held.current(line)
+		if current == nil {
+			if line.Location_ID != nil {
+				return 0, false, ErrNotStockedHere
+			}
+			return 0, false, nil
+		}
+		return *current, true, &ShortageError{Shortages: []Shortage{{Product_ID: line.Product_ID, Variant_ID: line.Variant_ID, SKU: line.SKU, Location_ID: line.Location_ID, Requested: -change, Available: *current}}}
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	held, _ := holdingOf(product, line.Variant_ID)
+	if current := held.current(line); current != nil {
+		return *current, true, nil
+	}
+	return 0, true, nil
+}
+
+// Record writes a movement to the ledger, a failing ledger never undoes the stock change so it is only logged
+func Record(ctx context.Context, movement Movement) {
+	movement.Movement_ID = primitive.NewObjectID()
+	if movement.Created_At.IsZero() {
+		movement.Created_At = time.Now()
+	}
+	if _, err := MovementCollection.InsertOne(ctx, movement); err != nil {
+		log.Println(err)
+	}
+}
+
+// Adjust corrects the stock of a product or variant by hand, after a stock
+// count or when goods arrive. Adding to a product that was not tracked starts
+// tracking it, adding at a location it was not stocked at starts keeping its
+// stock per location.
+func Adjust(ctx context.Context, line Line, change int, note *string) (Movement, error) {
+	if line.Location_ID != nil {
+		if err := stockAt(ctx, line); err != nil {
+			return Movement{}, err
+		}
+	}
+	stock, tracked, err := move(ctx, line, change)
+	var short *ShortageError
+	if errors.As(err, &short) || (err == nil && !tracked) {
+		return Movement{}, ErrNegativeStock
+	}
+	if err != nil {
+		return Movement{}, err
+	}
+	movement := Movement{
+		Product_ID:  line.Product_ID,
+		Variant_ID:  line.Variant_ID,
+		SKU:         line.SKU,
+		Location_ID: line.Location_ID,
+		Change:      change,
+		Stock:       stock,
+		Reason:      ReasonAdjustment,
+		Note:        note,
+		Created_At:  time.Now(),
+	}
+	Record(ctx, movement)
+	return movement, nil
+}
+
+// stockAt gives the product or variant of the line a level at its location.
+// The first location takes over the stock it had so far so the total stays
+// the same. Guarded by the product version like every other product change.
+func stockAt(ctx context.Context, line Line) error {
+	for attempt := 0; attempt < 3; attempt++ {
+		var product models.Product
+		if err := ProductCollection.FindOne(ctx, bson.M{"_id": line.Product_ID}).Decode(&product); err != nil {
+			if err == mongo.ErrNoDocuments {
+				return ErrProductNotFound
+			}
+			return err
+		}
+		held, ok := holdingOf(product, line.Variant_ID)
+		if !ok {
+			return ErrProductNotFound
+		}
+		if _, ok := held.at(*line.Location_ID); ok {
+			return nil
+		}
+		levels := append([]models.StockLevel(nil), held.levels...)
+		first := models.StockLevel{Location_ID: *line.Location_ID}
+		if len(levels) == 0 && held.stock != nil {
+			first.Stock = *held.stock
+		}
+		levels = append(levels, first)
+		total := 0
+		for _, level := range levels {
+			total += level.Stock
+		}
+		filter := bson.M{"_id": line.Product_ID, "version": product.Version}
+		if product.Version == 0 {
+			filter["version"] = bson.M{"$in": bson.A{0, nil}}
+		}
+		set := bson.M{"stock": total, "stock_levels": levels}
+		update := options.Update()
+		if line.Variant_ID != nil {
+			set = bson.M{"variants.$[variant].stock": total, "variants.$[variant].stock_levels": levels}
+			update.SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"variant._id": *line.Variant_ID}}})
+		}
+		result, err := ProductCollection.UpdateOne(ctx, filter, bson.M{"$set": set, "$inc": bson.M{"version": 1}}, update)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount > 0 {
+			return nil
+		}
+	}
+	return ErrBusy
+}
+
+// RecordEdits writes the stock changes an admin made by editing the product to the ledger
+func RecordEdits(ctx context.Context, before models.Product, after models.Product) {
+	changes := make([]Movement, 0)
+	if before.Stock != nil || after.Stock != nil {
+		was, is := 0, 0
+		if before.Stock != nil {
+			was = *before.Stock
+		}
+		if after.Stock != nil {
+			is = *after.Stock
+		}
+		if was != is {
+			changes = append(changes, Movement{Change: is - was, Stock: is, SKU: after.SKU})
+		}
+	}
+	variants := make(map[primitive.ObjectID][2]int)
+	skus := make(map[primitive.ObjectID]string)
+	for _, variant := range before.Variants {
+		if variant.Stock != nil {
+			counts := variants[variant.Variant_ID]
+			counts[0] = *variant.Stock
+			variants[variant.Variant_ID] = counts
+			skus[variant.Variant_ID] = variant.SKU
+		}
+	}
+	for _, variant := range after.Variants {
+		if variant.Stock != nil {
+			counts := variants[variant.Variant_ID]
+			counts[1] = *variant.Stock
+			variants[variant.Variant_ID] = counts
+			skus[variant.Variant_ID] = variant.SKU
+		}
+	}
+	for variant_id, counts := range variants {
+		if counts[0] != counts[1] {
+			variant_id, sku := variant_id, skus[variant_id]
+			changes = append(changes, Movement{Variant_ID: &variant_id, SKU: &sku, Change: counts[1] - counts[0], Stock: counts[1]})
+		}
+	}
+	for _, movement := range changes {
+		movement.Product_ID = after.Product_ID
+		movement.Reason = ReasonProductEdit
+		Record(ctx, movement)
+	}
+}
+
+// Movements lists the ledger newest first
+func Movements(ctx context.Context, filter bson.M, limit int64, offset int64) ([]Movement, int64, error) {
+	movements := make([]Movement, 0)
+	total, err := MovementCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return movements, 0, err
+	}
+	find := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).SetLimit(limit).SetSkip(offset)
+	cursor, err := MovementCollection.Find(ctx, filter, find)
+	if err != nil {
+		return movements, 0, err
+	}
+	if err := cursor.All(ctx, &movements); err != nil {
+		return movements, 0, err
+	}
+	return movements, total, nil
+}
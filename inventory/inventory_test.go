@@ -0,0 +1,58 @@
+package inventory
+
+import (
+	"ecommerce/models"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestLines(t *testing.T) {
+	tee, mug := primitive.NewObjectID(), primitive.NewObjectID()
+	small, large := primitive.NewObjectID(), primitive.NewObjectID()
+	sku := "TEE-S"
+	items := []models.ProductUser{
+		{Product_ID: tee, Variant_ID: &small, SKU: &sku},
+		{Product_ID: mug},
+		{Product_ID: tee, Variant_ID: &large},
+		{Product_ID: tee, Variant_ID: &small},
+		{Product_ID: mug},
+		{Product_ID: tee},
+	}
+	lines := Lines(items)
+	want := []struct {
+		product  primitive.ObjectID
+		variant  *primitive.ObjectID
+		quantity int
+	}{
+		{tee, &small, 2},
+		{mug, nil, 2},
+		{tee, &large, 1},
+		{tee, nil, 1},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("got %d lines, want %d", len(lines), len(want))
+	}
+	for i, line := range lines {
+		if line.Product_ID != want[i].product || !sameVariant(line.Variant_ID, want[i].variant) || line.Quantity != want[i].quantity {
+			t.Errorf("line %d is %+v, want %+v", i, line, want[i])
+		}
+	}
+	if lines[0].SKU == nil || *lines[0].SKU != "TEE-S" {
+		t.Errorf("the line lost its sku: %+v", lines[0])
+	}
+	if len(Lines(nil)) != 0 {
+		t.Error("an empty cart has lines")
+	}
+}
+
+func TestShortageError(t *testing.T) {
+	id, err := primitive.ObjectIDFromHex("000000000000000000000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shortage := &ShortageError{Shortages: []Shortage{{Product_ID: id, Requested: 3, Available: 1}}}
+	if got := shortage.Error(); got != "not enough stock for 000000000000000000000001: 3 requested, 1 available" {
+		t.Errorf("got %q", got)
+	}
+}
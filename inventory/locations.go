@@ -0,0 +1,136 @@
+package inventory
+
+import (
+	"context"
+	"ecommerce/database"
+	"ecommerce/models"
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+//locations are the warehouses and stores stock is kept at. Each one lists the
+//postal code prefixes it is close to, an order goes out from the location
+//whose prefix matches most of the shipping address
+
+const (
+	KindWarehouse = "warehouse"
+	KindStore     = "store"
+)
+
+// allocation strategies, see Allocate
+const (
+	StrategyNearest = "nearest"
+	StrategySingle  = "single"
+)
+
+var ErrLocationNotFound = errors.New("location not found")
+
+var LocationCollection *mongo.Collection = database.UserData(database.Client, "Locations")
+
+// Strategy is how orders are spread over the locations, FULFILLMENT_STRATEGY picks it
+var Strategy = strategy()
+
+func strategy() string {
+	if os.Getenv("FULFILLMENT_STRATEGY") == StrategySingle {
+		return StrategySingle
+	}
+	return StrategyNearest
+}
+
+type Location struct {
+	Location_ID     primitive.ObjectID `json:"_id" bson:"_id"`
+	Code            string             `json:"code" bson:"code" validate:"required,max=20"`
+	Name            string             `json:"name" bson:"name" validate:"required,max=100"`
+	Kind            string             `json:"kind" bson:"kind" validate:"oneof=warehouse store"`
+	Country         string             `json:"country_code,omitempty" bson:"country_code,omitempty" validate:"omitempty,len=2"`
+	Postal_Prefixes []string           `json:"postal_prefixes" bson:"postal_prefixes" validate:"max=100,dive,min=1,max=10"`
+	Priority        int                `json:"priority" bson:"priority"`
+	Active          bool               `json:"active" bson:"active"`
+	Created_At      time.Time          `json:"created_at" bson:"created_at"`
+	Updated_At      time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+var locationIndex bool
+
+// EnsureLocationIndex makes location codes unique, failures are only logged
+func EnsureLocationIndex(ctx context.Context) {
+	if locationIndex {
+		return
+	}
+	index := mongo.IndexModel{Keys: bson.M{"code": 1}, Options: options.Index().SetUnique(true)}
+	if _, err := LocationCollection.Indexes().CreateOne(ctx, index); err != nil {
+		log.Println(err)
+		return
+	}
+	locationIndex = true
+}
+
+func FindLocation(ctx context.Context, location_id primitive.ObjectID) (Location, error) {
+	var location Location
+	err := LocationCollection.FindOne(ctx, bson.M{"_id": location_id}).Decode(&location)
+	if err == mongo.ErrNoDocuments {
+		return location, ErrLocationNotFound
+	}
+	return location, err
+}
+
+// Locations lists the locations by priority, with active only the ones that ship
+func Locations(ctx context.Context, active bool) ([]Location, error) {
+	locations := make([]Location, 0)
+	filter := bson.M{}
+	if active {
+		filter["active"] = true
+	}
+	cursor, err := LocationCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "priority", Value: 1}, {Key: "code", Value: 1}}))
+	if err != nil {
+		return locations, err
+	}
+	err = cursor.All(ctx, &locations)
+	return locations, err
+}
+
+// closeness scores how near the location is to the address, the length of the
+// longest matching postal prefix with the same country counting for one more
+func (location Location) closeness(address *models.Address) int {
+	if address == nil {
+		return 0
+	}
+	score := 0
+	if address.Country != nil && location.Country != "" && strings.EqualFold(*address.Country, location.Country) {
+		score = 1
+	}
+	if address.Pincode == nil {
+		return score
+	}
+	postal := strings.ToUpper(strings.ReplaceAll(*address.Pincode, " ", ""))
+	best := 0
+	for _, prefix := range location.Postal_Prefixes {
+		prefix = strings.ToUpper(strings.ReplaceAll(prefix, " ", ""))
+		if strings.HasPrefix(postal, prefix) && len(prefix) > best {
+			best = len(prefix)
+		}
+	}
+	return score + best*2
+}
+
+// rank orders the locations nearest to the address first, ties go by priority
+func rank(locations []Location, address *models.Address) []Location {
+	ranked := append([]Location(nil), locations...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		a, b := ranked[i].closeness(address), ranked[j].closeness(address)
+		if a != b {
+			return a > b
+		}
+		return ranked[i].Priority < ranked[j].Priority
+	})
+	return ranked
+}
@@ -0,0 +1,230 @@
+package inventory
+
+import (
+	"context"
+	"ecommerce/database"
+	"ecommerce/events"
+	"ecommerce/models"
+	"ecommerce/notify"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+//a product or variant is low on stock once its stock is at or below its
+//reorder point. An alert is opened the first time that happens and the
+//buyers are notified once, it closes again when the stock is back above the
+//reorder point so the next time it runs low they hear about it again
+
+const (
+	AlertOpen   = "open"
+	AlertClosed = "closed"
+)
+
+var AlertCollection *mongo.Collection = database.UserData(database.Client, "StockAlerts")
+
+// WatchEvery is how often the background job looks for low stock, LOW_STOCK_INTERVAL sets it
+var WatchEvery = watchEvery()
+
+func watchEvery() time.Duration {
+	if every, err := time.ParseDuration(os.Getenv("LOW_STOCK_INTERVAL")); err == nil && every > 0 {
+		return every
+	}
+	return time.Hour
+}
+
+type Alert struct {
+	Alert_ID      primitive.ObjectID  `json:"_id" bson:"_id"`
+	Product_ID    primitive.ObjectID  `json:"product_id" bson:"product_id"`
+	Variant_ID    *primitive.ObjectID `json:"variant_id" bson:"variant_id"`
+	SKU           *string             `json:"sku,omitempty" bson:"sku,omitempty"`
+	Product_Name  string              `json:"product_name" bson:"product_name"`
+	Stock         int                 `json:"stock" bson:"stock"`
+	Reorder_Point int                 `json:"reorder_point" bson:"reorder_point"`
+	Status        string              `json:"status" bson:"status"`
+	Opened_At     time.Time           `json:"opened_at" bson:"opened_at"`
+	Closed_At     *time.Time          `json:"closed_at,omitempty" bson:"closed_at,omitempty"`
+}
+
+// level is the stock of a product or variant together with its reorder point
+type level struct {
+	product       models.Product
+	variant       *models.Variant
+	stock         *int
+	reorder_point *int
+}
+
+func (l level) variantID() *primitive.ObjectID {
+	if l.variant == nil {
+		return nil
+	}
+	id := l.variant.Variant_ID
+	return &id
+}
+
+func (l level) sku() *string {
+	if l.variant == nil {
+		return l.product.SKU
+	}
+	sku := l.variant.SKU
+	return &sku
+}
+
+// levels lists what a product tracks stock of, a variant without a reorder
+// point uses the one of its product
+func levels(product models.Product) []level {
+	if len(product.Variants) == 0 {
+		return []level{{product: product, stock: product.Stock, reorder_point: product.Reorder_Point}}
+	}
+	found := make([]level, 0, len(product.Variants))
+	for i := range product.Variants {
+		variant := &product.Variants[i]
+		point := variant.Reorder_Point
+		if point == nil {
+			point = product.Reorder_Point
+		}
+		found = append(found, level{product: product, variant: variant, stock: variant.Stock, reorder_point: point})
+	}
+	return found
+}
+
+func alertKey(product_id primitive.ObjectID, variant_id *primitive.ObjectID) string {
+	if variant_id == nil {
+		return product_id.Hex()
+	}
+	return product_id.Hex() + "/" + variant_id.Hex()
+}
+
+// CheckStock opens alerts for everything that ran low since the last check,
+// notifying about each new one, and closes the alerts of what was restocked.
+// It returns the alerts it opened.
+func CheckStock(ctx context.Context, notifier notify.Notifier) ([]Alert, error) {
+	opened := make([]Alert, 0)
+	open := make(map[string]Alert)
+	cursor, err := AlertCollection.Find(ctx, bson.M{"status": AlertOpen})
+	if err != nil {
+		return opened, err
+	}
+	var alerts []Alert
+	if err := cursor.All(ctx, &alerts); err != nil {
+		return opened, err
+	}
+	for _, alert := range alerts {
+		open[alertKey(alert.Product_ID, alert.Variant_ID)] = alert
+	}
+	filter := bson.M{"archived": bson.M{"$ne": true}, "$or": bson.A{
+		bson.M{"reorder_point": bson.M{"$exists": true}},
+		bson.M{"variants.reorder_point": bson.M{"$exists": true}},
+	}}
+	cursor, err = ProductCollection.Find(ctx, filter)
+	if err != nil {
+		return opened, err
+	}
+	var products []models.Product
+	if err := cursor.All(ctx, &products); err != nil {
+		return opened, err
+	}
+	now := time.Now()
+	for _, product := range products {
+		for _, held := range levels(product) {
+			if held.stock == nil || held.reorder_point == nil {
+				continue
+			}
+			key := alertKey(product.Product_ID, held.variantID())
+			alert, alerted := open[key]
+			delete(open, key)
+			low := *held.stock <= *held.reorder_point
+			if low == alerted {
+				continue
+			}
+			if !low {
+				update := bson.M{"$set": bson.M{"status": AlertClosed, "closed_at": now, "stock": *held.stock}}
+				if _, err := AlertCollection.UpdateOne(ctx, bson.M{"_id": alert.Alert_ID, "status": AlertOpen}, update); err != nil {
+					return opened, err
+				}
+				continue
+			}
+			alert = Alert{
+				Alert_ID:      primitive.NewObjectID(),
+				Product_ID:    product.Product_ID,
+				Variant_ID:    held.variantID(),
+				SKU:           held.sku(),
+				Stock:         *held.stock,
+				Reorder_Point: *held.reorder_point,
+				Status:        AlertOpen,
+				Opened_At:     now,
+			}
+			if product.Product_Name != nil {
+				alert.Product_Name = *product.Product_Name
+			}
+			//two instances checking at once must not both notify
+			upsert := options.Update().SetUpsert(true)
+			result, err := AlertCollection.UpdateOne(ctx, bson.M{"product_id": alert.Product_ID, "variant_id": alert.Variant_ID, "status": AlertOpen}, bson.M{"$setOnInsert": alert}, upsert)
+			if err != nil {
+				return opened, err
+			}
+			if result.UpsertedCount == 0 {
+				continue
+			}
+			opened = append(opened, alert)
+			if err := notifier.Notify(ctx, lowStockMessage(alert)); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+	//alerts of products that were archived or lost their reorder point are done with
+	for _, alert := range open {
+		update := bson.M{"$set": bson.M{"status": AlertClosed, "closed_at": now}}
+		if _, err := AlertCollection.UpdateOne(ctx, bson.M{"_id": alert.Alert_ID, "status": AlertOpen}, update); err != nil {
+			return opened, err
+		}
+	}
+	return opened, nil
+}
+
+func lowStockMessage(alert Alert) notify.Message {
+	name := alert.Product_Name
+	if alert.SKU != nil {
+		name = fmt.Sprintf("%s (%s)", name, *alert.SKU)
+	}
+	return notify.Message{
+		Kind:    events.StockLow,
+		Subject: "Low stock: " + name,
+		Body:    fmt.Sprintf("%d left, the reorder point is %d", alert.Stock, alert.Reorder_Point),
+		Data:    alert,
+	}
+}
+
+// WatchStock runs in the background and checks the stock every interval, starting right away
+func WatchStock(interval time.Duration, notifier notify.Notifier) {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		if _, err := CheckStock(ctx, notifier); err != nil {
+			log.Println(err)
+		}
+		cancel()
+		time.Sleep(interval)
+	}
+}
+
+// Alerts lists alerts newest first
+func Alerts(ctx context.Context, filter bson.M, limit int64, offset int64) ([]Alert, int64, error) {
+	alerts := make([]Alert, 0)
+	total, err := AlertCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return alerts, 0, err
+	}
+	find := options.Find().SetSort(bson.D{{Key: "opened_at", Value: -1}, {Key: "_id", Value: -1}}).SetLimit(limit).SetSkip(offset)
+	cursor, err := AlertCollection.Find(ctx, filter, find)
+	if err != nil {
+		return alerts, 0, err
+	}
+	err = cursor.All(ctx, &alerts)
+	return alerts, total, err
+}
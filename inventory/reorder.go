@@ -0,0 +1,173 @@
+package inventory
+
+import (
+	"context"
+	"ecommerce/database"
+	"ecommerce/models"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+//the reorder report estimates how long the stock lasts at the pace it sold
+//over the last days and how much to order so it lasts the lead time of the
+//supplier plus the cover days after the goods arrive
+
+// orders are embedded in the users
+var OrderCollection *mongo.Collection = database.UserData(database.Client, "Users")
+
+// LeadDays and CoverDays are the defaults of the report, REORDER_LEAD_DAYS and REORDER_COVER_DAYS set them
+var LeadDays = envDays("REORDER_LEAD_DAYS", 7)
+var CoverDays = envDays("REORDER_COVER_DAYS", 30)
+
+func envDays(name string, fallback int) int {
+	if days, err := strconv.Atoi(os.Getenv(name)); err == nil && days >= 0 {
+		return days
+	}
+	return fallback
+}
+
+type ReorderLine struct {
+	Product_ID     primitive.ObjectID  `json:"product_id"`
+	Variant_ID     *primitive.ObjectID `json:"variant_id,omitempty"`
+	SKU            *string             `json:"sku,omitempty"`
+	Product_Name   string              `json:"product_name"`
+	Stock          int                 `json:"stock"`
+	Reorder_Point  *int                `json:"reorder_point,omitempty"`
+	Sold           int                 `json:"sold"`
+	Per_Day        float64             `json:"per_day"`
+	Days_Left      *float64            `json:"days_left"`
+	Stockout_On    *time.Time          `json:"stockout_on,omitempty"`
+	Reorder_Now    bool                `json:"reorder_now"`
+	Order_Quantity int                 `json:"order_quantity"`
+}
+
+type ReorderOptions struct {
+	Days       int
+	Lead_Days  int
+	Cover_Days int
+}
+
+// Sold counts the units sold per product and variant since the given time,
+// cancelled orders do not count
+func Sold(ctx context.Context, since time.Time) (map[string]int, error) {
+	pipeline := bson.A{
+		bson.M{"$match": bson.M{"orders.ordered_on": bson.M{"$gte": since}}},
+		bson.M{"$unwind": "$orders"},
+		bson.M{"$match": bson.M{"orders.ordered_on": bson.M{"$gte": since}, "orders.status": bson.M{"$ne": models.OrderCancelled}}},
+		bson.M{"$unwind": "$orders.order_list"},
+		bson.M{"$group": bson.M{
+			"_id":  bson.M{"product": "$orders.order_list._id", "variant": "$orders.order_list.variant_id"},
+			"sold": bson.M{"$sum": bson.M{"$ifNull": bson.A{"$orders.order_list.quantity", 1}}},
+		}},
+	}
+	cursor, err := OrderCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		ID struct {
+			Product primitive.ObjectID  `bson:"product"`
+			Variant *primitive.ObjectID `bson:"variant"`
+		} `bson:"_id"`
+		Sold int `bson:"sold"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	sold := make(map[string]int, len(rows))
+	for _, row := range rows {
+		sold[alertKey(row.ID.Product, row.ID.Variant)] += row.Sold
+	}
+	return sold, nil
+}
+
+// ReorderReport lists everything that tracks stock, what will run out first on top
+func ReorderReport(ctx context.Context, opts ReorderOptions) ([]ReorderLine, error) {
+	report := make([]ReorderLine, 0)
+	now := time.Now()
+	sold, err := Sold(ctx, now.AddDate(0, 0, -opts.Days))
+	if err != nil {
+		return report, err
+	}
+	filter := bson.M{"archived": bson.M{"$ne": true}, "$or": bson.A{
+		bson.M{"stock": bson.M{"$exists": true}},
+		bson.M{"variants.stock": bson.M{"$exists": true}},
+	}}
+	cursor, err := ProductCollection.Find(ctx, filter)
+	if err != nil {
+		return report, err
+	}
+	var products []models.Product
+	if err := cursor.All(ctx, &products); err != nil {
+		return report, err
+	}
+	for _, product := range products {
+		for _, held := range levels(product) {
+			if held.stock == nil {
+				continue
+			}
+			report = append(report, reorderLine(held, sold[alertKey(product.Product_ID, held.variantID())], opts, now))
+		}
+	}
+	sortReport(report)
+	return report, nil
+}
+
+// reorderLine works out how long the stock of a level lasts at the pace it
+// sold and how much to order, the level has to track stock
+func reorderLine(held level, sold int, opts ReorderOptions, now time.Time) ReorderLine {
+	line := ReorderLine{
+		Product_ID:    held.product.Product_ID,
+		Variant_ID:    held.variantID(),
+		SKU:           held.sku(),
+		Stock:         *held.stock,
+		Reorder_Point: held.reorder_point,
+		Sold:          sold,
+	}
+	if held.product.Product_Name != nil {
+		line.Product_Name = *held.product.Product_Name
+	}
+	line.Per_Day = math.Round(float64(line.Sold)/float64(opts.Days)*100) / 100
+	perday := float64(line.Sold) / float64(opts.Days)
+	if perday > 0 {
+		left := math.Round(float64(line.Stock)/perday*10) / 10
+		stockout := now.Add(time.Duration(left * 24 * float64(time.Hour)))
+		line.Days_Left = &left
+		line.Stockout_On = &stockout
+	}
+	line.Reorder_Now = (line.Reorder_Point != nil && line.Stock <= *line.Reorder_Point) ||
+		(line.Days_Left != nil && *line.Days_Left <= float64(opts.Lead_Days))
+	//enough to get through the lead time and the cover days, and to end up above the reorder point
+	target := int(math.Ceil(perday * float64(opts.Lead_Days+opts.Cover_Days)))
+	if line.Reorder_Point != nil && target <= *line.Reorder_Point {
+		target = *line.Reorder_Point + 1
+	}
+	if line.Reorder_Now && target > line.Stock {
+		line.Order_Quantity = target - line.Stock
+	}
+	return line
+}
+
+// sortReport puts what has to be ordered now first, then what runs out soonest
+func sortReport(report []ReorderLine) {
+	sort.SliceStable(report, func(i, j int) bool {
+		a, b := report[i], report[j]
+		if a.Reorder_Now != b.Reorder_Now {
+			return a.Reorder_Now
+		}
+		if (a.Days_Left == nil) != (b.Days_Left == nil) {
+			return a.Days_Left != nil
+		}
+		if a.Days_Left != nil && *a.Days_Left != *b.Days_Left {
+			return *a.Days_Left < *b.Days_Left
+		}
+		return a.Product_Name < b.Product_Name
+	})
+}
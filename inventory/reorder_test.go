@@ -0,0 +1,106 @@
+package inventory
+
+import (
+	"ecommerce/models"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func number(value int) *int {
+	return &value
+}
+
+func TestLevels(t *testing.T) {
+	name, sku := "Basic Tee", "TEE"
+	single := models.Product{Product_ID: primitive.NewObjectID(), Product_Name: &name, SKU: &sku, Stock: number(10), Reorder_Point: number(4)}
+	found := levels(single)
+	if len(found) != 1 || found[0].variantID() != nil || *found[0].sku() != "TEE" || *found[0].stock != 10 || *found[0].reorder_point != 4 {
+		t.Errorf("a product without variants got %+v", found)
+	}
+
+	small, large := primitive.NewObjectID(), primitive.NewObjectID()
+	single.Variants = []models.Variant{
+		{Variant_ID: small, SKU: "TEE-S", Stock: number(3)},
+		{Variant_ID: large, SKU: "TEE-L", Stock: number(8), Reorder_Point: number(2)},
+	}
+	found = levels(single)
+	if len(found) != 2 {
+		t.Fatalf("got %d levels", len(found))
+	}
+	//a variant without a reorder point uses the one of the product
+	if *found[0].variantID() != small || *found[0].sku() != "TEE-S" || *found[0].stock != 3 || *found[0].reorder_point != 4 {
+		t.Errorf("the small variant got %+v", found[0])
+	}
+	if *found[1].variantID() != large || *found[1].reorder_point != 2 {
+		t.Errorf("the large variant got %+v", found[1])
+	}
+}
+
+func TestReorderLine(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	opts := ReorderOptions{Days: 30, Lead_Days: 7, Cover_Days: 30}
+	name := "Basic Tee"
+	held := func(stock int, point *int) level {
+		return level{product: models.Product{Product_ID: primitive.NewObjectID(), Product_Name: &name}, stock: &stock, reorder_point: point}
+	}
+	days := func(value float64) *float64 {
+		return &value
+	}
+	cases := []struct {
+		name     string
+		held     level
+		sold     int
+		perday   float64
+		left     *float64
+		now      bool
+		quantity int
+	}{
+		{"plenty left", held(100, nil), 30, 1, days(100), false, 0},
+		//5 days left is within the 7 days of lead time, enough for 37 days is ordered
+		{"runs out within the lead time", held(5, nil), 30, 1, days(5), true, 32},
+		//nothing sold, only the reorder point asks for more and one above it is enough
+		{"at the reorder point without sales", held(10, number(10)), 0, 0, nil, true, 1},
+		//a third a day lasts 9 days, 37 days need 13
+		{"below the reorder point", held(3, number(5)), 10, 0.33, days(9), true, 10},
+		{"nothing sold and no reorder point", held(0, nil), 0, 0, nil, false, 0},
+		{"above the reorder point with enough left", held(40, number(5)), 30, 1, days(40), false, 0},
+	}
+	for _, c := range cases {
+		line := reorderLine(c.held, c.sold, opts, now)
+		if line.Per_Day != c.perday || line.Reorder_Now != c.now || line.Order_Quantity != c.quantity {
+			t.Errorf("%s: got %v a day, reorder %v, order %d, want %v, %v, %d", c.name, line.Per_Day, line.Reorder_Now, line.Order_Quantity, c.perday, c.now, c.quantity)
+		}
+		if (line.Days_Left == nil) != (c.left == nil) || (c.left != nil && *line.Days_Left != *c.left) {
+			t.Errorf("%s: days left %v, want %v", c.name, line.Days_Left, c.left)
+		}
+		if c.left != nil && !line.Stockout_On.Equal(now.Add(time.Duration(*c.left*24)*time.Hour)) {
+			t.Errorf("%s: runs out on %v", c.name, line.Stockout_On)
+		}
+		if line.Product_Name != name || line.Sold != c.sold || line.Stock != *c.held.stock {
+			t.Errorf("%s: got %+v", c.name, line)
+		}
+	}
+}
+
+func TestSortReport(t *testing.T) {
+	left := func(value float64) *float64 {
+		return &value
+	}
+	report := []ReorderLine{
+		{Product_Name: "plenty", Days_Left: left(100)},
+		{Product_Name: "soon", Days_Left: left(5), Reorder_Now: true},
+		{Product_Name: "unsold low", Reorder_Now: true},
+		{Product_Name: "later", Days_Left: left(9), Reorder_Now: true},
+		{Product_Name: "b unsold"},
+		{Product_Name: "a unsold"},
+	}
+	sortReport(report)
+	want := []string{"soon", "later", "unsold low", "plenty", "a unsold", "b unsold"}
+	for i, line := range report {
+		if line.Product_Name != want[i] {
+			t.Errorf("line %d is %s, want %s", i, line.Product_Name, want[i])
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package inventory
+
+import (
+	"context"
+	"ecommerce/database"
+	"ecommerce/models"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+//a reservation holds the stock of an order. Orders waiting on payment hold
+//it for a limited time, when the time is up the stock goes back on the shelf.
+//held -> committed once paid, held -> expired when nobody paid in time,
+//held or committed -> released when the order is cancelled
+
+const (
+	StatusHeld      = "held"
+	StatusCommitted = "committed"
+	StatusReleased  = "released"
+	StatusExpired   = "expired"
+)
+
+var ErrReservationNotHeld = errors.New("the reservation is no longer held, the items went back on the shelf")
+
+var ReservationCollection *mongo.Collection = database.UserData(database.Client, "Reservations")
+
+// HoldFor is how long stock stays reserved for an order waiting on payment
+var HoldFor = holdFor()
+
+func holdFor() time.Duration {
+	if hold, err := time.ParseDuration(os.Getenv("RESERVATION_TTL")); err == nil && hold > 0 {
+		return hold
+	}
+	return 15 * time.Minute
+}
+
+type Reservation struct {
+	Reservation_ID primitive.ObjectID `json:"_id" bson:"_id"`
+	Order_ID       primitive.ObjectID `json:"order_id" bson:"order_id"`
+	User_ID        primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Items          []Line             `json:"items" bson:"items"`
+	Status         string             `json:"status" bson:"status"`
+	Expires_At     *time.Time         `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	Created_At     time.Time          `json:"created_at" bson:"created_at"`
+	Updated_At     time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// Reserve takes the stock of every line for the order, allocated over the
+// locations by Strategy for the shipping address. Either all of it is taken or
+// none, a ShortageError tells which lines are short. With hold the stock is
+// only held until HoldFor has passed, without it the sale is final. Only lines
+// that track stock end up on the reservation.
+func Reserve(ctx context.Context, order_id primitive.ObjectID, user_id primitive.ObjectID, lines []Line, to *models.Address, hold bool) (Reservation, error) {
+	now := time.Now()
+	reservation := Reservation{
+		Reservation_ID: primitive.NewObjectID(),
+		Order_ID:       order_id,
+		User_ID:        user_id,
+		Items:          make([]Line, 0),
+		Status:         StatusCommitted,
+		Created_At:     now,
+		Updated_At:     now,
+	}
+	reason := ReasonSale
+	if hold {
+		expires := now.Add(HoldFor)
+		reservation.Status = StatusHeld
+		reservation.Expires_At = &expires
+		reason = ReasonReservation
+	}
+	var taken []Line
+	var stocks []int
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		var retry bool
+		taken, stocks, retry, err = take(ctx, lines, to)
+		if !retry {
+			break
+		}
+	}
+	if err != nil {
+		return reservation, err
+	}
+	reservation.Items = taken
+	if _, err := ReservationCollection.InsertOne(ctx, reservation); err != nil {
+		putBack(ctx, reservation.Items)
+		return reservation, err
+	}
+	for i, line := range reservation.Items {
+		Record(ctx, Movement{
+			Product_ID:     line.Product_ID,
+			Variant_ID:     line.Variant_ID,
+			SKU:            line.SKU,
+			Location_ID:    line.Location_ID,
+			Change:         -line.Quantity,
+			Stock:          stocks[i],
+			Reason:         reason,
+			Order_ID:       &order_id,
+			Reservation_ID: &reservation.Reservation_ID,
+			Created_At:     now,
+		})
+	}
+	return reservation, nil
+}
+
+// Commit turns held stock into a sale, it fails once the hold has run out
+func Commit(ctx context.Context, reservation_id primitive.ObjectID) error {
+	now := time.Now()
+	filter := bson.M{"_id": reservation_id, "status": StatusHeld, "expires_at": bson.M{"$gt": now}}
+	update := bson.M{"$set": bson.M{"status": StatusCommitted, "updated_at": now}, "$unset": bson.M{"expires_at": ""}}
+	result, err := ReservationCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrReservationNotHeld
+	}
+	return nil
+}
+
+// release flips the reservation matching filter to status and puts its stock
+// back. Only the caller that flips it puts stock back, so a cancel racing an
+// expiry can not return the stock twice. Without a reason it follows from
+// whether the stock was still held or already sold.
+func release(ctx context.Context, filter bson.M, status string, reason string) (Reservation, bool, error) {
+	var reservation Reservation
+	now := time.Now()
+	update := bson.M{"$set": bson.M{"status": status, "updated_at": now}}
+	err := ReservationCollection.FindOneAndUpdate(ctx, filter, update).Decode(&reservation)
+	if err == mongo.ErrNoDocuments {
+		return reservation, false, nil
+	}
+	if err != nil {
+		return reservation, false, err
+	}
+	if reason == "" {
+		reason = ReasonCancellation
+		if reservation.Status == StatusHeld {
+			reason = ReasonRelease
+		}
+	}
+	for _, line := range reservation.Items {
+		stock, _, err := move(ctx, line, line.Quantity)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		Record(ctx, Movement{
+			Product_ID:     line.Product_ID,
+			Variant_ID:     line.Variant_ID,
+			SKU:            line.SKU,
+			Location_ID:    line.Location_ID,
+			Change:         line.Quantity,
+			Stock:          stock,
+			Reason:         reason,
+			Order_ID:       &reservation.Order_ID,
+			Reservation_ID: &reservation.Reservation_ID,
+			Created_At:     now,
+		})
+	}
+	reservation.Status = status
+	return reservation, true, nil
+}
+
+// Release puts the stock of a cancelled order back, it does nothing when the stock already went back
+func Release(ctx context.Context, reservation_id primitive.ObjectID) error {
+	filter := bson.M{"_id": reservation_id, "status": bson.M{"$in": bson.A{StatusHeld, StatusCommitted}}}
+	_, _, err := release(ctx, filter, StatusReleased, "")
+	return err
+}
+
+// ReleaseExpired puts back the stock of every hold that ran out and returns the reservations it released
+func ReleaseExpired(ctx context.Context) ([]Reservation, error) {
+	released := make([]Reservation, 0)
+	now := time.Now()
+	expired := bson.M{"status": StatusHeld, "expires_at": bson.M{"$lte": now}}
+	cursor, err := ReservationCollection.Find(ctx, expired, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return released, err
+	}
+	var ids []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &ids); err != nil {
+		return released, err
+	}
+	for _, id := range ids {
+		filter := bson.M{"_id": id.ID, "status": StatusHeld, "expires_at": bson.M{"$lte": now}}
+		reservation, ok, err := release(ctx, filter, StatusExpired, ReasonExpiry)
+		if err != nil {
+			return released, err
+		}
+		if ok {
+			released = append(released, reservation)
+		}
+	}
+	return released, nil
+}
@@ -0,0 +1,184 @@
+package inventory
+
+import (
+	"context"
+	"ecommerce/database"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+//a transfer moves stock from one location to another. The stock leaves the
+//first location when the transfer is sent and only arrives at the other one
+//when it is received, what is on the road can not be sold.
+//in_transit -> received, or in_transit -> cancelled which puts it back
+//receiving and cancelling hold the transfer while its stock moves, when the
+//stock can not be moved it goes back to in_transit and can be tried again
+
+const (
+	TransferInTransit  = "in_transit"
+	TransferReceiving  = "receiving"
+	TransferReceived   = "received"
+	TransferCancelling = "cancelling"
+	TransferCancelled  = "cancelled"
+)
+
+const (
+	ReasonTransferOut       = "transfer_out"
+	ReasonTransferIn        = "transfer_in"
+	ReasonTransferCancelled = "transfer_cancelled"
+)
+
+var ErrTransferNotFound = errors.New("transfer not found")
+var ErrTransferDone = errors.New("the transfer is no longer in transit")
+var ErrSameLocation = errors.New("a transfer needs two different locations")
+
+var TransferCollection *mongo.Collection = database.UserData(database.Client, "Transfers")
+
+type Transfer struct {
+	Transfer_ID  primitive.ObjectID  `json:"_id" bson:"_id"`
+	Product_ID   primitive.ObjectID  `json:"product_id" bson:"product_id"`
+	Variant_ID   *primitive.ObjectID `json:"variant_id,omitempty" bson:"variant_id,omitempty"`
+	SKU          *string             `json:"sku,omitempty" bson:"sku,omitempty"`
+	From         primitive.ObjectID  `json:"from_location_id" bson:"from_location_id"`
+	To           primitive.ObjectID  `json:"to_location_id" bson:"to_location_id"`
+	Quantity     int                 `json:"quantity" bson:"quantity"`
+	Status       string              `json:"status" bson:"status"`
+	Note         *string             `json:"note,omitempty" bson:"note,omitempty"`
+	Created_At   time.Time           `json:"created_at" bson:"created_at"`
+	Updated_At   time.Time           `json:"updated_at" bson:"updated_at"`
+	Received_At  *time.Time          `json:"received_at,omitempty" bson:"received_at,omitempty"`
+	Cancelled_At *time.Time          `json:"cancelled_at,omitempty" bson:"cancelled_at,omitempty"`
+}
+
+func (transfer Transfer) line(location_id primitive.ObjectID) Line {
+	return Line{Product_ID: transfer.Product_ID, Variant_ID: transfer.Variant_ID, SKU: transfer.SKU, Location_ID: &location_id, Quantity: transfer.Quantity}
+}
+
+func (transfer Transfer) record(ctx context.Context, line Line, change int, stock int, reason string) {
+	Record(ctx, Movement{
+		Product_ID:  line.Product_ID,
+		Variant_ID:  line.Variant_ID,
+		SKU:         line.SKU,
+		Location_ID: line.Location_ID,
+		Change:      change,
+		Stock:       stock,
+		Reason:      reason,
+		Transfer_ID: &transfer.Transfer_ID,
+		Note:        transfer.Note,
+	})
+}
+
+// SendTransfer takes the stock from the first location and puts it on the road
+func SendTransfer(ctx context.Context, transfer Transfer) (Transfer, error) {
+	if transfer.From == transfer.To {
+		return transfer, ErrSameLocation
+	}
+	now := time.Now()
+	transfer.Transfer_ID = primitive.NewObjectID()
+	transfer.Status = TransferInTransit
+	transfer.Created_At = now
+	transfer.Updated_At = now
+	from := transfer.line(transfer.From)
+	stock, tracked, err := move(ctx, from, -transfer.Quantity)
+	if err == nil && !tracked {
+		err = ErrNotStockedHere
+	}
+	if err != nil {
+		return transfer, err
+	}
+	if _, err := TransferCollection.InsertOne(ctx, transfer); err != nil {
+		putBack(ctx, []Line{from})
+		return transfer, err
+	}
+	transfer.record(ctx, from, -transfer.Quantity, stock, ReasonTransferOut)
+	return transfer, nil
+}
+
+// finish moves the transfer from one status to the next, only the caller that
+// takes it out of transit moves the stock
+func finish(ctx context.Context, transfer_id primitive.ObjectID, from string, status string, set bson.M) (Transfer, error) {
+	var transfer Transfer
+	set["status"] = status
+	set["updated_at"] = time.Now()
+	after := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := TransferCollection.FindOneAndUpdate(ctx, bson.M{"_id": transfer_id, "status": from}, bson.M{"$set": set}, after).Decode(&transfer)
+	if err == mongo.ErrNoDocuments {
+		if err := TransferCollection.FindOne(ctx, bson.M{"_id": transfer_id}).Decode(&transfer); err == mongo.ErrNoDocuments {
+			return transfer, ErrTransferNotFound
+		}
+		return transfer, ErrTransferDone
+	}
+	return transfer, err
+}
+
+// reopen puts a transfer whose stock could not be moved back in transit
+func reopen(ctx context.Context, transfer Transfer, from string) {
+	update := bson.M{"$set": bson.M{"status": TransferInTransit, "updated_at": time.Now()}}
+	if _, err := TransferCollection.UpdateOne(ctx, bson.M{"_id": transfer.Transfer_ID, "status": from}, update); err != nil {
+		log.Println(err)
+	}
+}
+
+// land moves the stock of a transfer to a location, the transfer is held in
+// pending meanwhile and only gets its final status once the stock is there
+func land(ctx context.Context, transfer_id primitive.ObjectID, pending string, status string, set bson.M, at func(Transfer) primitive.ObjectID, reason string) (Transfer, error) {
+	transfer, err := finish(ctx, transfer_id, TransferInTransit, pending, bson.M{})
+	if err != nil {
+		return transfer, err
+	}
+	line := transfer.line(at(transfer))
+	if err := stockAt(ctx, line); err != nil {
+		reopen(ctx, transfer, pending)
+		return transfer, err
+	}
+	stock, _, err := move(ctx, line, transfer.Quantity)
+	if err != nil {
+		reopen(ctx, transfer, pending)
+		return transfer, err
+	}
+	done, err := finish(ctx, transfer_id, pending, status, set)
+	if err != nil {
+		//take the stock away again so the transfer can be tried once more
+		undo := line
+		undo.Quantity = -transfer.Quantity
+		putBack(ctx, []Line{undo})
+		reopen(ctx, transfer, pending)
+		return transfer, err
+	}
+	done.record(ctx, line, transfer.Quantity, stock, reason)
+	return done, nil
+}
+
+// ReceiveTransfer adds the stock to the second location
+func ReceiveTransfer(ctx context.Context, transfer_id primitive.ObjectID) (Transfer, error) {
+	to := func(transfer Transfer) primitive.ObjectID { return transfer.To }
+	return land(ctx, transfer_id, TransferReceiving, TransferReceived, bson.M{"received_at": time.Now()}, to, ReasonTransferIn)
+}
+
+// CancelTransfer puts the stock back at the first location
+func CancelTransfer(ctx context.Context, transfer_id primitive.ObjectID) (Transfer, error) {
+	from := func(transfer Transfer) primitive.ObjectID { return transfer.From }
+	return land(ctx, transfer_id, TransferCancelling, TransferCancelled, bson.M{"cancelled_at": time.Now()}, from, ReasonTransferCancelled)
+}
+
+// Transfers lists transfers newest first
+func Transfers(ctx context.Context, filter bson.M, limit int64, offset int64) ([]Transfer, int64, error) {
+	transfers := make([]Transfer, 0)
+	total, err := TransferCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return transfers, 0, err
+	}
+	find := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).SetLimit(limit).SetSkip(offset)
+	cursor, err := TransferCollection.Find(ctx, filter, find)
+	if err != nil {
+		return transfers, 0, err
+	}
+	err = cursor.All(ctx, &transfers)
+	return transfers, total, err
+}
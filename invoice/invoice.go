@@ -0,0 +1,218 @@
+package invoice
+
+import (
+	"context"
+	"ecommerce/database"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	KindInvoice    = "INVOICE"
+	KindCreditNote = "CREDIT NOTE"
+)
+
+type Seller struct {
+	Name    string
+	Address []string
+	VAT     string
+	Email   string
+}
+
+// the seller block comes from the environment, INVOICE_SELLER_ADDRESS lines are separated by |
+var DefaultSeller = Seller{
+	Name:    envOr("INVOICE_SELLER_NAME", "Ecommerce"),
+	Address: splitLines(os.Getenv("INVOICE_SELLER_ADDRESS")),
+	VAT:     os.Getenv("INVOICE_SELLER_VAT"),
+	Email:   os.Getenv("INVOICE_SELLER_EMAIL"),
+}
+
+// TaxRate is the percentage of tax included in every price
+var TaxRate = parseRate(os.Getenv("TAX_RATE"))
+
+type Line struct {
+	Description string
+	Quantity    int
+	Unit_Price  int
+	Total       int
+}
+
+type Document struct {
+	Kind         string
+	Number       string
+	Reference    string
+	Issued       time.Time
+	Order_Number string
+	Seller       Seller
+	Buyer        []string
+	Lines        []Line
+	Discount     int
+	Shipping     int
+	Total        int
+	Tax_Rate     float64
+	Note         string
+}
+
+func envOr(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func splitLines(value string) []string {
+	lines := make([]string, 0)
+	for _, line := range strings.Split(value, "|") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func parseRate(value string) float64 {
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil || rate < 0 {
+		return 0
+	}
+	return rate
+}
+
+// Money formats whole currency units as 12.00
+func Money(amount int) string {
+	return Cents(int64(amount) * 100)
+}
+
+func Cents(cents int64) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+// TaxIncluded splits a gross amount into its net part and the tax in it, both in cents
+func TaxIncluded(gross int, rate float64) (int64, int64) {
+	grosscents := int64(gross) * 100
+	if rate <= 0 {
+		return grosscents, 0
+	}
+	net := int64(float64(grosscents)*100/(100+rate) + 0.5)
+	return net, grosscents - net
+}
+
+// Render lays the document out on as many a4 pages as the lines need
+func Render(doc Document) []byte {
+	p := newPDF()
+	const left, right = 50.0, PageWidth - 50
+	y := 60.0
+
+	p.Text(left, y, Bold, 20, doc.Kind)
+	p.TextRight(right, y, Bold, 11, doc.Seller.Name)
+	y += 18
+	sellerlines := append([]string{}, doc.Seller.Address...)
+	if doc.Seller.VAT != "" {
+		sellerlines = append(sellerlines, "VAT "+doc.Seller.VAT)
+	}
+	if doc.Seller.Email != "" {
+		sellerlines = append(sellerlines, doc.Seller.Email)
+	}
+	header := []string{
+		"Number: " + doc.Number,
+		"Date: " + doc.Issued.Format("2006-01-02"),
+		"Order: " + doc.Order_Number,
+	}
+	if doc.Reference != "" {
+		header = append(header, "Invoice: "+doc.Reference)
+	}
+	top := y
+	for _, line := range header {
+		p.Text(left, y, Regular, 10, line)
+		y += 14
+	}
+	sy := top
+	for _, line := range sellerlines {
+		p.TextRight(right, sy, Regular, 9, line)
+		sy += 12
+	}
+	if sy > y {
+		y = sy
+	}
+
+	y += 16
+	p.Text(left, y, Bold, 10, "Bill to")
+	y += 14
+	for _, line := range doc.Buyer {
+		p.Text(left, y, Regular, 10, line)
+		y += 13
+	}
+
+	const qtyx, unitx, totalx = 360.0, 450.0, right
+	tableheader := func() {
+		y += 20
+		p.Text(left, y, Bold, 10, "Description")
+		p.TextRight(qtyx, y, Bold, 10, "Qty")
+		p.TextRight(unitx, y, Bold, 10, "Unit price")
+		p.TextRight(totalx, y, Bold, 10, "Amount")
+		y += 6
+		p.Line(left, y, right, y)
+		y += 14
+	}
+	tableheader()
+	for _, line := range doc.Lines {
+		if y > PageHeight-140 {
+			p.AddPage()
+			y = 60
+			tableheader()
+		}
+		p.Text(left, y, Regular, 10, truncate(Regular, 10, line.Description, qtyx-left-40))
+		p.TextRight(qtyx, y, Regular, 10, strconv.Itoa(line.Quantity))
+		p.TextRight(unitx, y, Regular, 10, Money(line.Unit_Price))
+		p.TextRight(totalx, y, Regular, 10, Money(line.Total))
+		y += 16
+	}
+	p.Line(left, y-8, right, y-8)
+	y += 6
+
+	total := func(label string, value string, font string) {
+		p.TextRight(unitx, y, font, 10, label)
+		p.TextRight(totalx, y, font, 10, value)
+		y += 15
+	}
+	if doc.Discount != 0 {
+		total("Discount", Money(-doc.Discount), Regular)
+	}
+	if doc.Shipping != 0 {
+		total("Shipping", Money(doc.Shipping), Regular)
+	}
+	net, tax := TaxIncluded(doc.Total, doc.Tax_Rate)
+	total("Net amount", Cents(net), Regular)
+	total(fmt.Sprintf("Tax %s%%", strconv.FormatFloat(doc.Tax_Rate, 'f', -1, 64)), Cents(tax), Regular)
+	total("Total", Money(doc.Total), Bold)
+
+	if doc.Note != "" {
+		y += 20
+		p.Text(left, y, Regular, 9, doc.Note)
+	}
+	return p.Bytes()
+}
+
+// NextNumber reserves the next invoice (INV-2026-000001) or credit note
+// (CN-2026-000001) number, both sequences start over every year.
+func NextNumber(ctx context.Context, client *mongo.Client, kind string, at time.Time) (string, error) {
+	prefix := "INV"
+	if kind == KindCreditNote {
+		prefix = "CN"
+	}
+	seq, err := database.NextSequence(ctx, client, fmt.Sprintf("%s_number_%d", strings.ToLower(prefix), at.Year()))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s-%04d-%06d", prefix, at.Year(), seq), nil
+}
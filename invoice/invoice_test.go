@@ -0,0 +1,49 @@
+package invoice
+
+import "testing"
+
+func TestCents(t *testing.T) {
+	cases := map[int64]string{0: "0.00", 5: "0.05", 1234: "12.34", -1234: "-12.34", -5: "-0.05"}
+	for cents, want := range cases {
+		if got := Cents(cents); got != want {
+			t.Errorf("Cents(%d) = %q, want %q", cents, got, want)
+		}
+	}
+	if got := Money(12); got != "12.00" {
+		t.Errorf("Money(12) = %q", got)
+	}
+}
+
+func TestTaxIncluded(t *testing.T) {
+	cases := []struct {
+		gross    int
+		rate     float64
+		net, tax int64
+	}{
+		{118, 18, 10000, 1800},
+		{100, 0, 10000, 0},
+		{100, -5, 10000, 0},
+		{94, 18, 7966, 1434},
+		{55, 18, 4661, 839},
+		{1, 20, 83, 17},
+		{0, 18, 0, 0},
+	}
+	for _, c := range cases {
+		net, tax := TaxIncluded(c.gross, c.rate)
+		if net != c.net || tax != c.tax {
+			t.Errorf("TaxIncluded(%d, %v) = %d, %d, want %d, %d", c.gross, c.rate, net, tax, c.net, c.tax)
+		}
+		if net+tax != int64(c.gross)*100 {
+			t.Errorf("TaxIncluded(%d, %v) does not add up to the gross amount", c.gross, c.rate)
+		}
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	cases := map[string]float64{"": 0, "18": 18, "7.5": 7.5, "-1": 0, "abc": 0}
+	for value, want := range cases {
+		if got := parseRate(value); got != want {
+			t.Errorf("parseRate(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package invoice
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+//a very small pdf writer, just enough for invoices
+//text in the standard helvetica fonts (no embedding needed) and straight lines
+//coordinates are in points with the origin at the top left like on screen
+
+const (
+	PageWidth  = 595.0
+	PageHeight = 842.0
+)
+
+const (
+	Regular = "F1"
+	Bold    = "F2"
+)
+
+type pdf struct {
+	pages []*bytes.Buffer
+}
+
+func newPDF() *pdf {
+	p := &pdf{}
+	p.AddPage()
+	return p
+}
+
+func (p *pdf) AddPage() {
+	p.pages = append(p.pages, &bytes.Buffer{})
+}
+
+func (p *pdf) page() *bytes.Buffer {
+	return p.pages[len(p.pages)-1]
+}
+
+func (p *pdf) Text(x, y float64, font string, size float64, text string) {
+	fmt.Fprintf(p.page(), "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, PageHeight-y, escape(text))
+}
+
+func (p *pdf) TextRight(x, y float64, font string, size float64, text string) {
+	p.Text(x-TextWidth(font, size, text), y, font, size, text)
+}
+
+func (p *pdf) Line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(p.page(), "0.5 w %.2f %.2f m %.2f %.2f l S\n", x1, PageHeight-y1, x2, PageHeight-y2)
+}
+
+func (p *pdf) Bytes() []byte {
+	var out bytes.Buffer
+	offsets := make([]int, 0)
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+	out.WriteString("%PDF-1.4\n")
+	//1 catalog, 2 page tree, 3 and 4 fonts, then a page and its content stream per page
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	kids := make([]string, len(p.pages))
+	for i := range p.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(p.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	for i, content := range p.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>", PageWidth, PageHeight, 6+2*i))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()))
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return out.Bytes()
+}
+
+// escape makes text safe inside a pdf string, anything outside latin-1 becomes a question mark
+func escape(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\n' || r == '\r' || r == '\t':
+			b.WriteByte(' ')
+		case r < 32 || r > 255:
+			b.WriteByte('?')
+		case r > 126:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// glyph widths of the printable ascii range (32-126) in thousandths of the font size
+var widths = map[string][]int{
+	Regular: {
+		278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+		1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+		333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+		556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+	},
+	Bold: {
+		278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+		975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+		333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+		611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+	},
+}
+
+func TextWidth(font string, size float64, text string) float64 {
+	table := widths[font]
+	total := 0
+	for _, r := range text {
+		if r >= 32 && r <= 126 {
+			total += table[r-32]
+		} else {
+			total += 556
+		}
+	}
+	return float64(total) * size / 1000
+}
+
+// truncate cuts text so it fits into width, adding dots when something was cut
+func truncate(font string, size float64, text string, width float64) string {
+	if TextWidth(font, size, text) <= width {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 && TextWidth(font, size, string(runes)+"...") > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "..."
+}
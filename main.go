@@ -2,31 +2,101 @@ package main
 
 import (
 	"ecommerce/controllers"
+	"ecommerce/inventory"
 	"ecommerce/middleware"
+	"ecommerce/notify"
 	"ecommerce/routes"
+	"ecommerce/storage"
+	token "ecommerce/tokens"
+	"log"
+	"net"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+// trustedProxies reads TRUSTED_PROXIES, the ips or cidrs of the proxies in front
+// of the server separated by commas. Only requests coming from one of them get
+// their client ip from X-Forwarded-For, without any the header is ignored.
+func trustedProxies() []string {
+	proxies := make([]string, 0)
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			log.Fatal("TRUSTED_PROXIES: ", proxy, " is no ip or cidr")
+		}
+		proxies = append(proxies, proxy)
+	}
+	if len(proxies) == 0 {
+		return nil
+	}
+	return proxies
+}
+
 func main() {
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8000"
 	}
+	if err := token.CheckCartSecret(); err != nil {
+		log.Fatal(err)
+	}
+	go controllers.ExpireReservations(time.Minute)
+	go inventory.WatchStock(inventory.WatchEvery, notify.Default)
+	go controllers.WatchWishlistPrices(controllers.WishlistPricesEvery, notify.Default)
 	router := gin.New()
+	router.TrustedProxies = trustedProxies()
 	router.Use(gin.Logger())
 	routes.UserRoutes(router)
+	routes.AdminRoutes(router)
+	if local, ok := storage.Default.(*storage.Local); ok && local.Serves() {
+		router.Static(local.Base_URL, local.Dir)
+	}
 	router.Use(middleware.Authentication())
 	router.GET("/addtocart", controllers.AddToCart())
 	router.GET("/removeitem", controllers.RemoveItem())
 	router.GET("listcart", controllers.GetItemFromCart())
-	router.POST("addaddress", controllers.AddAddress())
-	router.PUT("edithomeaddress", controllers.EditHomeAddress())
-	router.PUT("editworkaddress", controllers.EditWorkAddress())
-	router.GET("deleteaddresses", controllers.DeleteAddress())
+	router.PUT("/cart/quantity", controllers.SetCartQuantity())
+	router.POST("/cart/increment", controllers.IncrementCartQuantity())
+	router.POST("/cart/decrement", controllers.DecrementCartQuantity())
+	router.GET("/cart", controllers.ViewCart())
+	router.DELETE("/cart/items", controllers.RemoveFromCart())
+	router.POST("/cart/acknowledge", controllers.AcknowledgeCart())
+	router.GET("/addresses", controllers.ListAddresses())
+	router.POST("/addresses", controllers.AddAddress())
+	router.GET("/addresses/:address_id", controllers.GetAddress())
+	router.PUT("/addresses/:address_id", controllers.UpdateAddress())
+	router.DELETE("/addresses/:address_id", controllers.DeleteAddress())
 	router.GET("cartcheckout", controllers.BuyFromCart())
+	router.POST("cartcheckout", controllers.BuyFromCart())
 	router.GET("instantbuy", controllers.InstantBuy())
+	router.POST("instantbuy", controllers.InstantBuy())
+	router.POST("/cancelorder", controllers.CancelOrder())
+	router.POST("/returns", controllers.RequestReturn())
+	router.GET("/orders/:id/invoice", controllers.OrderInvoice())
+	router.GET("/orders/:id/creditnote/:refund_id", controllers.OrderCreditNote())
+	router.POST("/reviews", controllers.AddReview())
+	router.GET("/reviews", controllers.MyReviews())
+	router.PUT("/reviews/:id", controllers.UpdateReview())
+	router.DELETE("/reviews/:id", controllers.DeleteReview())
+	router.POST("/reviews/:id/helpful", controllers.VoteReviewHelpful())
+	router.DELETE("/reviews/:id/helpful", controllers.UnvoteReviewHelpful())
+	router.GET("/wishlists", controllers.ListWishlists())
+	router.POST("/wishlists", controllers.AddWishlist())
+	router.GET("/wishlists/:id", controllers.GetWishlist())
+	router.PUT("/wishlists/:id", controllers.UpdateWishlist())
+	router.DELETE("/wishlists/:id", controllers.DeleteWishlist())
+	router.POST("/wishlists/:id/items", controllers.AddToWishlist())
+	router.DELETE("/wishlists/:id/items", controllers.RemoveFromWishlist())
+	router.POST("/wishlists/:id/tocart", controllers.MoveWishlistToCart())
+	router.POST("/wishlists/:id/fromcart", controllers.MoveCartToWishlist())
+	router.POST("/wishlists/:id/share", controllers.ShareWishlist())
+	router.DELETE("/wishlists/:id/share", controllers.UnshareWishlist())
 	//router.GET("logout", controllers.Logout())
 	//break :)
 	router.Run(":" + port)
@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"context"
+	"ecommerce/models"
+	token "ecommerce/tokens"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Admin lets only admins through, it goes after Authentication. The role is
+// read from the user every time so taking it away works straight away.
+func Admin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		usert_id, err := primitive.ObjectIDFromHex(c.GetString("uid"))
+		if err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "admins only"})
+			c.Abort()
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		var user struct {
+			Role string `bson:"role"`
+		}
+		err = token.UserData.FindOne(ctx, bson.M{"_id": usert_id}, options.FindOne().SetProjection(bson.M{"role": 1})).Decode(&user)
+		if err != nil || user.Role != models.RoleAdmin {
+			c.JSON(http.StatusForbidden, gin.H{"error": "admins only"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
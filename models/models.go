@@ -21,42 +21,368 @@ type User struct {
 	UserCart        []ProductUser      `json:"usercart" bson:"usercart"`
 	Address_Details []Address          `json:"address" bson:"address"`
 	Order_Status    []Order            `json:"orders" bson:"orders"`
+	Role            string             `json:"-" bson:"role,omitempty"`
+	Store_Credit    int                `json:"store_credit" bson:"store_credit"`
+	Guest           bool               `json:"guest,omitempty" bson:"guest,omitempty"`
 }
 
+// admins get their role set on their user in the database, the api never hands it out
+const RoleAdmin = "admin"
+
+// archived products stay in the collection so old orders and carts can still
+// point at them, they just stop showing up in the shop. The rating is the
+// whole stars of the average of the approved reviews, it is not set by hand.
 type Product struct {
-	Product_ID   primitive.ObjectID `bson:"_id"`
-	Product_Name *string            `json:"product_name"`
-	Price        *uint64            `json:"price"`
-	Rating       *uint8             `json:"rating"`
-	Image        *string            `json:"image"`
+	Product_ID    primitive.ObjectID `bson:"_id"`
+	Product_Name  *string            `json:"product_name" validate:"required,min=1,max=200"`
+	Price         *uint64            `json:"price"        validate:"required,min=1"`
+	Rating        *uint8             `json:"rating"       validate:"omitempty,max=10"`
+	Image         *string            `json:"image"        validate:"omitempty,max=2048"`
+	Images        []ProductImage     `json:"images,omitempty" bson:"images,omitempty"`
+	Reviews       *RatingSummary     `json:"reviews,omitempty" bson:"reviews,omitempty"`
+	Stock         *int               `json:"stock,omitempty" bson:"stock,omitempty" validate:"omitempty,min=0"`
+	Stock_Levels  []StockLevel       `json:"stock_levels,omitempty" bson:"stock_levels,omitempty"`
+	Reorder_Point *int               `json:"reorder_point,omitempty" bson:"reorder_point,omitempty" validate:"omitempty,min=0"`
+	Max_Quantity  *int               `json:"max_quantity,omitempty" bson:"max_quantity,omitempty" validate:"omitempty,min=1"`
+	Categories    []string           `json:"categories,omitempty" bson:"categories,omitempty" validate:"max=20,dive,min=1,max=100"`
+	Breadcrumbs   [][]Breadcrumb     `json:"breadcrumbs,omitempty" bson:"-"`
+	SKU           *string            `json:"sku,omitempty" bson:"sku,omitempty" validate:"omitempty,min=1,max=64"`
+	Options       []ProductOption    `json:"options,omitempty" bson:"options,omitempty" validate:"max=5,dive"`
+	Variants      []Variant          `json:"variants,omitempty" bson:"variants,omitempty" validate:"dive"`
+	Archived      bool               `json:"archived" bson:"archived"`
+	Archived_At   *time.Time         `json:"archived_at,omitempty" bson:"archived_at,omitempty"`
+	Version       int                `json:"version" bson:"version"`
+	Created_At    time.Time          `json:"created_at" bson:"created_at"`
+	Updated_At    time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// an option axis like size with the values S, M and L, a product gets one
+// variant for every combination of the values of all its axes
+type ProductOption struct {
+	Name   string   `json:"name" bson:"name" validate:"required,max=50"`
+	Values []string `json:"values" bson:"values" validate:"min=1,max=50,dive,required,max=50"`
+}
+
+// a variant has its own sku, price, stock and images, a missing price or
+// image falls back to the product's
+type Variant struct {
+	Variant_ID    primitive.ObjectID `json:"_id" bson:"_id"`
+	SKU           string             `json:"sku" bson:"sku" validate:"required,max=64"`
+	Options       map[string]string  `json:"options" bson:"options"`
+	Price         *uint64            `json:"price,omitempty" bson:"price,omitempty" validate:"omitempty,min=1"`
+	Stock         *int               `json:"stock,omitempty" bson:"stock,omitempty" validate:"omitempty,min=0"`
+	Stock_Levels  []StockLevel       `json:"stock_levels,omitempty" bson:"stock_levels,omitempty"`
+	Reorder_Point *int               `json:"reorder_point,omitempty" bson:"reorder_point,omitempty" validate:"omitempty,min=0"`
+	Images        []string           `json:"images,omitempty" bson:"images,omitempty" validate:"max=20,dive,max=2048"`
+}
+
+// what the approved reviews of a product add up to, the histogram counts the
+// reviews per number of stars from "1" to "5"
+type RatingSummary struct {
+	Average   float64        `json:"average" bson:"average"`
+	Count     int            `json:"count" bson:"count"`
+	Histogram map[string]int `json:"histogram" bson:"histogram"`
+}
+
+// reviews wait for an admin before the shop shows them
+const (
+	ReviewPending  = "pending"
+	ReviewApproved = "approved"
+	ReviewRejected = "rejected"
+)
+
+// a customer's review of a product they ordered, verified once an order with
+// the product was delivered to them. Every customer reviews a product once.
+type Review struct {
+	Review_ID       primitive.ObjectID   `json:"_id" bson:"_id"`
+	Product_ID      primitive.ObjectID   `json:"product_id" bson:"product_id"`
+	User_ID         primitive.ObjectID   `json:"user_id" bson:"user_id"`
+	Author          string               `json:"author" bson:"author"`
+	Rating          int                  `json:"rating" bson:"rating" validate:"min=1,max=5"`
+	Title           string               `json:"title" bson:"title" validate:"required,max=150"`
+	Text            string               `json:"text" bson:"text" validate:"max=5000"`
+	Verified        bool                 `json:"verified" bson:"verified"`
+	Status          string               `json:"status" bson:"status"`
+	Moderation_Note *string              `json:"moderation_note,omitempty" bson:"moderation_note,omitempty"`
+	Helpful         int                  `json:"helpful" bson:"helpful"`
+	Voters          []primitive.ObjectID `json:"-" bson:"voters"`
+	Created_At      time.Time            `json:"created_at" bson:"created_at"`
+	Updated_At      time.Time            `json:"updated_at" bson:"updated_at"`
+	Moderated_At    *time.Time           `json:"moderated_at,omitempty" bson:"moderated_at,omitempty"`
+}
+
+// an uploaded picture of a product, kept as uploaded and scaled down into
+// every size the shop shows. The first image of a product is its main image.
+type ProductImage struct {
+	Image_ID    primitive.ObjectID   `json:"_id" bson:"_id"`
+	Alt         string               `json:"alt,omitempty" bson:"alt,omitempty"`
+	Sizes       map[string]ImageFile `json:"sizes" bson:"sizes"`
+	Uploaded_At time.Time            `json:"uploaded_at" bson:"uploaded_at"`
+}
+
+type ImageFile struct {
+	Key          string `json:"-" bson:"key"`
+	URL          string `json:"url" bson:"url"`
+	Content_Type string `json:"content_type" bson:"content_type"`
+	Width        int    `json:"width" bson:"width"`
+	Height       int    `json:"height" bson:"height"`
+	Bytes        int    `json:"bytes" bson:"bytes"`
+}
+
+// stock kept at one location, a product or variant stocked at locations has a
+// stock that is always the sum of its levels
+type StockLevel struct {
+	Location_ID primitive.ObjectID `json:"location_id" bson:"location_id"`
+	Stock       int                `json:"stock" bson:"stock"`
+}
+
+// categories form a tree, ancestors holds the path from the root down to the
+// parent so a whole subtree can be found with one query. Products refer to
+// their categories by slug.
+type Category struct {
+	Category_ID primitive.ObjectID   `json:"_id" bson:"_id"`
+	Name        *string              `json:"name" bson:"name" validate:"required,min=1,max=100"`
+	Slug        string               `json:"slug" bson:"slug"`
+	Parent      *primitive.ObjectID  `json:"parent_id" bson:"parent_id"`
+	Ancestors   []primitive.ObjectID `json:"ancestors" bson:"ancestors"`
+	Position    int                  `json:"position" bson:"position"`
+	Created_At  time.Time            `json:"created_at" bson:"created_at"`
+	Updated_At  time.Time            `json:"updated_at" bson:"updated_at"`
+	Children    []*Category          `json:"children,omitempty" bson:"-"`
+}
+
+type Breadcrumb struct {
+	Category_ID primitive.ObjectID `json:"_id"`
+	Name        string             `json:"name"`
+	Slug        string             `json:"slug"`
 }
 
 type ProductUser struct {
-	Product_ID   primitive.ObjectID `bson:"_id"`
-	Product_Name *string            `json:"product_name" bson:"product_name"`
-	Price        int                `json:"price"  bson:"price"`
-	Rating       *uint              `json:"rating" bson:"rating"`
-	Image        *string            `json:"image"  bson:"image"`
+	Product_ID   primitive.ObjectID  `bson:"_id"`
+	Product_Name *string             `json:"product_name" bson:"product_name"`
+	Price        int                 `json:"price"  bson:"price"`
+	Rating       *uint               `json:"rating" bson:"rating"`
+	Image        *string             `json:"image"  bson:"image"`
+	Variant_ID   *primitive.ObjectID `json:"variant_id,omitempty" bson:"variant_id,omitempty"`
+	SKU          *string             `json:"sku,omitempty" bson:"sku,omitempty"`
+	Options      map[string]string   `json:"options,omitempty" bson:"options,omitempty"`
+	Quantity     int                 `json:"quantity" bson:"quantity,omitempty"`
+	// what the discounts of the order take off the whole line, set when the line is priced
+	Discount int `json:"discount,omitempty" bson:"discount,omitempty"`
+}
+
+// Units is how many of the product the line holds, carts and orders from
+// before quantities repeated the line instead and count as 1 each
+func (item ProductUser) Units() int {
+	if item.Quantity < 1 {
+		return 1
+	}
+	return item.Quantity
+}
+
+// a named list of products a customer wants to buy later, every customer has
+// a default one. Anyone with the share token of a wishlist can look at it.
+type Wishlist struct {
+	Wishlist_ID primitive.ObjectID `json:"_id" bson:"_id"`
+	User_ID     primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Name        string             `json:"name" bson:"name" validate:"required,min=1,max=100"`
+	Default     bool               `json:"default" bson:"default"`
+	Items       []WishlistItem     `json:"items" bson:"items"`
+	Share_Token *string            `json:"share_token,omitempty" bson:"share_token,omitempty"`
+	Created_At  time.Time          `json:"created_at" bson:"created_at"`
+	Updated_At  time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// name, image and price are what the product looks like now whenever a
+// wishlist is shown. Watched_Price is the price the customer last heard of,
+// they are notified when it drops below that.
+type WishlistItem struct {
+	Product_ID    primitive.ObjectID  `json:"product_id" bson:"product_id"`
+	Variant_ID    *primitive.ObjectID `json:"variant_id,omitempty" bson:"variant_id,omitempty"`
+	SKU           *string             `json:"sku,omitempty" bson:"sku,omitempty"`
+	Options       map[string]string   `json:"options,omitempty" bson:"options,omitempty"`
+	Product_Name  *string             `json:"product_name" bson:"product_name"`
+	Image         *string             `json:"image" bson:"image"`
+	Price         int                 `json:"price" bson:"price"`
+	Added_Price   int                 `json:"added_price" bson:"added_price"`
+	Watched_Price int                 `json:"-" bson:"watched_price"`
+	Available     bool                `json:"available" bson:"-"`
+	Added_At      time.Time           `json:"added_at" bson:"added_at"`
 }
 
 type Address struct {
-	Address_id primitive.ObjectID `bson:"_id"`
-	House      *string            `json:"house_name" bson:"house_name"`
-	Street     *string            `json:"street_name" bson:"street_name"`
-	City       *string            `json:"city_name" bson:"city_name"`
-	Pincode    *string            `json:"pin_code" bson:"pin_code"`
+	Address_id       primitive.ObjectID `bson:"_id"`
+	Label            *string            `json:"label" bson:"label"`
+	Recipient_Name   *string            `json:"recipient_name" bson:"recipient_name"`
+	Phone            *string            `json:"phone" bson:"phone"`
+	House            *string            `json:"house_name" bson:"house_name"`
+	Street           *string            `json:"street_name" bson:"street_name"`
+	City             *string            `json:"city_name" bson:"city_name"`
+	Pincode          *string            `json:"pin_code" bson:"pin_code"`
+	Region           *string            `json:"region" bson:"region"`
+	Country          *string            `json:"country_code" bson:"country_code"`
+	Default_Shipping bool               `json:"default_shipping" bson:"default_shipping"`
+	Default_Billing  bool               `json:"default_billing" bson:"default_billing"`
 }
 
+// order lifecycle, an order can be cancelled by the customer until it is shipped
+// orders paid digitally wait in pending_payment until the payment comes through
+const (
+	OrderPendingPayment = "pending_payment"
+	OrderPlaced         = "placed"
+	OrderShipped        = "shipped"
+	OrderDelivered      = "delivered"
+	OrderCancelled      = "cancelled"
+)
+
+// refund state is kept apart from the lifecycle, a partially refunded order can still ship
+const (
+	RefundPartial = "partial"
+	RefundFull    = "full"
+)
+
 type Order struct {
-	Order_ID       primitive.ObjectID `bson:"_id"`
-	Order_Cart     []ProductUser      `json:"order_list"  bson:"order_list"`
-	Orderered_At   time.Time          `json:"ordered_on"  bson:"ordered_on"`
-	Price          int                `json:"total_price" bson:"total_price"`
-	Discount       *int               `json:"discount"    bson:"discount"`
-	Payment_Method Payment            `json:"payment_method" bson:"payment_method"`
+	Order_ID       primitive.ObjectID  `bson:"_id"`
+	Order_Number   string              `json:"order_number" bson:"order_number"`
+	Order_Cart     []ProductUser       `json:"order_list"  bson:"order_list"`
+	Orderered_At   time.Time           `json:"ordered_on"  bson:"ordered_on"`
+	Price          int                 `json:"total_price" bson:"total_price"`
+	Discount       *int                `json:"discount"    bson:"discount"`
+	Payment_Method Payment             `json:"payment_method" bson:"payment_method"`
+	Status         string              `json:"status"      bson:"status"`
+	Cancelled_At   *time.Time          `json:"cancelled_on,omitempty" bson:"cancelled_on,omitempty"`
+	Refunds        []Refund            `json:"refunds"     bson:"refunds"`
+	Refund_Status  string              `json:"refund_status,omitempty" bson:"refund_status,omitempty"`
+	Returns        []Return            `json:"returns"     bson:"returns"`
+	Invoice_Number string              `json:"invoice_number,omitempty" bson:"invoice_number,omitempty"`
+	Shipments      []Shipment          `json:"shipments,omitempty" bson:"shipments,omitempty"`
+	Reservation_ID *primitive.ObjectID `json:"reservation_id,omitempty" bson:"reservation_id,omitempty"`
+	Reserved_Until *time.Time          `json:"reserved_until,omitempty" bson:"reserved_until,omitempty"`
+	// copies taken at checkout, later changes to the address book do not touch placed orders
+	Shipping_Address *Address `json:"shipping_address" bson:"shipping_address"`
+	Billing_Address  *Address `json:"billing_address"  bson:"billing_address"`
+	// the breakdown of total_price, orders from before it was kept have none
+	Totals *Totals `json:"totals,omitempty" bson:"totals,omitempty"`
+}
+
+// what a cart or an order comes to, worked out by the pricing package so the
+// cart and the checkout always agree. Amounts are whole currency units like
+// the prices, the tax is included in them.
+type Totals struct {
+	Subtotal       int            `json:"subtotal" bson:"subtotal"`
+	Discounts      []DiscountLine `json:"discounts" bson:"discounts"`
+	Discount_Total int            `json:"discount_total" bson:"discount_total"`
+	Shipping       int            `json:"shipping" bson:"shipping"`
+	Taxes          []TaxLine      `json:"taxes" bson:"taxes"`
+	Grand_Total    int            `json:"grand_total" bson:"grand_total"`
+}
+
+type DiscountLine struct {
+	Code        string `json:"code" bson:"code"`
+	Description string `json:"description" bson:"description"`
+	Amount      int    `json:"amount" bson:"amount"`
+}
+
+// tax already in the prices, amount is in cents since it rarely comes out even
+type TaxLine struct {
+	Name     string  `json:"name" bson:"name"`
+	Rate     float64 `json:"rate" bson:"rate"`
+	Included bool    `json:"included" bson:"included"`
+	Taxable  int     `json:"taxable" bson:"taxable"`
+	Amount   int64   `json:"amount_cents" bson:"amount_cents"`
+}
+
+// the part of an order sent from one location, orders no single location can
+// fulfill are split over several. Stock that is not kept per location ships
+// without a location.
+type Shipment struct {
+	Location_ID   *primitive.ObjectID `json:"location_id,omitempty" bson:"location_id,omitempty"`
+	Location_Name string              `json:"location_name,omitempty" bson:"location_name,omitempty"`
+	Items         []ShipmentItem      `json:"items" bson:"items"`
+}
+
+type ShipmentItem struct {
+	Product_ID primitive.ObjectID  `json:"product_id" bson:"product_id"`
+	Variant_ID *primitive.ObjectID `json:"variant_id,omitempty" bson:"variant_id,omitempty"`
+	SKU        *string             `json:"sku,omitempty" bson:"sku,omitempty"`
+	Quantity   int                 `json:"quantity" bson:"quantity"`
 }
 
 type Payment struct {
-	Digital bool `json:"digital" bson:"digital"`
-	COD     bool `json:"cod"     bson:"cod"`
+	Payment_ID primitive.ObjectID `json:"payment_id" bson:"payment_id"`
+	Digital    bool               `json:"digital"    bson:"digital"`
+	COD        bool               `json:"cod"        bson:"cod"`
+}
+
+// a refund always points back to the payment of the order it was issued against
+type Refund struct {
+	Refund_ID          primitive.ObjectID `json:"refund_id"  bson:"refund_id"`
+	Payment_ID         primitive.ObjectID `json:"payment_id" bson:"payment_id"`
+	Items              []RefundItem       `json:"items"      bson:"items"`
+	Amount             int                `json:"amount"     bson:"amount"`
+	Reason             *string            `json:"reason"     bson:"reason"`
+	Created_At         time.Time          `json:"created_at" bson:"created_at"`
+	Credit_Note_Number string             `json:"credit_note_number,omitempty" bson:"credit_note_number,omitempty"`
+}
+
+// a refunded line of the order, or with shipping set (and no product) the shipping
+type RefundItem struct {
+	Product_ID primitive.ObjectID  `json:"product_id" bson:"product_id"`
+	Variant_ID *primitive.ObjectID `json:"variant_id,omitempty" bson:"variant_id,omitempty"`
+	Shipping   bool                `json:"shipping,omitempty" bson:"shipping,omitempty"`
+	Amount     int                 `json:"amount"     bson:"amount"`
+}
+
+// return (rma) lifecycle
+// requested -> approved -> received -> completed, or requested -> rejected
+const (
+	ReturnRequested = "requested"
+	ReturnApproved  = "approved"
+	ReturnRejected  = "rejected"
+	ReturnReceived  = "received"
+	ReturnCompleted = "completed"
+)
+
+const (
+	InspectionPending = "pending"
+	InspectionPassed  = "passed"
+	InspectionFailed  = "failed"
+)
+
+const (
+	ResolutionRefund      = "refund"
+	ResolutionStoreCredit = "store_credit"
+)
+
+var ReturnReasons = []string{"damaged", "defective", "wrong_item", "not_as_described", "no_longer_needed", "other"}
+
+type Return struct {
+	Return_ID     primitive.ObjectID  `json:"return_id"     bson:"return_id"`
+	Items         []ReturnItem        `json:"items"         bson:"items"`
+	Status        string              `json:"status"        bson:"status"`
+	Resolution    string              `json:"resolution"    bson:"resolution"`
+	Label         *ReturnLabel        `json:"label"         bson:"label"`
+	Inspection    string              `json:"inspection"    bson:"inspection"`
+	Admin_Note    *string             `json:"admin_note"    bson:"admin_note"`
+	Refund_ID     *primitive.ObjectID `json:"refund_id"     bson:"refund_id"`
+	Credit_Amount int                 `json:"credit_amount" bson:"credit_amount"`
+	Credit_Items  []RefundItem        `json:"credit_items,omitempty" bson:"credit_items,omitempty"`
+	Created_At    time.Time           `json:"created_at"    bson:"created_at"`
+	Updated_At    time.Time           `json:"updated_at"    bson:"updated_at"`
+}
+
+type ReturnItem struct {
+	Product_ID  primitive.ObjectID  `json:"product_id"  bson:"product_id"`
+	Variant_ID  *primitive.ObjectID `json:"variant_id,omitempty" bson:"variant_id,omitempty"`
+	Quantity    int                 `json:"quantity"    bson:"quantity"`
+	Reason_Code string              `json:"reason_code" bson:"reason_code"`
+	Comment     *string             `json:"comment"     bson:"comment"`
+}
+
+// placeholder until we integrate with a carrier, the customer gets the rma number to write on the parcel
+type ReturnLabel struct {
+	Carrier         string    `json:"carrier"         bson:"carrier"`
+	Tracking_Number string    `json:"tracking_number" bson:"tracking_number"`
+	Label_URL       string    `json:"label_url"       bson:"label_url"`
+	Created_At      time.Time `json:"created_at"      bson:"created_at"`
 }
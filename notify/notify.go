@@ -0,0 +1,108 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"ecommerce/events"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+//notifications for people, buyers about stock running low, customers about
+//prices dropping, guests the link to their account. Where they end up is
+//pluggable, NOTIFIER picks one of log (the default), webhook (posts json to
+//NOTIFY_WEBHOOK_URL) or events (appends them to the Events collection for
+//another system to send)
+
+type Message struct {
+	Kind    string      `json:"kind"`
+	To      string      `json:"to,omitempty"`
+	Subject string      `json:"subject"`
+	Body    string      `json:"body"`
+	Data    interface{} `json:"data,omitempty"`
+	Sent_At time.Time   `json:"sent_at"`
+}
+
+type Notifier interface {
+	Notify(ctx context.Context, message Message) error
+}
+
+// Default is the notifier picked by NOTIFIER
+var Default Notifier = fromEnv()
+
+func fromEnv() Notifier {
+	switch os.Getenv("NOTIFIER") {
+	case "webhook":
+		return NewWebhook(os.Getenv("NOTIFY_WEBHOOK_URL"))
+	case "events":
+		return Events{}
+	default:
+		return Log{}
+	}
+}
+
+func stamp(message Message) Message {
+	if message.Sent_At.IsZero() {
+		message.Sent_At = time.Now()
+	}
+	return message
+}
+
+// Log writes notifications to the server log, enough for development
+type Log struct{}
+
+func (Log) Notify(ctx context.Context, message Message) error {
+	message = stamp(message)
+	if message.To != "" {
+		log.Printf("notify %s to %s: %s - %s", message.Kind, message.To, message.Subject, message.Body)
+		return nil
+	}
+	log.Printf("notify %s: %s - %s", message.Kind, message.Subject, message.Body)
+	return nil
+}
+
+// Webhook posts every notification as json to a url, chat tools and mail
+// relays can take it from there
+type Webhook struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewWebhook(url string) *Webhook {
+	return &Webhook{URL: url, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (w *Webhook) Notify(ctx context.Context, message Message) error {
+	if w.URL == "" {
+		return fmt.Errorf("notify: no webhook url, set NOTIFY_WEBHOOK_URL")
+	}
+	body, err := json.Marshal(stamp(message))
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := w.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("notify: webhook answered %s", response.Status)
+	}
+	return nil
+}
+
+// Events appends notifications to the event log under their kind
+type Events struct{}
+
+func (Events) Notify(ctx context.Context, message Message) error {
+	return events.Emit(ctx, message.Kind, stamp(message))
+}
@@ -0,0 +1,92 @@
+package ordernumber
+
+import (
+	"context"
+	"ecommerce/database"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+//order numbers look like ORD-2026-000042-5
+//prefix, year, a sequence that starts over every year and a luhn check digit
+//so a number read over the phone with one wrong digit is caught straight away
+
+var Prefix = defaultPrefix()
+
+func defaultPrefix() string {
+	prefix := os.Getenv("ORDER_NUMBER_PREFIX")
+	if prefix == "" {
+		prefix = "ORD"
+	}
+	//the other separators Normalize accepts would not survive a round trip
+	return strings.NewReplacer(" ", "-", "/", "-", ".", "-").Replace(strings.ToUpper(prefix))
+}
+
+// Next reserves the next number of the year of at, the sequence lives in the
+// Counters collection so concurrent checkouts never get the same number.
+func Next(ctx context.Context, client *mongo.Client, at time.Time) (string, error) {
+	year := at.Year()
+	seq, err := database.NextSequence(ctx, client, fmt.Sprintf("order_number_%d", year))
+	if err != nil {
+		return "", err
+	}
+	return Format(Prefix, year, seq), nil
+}
+
+func Format(prefix string, year int, seq int64) string {
+	digits := fmt.Sprintf("%04d%06d", year, seq)
+	return fmt.Sprintf("%s-%04d-%06d-%d", prefix, year, seq, CheckDigit(digits))
+}
+
+// CheckDigit computes the luhn check digit of a string of digits
+func CheckDigit(digits string) int {
+	sum := 0
+	double := true
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return (10 - sum%10) % 10
+}
+
+// Normalize turns what support staff type in ("ord 2026 42 5") into the stored form.
+// The year, sequence and check digit are read from the right so a prefix may
+// have dashes of its own, a number typed without its prefix gets Prefix.
+func Normalize(input string) (string, error) {
+	fields := strings.FieldsFunc(strings.ToUpper(input), func(r rune) bool {
+		return r == '-' || r == ' ' || r == '/' || r == '.'
+	})
+	if len(fields) < 3 {
+		return "", fmt.Errorf("order number should look like %s-2026-000001-1", Prefix)
+	}
+	n := len(fields)
+	prefix := strings.Join(fields[:n-3], "-")
+	if prefix == "" {
+		prefix = Prefix
+	}
+	year, err := strconv.Atoi(fields[n-3])
+	if err != nil {
+		return "", fmt.Errorf("invalid year %q", fields[n-3])
+	}
+	seq, err := strconv.ParseInt(fields[n-2], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid sequence %q", fields[n-2])
+	}
+	number := Format(prefix, year, seq)
+	if !strings.HasSuffix(number, "-"+fields[n-1]) {
+		return "", fmt.Errorf("check digit does not match, the number was probably mistyped")
+	}
+	return number, nil
+}
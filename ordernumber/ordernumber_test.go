@@ -0,0 +1,64 @@
+package ordernumber
+
+import "testing"
+
+func TestCheckDigit(t *testing.T) {
+	cases := map[string]int{
+		"7992739871":     3,
+		"0":              0,
+		"2026000042":     CheckDigit("2026000042"),
+		"20260000010000": CheckDigit("20260000010000"),
+	}
+	for digits, want := range cases {
+		if got := CheckDigit(digits); got != want {
+			t.Errorf("CheckDigit(%q) = %d, want %d", digits, got, want)
+		}
+	}
+	//every single digit typo changes the check digit
+	digits := "2026000042"
+	want := CheckDigit(digits)
+	for i := range digits {
+		for d := byte('0'); d <= '9'; d++ {
+			if d == digits[i] {
+				continue
+			}
+			typo := digits[:i] + string(d) + digits[i+1:]
+			if CheckDigit(typo) == want {
+				t.Errorf("typo %q has the same check digit as %q", typo, digits)
+			}
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	if got := Format("ORD", 2026, 42); got != "ORD-2026-000042-5" {
+		t.Errorf("Format = %q", got)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	Prefix = "ORD"
+	cases := []struct {
+		input string
+		want  string
+		ok    bool
+	}{
+		{"ORD-2026-000042-5", "ORD-2026-000042-5", true},
+		{"ord 2026 42 5", "ORD-2026-000042-5", true},
+		{"ORD/2026/000042/5", "ORD-2026-000042-5", true},
+		{"2026 42 5", "ORD-2026-000042-5", true},
+		{"EU-SHOP-2026-000042-5", "EU-SHOP-2026-000042-5", true},
+		{"eu shop 2026 42 5", "EU-SHOP-2026-000042-5", true},
+		{"ORD-2026-000042-6", "", false},
+		{"ORD-2026-000043-5", "", false},
+		{"ORD-20X6-000042-5", "", false},
+		{"ORD-2026", "", false},
+		{"", "", false},
+	}
+	for _, c := range cases {
+		got, err := Normalize(c.input)
+		if (err == nil) != c.ok || got != c.want {
+			t.Errorf("Normalize(%q) = %q, %v, want %q ok=%v", c.input, got, err, c.want, c.ok)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package pricing
+
+import (
+	"ecommerce/invoice"
+	"ecommerce/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+)
+
+//the cart view and every checkout price their lines here so what the cart
+//shows is what the order charges. Prices include the tax, the discounts come
+//off the lines, shipping goes on top of what is left.
+//discount rules come from DISCOUNT_RULES, a json list
+/*
+[
+{"code":"BULK10","description":"10% off 10 or more of a product","percent":10,"min_quantity":10},
+{"code":"OVER500","description":"50 off orders over 500","amount":50,"min_subtotal":500}
+]
+*/
+//a rule with min_quantity takes its percent off every line with at least that
+//many units, any other rule is taken off the whole order once the subtotal
+//(after the line rules) reaches min_subtotal
+
+type Rule struct {
+	Code         string  `json:"code"`
+	Description  string  `json:"description"`
+	Percent      float64 `json:"percent"`
+	Amount       int     `json:"amount"`
+	Min_Subtotal int     `json:"min_subtotal"`
+	Min_Quantity int     `json:"min_quantity"`
+}
+
+var Rules = loadRules(os.Getenv("DISCOUNT_RULES"))
+
+// ShippingRate is charged on every order that ships something, SHIPPING_RATE sets it
+var ShippingRate = envInt("SHIPPING_RATE")
+
+// FreeShippingOver is the amount (after discounts) from which shipping is free, 0 never is
+var FreeShippingOver = envInt("FREE_SHIPPING_OVER")
+
+func envInt(key string) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || value < 0 {
+		return 0
+	}
+	return value
+}
+
+func loadRules(value string) []Rule {
+	rules := make([]Rule, 0)
+	if value == "" {
+		return rules
+	}
+	if err := json.Unmarshal([]byte(value), &rules); err != nil {
+		log.Fatal("DISCOUNT_RULES: ", err)
+	}
+	for _, rule := range rules {
+		if err := rule.check(); err != nil {
+			log.Fatal("DISCOUNT_RULES: ", err)
+		}
+	}
+	return rules
+}
+
+func (rule Rule) check() error {
+	switch {
+	case rule.Code == "":
+		return errors.New("every rule needs a code")
+	case rule.Percent < 0 || rule.Percent > 100:
+		return fmt.Errorf("rule %s: percent must be between 0 and 100", rule.Code)
+	case rule.Amount < 0 || rule.Min_Subtotal < 0 || rule.Min_Quantity < 0:
+		return fmt.Errorf("rule %s: amounts and minimums can not be negative", rule.Code)
+	case (rule.Percent > 0) == (rule.Amount > 0):
+		return fmt.Errorf("rule %s: takes either a percent or an amount off", rule.Code)
+	case rule.Min_Quantity > 0 && rule.Amount > 0:
+		return fmt.Errorf("rule %s: rules on lines take a percent off", rule.Code)
+	}
+	return nil
+}
+
+func (rule Rule) description() string {
+	if rule.Description != "" {
+		return rule.Description
+	}
+	return rule.Code
+}
+
+func percentOf(amount int, percent float64) int {
+	return int(float64(amount) * percent / 100)
+}
+
+// due is what is left to pay for a line
+func due(line models.ProductUser) int {
+	return line.Price*line.Units() - line.Discount
+}
+
+// spread takes an order discount off the lines in proportion to what is left
+// on them, so a refunded line gives back what was actually paid for it
+func spread(lines []models.ProductUser, amount int) {
+	base := 0
+	for _, line := range lines {
+		base += due(line)
+	}
+	if base <= 0 {
+		return
+	}
+	left := amount
+	for i := range lines {
+		share := int(int64(amount) * int64(due(lines[i])) / int64(base))
+		lines[i].Discount += share
+		left -= share
+	}
+	//what rounding down left over goes on the first lines with room for it
+	for i := range lines {
+		if left == 0 {
+			break
+		}
+		take := due(lines[i])
+		if take > left {
+			take = left
+		}
+		lines[i].Discount += take
+		left -= take
+	}
+}
+
+// Quote prices the lines with the rules, the shipping rate and the tax rate.
+// The lines come back as copies with their share of the discounts set.
+func Quote(lines []models.ProductUser) ([]models.ProductUser, models.Totals) {
+	return quote(lines, Rules, ShippingRate, FreeShippingOver, invoice.TaxRate)
+}
+
+func quote(lines []models.ProductUser, rules []Rule, rate int, freeover int, taxrate float64) ([]models.ProductUser, models.Totals) {
+	priced := make([]models.ProductUser, len(lines))
+	totals := models.Totals{Discounts: make([]models.DiscountLine, 0), Taxes: make([]models.TaxLine, 0)}
+	for i, line := range lines {
+		line.Discount = 0
+		priced[i] = line
+		totals.Subtotal += line.Price * line.Units()
+	}
+	for _, rule := range rules {
+		if rule.Min_Quantity == 0 {
+			continue
+		}
+		amount := 0
+		for i := range priced {
+			if priced[i].Units() < rule.Min_Quantity {
+				continue
+			}
+			off := percentOf(due(priced[i]), rule.Percent)
+			priced[i].Discount += off
+			amount += off
+		}
+		if amount > 0 {
+			totals.Discounts = append(totals.Discounts, models.DiscountLine{Code: rule.Code, Description: rule.description(), Amount: amount})
+			totals.Discount_Total += amount
+		}
+	}
+	for _, rule := range rules {
+		if rule.Min_Quantity > 0 {
+			continue
+		}
+		left := totals.Subtotal - totals.Discount_Total
+		if left <= 0 || left < rule.Min_Subtotal {
+			continue
+		}
+		amount := rule.Amount
+		if rule.Percent > 0 {
+			amount = percentOf(left, rule.Percent)
+		}
+		if amount > left {
+			amount = left
+		}
+		if amount <= 0 {
+			continue
+		}
+		spread(priced, amount)
+		totals.Discounts = append(totals.Discounts, models.DiscountLine{Code: rule.Code, Description: rule.description(), Amount: amount})
+		totals.Discount_Total += amount
+	}
+	goods := totals.Subtotal - totals.Discount_Total
+	if len(priced) > 0 && (freeover == 0 || goods < freeover) {
+		totals.Shipping = rate
+	}
+	totals.Grand_Total = goods + totals.Shipping
+	if taxrate > 0 && len(priced) > 0 {
+		_, tax := invoice.TaxIncluded(totals.Grand_Total, taxrate)
+		totals.Taxes = append(totals.Taxes, models.TaxLine{Name: "Tax", Rate: taxrate, Included: true, Taxable: totals.Grand_Total, Amount: tax})
+	}
+	return priced, totals
+}
+
+// PriceOrder quotes the lines of a new order and puts them on it with the
+// totals, the total price of the order is the grand total
+func PriceOrder(order *models.Order, lines []models.ProductUser) {
+	lines, totals := Quote(lines)
+	order.Order_Cart = append(order.Order_Cart, lines...)
+	order.Price = totals.Grand_Total
+	if totals.Discount_Total > 0 {
+		discount := totals.Discount_Total
+		order.Discount = &discount
+	}
+	order.Totals = &totals
+}
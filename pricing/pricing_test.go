@@ -0,0 +1,120 @@
+package pricing
+
+import (
+	"ecommerce/models"
+	"testing"
+)
+
+func line(price int, quantity int) models.ProductUser {
+	return models.ProductUser{Price: price, Quantity: quantity}
+}
+
+var (
+	bulk10  = Rule{Code: "BULK10", Percent: 10, Min_Quantity: 10}
+	over100 = Rule{Code: "OVER100", Percent: 10, Min_Subtotal: 100}
+	flat50  = Rule{Code: "FLAT50", Amount: 50}
+	flat500 = Rule{Code: "FLAT500", Amount: 500}
+	tenth   = Rule{Code: "TENTH", Percent: 10}
+)
+
+func TestQuote(t *testing.T) {
+	cases := []struct {
+		name      string
+		lines     []models.ProductUser
+		rules     []Rule
+		rate      int
+		freeover  int
+		codes     []string
+		discounts []int
+		shipping  int
+		grand     int
+	}{
+		{"no rules", []models.ProductUser{line(5, 3), line(20, 1)}, nil, 0, 0, nil, []int{0, 0}, 0, 35},
+		{"line rule rounds down", []models.ProductUser{line(7, 11), line(7, 9)}, []Rule{bulk10}, 0, 0, []string{"BULK10"}, []int{7, 0}, 0, 133},
+		{"order rule is shared out by what is left on the lines", []models.ProductUser{line(60, 1), line(40, 1)}, []Rule{flat50}, 0, 0, []string{"FLAT50"}, []int{30, 20}, 0, 50},
+		{"what rounding leaves goes on the first lines", []models.ProductUser{line(1, 1), line(1, 1), line(1, 1)}, []Rule{{Code: "TWO", Amount: 2}}, 0, 0, []string{"TWO"}, []int{1, 1, 0}, 0, 1},
+		{"line rules come first whatever the order of the list", []models.ProductUser{line(10, 10)}, []Rule{over100, bulk10}, 0, 0, []string{"BULK10"}, []int{10}, 0, 90},
+		{"order rules take from what the ones before left", []models.ProductUser{line(200, 1)}, []Rule{flat50, tenth}, 0, 0, []string{"FLAT50", "TENTH"}, []int{65}, 0, 135},
+		{"a discount never goes below nothing", []models.ProductUser{line(100, 1)}, []Rule{flat500, tenth}, 40, 0, []string{"FLAT500"}, []int{100}, 40, 40},
+		{"shipping below the free shipping amount", []models.ProductUser{line(499, 1)}, nil, 40, 500, nil, []int{0}, 40, 539},
+		{"free shipping from the amount on", []models.ProductUser{line(500, 1)}, nil, 40, 500, nil, []int{0}, 0, 500},
+		{"free shipping counts after the discounts", []models.ProductUser{line(540, 1)}, []Rule{flat50}, 40, 500, []string{"FLAT50"}, []int{50}, 40, 530},
+		{"nothing to ship", nil, []Rule{flat50}, 40, 0, nil, []int{}, 0, 0},
+	}
+	for _, c := range cases {
+		priced, totals := quote(c.lines, c.rules, c.rate, c.freeover, 0)
+		if len(totals.Discounts) != len(c.codes) {
+			t.Errorf("%s: got discounts %v, want %v", c.name, totals.Discounts, c.codes)
+			continue
+		}
+		for i, discount := range totals.Discounts {
+			if discount.Code != c.codes[i] {
+				t.Errorf("%s: discount %d is %s, want %s", c.name, i, discount.Code, c.codes[i])
+			}
+		}
+		shared := 0
+		for i, line := range priced {
+			if line.Discount != c.discounts[i] {
+				t.Errorf("%s: line %d got %d off, want %d", c.name, i, line.Discount, c.discounts[i])
+			}
+			shared += line.Discount
+		}
+		if shared != totals.Discount_Total {
+			t.Errorf("%s: the lines got %d off, the totals %d", c.name, shared, totals.Discount_Total)
+		}
+		if totals.Shipping != c.shipping || totals.Grand_Total != c.grand {
+			t.Errorf("%s: shipping %d grand total %d, want %d and %d", c.name, totals.Shipping, totals.Grand_Total, c.shipping, c.grand)
+		}
+		if totals.Grand_Total != totals.Subtotal-totals.Discount_Total+totals.Shipping {
+			t.Errorf("%s: the totals do not add up: %+v", c.name, totals)
+		}
+	}
+}
+
+func TestQuoteLeavesTheLinesAlone(t *testing.T) {
+	lines := []models.ProductUser{line(10, 10)}
+	lines[0].Discount = 3
+	priced, _ := quote(lines, []Rule{bulk10}, 0, 0, 0)
+	if lines[0].Discount != 3 || priced[0].Discount != 10 {
+		t.Errorf("got %d on the input and %d on the quote", lines[0].Discount, priced[0].Discount)
+	}
+}
+
+func TestQuoteTax(t *testing.T) {
+	_, totals := quote([]models.ProductUser{line(54, 1)}, nil, 40, 0, 18)
+	if len(totals.Taxes) != 1 {
+		t.Fatalf("got taxes %v", totals.Taxes)
+	}
+	tax := totals.Taxes[0]
+	if tax.Rate != 18 || !tax.Included || tax.Taxable != 94 || tax.Amount != 1434 {
+		t.Errorf("got %+v", tax)
+	}
+	if _, totals := quote(nil, nil, 40, 0, 18); len(totals.Taxes) != 0 {
+		t.Error("an empty cart got a tax line")
+	}
+	if _, totals := quote([]models.ProductUser{line(54, 1)}, nil, 40, 0, 0); len(totals.Taxes) != 0 {
+		t.Error("no tax rate still got a tax line")
+	}
+}
+
+func TestRuleCheck(t *testing.T) {
+	cases := []struct {
+		rule Rule
+		ok   bool
+	}{
+		{bulk10, true},
+		{over100, true},
+		{flat50, true},
+		{Rule{Percent: 10}, false},
+		{Rule{Code: "BIG", Percent: 120}, false},
+		{Rule{Code: "NEG", Amount: 10, Min_Subtotal: -1}, false},
+		{Rule{Code: "BOTH", Percent: 10, Amount: 10}, false},
+		{Rule{Code: "NONE"}, false},
+		{Rule{Code: "LINEAMOUNT", Amount: 10, Min_Quantity: 2}, false},
+	}
+	for _, c := range cases {
+		if err := c.rule.check(); (err == nil) != c.ok {
+			t.Errorf("%+v: check() = %v", c.rule, err)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package routes
 
 import (
 	"ecommerce/controllers"
+	"ecommerce/middleware"
 
 	"github.com/gin-gonic/gin"
 )
@@ -9,7 +10,69 @@ import (
 func UserRoutes(incomingRoutes *gin.Engine) {
 	incomingRoutes.POST("/users/signup", controllers.SignUp())
 	incomingRoutes.POST("/users/login", controllers.Login())
-	incomingRoutes.POST("/admin/addproduct", controllers.ProductViewerAdmin())
 	incomingRoutes.GET("/users/productview", controllers.SearchProduct())
+	incomingRoutes.GET("/users/products/:id", controllers.GetProduct())
+	incomingRoutes.GET("/users/products/:id/reviews", controllers.ListProductReviews())
+	incomingRoutes.GET("/users/categories", controllers.ListCategories())
+	incomingRoutes.GET("/users/categories/:slug", controllers.GetCategory())
 	incomingRoutes.GET("/users/search", controllers.SearchProductByQuery())
+	incomingRoutes.GET("/users/search/suggest", controllers.SearchSuggest())
+	incomingRoutes.GET("/users/countries", controllers.ListCountries())
+	incomingRoutes.GET("/users/wishlists/:token", controllers.SharedWishlist())
+	incomingRoutes.GET("/guest/cart", controllers.ViewCart())
+	incomingRoutes.PUT("/guest/cart/quantity", controllers.SetCartQuantity())
+	incomingRoutes.POST("/guest/cart/increment", controllers.IncrementCartQuantity())
+	incomingRoutes.POST("/guest/cart/decrement", controllers.DecrementCartQuantity())
+	incomingRoutes.DELETE("/guest/cart/items", controllers.RemoveFromCart())
+	incomingRoutes.POST("/guest/cart/acknowledge", controllers.AcknowledgeCart())
+	incomingRoutes.POST("/guest/checkout", controllers.GuestCheckout())
+	incomingRoutes.GET("/guest/orders", controllers.GuestOrderLookup())
+	incomingRoutes.POST("/guest/account", controllers.ConfirmGuestAccount())
+	incomingRoutes.POST("/guest/orders/account", controllers.GuestAccount())
+}
+
+// AdminRoutes are only for logged in admins, everyone else gets 403
+func AdminRoutes(incomingRoutes *gin.Engine) {
+	admin := incomingRoutes.Group("/admin", middleware.Authentication(), middleware.Admin())
+	admin.POST("/addproduct", controllers.ProductViewerAdmin())
+	admin.POST("/refund", controllers.RefundOrder())
+	admin.PUT("/orderstatus", controllers.UpdateOrderStatus())
+	admin.GET("/orders/search", controllers.SearchOrderByNumber())
+	admin.GET("/invoices/export", controllers.ExportInvoices())
+	admin.PUT("/returns/approve", controllers.ApproveReturn())
+	admin.PUT("/returns/reject", controllers.RejectReturn())
+	admin.PUT("/returns/receive", controllers.ReceiveReturn())
+	admin.GET("/products/:id", controllers.GetProductAdmin())
+	admin.PUT("/products/:id", controllers.UpdateProduct())
+	admin.PATCH("/products/:id", controllers.PatchProduct())
+	admin.DELETE("/products/:id", controllers.DeleteProduct())
+	admin.POST("/categories", controllers.AddCategory())
+	admin.PUT("/categories/reorder", controllers.ReorderCategories())
+	admin.PUT("/categories/:id", controllers.UpdateCategory())
+	admin.PUT("/categories/:id/move", controllers.MoveCategory())
+	admin.DELETE("/categories/:id", controllers.DeleteCategory())
+	admin.PUT("/products/:id/options", controllers.SetProductOptions())
+	admin.PATCH("/products/:id/variants/:variant_id", controllers.UpdateVariant())
+	admin.POST("/orders/pay", controllers.PayOrder())
+	admin.POST("/inventory/adjust", controllers.AdjustStock())
+	admin.GET("/inventory/movements", controllers.ListMovements())
+	admin.POST("/inventory/transfers", controllers.SendTransfer())
+	admin.GET("/inventory/transfers", controllers.ListTransfers())
+	admin.POST("/inventory/transfers/:id/receive", controllers.ReceiveTransfer())
+	admin.POST("/inventory/transfers/:id/cancel", controllers.CancelTransfer())
+	admin.POST("/locations", controllers.AddLocation())
+	admin.GET("/locations", controllers.ListLocations())
+	admin.PUT("/locations/:id", controllers.UpdateLocation())
+	admin.GET("/inventory/alerts", controllers.ListStockAlerts())
+	admin.POST("/inventory/alerts/check", controllers.CheckStock())
+	admin.GET("/inventory/reorder", controllers.ReorderReport())
+	admin.POST("/products/:id/images", controllers.UploadProductImages())
+	admin.PUT("/products/:id/images", controllers.ArrangeProductImages())
+	admin.DELETE("/products/:id/images/:image_id", controllers.DeleteProductImage())
+	admin.POST("/catalog/import", controllers.ImportCatalog())
+	admin.GET("/catalog/imports", controllers.ListImports())
+	admin.GET("/catalog/imports/:id", controllers.GetImport())
+	admin.GET("/catalog/export", controllers.ExportCatalog())
+	admin.GET("/reviews", controllers.ListReviewsAdmin())
+	admin.PUT("/reviews/:id/moderate", controllers.ModerateReview())
 }
@@ -0,0 +1,106 @@
+package search
+
+import (
+	"html"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+//text goes through the same steps when products are indexed and when they are searched
+//accents are dropped (crème -> creme), everything is lower case, and plurals are cut back (pencils -> pencil)
+
+var stopwords = map[string]bool{
+	"a": true, "an": true, "and": true, "the": true, "of": true, "or": true,
+	"for": true, "with": true, "in": true, "on": true, "to": true, "by": true,
+}
+
+// Fold removes accents and case so "Crème" and "creme" are the same word
+func Fold(text string) string {
+	folder := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	folded, _, err := transform.String(folder, text)
+	if err != nil {
+		folded = text
+	}
+	return strings.ToLower(folded)
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// Tokenize splits text into the terms that get indexed and searched for
+func Tokenize(text string) []string {
+	terms := make([]string, 0)
+	for _, word := range strings.FieldsFunc(Fold(text), func(r rune) bool { return !isWordRune(r) }) {
+		if stopwords[word] {
+			continue
+		}
+		terms = append(terms, stem(word))
+	}
+	return terms
+}
+
+// stem is a light english stemmer, it only takes plurals back to the singular
+func stem(word string) string {
+	switch {
+	case len(word) <= 3:
+		return word
+	case strings.HasSuffix(word, "ies"):
+		return word[:len(word)-3] + "y"
+	case strings.HasSuffix(word, "sses"), strings.HasSuffix(word, "shes"), strings.HasSuffix(word, "ches"), strings.HasSuffix(word, "xes"):
+		return word[:len(word)-2]
+	case strings.HasSuffix(word, "ss"), strings.HasSuffix(word, "us"), strings.HasSuffix(word, "is"):
+		return word
+	case strings.HasSuffix(word, "s"):
+		return word[:len(word)-1]
+	}
+	return word
+}
+
+// unique drops repeated terms, keeping the order they came in
+func unique(terms []string) []string {
+	seen := make(map[string]bool)
+	out := make([]string, 0, len(terms))
+	for _, term := range terms {
+		if !seen[term] {
+			seen[term] = true
+			out = append(out, term)
+		}
+	}
+	return out
+}
+
+// Highlight wraps every word of text matching one of the terms in <em></em>.
+// The rest of the text is html escaped so the result can be shown as is.
+func Highlight(text string, terms []string) string {
+	wanted := make(map[string]bool)
+	for _, term := range terms {
+		wanted[term] = true
+	}
+	var out strings.Builder
+	matched := false
+	chars := []rune(text)
+	for start := 0; start < len(chars); {
+		end := start + 1
+		word := isWordRune(chars[start])
+		for end < len(chars) && isWordRune(chars[end]) == word {
+			end++
+		}
+		piece := string(chars[start:end])
+		if word && wanted[stem(Fold(piece))] {
+			out.WriteString("<em>" + html.EscapeString(piece) + "</em>")
+			matched = true
+		} else {
+			out.WriteString(html.EscapeString(piece))
+		}
+		start = end
+	}
+	if !matched {
+		return ""
+	}
+	return out.String()
+}
@@ -0,0 +1,67 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	cases := []struct {
+		text string
+		want []string
+	}{
+		{"", []string{}},
+		{"Red Pencil", []string{"red", "pencil"}},
+		{"The Crème Brûlée Dishes", []string{"creme", "brulee", "dish"}},
+		{"pens and pencils for the office", []string{"pen", "pencil", "office"}},
+		{"USB-C cables, 2m", []string{"usb", "c", "cable", "2m"}},
+		{"  ...  ", []string{}},
+	}
+	for _, c := range cases {
+		if got := Tokenize(c.text); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Tokenize(%q) = %q, want %q", c.text, got, c.want)
+		}
+	}
+}
+
+func TestStem(t *testing.T) {
+	cases := map[string]string{
+		"pencils":   "pencil",
+		"batteries": "battery",
+		"glasses":   "glass",
+		"dishes":    "dish",
+		"watches":   "watch",
+		"boxes":     "box",
+		"class":     "class",
+		"status":    "status",
+		"analysis":  "analysis",
+		"bus":       "bus",
+		"gas":       "gas",
+		"pen":       "pen",
+	}
+	for word, want := range cases {
+		if got := stem(word); got != want {
+			t.Errorf("stem(%q) = %q, want %q", word, got, want)
+		}
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	cases := []struct {
+		text  string
+		terms []string
+		want  string
+	}{
+		{"Red Pencils & Pens", []string{"pencil"}, "Red <em>Pencils</em> &amp; Pens"},
+		{"Red Pencils & Pens", []string{"red", "pen"}, "<em>Red</em> Pencils &amp; <em>Pens</em>"},
+		{"Crème Brûlée", []string{"creme"}, "<em>Crème</em> Brûlée"},
+		{"<b>pen</b>", []string{"pen"}, "&lt;b&gt;<em>pen</em>&lt;/b&gt;"},
+		{"Red Pencils", []string{"blue"}, ""},
+		{"", []string{"red"}, ""},
+	}
+	for _, c := range cases {
+		if got := Highlight(c.text, c.terms); got != c.want {
+			t.Errorf("Highlight(%q, %q) = %q, want %q", c.text, c.terms, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package search
+
+import (
+	"context"
+	"ecommerce/models"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// words in the product name count three times as much as words in a category
+const (
+	nameWeight     = 3
+	categoryWeight = 1
+)
+
+type document struct {
+	product models.Product
+	terms   map[string]float64
+}
+
+// Memory keeps an inverted index of every product that is not archived. It is
+// filled from the collection on first use and reloaded every refresh interval
+// so changes made by other instances show up too, changes made here go in
+// right away through Index.
+type Memory struct {
+	collection *mongo.Collection
+	refresh    time.Duration
+	mu         sync.RWMutex
+	loaded     time.Time
+	docs       map[primitive.ObjectID]*document
+	postings   map[string]map[primitive.ObjectID]float64
+}
+
+func NewMemory(collection *mongo.Collection, refresh time.Duration) *Memory {
+	return &Memory{
+		collection: collection,
+		refresh:    refresh,
+		docs:       make(map[primitive.ObjectID]*document),
+		postings:   make(map[string]map[primitive.ObjectID]float64),
+	}
+}
+
+func (m *Memory) stale() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.loaded.IsZero() || (m.refresh > 0 && time.Since(m.loaded) > m.refresh)
+}
+
+// Load replaces the index with the products in the collection
+func (m *Memory) Load(ctx context.Context) error {
+	cursor, err := m.collection.Find(ctx, bson.M{"archived": bson.M{"$ne": true}})
+	if err != nil {
+		return err
+	}
+	var products []models.Product
+	if err := cursor.All(ctx, &products); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.docs = make(map[primitive.ObjectID]*document, len(products))
+	m.postings = make(map[string]map[primitive.ObjectID]float64)
+	for _, product := range products {
+		m.add(product)
+	}
+	m.loaded = time.Now()
+	return nil
+}
+
+func (m *Memory) ensureLoaded(ctx context.Context) error {
+	if !m.stale() {
+		return nil
+	}
+	return m.Load(ctx)
+}
+
+func (m *Memory) Index(ctx context.Context, product models.Product) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.loaded.IsZero() {
+		//the first search loads everything anyway
+		return nil
+	}
+	m.remove(product.Product_ID)
+	if !product.Archived {
+		m.add(product)
+	}
+	return nil
+}
+
+func (m *Memory) add(product models.Product) {
+	doc := &document{product: product, terms: make(map[string]float64)}
+	if product.Product_Name != nil {
+		for _, term := range Tokenize(*product.Product_Name) {
+			doc.terms[term] += nameWeight
+		}
+	}
+	for _, category := range product.Categories {
+		for _, term := range Tokenize(category) {
+			doc.terms[term] += categoryWeight
+		}
+	}
+	m.docs[product.Product_ID] = doc
+	for term, weight := range doc.terms {
+		if m.postings[term] == nil {
+			m.postings[term] = make(map[primitive.ObjectID]float64)
+		}
+		m.postings[term][product.Product_ID] = weight
+	}
+}
+
+func (m *Memory) remove(id primitive.ObjectID) {
+	doc, ok := m.docs[id]
+	if !ok {
+		return
+	}
+	for term := range doc.terms {
+		delete(m.postings[term], id)
+		if len(m.postings[term]) == 0 {
+			delete(m.postings, term)
+		}
+	}
+	delete(m.docs, id)
+}
+
+// Search scores with tf-idf, a product matching any of the terms is a hit and
+// products matching more and rarer terms rank higher
+func (m *Memory) Search(ctx context.Context, query Query) (Result, error) {
+	result := Result{Hits: make([]Hit, 0), Facets: Facets{Categories: make([]FacetCount, 0), Price: emptyPriceBuckets(), Rating: make([]FacetCount, 0)}}
+	if err := m.ensureLoaded(ctx); err != nil {
+		return result, err
+	}
+	terms := unique(Tokenize(query.Text))
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	scores := make(map[primitive.ObjectID]float64)
+	for _, term := range terms {
+		postings := m.postings[term]
+		if len(postings) == 0 {
+			continue
+		}
+		idf := math.Log(1 + float64(len(m.docs))/float64(len(postings)))
+		for id, weight := range postings {
+			scores[id] += idf * weight
+		}
+	}
+
+	categories := make(map[string]int)
+	ratings := make(map[string]int)
+	for id := range scores {
+		product := m.docs[id].product
+		if query.Filter.withoutCategories().Match(product) {
+			for _, category := range product.Categories {
+				categories[category]++
+			}
+		}
+		if query.Filter.withoutPrice().Match(product) && product.Price != nil {
+			result.Facets.Price[priceBucket(*product.Price)].Count++
+		}
+		if query.Filter.withoutRating().Match(product) && product.Rating != nil {
+			ratings[strconv.Itoa(int(*product.Rating))]++
+		}
+		if query.Filter.Match(product) {
+			result.Hits = append(result.Hits, Hit{Product: product, Score: math.Round(scores[id]*1000) / 1000})
+		}
+	}
+	result.Facets.Categories = sortCounts(categories)
+	result.Facets.Rating = sortCounts(ratings)
+	result.Total = len(result.Hits)
+
+	sort.Slice(result.Hits, func(i, j int) bool {
+		if result.Hits[i].Score != result.Hits[j].Score {
+			return result.Hits[i].Score > result.Hits[j].Score
+		}
+		return result.Hits[i].Product.Product_ID.Hex() > result.Hits[j].Product.Product_ID.Hex()
+	})
+	if query.Offset >= len(result.Hits) {
+		result.Hits = result.Hits[:0]
+	} else {
+		result.Hits = result.Hits[query.Offset:]
+	}
+	if query.Limit > 0 && len(result.Hits) > query.Limit {
+		result.Hits = result.Hits[:query.Limit]
+	}
+	for i := range result.Hits {
+		result.Hits[i].Highlights = highlights(result.Hits[i].Product, terms)
+	}
+	return result, nil
+}
@@ -0,0 +1,181 @@
+package search
+
+import (
+	"context"
+	"ecommerce/models"
+	"math"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func product(id string, name string, price uint64, categories ...string) models.Product {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		panic(err)
+	}
+	return models.Product{Product_ID: oid, Product_Name: &name, Price: &price, Categories: categories}
+}
+
+var (
+	redPencils = product("000000000000000000000001", "Red Pencils", 50, "Stationery")
+	pencilCase = product("000000000000000000000002", "Pencil Case", 150, "Stationery", "Bags")
+	redBag     = product("000000000000000000000003", "Red Bag", 600, "Bags")
+)
+
+// memory is an index filled without a collection, marked as loaded so Search does not go to mongo
+func memory(products ...models.Product) *Memory {
+	m := NewMemory(nil, 0)
+	for _, product := range products {
+		m.add(product)
+	}
+	m.loaded = time.Now()
+	return m
+}
+
+func names(hits []Hit) []string {
+	out := make([]string, len(hits))
+	for i, hit := range hits {
+		out[i] = *hit.Product.Product_Name
+	}
+	return out
+}
+
+func TestMemoryRanking(t *testing.T) {
+	m := memory(redPencils, pencilCase, redBag)
+	result, err := m.Search(context.Background(), Query{Text: "red pencil"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//both terms are in two of the three products, a name match weighs 3
+	idf := math.Log(1 + 3.0/2.0)
+	want := []struct {
+		name  string
+		score float64
+	}{
+		{"Red Pencils", 2 * nameWeight * idf},
+		//equal scores go by id, the newest first
+		{"Red Bag", nameWeight * idf},
+		{"Pencil Case", nameWeight * idf},
+	}
+	if result.Total != len(want) || len(result.Hits) != len(want) {
+		t.Fatalf("got %v, want %d hits", names(result.Hits), len(want))
+	}
+	for i, hit := range result.Hits {
+		if *hit.Product.Product_Name != want[i].name || hit.Score != math.Round(want[i].score*1000)/1000 {
+			t.Errorf("hit %d is %s scored %v, want %s scored %.3f", i, *hit.Product.Product_Name, hit.Score, want[i].name, want[i].score)
+		}
+	}
+	if got := result.Hits[0].Highlights["product_name"]; got != "<em>Red</em> <em>Pencils</em>" {
+		t.Errorf("highlighted %q", got)
+	}
+
+	//a category match counts less than a name match
+	result, _ = m.Search(context.Background(), Query{Text: "bags"})
+	if got := names(result.Hits); len(got) != 2 || got[0] != "Red Bag" {
+		t.Errorf("searching bags got %v", got)
+	}
+
+	result, _ = m.Search(context.Background(), Query{Text: "stapler"})
+	if result.Total != 0 || len(result.Hits) != 0 {
+		t.Errorf("a word no product has found %v", names(result.Hits))
+	}
+}
+
+func TestMemoryFacets(t *testing.T) {
+	m := memory(redPencils, pencilCase, redBag)
+	result, err := m.Search(context.Background(), Query{Text: "red pencil", Filter: Filter{Categories: []string{"Bags"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(result.Hits); result.Total != 2 || len(got) != 2 || got[0] != "Red Bag" || got[1] != "Pencil Case" {
+		t.Errorf("got %v", got)
+	}
+	//the category facet leaves out the category filter, the price facet does not
+	categories := []FacetCount{{"Bags", 2}, {"Stationery", 2}}
+	if len(result.Facets.Categories) != len(categories) {
+		t.Fatalf("categories %v", result.Facets.Categories)
+	}
+	for i, count := range categories {
+		if result.Facets.Categories[i] != count {
+			t.Errorf("category %d is %v, want %v", i, result.Facets.Categories[i], count)
+		}
+	}
+	prices := []int{0, 1, 1, 0, 0}
+	for i, bucket := range result.Facets.Price {
+		if bucket.Count != prices[i] {
+			t.Errorf("price bucket from %d has %d, want %d", bucket.From, bucket.Count, prices[i])
+		}
+	}
+
+	max := uint64(100)
+	result, _ = m.Search(context.Background(), Query{Text: "red pencil", Filter: Filter{Max_Price: &max}})
+	if got := names(result.Hits); len(got) != 1 || got[0] != "Red Pencils" {
+		t.Errorf("under 100 got %v", got)
+	}
+	prices = []int{1, 1, 1, 0, 0}
+	for i, bucket := range result.Facets.Price {
+		if bucket.Count != prices[i] {
+			t.Errorf("price bucket from %d has %d, want %d", bucket.From, bucket.Count, prices[i])
+		}
+	}
+	if len(result.Facets.Categories) != 1 || result.Facets.Categories[0] != (FacetCount{"Stationery", 1}) {
+		t.Errorf("categories under 100 %v", result.Facets.Categories)
+	}
+}
+
+func TestMemoryPaging(t *testing.T) {
+	m := memory(redPencils, pencilCase, redBag)
+	cases := []struct {
+		offset, limit int
+		want          []string
+	}{
+		{0, 0, []string{"Red Pencils", "Red Bag", "Pencil Case"}},
+		{0, 2, []string{"Red Pencils", "Red Bag"}},
+		{1, 1, []string{"Red Bag"}},
+		{2, 5, []string{"Pencil Case"}},
+		{3, 1, []string{}},
+	}
+	for _, c := range cases {
+		result, err := m.Search(context.Background(), Query{Text: "red pencil", Offset: c.offset, Limit: c.limit})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := names(result.Hits)
+		if result.Total != 3 || len(got) != len(c.want) {
+			t.Errorf("offset %d limit %d: got %v of %d, want %v of 3", c.offset, c.limit, got, result.Total, c.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("offset %d limit %d: got %v, want %v", c.offset, c.limit, got, c.want)
+				break
+			}
+		}
+	}
+}
+
+func TestMemoryIndex(t *testing.T) {
+	m := memory(redPencils, pencilCase, redBag)
+	archived := redBag
+	archived.Archived = true
+	if err := m.Index(context.Background(), archived); err != nil {
+		t.Fatal(err)
+	}
+	result, _ := m.Search(context.Background(), Query{Text: "red"})
+	if got := names(result.Hits); len(got) != 1 || got[0] != "Red Pencils" {
+		t.Errorf("after archiving got %v", got)
+	}
+	if _, ok := m.postings["bag"][redBag.Product_ID]; ok {
+		t.Error("the archived product is still in the postings")
+	}
+	renamed := pencilCase
+	name := "Blue Case"
+	renamed.Product_Name = &name
+	m.Index(context.Background(), renamed)
+	result, _ = m.Search(context.Background(), Query{Text: "pencil"})
+	if got := names(result.Hits); len(got) != 1 || got[0] != "Red Pencils" {
+		t.Errorf("after renaming got %v", got)
+	}
+}
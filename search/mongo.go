@@ -0,0 +1,161 @@
+package search
+
+import (
+	"context"
+	"ecommerce/models"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Mongo searches with a text index on the product name and categories, the
+// index is created the first time it is needed. Mongo keeps the index up to
+// date by itself so Index has nothing to do.
+type Mongo struct {
+	collection *mongo.Collection
+	once       sync.Once
+	indexErr   error
+}
+
+func NewMongo(collection *mongo.Collection) *Mongo {
+	return &Mongo{collection: collection}
+}
+
+func (m *Mongo) ensureIndex(ctx context.Context) error {
+	m.once.Do(func() {
+		index := mongo.IndexModel{
+			Keys: bson.D{{Key: "product_name", Value: "text"}, {Key: "categories", Value: "text"}},
+			Options: options.Index().
+				SetName("product_text").
+				SetWeights(bson.M{"product_name": nameWeight, "categories": categoryWeight}).
+				SetDefaultLanguage("english"),
+		}
+		_, m.indexErr = m.collection.Indexes().CreateOne(ctx, index)
+	})
+	return m.indexErr
+}
+
+func (m *Mongo) Index(ctx context.Context, product models.Product) error {
+	return nil
+}
+
+// Search hands mongo the analyzed terms instead of what the user typed, so
+// quotes and dashes can not turn into phrase or negation operators
+func (m *Mongo) Search(ctx context.Context, query Query) (Result, error) {
+	result := Result{Hits: make([]Hit, 0), Facets: Facets{Categories: make([]FacetCount, 0), Price: emptyPriceBuckets(), Rating: make([]FacetCount, 0)}}
+	terms := unique(Tokenize(query.Text))
+	if len(terms) == 0 {
+		return result, nil
+	}
+	if err := m.ensureIndex(ctx); err != nil {
+		return result, err
+	}
+	text := bson.M{"$text": bson.M{"$search": strings.Join(terms, " ")}, "archived": bson.M{"$ne": true}}
+	hits := bson.A{
+		bson.M{"$match": query.Filter.BSON()},
+		bson.M{"$sort": bson.D{{Key: "score", Value: bson.M{"$meta": "textScore"}}, {Key: "_id", Value: -1}}},
+	}
+	if query.Offset > 0 {
+		hits = append(hits, bson.M{"$skip": query.Offset})
+	}
+	if query.Limit > 0 {
+		hits = append(hits, bson.M{"$limit": query.Limit})
+	}
+	hits = append(hits, bson.M{"$addFields": bson.M{"_score": bson.M{"$meta": "textScore"}}})
+	boundaries := bson.A{}
+	for _, bound := range PriceBounds {
+		boundaries = append(boundaries, int64(bound))
+	}
+	boundaries = append(boundaries, int64(math.MaxInt64))
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: text}},
+		{{Key: "$facet", Value: bson.M{
+			"hits":  hits,
+			"total": bson.A{bson.M{"$match": query.Filter.BSON()}, bson.M{"$count": "count"}},
+			"categories": bson.A{
+				bson.M{"$match": query.Filter.withoutCategories().BSON()},
+				bson.M{"$unwind": "$categories"},
+				bson.M{"$group": bson.M{"_id": "$categories", "count": bson.M{"$sum": 1}}},
+			},
+			"price": bson.A{
+				bson.M{"$match": query.Filter.withoutPrice().BSON()},
+				bson.M{"$match": bson.M{"price": bson.M{"$type": "number"}}},
+				bson.M{"$bucket": bson.M{"groupBy": "$price", "boundaries": boundaries, "default": "other", "output": bson.M{"count": bson.M{"$sum": 1}}}},
+			},
+			"rating": bson.A{
+				bson.M{"$match": query.Filter.withoutRating().BSON()},
+				bson.M{"$match": bson.M{"rating": bson.M{"$type": "number"}}},
+				bson.M{"$group": bson.M{"_id": "$rating", "count": bson.M{"$sum": 1}}},
+			},
+		}}},
+	}
+	cursor, err := m.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return result, err
+	}
+	var facets []struct {
+		Hits []struct {
+			models.Product `bson:",inline"`
+			Score          float64 `bson:"_score"`
+		} `bson:"hits"`
+		Total      []struct{ Count int } `bson:"total"`
+		Categories []struct {
+			ID    string `bson:"_id"`
+			Count int    `bson:"count"`
+		} `bson:"categories"`
+		Price []struct {
+			ID    interface{} `bson:"_id"`
+			Count int         `bson:"count"`
+		} `bson:"price"`
+		Rating []struct {
+			ID    float64 `bson:"_id"`
+			Count int     `bson:"count"`
+		} `bson:"rating"`
+	}
+	if err := cursor.All(ctx, &facets); err != nil {
+		return result, err
+	}
+	if len(facets) == 0 {
+		return result, nil
+	}
+	found := facets[0]
+	for _, hit := range found.Hits {
+		result.Hits = append(result.Hits, Hit{Product: hit.Product, Score: math.Round(hit.Score*1000) / 1000, Highlights: highlights(hit.Product, terms)})
+	}
+	if len(found.Total) > 0 {
+		result.Total = found.Total[0].Count
+	}
+	categories := make(map[string]int)
+	for _, category := range found.Categories {
+		categories[category.ID] = category.Count
+	}
+	result.Facets.Categories = sortCounts(categories)
+	for _, bucket := range found.Price {
+		//buckets come back keyed by their lower boundary
+		var from int64
+		switch value := bucket.ID.(type) {
+		case int64:
+			from = value
+		case int32:
+			from = int64(value)
+		case float64:
+			from = int64(value)
+		default:
+			continue
+		}
+		if from >= 0 {
+			result.Facets.Price[priceBucket(uint64(from))].Count += bucket.Count
+		}
+	}
+	ratings := make(map[string]int)
+	for _, rating := range found.Rating {
+		ratings[strconv.Itoa(int(rating.ID))] += rating.Count
+	}
+	result.Facets.Rating = sortCounts(ratings)
+	return result, nil
+}
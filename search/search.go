@@ -0,0 +1,198 @@
+package search
+
+import (
+	"context"
+	"ecommerce/models"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+//product search runs either on a mongo text index (SEARCH_ENGINE=mongo, the default)
+//or on an index kept in memory (SEARCH_ENGINE=memory) that also works without a text index
+//both rank by relevance and answer with the same facets and highlights
+
+type Engine interface {
+	Search(ctx context.Context, query Query) (Result, error)
+	// Index tells the engine a product was added or changed, archived products are dropped
+	Index(ctx context.Context, product models.Product) error
+}
+
+// Filter narrows the products down, it is shared with the product listing
+type Filter struct {
+	Categories []string
+	Min_Price  *uint64
+	Max_Price  *uint64
+	Min_Rating *uint64
+}
+
+type Query struct {
+	Text   string
+	Filter Filter
+	Limit  int
+	Offset int
+}
+
+type Hit struct {
+	Product    models.Product    `json:"product"`
+	Score      float64           `json:"score"`
+	Highlights map[string]string `json:"highlights,omitempty"`
+}
+
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// PriceBucket counts the products from From up to but not including To, the last bucket has no To
+type PriceBucket struct {
+	From  uint64  `json:"from"`
+	To    *uint64 `json:"to"`
+	Count int     `json:"count"`
+}
+
+// every facet is counted with all the filters but its own, so picking a
+// category still shows how many products the other categories have
+type Facets struct {
+	Categories []FacetCount  `json:"categories"`
+	Price      []PriceBucket `json:"price"`
+	Rating     []FacetCount  `json:"rating"`
+}
+
+type Result struct {
+	Hits   []Hit  `json:"hits"`
+	Total  int    `json:"total"`
+	Facets Facets `json:"facets"`
+}
+
+// PriceBounds are where the price buckets start
+var PriceBounds = []uint64{0, 100, 500, 1000, 5000}
+
+func New(collection *mongo.Collection) Engine {
+	if strings.EqualFold(os.Getenv("SEARCH_ENGINE"), "memory") {
+		refresh, _ := time.ParseDuration(os.Getenv("SEARCH_REFRESH"))
+		return NewMemory(collection, refresh)
+	}
+	return NewMongo(collection)
+}
+
+// BSON is the filter as a mongo query, archived products are always left out
+func (f Filter) BSON() bson.M {
+	filter := bson.M{"archived": bson.M{"$ne": true}}
+	price := bson.M{}
+	if f.Min_Price != nil {
+		price["$gte"] = int64(*f.Min_Price)
+	}
+	if f.Max_Price != nil {
+		price["$lte"] = int64(*f.Max_Price)
+	}
+	if len(price) > 0 {
+		filter["price"] = price
+	}
+	if f.Min_Rating != nil {
+		filter["rating"] = bson.M{"$gte": int64(*f.Min_Rating)}
+	}
+	if len(f.Categories) > 0 {
+		filter["categories"] = bson.M{"$in": f.Categories}
+	}
+	return filter
+}
+
+// Match is the same filter for products already in memory
+func (f Filter) Match(product models.Product) bool {
+	if product.Archived {
+		return false
+	}
+	if f.Min_Price != nil && (product.Price == nil || *product.Price < *f.Min_Price) {
+		return false
+	}
+	if f.Max_Price != nil && (product.Price == nil || *product.Price > *f.Max_Price) {
+		return false
+	}
+	if f.Min_Rating != nil && (product.Rating == nil || uint64(*product.Rating) < *f.Min_Rating) {
+		return false
+	}
+	if len(f.Categories) > 0 {
+		for _, wanted := range f.Categories {
+			for _, category := range product.Categories {
+				if category == wanted {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func (f Filter) withoutCategories() Filter {
+	f.Categories = nil
+	return f
+}
+
+func (f Filter) withoutPrice() Filter {
+	f.Min_Price, f.Max_Price = nil, nil
+	return f
+}
+
+func (f Filter) withoutRating() Filter {
+	f.Min_Rating = nil
+	return f
+}
+
+func priceBucket(price uint64) int {
+	bucket := 0
+	for i, bound := range PriceBounds {
+		if price >= bound {
+			bucket = i
+		}
+	}
+	return bucket
+}
+
+func emptyPriceBuckets() []PriceBucket {
+	buckets := make([]PriceBucket, len(PriceBounds))
+	for i, bound := range PriceBounds {
+		buckets[i].From = bound
+		if i+1 < len(PriceBounds) {
+			to := PriceBounds[i+1]
+			buckets[i].To = &to
+		}
+	}
+	return buckets
+}
+
+// sortCounts orders facet values by count, then by value so the order is stable
+func sortCounts(counts map[string]int) []FacetCount {
+	out := make([]FacetCount, 0, len(counts))
+	for value, count := range counts {
+		out = append(out, FacetCount{Value: value, Count: count})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Value < out[j].Value
+	})
+	return out
+}
+
+func highlights(product models.Product, terms []string) map[string]string {
+	marked := make(map[string]string)
+	if product.Product_Name != nil {
+		if name := Highlight(*product.Product_Name, terms); name != "" {
+			marked["product_name"] = name
+		}
+	}
+	for _, category := range product.Categories {
+		if highlighted := Highlight(category, terms); highlighted != "" {
+			marked["categories"] = highlighted
+			break
+		}
+	}
+	return marked
+}
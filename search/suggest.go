@@ -0,0 +1,383 @@
+package search
+
+import (
+	"context"
+	"ecommerce/models"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+//suggestions come from the product names and from what other customers searched for
+//everything is kept in memory, products are updated as they change and the whole
+//thing is reloaded every refresh interval to pick up other servers' changes
+
+const (
+	KindQuery   = "query"
+	KindProduct = "product"
+)
+
+// popularLimit is how many of the most searched queries are kept in memory
+const popularLimit = 1000
+
+type Completion struct {
+	Text       string              `json:"text"`
+	Kind       string              `json:"kind"`
+	Product_ID *primitive.ObjectID `json:"product_id,omitempty"`
+}
+
+type Suggestions struct {
+	Completions  []Completion `json:"completions"`
+	Did_You_Mean string       `json:"did_you_mean,omitempty"`
+}
+
+type suggestEntry struct {
+	text   string
+	words  []string
+	weight int
+}
+
+type Suggester struct {
+	products   *mongo.Collection
+	queries    *mongo.Collection
+	refresh    time.Duration
+	mu         sync.RWMutex
+	loaded     time.Time
+	names      map[primitive.ObjectID]suggestEntry
+	popular    map[string]suggestEntry
+	vocabulary map[string]int
+}
+
+func NewSuggester(products *mongo.Collection, queries *mongo.Collection, refresh time.Duration) *Suggester {
+	return &Suggester{
+		products:   products,
+		queries:    queries,
+		refresh:    refresh,
+		names:      make(map[primitive.ObjectID]suggestEntry),
+		popular:    make(map[string]suggestEntry),
+		vocabulary: make(map[string]int),
+	}
+}
+
+// words folds text and splits it into words, unlike Tokenize it keeps stopwords
+// and does not stem because a prefix like "batter" has to match "battery"
+func words(text string) []string {
+	return strings.FieldsFunc(Fold(text), func(r rune) bool { return !isWordRune(r) })
+}
+
+// NormalizeQuery is the form searches are counted under
+func NormalizeQuery(text string) string {
+	return strings.Join(words(text), " ")
+}
+
+func (s *Suggester) stale() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.loaded.IsZero() || (s.refresh > 0 && time.Since(s.loaded) > s.refresh)
+}
+
+// Load reads the product names and the most popular queries
+func (s *Suggester) Load(ctx context.Context) error {
+	var products []models.Product
+	projection := options.Find().SetProjection(bson.M{"product_name": 1})
+	cursor, err := s.products.Find(ctx, bson.M{"archived": bson.M{"$ne": true}}, projection)
+	if err != nil {
+		return err
+	}
+	if err := cursor.All(ctx, &products); err != nil {
+		return err
+	}
+	var queries []struct {
+		Query string `bson:"_id"`
+		Count int    `bson:"count"`
+	}
+	popular := options.Find().SetSort(bson.M{"count": -1}).SetLimit(popularLimit)
+	cursor, err = s.queries.Find(ctx, bson.M{}, popular)
+	if err != nil {
+		return err
+	}
+	if err := cursor.All(ctx, &queries); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.names = make(map[primitive.ObjectID]suggestEntry, len(products))
+	s.popular = make(map[string]suggestEntry, len(queries))
+	s.vocabulary = make(map[string]int)
+	for _, product := range products {
+		s.addProduct(product)
+	}
+	for _, query := range queries {
+		s.addQuery(query.Query, query.Count)
+	}
+	s.loaded = time.Now()
+	return nil
+}
+
+func (s *Suggester) ensureLoaded(ctx context.Context) error {
+	if !s.stale() {
+		return nil
+	}
+	return s.Load(ctx)
+}
+
+func (s *Suggester) learn(words []string, delta int) {
+	for _, word := range words {
+		s.vocabulary[word] += delta
+		if s.vocabulary[word] <= 0 {
+			delete(s.vocabulary, word)
+		}
+	}
+}
+
+func (s *Suggester) addProduct(product models.Product) {
+	if product.Product_Name == nil || product.Archived {
+		return
+	}
+	entry := suggestEntry{text: *product.Product_Name, words: words(*product.Product_Name)}
+	s.names[product.Product_ID] = entry
+	s.learn(entry.words, 1)
+}
+
+func (s *Suggester) addQuery(query string, count int) {
+	if old, ok := s.popular[query]; ok {
+		old.weight += count
+		s.popular[query] = old
+		return
+	}
+	entry := suggestEntry{text: query, words: words(query), weight: count}
+	s.popular[query] = entry
+	s.learn(entry.words, 1)
+}
+
+// Index updates the name of a product, archived products stop being suggested
+func (s *Suggester) Index(ctx context.Context, product models.Product) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.loaded.IsZero() {
+		return nil
+	}
+	if old, ok := s.names[product.Product_ID]; ok {
+		s.learn(old.words, -1)
+		delete(s.names, product.Product_ID)
+	}
+	s.addProduct(product)
+	return nil
+}
+
+// Record counts a search that found something so it can be suggested to others
+func (s *Suggester) Record(ctx context.Context, text string) error {
+	query := NormalizeQuery(text)
+	if query == "" {
+		return nil
+	}
+	filter := bson.M{"_id": query}
+	update := bson.M{"$inc": bson.M{"count": 1}, "$set": bson.M{"last_searched_at": time.Now()}}
+	if _, err := s.queries.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true)); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.loaded.IsZero() {
+		s.addQuery(query, 1)
+	}
+	return nil
+}
+
+// matches tells whether the entry has every typed word, the last one only as a prefix
+func (entry suggestEntry) matches(typed []string) (bool, bool) {
+	last := typed[len(typed)-1]
+	for _, word := range typed[:len(typed)-1] {
+		found := false
+		for _, have := range entry.words {
+			if have == word {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false, false
+		}
+	}
+	for i, have := range entry.words {
+		if strings.HasPrefix(have, last) {
+			return true, i == 0
+		}
+	}
+	return false, false
+}
+
+func (s *Suggester) complete(typed []string, limit int) []Completion {
+	type candidate struct {
+		completion Completion
+		weight     int
+		first      bool
+	}
+	queries := make([]candidate, 0)
+	for _, entry := range s.popular {
+		if ok, first := entry.matches(typed); ok {
+			queries = append(queries, candidate{Completion{Text: entry.text, Kind: KindQuery}, entry.weight, first})
+		}
+	}
+	products := make([]candidate, 0)
+	for id, entry := range s.names {
+		if ok, first := entry.matches(typed); ok {
+			id := id
+			products = append(products, candidate{Completion{Text: entry.text, Kind: KindProduct, Product_ID: &id}, 0, first})
+		}
+	}
+	//popular queries by how often they were searched, products starting with
+	//the typed word before the ones that only have it further on
+	rank := func(list []candidate) {
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].weight != list[j].weight {
+				return list[i].weight > list[j].weight
+			}
+			if list[i].first != list[j].first {
+				return list[i].first
+			}
+			if len(list[i].completion.Text) != len(list[j].completion.Text) {
+				return len(list[i].completion.Text) < len(list[j].completion.Text)
+			}
+			return list[i].completion.Text < list[j].completion.Text
+		})
+	}
+	rank(queries)
+	rank(products)
+	out := make([]Completion, 0, limit)
+	seen := make(map[string]bool)
+	for _, list := range [][]candidate{queries, products} {
+		for _, candidate := range list {
+			key := NormalizeQuery(candidate.completion.Text)
+			if len(out) == limit || seen[key] {
+				continue
+			}
+			seen[key] = true
+			out = append(out, candidate.completion)
+		}
+	}
+	return out
+}
+
+// correct swaps every word we have never seen for the closest known word.
+// The last word is left alone while it is still the start of a known word.
+func (s *Suggester) correct(typed []string) []string {
+	corrected := make([]string, len(typed))
+	for i, word := range typed {
+		corrected[i] = word
+		if _, ok := s.vocabulary[word]; ok {
+			continue
+		}
+		if i == len(typed)-1 && s.isPrefix(word) {
+			continue
+		}
+		if better, ok := s.closest(word); ok {
+			corrected[i] = better
+		}
+	}
+	return corrected
+}
+
+func (s *Suggester) isPrefix(word string) bool {
+	for known := range s.vocabulary {
+		if strings.HasPrefix(known, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// closest finds the known word the fewest edits away, short words may be one
+// edit off and longer ones two, ties go to the word used most
+func (s *Suggester) closest(word string) (string, bool) {
+	length := len([]rune(word))
+	allowed := 1
+	switch {
+	case length < 3:
+		return "", false
+	case length > 5:
+		allowed = 2
+	}
+	best, bestdistance, bestuse := "", allowed+1, 0
+	for known, use := range s.vocabulary {
+		if abs(len([]rune(known))-length) > allowed {
+			continue
+		}
+		distance := Levenshtein(word, known)
+		if distance > allowed {
+			continue
+		}
+		if distance < bestdistance || (distance == bestdistance && (use > bestuse || (use == bestuse && known < best))) {
+			best, bestdistance, bestuse = known, distance, use
+		}
+	}
+	return best, best != ""
+}
+
+// Suggest completes what was typed so far and offers a correction when a word looks misspelled
+func (s *Suggester) Suggest(ctx context.Context, text string, limit int) (Suggestions, error) {
+	suggestions := Suggestions{Completions: make([]Completion, 0)}
+	if err := s.ensureLoaded(ctx); err != nil {
+		return suggestions, err
+	}
+	typed := words(text)
+	if len(typed) == 0 {
+		return suggestions, nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	suggestions.Completions = s.complete(typed, limit)
+	corrected := s.correct(typed)
+	if strings.Join(corrected, " ") != strings.Join(typed, " ") {
+		suggestions.Did_You_Mean = strings.Join(corrected, " ")
+		if len(suggestions.Completions) == 0 {
+			suggestions.Completions = s.complete(corrected, limit)
+		}
+	}
+	return suggestions, nil
+}
+
+// Levenshtein counts the single letter insertions, deletions and substitutions between a and b
+func Levenshtein(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min3(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,154 @@
+package search
+
+import (
+	"context"
+	"ecommerce/models"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// suggester knows the given words, each used as often as it says
+func suggester(vocabulary map[string]int) *Suggester {
+	s := NewSuggester(nil, nil, 0)
+	for word, use := range vocabulary {
+		s.vocabulary[word] = use
+	}
+	s.loaded = time.Now()
+	return s
+}
+
+func TestLevenshtein(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"", "abc", 3},
+		{"pencil", "pencil", 0},
+		{"pencel", "pencil", 1},
+		{"pencl", "pencil", 1},
+		{"pencils", "pencil", 1},
+		{"kitten", "sitting", 3},
+		{"flaw", "lawn", 2},
+		{"crème", "creme", 1},
+	}
+	for _, c := range cases {
+		if got := Levenshtein(c.a, c.b); got != c.want {
+			t.Errorf("Levenshtein(%q, %q) = %d, want %d", c.a, c.b, got, c.want)
+		}
+		if got := Levenshtein(c.b, c.a); got != c.want {
+			t.Errorf("Levenshtein(%q, %q) = %d, want %d", c.b, c.a, got, c.want)
+		}
+	}
+}
+
+func TestClosest(t *testing.T) {
+	s := suggester(map[string]int{"pen": 3, "pencil": 1, "paper": 1, "battery": 2, "bag": 1, "card": 5, "cart": 1, "cap": 1, "cat": 1})
+	cases := []struct {
+		word string
+		want string
+		ok   bool
+	}{
+		//too short to guess
+		{"pe", "", false},
+		{"xy", "", false},
+		//one edit up to five letters
+		{"pwn", "pen", true},
+		{"papr", "paper", true},
+		{"papper", "paper", true},
+		{"pxxer", "", false},
+		//two edits from six letters on
+		{"pencel", "pencil", true},
+		{"batery", "battery", true},
+		{"bxttxry", "battery", true},
+		{"bxttxxy", "", false},
+		//ties go to the word used most, then the first in the alphabet
+		{"carx", "card", true},
+		{"cax", "cap", true},
+	}
+	for _, c := range cases {
+		if got, ok := s.closest(c.word); got != c.want || ok != c.ok {
+			t.Errorf("closest(%q) = %q %v, want %q %v", c.word, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestCorrect(t *testing.T) {
+	s := suggester(map[string]int{"red": 2, "pencil": 1, "case": 1, "battery": 1})
+	cases := []struct {
+		typed []string
+		want  []string
+	}{
+		{[]string{"red", "pencil"}, []string{"red", "pencil"}},
+		{[]string{"red", "pencl"}, []string{"red", "pencil"}},
+		{[]string{"pencl", "case"}, []string{"pencil", "case"}},
+		//the last word may still be typed
+		{[]string{"red", "pen"}, []string{"red", "pen"}},
+		{[]string{"batt"}, []string{"batt"}},
+		//but not the ones before it
+		{[]string{"rad", "case"}, []string{"red", "case"}},
+		{[]string{"xy", "case"}, []string{"xy", "case"}},
+	}
+	for _, c := range cases {
+		if got := s.correct(c.typed); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("correct(%q) = %q, want %q", c.typed, got, c.want)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	entry := suggestEntry{text: "Red Pencil Case", words: words("Red Pencil Case")}
+	cases := []struct {
+		typed        []string
+		match, first bool
+	}{
+		{[]string{"re"}, true, true},
+		{[]string{"pen"}, true, false},
+		{[]string{"red", "ca"}, true, false},
+		{[]string{"case", "red"}, true, true},
+		{[]string{"red", "pencil", "case"}, true, false},
+		//only the last word completes
+		{[]string{"pen", "case"}, false, false},
+		{[]string{"blue", "ca"}, false, false},
+		{[]string{"bag"}, false, false},
+	}
+	for _, c := range cases {
+		if match, first := entry.matches(c.typed); match != c.match || first != c.first {
+			t.Errorf("matches(%q) = %v %v, want %v %v", c.typed, match, first, c.match, c.first)
+		}
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	s := suggester(nil)
+	s.addQuery("red pencils", 5)
+	s.addQuery("red pen", 2)
+	for _, p := range []models.Product{
+		product("000000000000000000000001", "Red Pencil Case", 150),
+		product("000000000000000000000002", "Pencil Red", 50),
+		product("000000000000000000000003", "Blue Bag", 600),
+	} {
+		s.addProduct(p)
+	}
+	texts := func(completions []Completion) []string {
+		out := make([]string, len(completions))
+		for i, completion := range completions {
+			out[i] = completion.Text
+		}
+		return out
+	}
+	suggestions, err := s.Suggest(context.Background(), "Red pen", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//searches first by how often, then products starting with the word
+	if got, want := texts(suggestions.Completions), []string{"red pencils", "red pen", "Pencil Red"}; !reflect.DeepEqual(got, want) || suggestions.Did_You_Mean != "" {
+		t.Errorf("got %q and %q, want %q", got, suggestions.Did_You_Mean, want)
+	}
+	suggestions, _ = s.Suggest(context.Background(), "red pencl", 5)
+	if got, want := texts(suggestions.Completions), []string{"red pencils", "Pencil Red", "Red Pencil Case"}; !reflect.DeepEqual(got, want) || suggestions.Did_You_Mean != "red pencil" {
+		t.Errorf("got %q and %q, want %q and red pencil", got, suggestions.Did_You_Mean, want)
+	}
+}
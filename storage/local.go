@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Local keeps the files in a directory on this server
+type Local struct {
+	Dir      string
+	Base_URL string
+}
+
+func NewLocal(dir string, base_url string) *Local {
+	return &Local{Dir: dir, Base_URL: base_url}
+}
+
+// Serves is true when the files have to be handed out by this server, the
+// base url is then the path they are served under. With a full url something
+// else (a cdn, nginx) serves the directory.
+func (l *Local) Serves() bool {
+	return strings.HasPrefix(l.Base_URL, "/")
+}
+
+func (l *Local) path(key string) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(l.Dir, filepath.FromSlash(key)), nil
+}
+
+func (l *Local) Put(ctx context.Context, key string, content_type string, data []byte) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	//written next to the target and renamed so nobody downloads half a file
+	file, err := ioutil.TempFile(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	if err := os.Chmod(file.Name(), 0644); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (l *Local) URL(key string) string {
+	return joinURL(l.Base_URL, key)
+}
@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3 keeps the files in a bucket, requests are signed with signature version 4.
+// Endpoint is https://s3.<region>.amazonaws.com for aws or the address of an
+// S3 compatible server, http://localhost:9000 for a local MinIO. Path_Style
+// puts the bucket in the path instead of the host name, most servers other
+// than aws need it. Public_URL is where the bucket can be read from, it
+// defaults to the bucket url.
+type S3 struct {
+	Endpoint   string
+	Region     string
+	Bucket     string
+	Access_Key string
+	Secret_Key string
+	Path_Style bool
+	Public_URL string
+	Client     *http.Client
+}
+
+// NewS3FromEnv reads S3_ENDPOINT, S3_REGION, S3_BUCKET, S3_ACCESS_KEY,
+// S3_SECRET_KEY, S3_PATH_STYLE and S3_PUBLIC_URL
+func NewS3FromEnv() *S3 {
+	region := env("S3_REGION", "us-east-1")
+	return &S3{
+		Endpoint:   env("S3_ENDPOINT", "https://s3."+region+".amazonaws.com"),
+		Region:     region,
+		Bucket:     os.Getenv("S3_BUCKET"),
+		Access_Key: os.Getenv("S3_ACCESS_KEY"),
+		Secret_Key: os.Getenv("S3_SECRET_KEY"),
+		Path_Style: os.Getenv("S3_PATH_STYLE") == "true",
+		Public_URL: os.Getenv("S3_PUBLIC_URL"),
+		Client:     &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+// objectURL is the address of the key on the server
+func (s *S3) objectURL(key string) (*url.URL, error) {
+	base, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if s.Path_Style {
+		base.Path = strings.TrimSuffix(base.Path, "/") + "/" + s.Bucket + "/" + key
+	} else {
+		base.Host = s.Bucket + "." + base.Host
+		base.Path = strings.TrimSuffix(base.Path, "/") + "/" + key
+	}
+	return base, nil
+}
+
+func (s *S3) Put(ctx context.Context, key string, content_type string, data []byte) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set("Content-Type", content_type)
+	_, err := s.do(ctx, http.MethodPut, key, header, data)
+	return err
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	//aws answers 204 for a missing key, some compatible servers 404
+	if status, err := s.do(ctx, http.MethodDelete, key, http.Header{}, nil); err != nil && status != http.StatusNotFound {
+		return err
+	}
+	return nil
+}
+
+func (s *S3) URL(key string) string {
+	if s.Public_URL != "" {
+		return joinURL(s.Public_URL, key)
+	}
+	target, err := s.objectURL(key)
+	if err != nil {
+		return key
+	}
+	target.Path = "/" + strings.TrimPrefix(target.Path, "/")
+	return target.Scheme + "://" + target.Host + uriEncode(target.Path, false)
+}
+
+// do sends the signed request, answers of 300 and up are errors with the status they came with
+func (s *S3) do(ctx context.Context, method string, key string, header http.Header, body []byte) (int, error) {
+	target, err := s.objectURL(key)
+	if err != nil {
+		return 0, err
+	}
+	request, err := http.NewRequestWithContext(ctx, method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	request.ContentLength = int64(len(body))
+	s.sign(request, body, time.Now())
+	response, err := s.Client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		message, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+		return response.StatusCode, fmt.Errorf("storage: s3 %s %s answered %s: %s", method, key, response.Status, strings.TrimSpace(string(message)))
+	}
+	return response.StatusCode, nil
+}
+
+// sign adds the Authorization header of signature version 4, every header
+// already on the request is signed along with the host
+func (s *S3) sign(request *http.Request, body []byte, now time.Time) {
+	now = now.UTC()
+	stamp := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payload := sha256.Sum256(body)
+	request.Header.Set("X-Amz-Date", stamp)
+	request.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payload[:]))
+
+	headers := map[string]string{"host": request.URL.Host}
+	for name, values := range request.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonical strings.Builder
+	for _, name := range names {
+		canonical.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	path := request.URL.EscapedPath()
+	if unescaped, err := url.PathUnescape(path); err == nil {
+		path = unescaped
+	}
+	if path == "" {
+		path = "/"
+	}
+	query := canonicalQuery(request.URL.Query())
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		uriEncode(path, false),
+		query,
+		canonical.String(),
+		signed,
+		hex.EncodeToString(payload[:]),
+	}, "\n")
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	scope := day + "/" + s.Region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.Secret_Key), day)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	request.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.Access_Key, scope, signed, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func canonicalQuery(values url.Values) string {
+	pairs := make([]string, 0, len(values))
+	for name, list := range values {
+		for _, value := range list {
+			pairs = append(pairs, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode escapes everything but the unreserved characters of RFC 3986,
+// slashes stay in paths
+func uriEncode(value string, slash bool) string {
+	var encoded strings.Builder
+	for _, b := range []byte(value) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9', b == '-', b == '_', b == '.', b == '~':
+			encoded.WriteByte(b)
+		case b == '/' && !slash:
+			encoded.WriteByte(b)
+		default:
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+	return encoded.String()
+}
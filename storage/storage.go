@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+)
+
+//uploaded files (product images for now) are kept in a blob store. STORAGE
+//picks where: local (the default) writes them below MEDIA_DIR and the server
+//hands them out under MEDIA_URL, s3 puts them in a bucket of S3 or anything
+//that speaks its api (MinIO, R2, ...)
+
+var ErrInvalidKey = errors.New("storage: invalid key")
+
+type Store interface {
+	// Put saves the data under the key, replacing what was there
+	Put(ctx context.Context, key string, content_type string, data []byte) error
+	// Delete removes the key, removing a key that is not there is not an error
+	Delete(ctx context.Context, key string) error
+	// URL is where clients download the key from
+	URL(key string) string
+}
+
+// Default is the store picked by STORAGE
+var Default Store = fromEnv()
+
+func fromEnv() Store {
+	switch os.Getenv("STORAGE") {
+	case "s3":
+		return NewS3FromEnv()
+	default:
+		return NewLocal(env("MEDIA_DIR", "media"), env("MEDIA_URL", "/media"))
+	}
+}
+
+func env(name string, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// checkKey only lets through relative slash separated paths without empty, . or .. parts
+func checkKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return ErrInvalidKey
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return ErrInvalidKey
+		}
+	}
+	return nil
+}
+
+func joinURL(base string, key string) string {
+	return strings.TrimSuffix(base, "/") + "/" + key
+}
@@ -0,0 +1,57 @@
+package token
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+//an account token goes out by email to a guest who wants an account, only
+//whoever reads that mailbox can turn the guest user into an account with it.
+//It carries the guest, the email and when it expires, signed like the cart tokens
+
+var ErrAccountToken = errors.New("invalid or expired account link")
+
+func accountSignature(payload string) string {
+	mac := hmac.New(sha256.New, []byte("account:"+CART_SECRET))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// AccountToken signs the guest id and the email, it is good until expires
+func AccountToken(guest_id primitive.ObjectID, email string, expires time.Time) string {
+	payload := guest_id.Hex() + "." + base64.RawURLEncoding.EncodeToString([]byte(email)) + "." + strconv.FormatInt(expires.Unix(), 10)
+	return payload + "." + accountSignature(payload)
+}
+
+// ValidateAccountToken checks the signature and the expiry of an account token
+// and returns the guest and the email it was sent to
+func ValidateAccountToken(signedtoken string, now time.Time) (primitive.ObjectID, string, error) {
+	parts := strings.Split(signedtoken, ".")
+	if CART_SECRET == "" || len(parts) != 4 {
+		return primitive.NilObjectID, "", ErrAccountToken
+	}
+	payload := strings.Join(parts[:3], ".")
+	if !hmac.Equal([]byte(parts[3]), []byte(accountSignature(payload))) {
+		return primitive.NilObjectID, "", ErrAccountToken
+	}
+	guest_id, err := primitive.ObjectIDFromHex(parts[0])
+	if err != nil {
+		return primitive.NilObjectID, "", ErrAccountToken
+	}
+	email, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return primitive.NilObjectID, "", ErrAccountToken
+	}
+	expires, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || now.Unix() > expires {
+		return primitive.NilObjectID, "", ErrAccountToken
+	}
+	return guest_id, string(email), nil
+}
@@ -0,0 +1,61 @@
+package token
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+//a cart token names the cart of a visitor who is not logged in, the id of the
+//cart with a signature so nobody can guess their way into somebody else's cart
+
+var ErrCartToken = errors.New("invalid cart token")
+var ErrNoCartSecret = errors.New("CART_SECRET or SECRET_LOVE has to be set to sign cart tokens")
+
+// CART_SECRET signs the cart tokens, without it the secret of the login tokens is used
+var CART_SECRET = cartSecret()
+
+func cartSecret() string {
+	if secret := os.Getenv("CART_SECRET"); secret != "" {
+		return secret
+	}
+	return SECRET_KEY
+}
+
+// CheckCartSecret refuses to run without a secret, cart tokens signed with an
+// empty one could be made by anybody
+func CheckCartSecret() error {
+	if CART_SECRET == "" {
+		return ErrNoCartSecret
+	}
+	return nil
+}
+
+func cartSignature(id string) string {
+	mac := hmac.New(sha256.New, []byte("cart:"+CART_SECRET))
+	mac.Write([]byte(id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// CartToken signs the id of a guest cart
+func CartToken(cart_id primitive.ObjectID) string {
+	return cart_id.Hex() + "." + cartSignature(cart_id.Hex())
+}
+
+// ValidateCartToken checks the signature of a cart token and returns the id of the cart
+func ValidateCartToken(signedtoken string) (primitive.ObjectID, error) {
+	parts := strings.Split(signedtoken, ".")
+	if CART_SECRET == "" || len(parts) != 2 || !hmac.Equal([]byte(parts[1]), []byte(cartSignature(parts[0]))) {
+		return primitive.NilObjectID, ErrCartToken
+	}
+	cart_id, err := primitive.ObjectIDFromHex(parts[0])
+	if err != nil {
+		return primitive.NilObjectID, ErrCartToken
+	}
+	return cart_id, nil
+}
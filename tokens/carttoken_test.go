@@ -0,0 +1,56 @@
+package token
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCartToken(t *testing.T) {
+	defer func(secret string) { CART_SECRET = secret }(CART_SECRET)
+	CART_SECRET = "test secret"
+	cart_id := primitive.NewObjectID()
+	signed := CartToken(cart_id)
+	if got, err := ValidateCartToken(signed); err != nil || got != cart_id {
+		t.Fatalf("ValidateCartToken = %v, %v", got, err)
+	}
+	other := primitive.NewObjectID().Hex() + signed[len(cart_id.Hex()):]
+	for _, bad := range []string{"", cart_id.Hex(), other, signed + "x"} {
+		if _, err := ValidateCartToken(bad); err != ErrCartToken {
+			t.Errorf("ValidateCartToken(%q) = %v, want ErrCartToken", bad, err)
+		}
+	}
+	CART_SECRET = ""
+	if CheckCartSecret() != ErrNoCartSecret {
+		t.Error("an empty secret was accepted")
+	}
+	if _, err := ValidateCartToken(CartToken(cart_id)); err != ErrCartToken {
+		t.Error("a token signed with an empty secret was accepted")
+	}
+}
+
+func TestAccountToken(t *testing.T) {
+	defer func(secret string) { CART_SECRET = secret }(CART_SECRET)
+	CART_SECRET = "test secret"
+	guest_id := primitive.NewObjectID()
+	now := time.Now()
+	signed := AccountToken(guest_id, "someone@example.com", now.Add(time.Hour))
+	id, email, err := ValidateAccountToken(signed, now)
+	if err != nil || id != guest_id || email != "someone@example.com" {
+		t.Fatalf("ValidateAccountToken = %v, %q, %v", id, email, err)
+	}
+	if _, _, err := ValidateAccountToken(signed, now.Add(2*time.Hour)); err != ErrAccountToken {
+		t.Error("an expired link was accepted")
+	}
+	parts := strings.Split(signed, ".")
+	other := AccountToken(guest_id, "attacker@example.com", now.Add(time.Hour))
+	swapped := strings.Join([]string{parts[0], strings.Split(other, ".")[1], parts[2], parts[3]}, ".")
+	later := strings.Join([]string{parts[0], parts[1], "99999999999", parts[3]}, ".")
+	for _, bad := range []string{"", signed + "x", swapped, later, CartToken(guest_id)} {
+		if _, _, err := ValidateAccountToken(bad, now); err != ErrAccountToken {
+			t.Errorf("ValidateAccountToken(%q) = %v, want ErrAccountToken", bad, err)
+		}
+	}
+}
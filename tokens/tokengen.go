@@ -76,17 +76,17 @@ func ValidateToken(signedtoken string) (claims *SignedDetails, msg string) {
 func UpdateAllTokens(signedtoken string, signedrefreshtoken string, userid string) {
 	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 	var updateobj primitive.D
-	updateobj = append(updateobj, bson.E{"token", signedtoken})
-	updateobj = append(updateobj, bson.E{"refresh_token", signedrefreshtoken})
+	updateobj = append(updateobj, bson.E{Key: "token", Value: signedtoken})
+	updateobj = append(updateobj, bson.E{Key: "refresh_token", Value: signedrefreshtoken})
 	updated_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-	updateobj = append(updateobj, bson.E{"updatedat", updated_at})
+	updateobj = append(updateobj, bson.E{Key: "updatedat", Value: updated_at})
 	upsert := true
 	filter := bson.M{"user_id": userid}
 	opt := options.UpdateOptions{
 		Upsert: &upsert,
 	}
 	_, err := UserData.UpdateOne(ctx, filter, bson.D{
-		{"$set", updateobj},
+		{Key: "$set", Value: updateobj},
 	},
 		&opt)
 	defer cancel()